@@ -0,0 +1,44 @@
+package contract
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Roles a caller may act with. The API passes the authenticated user's role;
+// backend workflows such as saga compensations act with RoleSystem.
+const (
+	RoleUser     = "user"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+	RoleSystem   = "system"
+)
+
+// authorize allows the owner of a resource, the operator running it, admins and the
+// system to act on it
+func authorize(callerId, callerRole, ownerId, operatorId string) error {
+	switch {
+	case callerRole == RoleAdmin || callerRole == RoleSystem:
+		return nil
+	case callerId != "" && callerId == ownerId:
+		return nil
+	case callerRole == RoleOperator && callerId != "" && callerId == operatorId:
+		return nil
+	}
+	return fmt.Errorf("access denied: %s is not allowed to act on this resource", callerId)
+}
+
+// authorizeSession checks that the caller may act on a charging session: its user, the
+// operator of its station, or an admin
+func (c *ChargingContract) authorizeSession(ctx contractapi.TransactionContextInterface, session *ChargingSession, callerId, callerRole string) error {
+	operatorId := ""
+	if callerRole == RoleOperator {
+		station, err := c.GetChargingStation(ctx, session.StationID)
+		if err != nil {
+			return err
+		}
+		operatorId = station.OperatorID
+	}
+	return authorize(callerId, callerRole, session.UserID, operatorId)
+}
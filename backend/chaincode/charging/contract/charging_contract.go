@@ -10,39 +10,55 @@ import (
 
 // ChargingStation represents an EV charging station
 type ChargingStation struct {
-	DocType       string    `json:"docType"`
-	StationID     string    `json:"stationId"`
-	StationNumber string    `json:"stationNumber"`
-	Location      string    `json:"location"`
-	Latitude      float64   `json:"latitude"`
-	Longitude     float64   `json:"longitude"`
-	PowerOutput   int       `json:"powerOutput"` // kW
-	PricePerKwh   float64   `json:"pricePerKwh"`
-	ConnectorType string    `json:"connectorType"` // CCS, CHAdeMO, Type2
-	Status        string    `json:"status"`        // available, in-use, maintenance, out-of-service
-	Features      []string  `json:"features"`
-	OperatorID    string    `json:"operatorId"`
-	CreatedAt     time.Time `json:"createdAt"`
-	UpdatedAt     time.Time `json:"updatedAt"`
+	DocType       string       `json:"docType"`
+	StationID     string       `json:"stationId"`
+	StationNumber string       `json:"stationNumber"`
+	Location      string       `json:"location"`
+	Latitude      float64      `json:"latitude"`
+	Longitude     float64      `json:"longitude"`
+	PowerOutput   int          `json:"powerOutput"` // kW
+	PricePerKwh   float64      `json:"pricePerKwh"`
+	ConnectorType string       `json:"connectorType"` // CCS, CHAdeMO, Type2
+	Status        string       `json:"status"`        // available, in-use, maintenance, out-of-service
+	Features      []string     `json:"features"`
+	Fees          ChargingFees `json:"fees"`
+	OperatorID    string       `json:"operatorId"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	UpdatedAt     time.Time    `json:"updatedAt"`
+
+	// Opening hours, in local time at UTCOffsetMinutes; always open when empty
+	UTCOffsetMinutes int            `json:"utcOffsetMinutes,omitempty" metadata:",optional"`
+	OpeningHours     []OpeningHours `json:"openingHours,omitempty" metadata:",optional"`
 }
 
 // ChargingSession represents an EV charging session
 type ChargingSession struct {
-	DocType        string    `json:"docType"`
-	SessionID      string    `json:"sessionId"`
-	UserID         string    `json:"userId"`
-	StationID      string    `json:"stationId"`
-	StartTime      time.Time `json:"startTime"`
-	EndTime        time.Time `json:"endTime"`
-	Duration       int       `json:"duration"` // minutes
-	EnergyConsumed float64   `json:"energyConsumed"` // kWh
-	PricePerKwh    float64   `json:"pricePerKwh"`
-	CurrentCost    float64   `json:"currentCost"`
-	TotalCost      float64   `json:"totalCost"`
-	Status         string    `json:"status"` // starting, active, completed, cancelled
-	PaymentID      string    `json:"paymentId"`
-	CreatedAt      time.Time `json:"createdAt"`
-	UpdatedAt      time.Time `json:"updatedAt"`
+	DocType         string         `json:"docType"`
+	SessionID       string         `json:"sessionId"`
+	UserID          string         `json:"userId"`
+	StationID       string         `json:"stationId"`
+	StartTime       time.Time      `json:"startTime"`
+	EndTime         time.Time      `json:"endTime"`
+	Duration        int            `json:"duration"`       // minutes
+	EnergyConsumed  float64        `json:"energyConsumed"` // kWh
+	PricePerKwh     float64        `json:"pricePerKwh"`
+	SurgeMultiplier float64        `json:"surgeMultiplier"`
+	EnergySamples   []EnergySample `json:"energySamples,omitempty" metadata:",optional"`
+	Fees            ChargingFees   `json:"fees"`
+	CurrentCost     float64        `json:"currentCost"`
+	LineItems       []LineItem     `json:"lineItems,omitempty" metadata:",optional"`
+	TotalCost       float64        `json:"totalCost"`
+	Status          string         `json:"status"` // starting, active, completed, cancelled, disputed
+	DisputeReason   string         `json:"disputeReason,omitempty" metadata:",optional"`
+	RefundPercent   int            `json:"refundPercent,omitempty" metadata:",optional"`
+	ReadingCount    int            `json:"readingCount"`    // entries in the meter log
+	LastReadingHash string         `json:"lastReadingHash"` // hash of the last meter log entry
+	PaymentID       string         `json:"paymentId"`
+	IdTag           string         `json:"idTag"`         // OCPP sessions only
+	TransactionID   int            `json:"transactionId"` // OCPP sessions only
+	MeterStart      float64        `json:"meterStart"`    // kWh on the station meter at start, OCPP sessions only
+	CreatedAt       time.Time      `json:"createdAt"`
+	UpdatedAt       time.Time      `json:"updatedAt"`
 }
 
 // ChargingContract provides functions for managing charging stations and sessions
@@ -86,12 +102,7 @@ func (c *ChargingContract) CreateChargingStation(ctx contractapi.TransactionCont
 		UpdatedAt:     now,
 	}
 
-	stationJSON, err := json.Marshal(station)
-	if err != nil {
-		return err
-	}
-
-	return ctx.GetStub().PutState(stationId, stationJSON)
+	return c.putStation(ctx, &station)
 }
 
 // GetChargingStation retrieves a charging station by ID
@@ -129,12 +140,30 @@ func (c *ChargingContract) UpdateChargingStation(ctx contractapi.TransactionCont
 	station.ConnectorType = connectorType
 	station.UpdatedAt = time.Now()
 
-	stationJSON, err := json.Marshal(station)
+	return c.putStation(ctx, station)
+}
+
+// SetStationFees sets the session, per-minute and idle fees of a charging station.
+// Sessions keep the fees in force when they started.
+func (c *ChargingContract) SetStationFees(ctx contractapi.TransactionContextInterface, stationId string, sessionFee, perMinuteFee, idleFeePerMinute float64, idleGraceMinutes int) error {
+	if sessionFee < 0 || perMinuteFee < 0 || idleFeePerMinute < 0 || idleGraceMinutes < 0 {
+		return fmt.Errorf("fees and grace period must not be negative")
+	}
+
+	station, err := c.GetChargingStation(ctx, stationId)
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(stationId, stationJSON)
+	station.Fees = ChargingFees{
+		SessionFee:       sessionFee,
+		PerMinuteFee:     perMinuteFee,
+		IdleFeePerMinute: idleFeePerMinute,
+		IdleGraceMinutes: idleGraceMinutes,
+	}
+	station.UpdatedAt = time.Now()
+
+	return c.putStation(ctx, station)
 }
 
 // UpdateStationStatus updates the status of a charging station
@@ -147,12 +176,7 @@ func (c *ChargingContract) UpdateStationStatus(ctx contractapi.TransactionContex
 	station.Status = status
 	station.UpdatedAt = time.Now()
 
-	stationJSON, err := json.Marshal(station)
-	if err != nil {
-		return err
-	}
-
-	return ctx.GetStub().PutState(stationId, stationJSON)
+	return c.putStation(ctx, station)
 }
 
 // DeleteChargingStation marks a charging station as out of service
@@ -191,72 +215,44 @@ func (c *ChargingContract) GetAllChargingStations(ctx contractapi.TransactionCon
 	return stations, nil
 }
 
-// GetAvailableStations returns available charging stations at a location
+// GetAvailableStations returns the charging stations at a location that are available
+// and open now
 func (c *ChargingContract) GetAvailableStations(ctx contractapi.TransactionContextInterface, location string) ([]*ChargingStation, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("station_", "station_~")
+	stations, err := c.stationsByIndex(ctx, stationStatusIndex, []string{"available", location})
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
 
-	stations := make([]*ChargingStation, 0)
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
+	now := time.Now()
+	available := make([]*ChargingStation, 0, len(stations))
+	for _, station := range stations {
+		open, err := c.inService(ctx, station, now, now.Add(time.Minute))
 		if err != nil {
 			return nil, err
 		}
-
-		var station ChargingStation
-		err = json.Unmarshal(queryResponse.Value, &station)
-		if err != nil {
-			continue
-		}
-		
-		if station.DocType == "chargingStation" && station.Location == location && station.Status == "available" {
-			stations = append(stations, &station)
+		if open {
+			available = append(available, station)
 		}
 	}
 
-	return stations, nil
+	return available, nil
 }
 
 // QueryStationsByLocation returns stations by location
 func (c *ChargingContract) QueryStationsByLocation(ctx contractapi.TransactionContextInterface, location string) ([]*ChargingStation, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("station_", "station_~")
-	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
-
-	stations := make([]*ChargingStation, 0)
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
-		var station ChargingStation
-		err = json.Unmarshal(queryResponse.Value, &station)
-		if err != nil {
-			continue
-		}
-		
-		if station.DocType == "chargingStation" && station.Location == location {
-			stations = append(stations, &station)
-		}
-	}
-
-	return stations, nil
+	return c.stationsByIndex(ctx, stationLocationIndex, []string{location})
 }
 
 // QueryStationsByPowerOutput returns stations within a power output range
 func (c *ChargingContract) QueryStationsByPowerOutput(ctx contractapi.TransactionContextInterface, minPower, maxPower int) ([]*ChargingStation, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("station_", "station_~")
+	// The power index sorts by power, so stop at the first station above the range
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(stationPowerIndex, []string{})
 	if err != nil {
 		return nil, err
 	}
 	defer resultsIterator.Close()
 
+	minKey, maxKey := powerKey(minPower), powerKey(maxPower)
 	stations := make([]*ChargingStation, 0)
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
@@ -264,49 +260,32 @@ func (c *ChargingContract) QueryStationsByPowerOutput(ctx contractapi.Transactio
 			return nil, err
 		}
 
-		var station ChargingStation
-		err = json.Unmarshal(queryResponse.Value, &station)
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
 		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) < 2 || compositeKeyParts[0] < minKey {
 			continue
 		}
-		
-		if station.DocType == "chargingStation" && station.PowerOutput >= minPower && station.PowerOutput <= maxPower {
-			stations = append(stations, &station)
+		if compositeKeyParts[0] > maxKey {
+			break
 		}
-	}
-
-	return stations, nil
-}
-
-// QueryStationsByConnectorType returns stations by connector type
-func (c *ChargingContract) QueryStationsByConnectorType(ctx contractapi.TransactionContextInterface, connectorType string) ([]*ChargingStation, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("station_", "station_~")
-	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
 
-	stations := make([]*ChargingStation, 0)
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
+		station, err := c.GetChargingStation(ctx, compositeKeyParts[1])
 		if err != nil {
 			return nil, err
 		}
-
-		var station ChargingStation
-		err = json.Unmarshal(queryResponse.Value, &station)
-		if err != nil {
-			continue
-		}
-		
-		if station.DocType == "chargingStation" && station.ConnectorType == connectorType {
-			stations = append(stations, &station)
-		}
+		stations = append(stations, station)
 	}
 
 	return stations, nil
 }
 
+// QueryStationsByConnectorType returns stations by connector type
+func (c *ChargingContract) QueryStationsByConnectorType(ctx contractapi.TransactionContextInterface, connectorType string) ([]*ChargingStation, error) {
+	return c.stationsByIndex(ctx, stationConnectorIndex, []string{connectorType})
+}
+
 // StationExists checks if a charging station exists
 func (c *ChargingContract) StationExists(ctx contractapi.TransactionContextInterface, stationId string) (bool, error) {
 	stationJSON, err := ctx.GetStub().GetState(stationId)
@@ -320,44 +299,78 @@ func (c *ChargingContract) StationExists(ctx contractapi.TransactionContextInter
 
 // CreateChargingSession creates a new charging session
 func (c *ChargingContract) CreateChargingSession(ctx contractapi.TransactionContextInterface, sessionId, userId, stationId string) error {
+	session, err := c.newSession(ctx, sessionId, userId, stationId)
+	if err != nil {
+		return err
+	}
+
+	return c.startSession(ctx, session)
+}
+
+// newSession prepares an active session on an available station
+func (c *ChargingContract) newSession(ctx contractapi.TransactionContextInterface, sessionId, userId, stationId string) (*ChargingSession, error) {
+	existing, err := ctx.GetStub().GetState(sessionId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("charging session %s already exists", sessionId)
+	}
+
 	// Verify station exists and is available
 	station, err := c.GetChargingStation(ctx, stationId)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if station.Status != "available" {
-		return fmt.Errorf("charging station %s is not available", stationId)
+		return nil, fmt.Errorf("charging station %s is not available", stationId)
 	}
-
 	now := time.Now()
-	session := ChargingSession{
-		DocType:        "chargingSession",
-		SessionID:      sessionId,
-		UserID:         userId,
-		StationID:      stationId,
-		StartTime:      now,
-		Duration:       0,
-		EnergyConsumed: 0,
-		PricePerKwh:    station.PricePerKwh,
-		CurrentCost:    0,
-		TotalCost:      0,
-		Status:         "active",
-		CreatedAt:      now,
-		UpdatedAt:      now,
-	}
-
-	sessionJSON, err := json.Marshal(session)
+	if err := c.checkInService(ctx, station, now, now.Add(time.Minute)); err != nil {
+		return nil, err
+	}
+
+	// Demand-based surge is fixed by the occupancy of the location when charging starts
+	schedule, err := c.newTariffSchedule(ctx, station, station.PricePerKwh)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	occupancy, err := c.locationOccupancy(ctx, station.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	session := ChargingSession{
+		DocType:         "chargingSession",
+		SessionID:       sessionId,
+		UserID:          userId,
+		StationID:       stationId,
+		StartTime:       now,
+		Duration:        0,
+		EnergyConsumed:  0,
+		PricePerKwh:     station.PricePerKwh,
+		SurgeMultiplier: schedule.surgeMultiplier(now, occupancy),
+		EnergySamples:   []EnergySample{},
+		Fees:            station.Fees,
+		CurrentCost:     0,
+		TotalCost:       0,
+		Status:          "active",
+		CreatedAt:       now,
+		UpdatedAt:       now,
 	}
 
+	return &session, nil
+}
+
+// startSession saves a new session and marks its station in use
+func (c *ChargingContract) startSession(ctx contractapi.TransactionContextInterface, session *ChargingSession) error {
 	// Update station status to in-use
-	err = c.UpdateStationStatus(ctx, stationId, "in-use")
+	err := c.UpdateStationStatus(ctx, session.StationID, "in-use")
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(sessionId, sessionJSON)
+	return c.putSession(ctx, session)
 }
 
 // GetChargingSession retrieves a charging session by ID
@@ -379,63 +392,116 @@ func (c *ChargingContract) GetChargingSession(ctx contractapi.TransactionContext
 	return &session, nil
 }
 
-// UpdateSessionProgress updates the progress of a charging session
-func (c *ChargingContract) UpdateSessionProgress(ctx contractapi.TransactionContextInterface, sessionId string, energyConsumed float64) error {
+// UpdateSessionProgress records a meter reading of a charging session. A reading that
+// decreases or exceeds what the station can deliver is logged as rejected, and the
+// session is flagged as disputed instead of billed. Only the session's user, the
+// operator of its station, an admin or the system may report readings.
+func (c *ChargingContract) UpdateSessionProgress(ctx contractapi.TransactionContextInterface, sessionId string, energyConsumed float64, callerId string, callerRole string) (*MeterReading, error) {
 	session, err := c.GetChargingSession(ctx, sessionId)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	err = c.authorizeSession(ctx, session, callerId, callerRole)
+	if err != nil {
+		return nil, err
 	}
 
 	if session.Status != "active" {
-		return fmt.Errorf("session %s is not active", sessionId)
+		return nil, fmt.Errorf("session %s is not active", sessionId)
 	}
 
 	now := time.Now()
-	session.EnergyConsumed = energyConsumed
-	session.CurrentCost = energyConsumed * session.PricePerKwh
-	session.Duration = int(now.Sub(session.StartTime).Minutes())
-	session.UpdatedAt = now
+	reading, err := c.recordReading(ctx, session, energyConsumed, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if reading.Accepted {
+		quote, err := c.quoteSession(ctx, session, energyConsumed, now)
+		if err != nil {
+			return nil, err
+		}
+
+		session.EnergyConsumed = energyConsumed
+		session.EnergySamples = append(session.EnergySamples, EnergySample{Time: now, Energy: energyConsumed})
+		session.CurrentCost = quote.TotalCost
+		session.Duration = int(now.Sub(session.StartTime).Minutes())
+	}
 
-	sessionJSON, err := json.Marshal(session)
+	err = c.putSession(ctx, session)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return ctx.GetStub().PutState(sessionId, sessionJSON)
+	return reading, nil
 }
 
-// StopChargingSession stops a charging session
-func (c *ChargingContract) StopChargingSession(ctx contractapi.TransactionContextInterface, sessionId string, totalEnergy float64, paymentId string) (*ChargingSession, error) {
+// StopChargingSession stops a charging session. The price is computed by the ledger at
+// endTime, the end time of the quote paid for, and paidAmount, the amount charged by
+// paymentId, must match it. The caller must be the session's user, the station's operator,
+// an admin or the system.
+func (c *ChargingContract) StopChargingSession(ctx contractapi.TransactionContextInterface, sessionId string, totalEnergy, paidAmount float64, paymentId, endTime, callerId, callerRole string) (*ChargingSession, error) {
 	session, err := c.GetChargingSession(ctx, sessionId)
 	if err != nil {
 		return nil, err
 	}
 
+	err = c.authorizeSession(ctx, session, callerId, callerRole)
+	if err != nil {
+		return nil, err
+	}
+
 	if session.Status != "active" {
 		return nil, fmt.Errorf("session %s is not active", sessionId)
 	}
 
 	now := time.Now()
-	session.EndTime = now
-	session.EnergyConsumed = totalEnergy
-	session.TotalCost = totalEnergy * session.PricePerKwh
-	session.Duration = int(now.Sub(session.StartTime).Minutes())
-	session.Status = "completed"
-	session.PaymentID = paymentId
-	session.UpdatedAt = now
+	end, err := quotedEndTime(session, endTime, now)
+	if err != nil {
+		return nil, err
+	}
+
+	reading, err := c.recordReading(ctx, session, totalEnergy, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// A rejected final reading leaves the session disputed and unpaid
+	if !reading.Accepted {
+		err = c.putSession(ctx, session)
+		if err != nil {
+			return nil, err
+		}
+		return session, nil
+	}
 
-	sessionJSON, err := json.Marshal(session)
+	quote, err := c.quoteSession(ctx, session, totalEnergy, end)
+	if err != nil {
+		return nil, err
+	}
+	err = checkPaidAmount(paidAmount, quote.TotalCost)
 	if err != nil {
 		return nil, err
 	}
 
+	session.EndTime = end
+	session.EnergyConsumed = totalEnergy
+	session.EnergySamples = append(session.EnergySamples, EnergySample{Time: end, Energy: totalEnergy})
+	session.LineItems = quote.LineItems
+	session.TotalCost = quote.TotalCost
+	session.Duration = int(end.Sub(session.StartTime).Minutes())
+	session.Status = "completed"
+	session.PaymentID = paymentId
+	session.UpdatedAt = now
+
 	// Update station status to available
 	err = c.UpdateStationStatus(ctx, session.StationID, "available")
 	if err != nil {
 		return nil, err
 	}
 
-	err = ctx.GetStub().PutState(sessionId, sessionJSON)
+	err = c.putSession(ctx, session)
 	if err != nil {
 		return nil, err
 	}
@@ -443,146 +509,65 @@ func (c *ChargingContract) StopChargingSession(ctx contractapi.TransactionContex
 	return session, nil
 }
 
-// CancelSession cancels a charging session
-func (c *ChargingContract) CancelSession(ctx contractapi.TransactionContextInterface, sessionId string) error {
+// CancelSession cancels a charging session on behalf of the caller and records the
+// percent of its cost to refund, according to the refund policy
+func (c *ChargingContract) CancelSession(ctx contractapi.TransactionContextInterface, sessionId, callerId, callerRole string) error {
 	session, err := c.GetChargingSession(ctx, sessionId)
 	if err != nil {
 		return err
 	}
 
+	if err := c.authorizeSession(ctx, session, callerId, callerRole); err != nil {
+		return err
+	}
+
 	if session.Status == "completed" || session.Status == "cancelled" {
 		return fmt.Errorf("session %s cannot be cancelled", sessionId)
 	}
 
+	policy, err := c.GetRefundPolicy(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Disputed sessions already released their station
+	releaseStation := session.Status != "disputed"
+
 	now := time.Now()
 	session.EndTime = now
 	session.Status = "cancelled"
+	session.RefundPercent = policy.refundPercent(session.StartTime.Sub(now))
 	session.UpdatedAt = now
 
-	sessionJSON, err := json.Marshal(session)
-	if err != nil {
-		return err
-	}
-
 	// Update station status to available
-	err = c.UpdateStationStatus(ctx, session.StationID, "available")
-	if err != nil {
-		return err
+	if releaseStation {
+		err = c.UpdateStationStatus(ctx, session.StationID, "available")
+		if err != nil {
+			return err
+		}
 	}
 
-	return ctx.GetStub().PutState(sessionId, sessionJSON)
+	return c.putSession(ctx, session)
 }
 
 // GetUserSessions returns all charging sessions for a user
 func (c *ChargingContract) GetUserSessions(ctx contractapi.TransactionContextInterface, userId string) ([]*ChargingSession, error) {
-	queryString := fmt.Sprintf(`{"selector":{"docType":"chargingSession","userId":"%s"}}`, userId)
-	
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
-	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
-
-	sessions := make([]*ChargingSession, 0)
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
-		var session ChargingSession
-		err = json.Unmarshal(queryResponse.Value, &session)
-		if err != nil {
-			return nil, err
-		}
-		sessions = append(sessions, &session)
-	}
-
-	return sessions, nil
+	return c.sessionsByIndex(ctx, userSessionIndex, []string{userId})
 }
 
 // GetStationSessions returns all sessions for a charging station
 func (c *ChargingContract) GetStationSessions(ctx contractapi.TransactionContextInterface, stationId string) ([]*ChargingSession, error) {
-	queryString := fmt.Sprintf(`{"selector":{"docType":"chargingSession","stationId":"%s"}}`, stationId)
-	
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
-	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
-
-	sessions := make([]*ChargingSession, 0)
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
-		var session ChargingSession
-		err = json.Unmarshal(queryResponse.Value, &session)
-		if err != nil {
-			return nil, err
-		}
-		sessions = append(sessions, &session)
-	}
-
-	return sessions, nil
+	return c.sessionsByIndex(ctx, stationSessionIndex, []string{stationId})
 }
 
 // GetActiveSessions returns active sessions for a user
 func (c *ChargingContract) GetActiveSessions(ctx contractapi.TransactionContextInterface, userId string) ([]*ChargingSession, error) {
-	queryString := fmt.Sprintf(`{"selector":{"docType":"chargingSession","userId":"%s","status":"active"}}`, userId)
-	
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
-	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
-
-	sessions := make([]*ChargingSession, 0)
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
-		var session ChargingSession
-		err = json.Unmarshal(queryResponse.Value, &session)
-		if err != nil {
-			return nil, err
-		}
-		sessions = append(sessions, &session)
-	}
-
-	return sessions, nil
+	return c.sessionsByStatus(ctx, userId, "active")
 }
 
 // GetSessionHistory returns completed/cancelled sessions for a user
 func (c *ChargingContract) GetSessionHistory(ctx contractapi.TransactionContextInterface, userId string) ([]*ChargingSession, error) {
-	queryString := fmt.Sprintf(`{"selector":{"docType":"chargingSession","userId":"%s","status":{"$in":["completed","cancelled"]}}}`, userId)
-	
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
-	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
-
-	sessions := make([]*ChargingSession, 0)
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
-		var session ChargingSession
-		err = json.Unmarshal(queryResponse.Value, &session)
-		if err != nil {
-			return nil, err
-		}
-		sessions = append(sessions, &session)
-	}
-
-	return sessions, nil
+	return c.sessionsByStatus(ctx, userId, "completed", "cancelled")
 }
 
 // GetTotalEnergyConsumed returns total energy consumed by a user
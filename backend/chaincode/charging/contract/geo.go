@@ -0,0 +1,263 @@
+package contract
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// stationGeoIndex lists stations by geohash cell. A station has an entry for its cell
+// at each precision from geohashMinPrecision to geohashMaxPrecision, so that a search
+// reads the cells of the precision matching its radius.
+const stationGeoIndex = "geohash~stationId"
+
+const (
+	geohashMinPrecision = 4 // cells of about 39 x 19.5 km
+	geohashMaxPrecision = 7 // cells of about 153 x 153 m
+	// maxSearchRadius is the largest radius, in meters, the cells of the minimum
+	// precision cover at mid latitudes
+	maxSearchRadius = 10000
+	earthRadius     = 6371000 // meters
+	metersPerDegree = earthRadius * math.Pi / 180
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// NearbyStationFilter selects stations in a nearby search. Stations are not reserved
+// ahead: without a time window only stations available and open now match; with one,
+// stations in service and open for the whole window.
+type NearbyStationFilter struct {
+	ConnectorType string `json:"connectorType,omitempty" metadata:",optional"`
+	MinPower      int    `json:"minPower,omitempty" metadata:",optional"` // kW
+	From          string `json:"from,omitempty" metadata:",optional"`     // RFC3339
+	To            string `json:"to,omitempty" metadata:",optional"`       // RFC3339
+}
+
+// NearbyStation is a station found by a nearby search and its distance from the search
+// point
+type NearbyStation struct {
+	Station  *ChargingStation `json:"station"`
+	Distance float64          `json:"distance"` // meters
+}
+
+// ==================== Nearby Search ====================
+
+// FindNearbyStations returns up to limit stations within radius meters of a point that
+// match the filter, nearest first
+func (c *ChargingContract) FindNearbyStations(ctx contractapi.TransactionContextInterface, latitude, longitude, radius float64, filter NearbyStationFilter, limit int) ([]*NearbyStation, error) {
+	if err := checkSearchArea(latitude, longitude, radius); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	from, to := now, now.Add(time.Minute)
+	hasWindow := filter.From != "" || filter.To != ""
+	if hasWindow {
+		var err error
+		from, to, err = parseWindow(filter.From, filter.To)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stationIds := make(map[string]bool)
+	for _, cell := range geohashCells(latitude, longitude, radius) {
+		ids, err := indexedIDs(ctx, stationGeoIndex, []string{cell})
+		if err != nil {
+			return nil, err
+		}
+		for _, stationId := range ids {
+			stationIds[stationId] = true
+		}
+	}
+
+	nearby := make([]*NearbyStation, 0)
+	for stationId := range stationIds {
+		station, err := c.GetChargingStation(ctx, stationId)
+		if err != nil {
+			return nil, err
+		}
+		distance := distanceMeters(latitude, longitude, station.Latitude, station.Longitude)
+		if distance > radius {
+			continue
+		}
+		if filter.ConnectorType != "" && station.ConnectorType != filter.ConnectorType {
+			continue
+		}
+		if station.PowerOutput < filter.MinPower {
+			continue
+		}
+
+		if !hasWindow && station.Status != "available" {
+			continue
+		}
+		if station.Status == "maintenance" || station.Status == "out-of-service" {
+			continue
+		}
+		open, err := c.inService(ctx, station, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if !open {
+			continue
+		}
+
+		nearby = append(nearby, &NearbyStation{Station: station, Distance: math.Round(distance)})
+	}
+
+	sort.Slice(nearby, func(i, j int) bool {
+		if nearby[i].Distance != nearby[j].Distance {
+			return nearby[i].Distance < nearby[j].Distance
+		}
+		return nearby[i].Station.StationID < nearby[j].Station.StationID
+	})
+	if limit := clampPageSize(limit); len(nearby) > limit {
+		nearby = nearby[:limit]
+	}
+
+	return nearby, nil
+}
+
+// geoIndexEntries returns the geohash index entries of a record at a position
+func geoIndexEntries(index string, latitude, longitude float64, id string) []indexEntry {
+	hash := geohash(latitude, longitude, geohashMaxPrecision)
+	entries := make([]indexEntry, 0, geohashMaxPrecision-geohashMinPrecision+1)
+	for precision := geohashMinPrecision; precision <= geohashMaxPrecision; precision++ {
+		entries = append(entries, indexEntry{index, []string{hash[:precision], id}})
+	}
+	return entries
+}
+
+// checkSearchArea validates the center and radius of a nearby search
+func checkSearchArea(latitude, longitude, radius float64) error {
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+		return fmt.Errorf("invalid coordinates %f, %f", latitude, longitude)
+	}
+	if radius <= 0 || radius > maxSearchRadius {
+		return fmt.Errorf("radius must be between 0 and %d meters", maxSearchRadius)
+	}
+	return nil
+}
+
+// parseWindow parses an RFC3339 time range and checks that it is not empty
+func parseWindow(startStr, endStr string) (time.Time, time.Time, error) {
+	start, err := time.Parse(time.RFC3339, startStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start time format: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339, endStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end time format: %v", err)
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end time must be after start time")
+	}
+	return start, end, nil
+}
+
+// geohashCells returns the cell containing a point and its eight neighbours, at the
+// finest precision whose cells are at least radius meters high and wide there, so that
+// together they cover every point within radius
+func geohashCells(latitude, longitude, radius float64) []string {
+	precision := geohashMinPrecision
+	for p := geohashMaxPrecision; p > geohashMinPrecision; p-- {
+		height, width := cellSize(p)
+		if height*metersPerDegree >= radius && width*metersPerDegree*math.Cos(latitude*math.Pi/180) >= radius {
+			precision = p
+			break
+		}
+	}
+
+	latBits, lngBits := geohashBits(precision)
+	latCells, lngCells := int64(1)<<latBits, int64(1)<<lngBits
+	latIdx, lngIdx := cellIndex(latitude, longitude, precision)
+
+	seen := make(map[string]bool)
+	var cells []string
+	for dLat := int64(-1); dLat <= 1; dLat++ {
+		row := latIdx + dLat
+		if row < 0 || row >= latCells {
+			continue
+		}
+		for dLng := int64(-1); dLng <= 1; dLng++ {
+			column := (lngIdx + dLng + lngCells) % lngCells
+			cell := geohashOfCell(row, column, precision)
+			if !seen[cell] {
+				seen[cell] = true
+				cells = append(cells, cell)
+			}
+		}
+	}
+	return cells
+}
+
+// geohash encodes a position as a geohash of the given precision
+func geohash(latitude, longitude float64, precision int) string {
+	latIdx, lngIdx := cellIndex(latitude, longitude, precision)
+	return geohashOfCell(latIdx, lngIdx, precision)
+}
+
+// cellIndex returns the row and column of the cell of a position in the grid of a
+// geohash precision
+func cellIndex(latitude, longitude float64, precision int) (int64, int64) {
+	latBits, lngBits := geohashBits(precision)
+	latCells, lngCells := int64(1)<<latBits, int64(1)<<lngBits
+
+	latIdx := int64(math.Floor((latitude + 90) / 180 * float64(latCells)))
+	lngIdx := int64(math.Floor((longitude + 180) / 360 * float64(lngCells)))
+	if latIdx >= latCells {
+		latIdx = latCells - 1
+	}
+	if latIdx < 0 {
+		latIdx = 0
+	}
+	return latIdx, (lngIdx%lngCells + lngCells) % lngCells
+}
+
+// geohashOfCell encodes a grid cell, interleaving the bits of its column and row,
+// longitude first
+func geohashOfCell(latIdx, lngIdx int64, precision int) string {
+	latBits, lngBits := geohashBits(precision)
+	var hash int64
+	for bit := 0; bit < 5*precision; bit++ {
+		hash <<= 1
+		if bit%2 == 0 {
+			lngBits--
+			hash |= (lngIdx >> lngBits) & 1
+		} else {
+			latBits--
+			hash |= (latIdx >> latBits) & 1
+		}
+	}
+
+	encoded := make([]byte, precision)
+	for i := precision - 1; i >= 0; i-- {
+		encoded[i] = geohashAlphabet[hash&31]
+		hash >>= 5
+	}
+	return string(encoded)
+}
+
+// geohashBits returns the number of latitude and longitude bits of a geohash precision
+func geohashBits(precision int) (uint, uint) {
+	bits := uint(5 * precision)
+	return bits / 2, (bits + 1) / 2
+}
+
+// cellSize returns the height and width, in degrees, of the cells of a geohash precision
+func cellSize(precision int) (float64, float64) {
+	latBits, lngBits := geohashBits(precision)
+	return 180 / float64(int64(1)<<latBits), 360 / float64(int64(1)<<lngBits)
+}
+
+// distanceMeters returns the great-circle distance between two positions
+func distanceMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	toRadians := math.Pi / 180
+	dLat := (lat2 - lat1) * toRadians
+	dLng := (lng2 - lng1) * toRadians
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRadians)*math.Cos(lat2*toRadians)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
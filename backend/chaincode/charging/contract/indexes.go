@@ -0,0 +1,330 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Composite-key indexes of stations and sessions. An entry's key holds the attributes
+// the record is looked up by, ending with its ID, and its value is empty; queries walk
+// an index and read only the records it lists. putStation and putSession keep them in
+// step with every write.
+const (
+	stationLocationIndex  = "location~stationId"
+	stationStatusIndex    = "status~location~stationId"
+	stationConnectorIndex = "connectorType~stationId"
+	stationPowerIndex     = "powerOutput~stationId" // kW, zero-padded to sort numerically
+	userSessionIndex      = "userId~sessionId"
+	userStatusIndex       = "userId~status~sessionId"
+	stationSessionIndex   = "stationId~sessionId"
+)
+
+// powerKeyWidth is the width power outputs are zero-padded to in the power index
+const powerKeyWidth = 6
+
+// ReindexResult reports the progress of a reindex run
+type ReindexResult struct {
+	Scanned  int    `json:"scanned"`
+	Stations int    `json:"stations"`
+	Sessions int    `json:"sessions"`
+	Bookmark string `json:"bookmark"` // empty when the last page was indexed
+}
+
+// ==================== Indexes ====================
+
+// ReindexCharging writes the index entries of stations and sessions created before the
+// indexes existed. Records are scanned a page at a time; call again with the returned
+// bookmark until it is empty. Entries are rewritten as they are, so runs can be
+// repeated safely.
+func (c *ChargingContract) ReindexCharging(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*ReindexResult, error) {
+	if pageSize < 1 || pageSize > 1000 {
+		pageSize = 100
+	}
+
+	// Paginated range queries are only allowed in read-only transactions, so pages are
+	// walked with a plain range query starting at the bookmark key. Range queries skip
+	// composite keys, so only records are scanned.
+	resultsIterator, err := ctx.GetStub().GetStateByRange(bookmark, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &ReindexResult{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if result.Scanned == pageSize {
+			result.Bookmark = queryResponse.Key
+			break
+		}
+		result.Scanned++
+
+		var doc struct {
+			DocType string `json:"docType"`
+		}
+		if err := json.Unmarshal(queryResponse.Value, &doc); err != nil {
+			continue
+		}
+
+		var keys []string
+		switch doc.DocType {
+		case "chargingStation":
+			var station ChargingStation
+			if err := json.Unmarshal(queryResponse.Value, &station); err != nil {
+				continue
+			}
+			keys, err = stationIndexKeys(ctx, &station)
+			result.Stations++
+		case "chargingSession":
+			var session ChargingSession
+			if err := json.Unmarshal(queryResponse.Value, &session); err != nil {
+				continue
+			}
+			keys, err = sessionIndexKeys(ctx, &session)
+			result.Sessions++
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := updateIndexes(ctx, nil, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// putStation saves a station and moves its index entries if an indexed attribute changed
+func (c *ChargingContract) putStation(ctx contractapi.TransactionContextInterface, station *ChargingStation) error {
+	var oldKeys []string
+	previousJSON, err := ctx.GetStub().GetState(station.StationID)
+	if err != nil {
+		return err
+	}
+	if previousJSON != nil {
+		var previous ChargingStation
+		if err := json.Unmarshal(previousJSON, &previous); err == nil && previous.DocType == "chargingStation" {
+			oldKeys, err = stationIndexKeys(ctx, &previous)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	newKeys, err := stationIndexKeys(ctx, station)
+	if err != nil {
+		return err
+	}
+
+	stationJSON, err := json.Marshal(station)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(station.StationID, stationJSON); err != nil {
+		return err
+	}
+
+	return updateIndexes(ctx, oldKeys, newKeys)
+}
+
+// putSession saves a session and moves its index entries if an indexed attribute changed
+func (c *ChargingContract) putSession(ctx contractapi.TransactionContextInterface, session *ChargingSession) error {
+	var oldKeys []string
+	previousJSON, err := ctx.GetStub().GetState(session.SessionID)
+	if err != nil {
+		return err
+	}
+	if previousJSON != nil {
+		var previous ChargingSession
+		if err := json.Unmarshal(previousJSON, &previous); err == nil && previous.DocType == "chargingSession" {
+			oldKeys, err = sessionIndexKeys(ctx, &previous)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	newKeys, err := sessionIndexKeys(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(session.SessionID, sessionJSON); err != nil {
+		return err
+	}
+
+	return updateIndexes(ctx, oldKeys, newKeys)
+}
+
+// stationsByIndex returns the stations listed under a partial key of a station index,
+// in index order
+func (c *ChargingContract) stationsByIndex(ctx contractapi.TransactionContextInterface, index string, attributes []string) ([]*ChargingStation, error) {
+	ids, err := indexedIDs(ctx, index, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	stations := make([]*ChargingStation, 0, len(ids))
+	for _, stationId := range ids {
+		station, err := c.GetChargingStation(ctx, stationId)
+		if err != nil {
+			return nil, err
+		}
+		stations = append(stations, station)
+	}
+
+	return stations, nil
+}
+
+// sessionsByIndex returns the sessions listed under a partial key of a session index,
+// in index order
+func (c *ChargingContract) sessionsByIndex(ctx contractapi.TransactionContextInterface, index string, attributes []string) ([]*ChargingSession, error) {
+	ids, err := indexedIDs(ctx, index, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*ChargingSession, 0, len(ids))
+	for _, sessionId := range ids {
+		session, err := c.GetChargingSession(ctx, sessionId)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// sessionsByStatus returns the sessions of a user in any of the given statuses
+func (c *ChargingContract) sessionsByStatus(ctx contractapi.TransactionContextInterface, userId string, statuses ...string) ([]*ChargingSession, error) {
+	sessions := make([]*ChargingSession, 0)
+	for _, status := range statuses {
+		matching, err := c.sessionsByIndex(ctx, userStatusIndex, []string{userId, status})
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, matching...)
+	}
+
+	return sessions, nil
+}
+
+// indexedIDs returns the record IDs, the last attribute of each entry, listed under a
+// partial key of an index
+func indexedIDs(ctx contractapi.TransactionContextInterface, index string, attributes []string) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var ids []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) == 0 {
+			continue
+		}
+		ids = append(ids, compositeKeyParts[len(compositeKeyParts)-1])
+	}
+
+	return ids, nil
+}
+
+// stationIndexKeys returns the index entries of a station
+func stationIndexKeys(ctx contractapi.TransactionContextInterface, station *ChargingStation) ([]string, error) {
+	entries := []indexEntry{
+		{stationLocationIndex, []string{station.Location, station.StationID}},
+		{stationStatusIndex, []string{station.Status, station.Location, station.StationID}},
+		{stationConnectorIndex, []string{station.ConnectorType, station.StationID}},
+		{stationPowerIndex, []string{powerKey(station.PowerOutput), station.StationID}},
+	}
+	entries = append(entries, geoIndexEntries(stationGeoIndex, station.Latitude, station.Longitude, station.StationID)...)
+	return compositeKeys(ctx, entries)
+}
+
+// sessionIndexKeys returns the index entries of a session
+func sessionIndexKeys(ctx contractapi.TransactionContextInterface, session *ChargingSession) ([]string, error) {
+	return compositeKeys(ctx, []indexEntry{
+		{userSessionIndex, []string{session.UserID, session.SessionID}},
+		{userStatusIndex, []string{session.UserID, session.Status, session.SessionID}},
+		{stationSessionIndex, []string{session.StationID, session.SessionID}},
+	})
+}
+
+// indexEntry is the entry of a record in one index
+type indexEntry struct {
+	index      string
+	attributes []string
+}
+
+func compositeKeys(ctx contractapi.TransactionContextInterface, entries []indexEntry) ([]string, error) {
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		key, err := ctx.GetStub().CreateCompositeKey(entry.index, entry.attributes)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// updateIndexes deletes the entries of oldKeys that are not in newKeys and writes those
+// of newKeys that are not in oldKeys
+func updateIndexes(ctx contractapi.TransactionContextInterface, oldKeys, newKeys []string) error {
+	previous := make(map[string]bool, len(oldKeys))
+	for _, key := range oldKeys {
+		previous[key] = true
+	}
+	current := make(map[string]bool, len(newKeys))
+	for _, key := range newKeys {
+		current[key] = true
+	}
+
+	for _, key := range oldKeys {
+		if current[key] {
+			continue
+		}
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return err
+		}
+	}
+	for _, key := range newKeys {
+		if previous[key] {
+			continue
+		}
+		if err := ctx.GetStub().PutState(key, []byte{0x00}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// powerKey encodes a power output as zero-padded kW, so that the entries of the power
+// index sort by power
+func powerKey(power int) string {
+	if power < 0 {
+		power = 0
+	}
+	return fmt.Sprintf("%0*d", powerKeyWidth, power)
+}
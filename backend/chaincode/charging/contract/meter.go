@@ -0,0 +1,216 @@
+package contract
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// meterReadingIndex keys the meter readings of a session by sequence number
+const meterReadingIndex = "sessionId~sequence"
+
+const (
+	// meterTolerance allows readings slightly above the station's rated power
+	meterTolerance = 1.1
+	// meterSlackKwh absorbs meter rounding on short intervals
+	meterSlackKwh = 0.05
+)
+
+// MeterReading is an entry of a session's meter log. Each entry is bound to the
+// transaction and client identity that submitted it, and chained to the previous
+// entry by its hash so that the log cannot be altered after the fact.
+type MeterReading struct {
+	DocType      string    `json:"docType"`
+	SessionID    string    `json:"sessionId"`
+	Sequence     int       `json:"sequence"`
+	Energy       float64   `json:"energy"` // kWh since the session started
+	Time         time.Time `json:"time"`
+	TxID         string    `json:"txId"`
+	SubmitterMSP string    `json:"submitterMsp"`
+	SubmitterID  string    `json:"submitterId"`
+	Accepted     bool      `json:"accepted"`
+	Reason       string    `json:"reason,omitempty" metadata:",optional"` // why the reading was rejected
+	PreviousHash string    `json:"previousHash"`
+	Hash         string    `json:"hash"`
+}
+
+// ==================== Meter Readings ====================
+
+// GetMeterReadings returns the meter log of a session in order
+func (c *ChargingContract) GetMeterReadings(ctx contractapi.TransactionContextInterface, sessionId string) ([]*MeterReading, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(meterReadingIndex, []string{sessionId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	readings := []*MeterReading{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var reading MeterReading
+		err = json.Unmarshal(queryResponse.Value, &reading)
+		if err != nil {
+			return nil, err
+		}
+		readings = append(readings, &reading)
+	}
+
+	return readings, nil
+}
+
+// VerifyMeterReadings checks the hash chain of a session's meter log
+func (c *ChargingContract) VerifyMeterReadings(ctx contractapi.TransactionContextInterface, sessionId string) error {
+	session, err := c.GetChargingSession(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+
+	readings, err := c.GetMeterReadings(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	if len(readings) != session.ReadingCount {
+		return fmt.Errorf("meter log has %d readings, session recorded %d", len(readings), session.ReadingCount)
+	}
+
+	previousHash := ""
+	for i, reading := range readings {
+		if reading.Sequence != i+1 {
+			return fmt.Errorf("meter reading %d is out of sequence", reading.Sequence)
+		}
+		if reading.PreviousHash != previousHash || reading.Hash != reading.computeHash() {
+			return fmt.Errorf("meter reading %d does not match the chain", reading.Sequence)
+		}
+		previousHash = reading.Hash
+	}
+
+	if previousHash != session.LastReadingHash {
+		return fmt.Errorf("meter log does not end at the session's last reading")
+	}
+	return nil
+}
+
+// recordReading validates a reading, appends it to the session's meter log and
+// returns it. A rejected reading flags the session as disputed and ends it, so
+// that it is not billed until an operator reviews it.
+func (c *ChargingContract) recordReading(ctx contractapi.TransactionContextInterface, session *ChargingSession, energy float64, now time.Time) (*MeterReading, error) {
+	station, err := c.GetChargingStation(ctx, session.StationID)
+	if err != nil {
+		return nil, err
+	}
+
+	mspId, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get submitter MSP: %v", err)
+	}
+	submitterId, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get submitter identity: %v", err)
+	}
+
+	reading := &MeterReading{
+		DocType:      "meterReading",
+		SessionID:    session.SessionID,
+		Sequence:     session.ReadingCount + 1,
+		Energy:       energy,
+		Time:         now,
+		TxID:         ctx.GetStub().GetTxID(),
+		SubmitterMSP: mspId,
+		SubmitterID:  submitterId,
+		Accepted:     true,
+		PreviousHash: session.LastReadingHash,
+	}
+	if err := validateReading(session, station, energy, now); err != nil {
+		reading.Accepted = false
+		reading.Reason = err.Error()
+	}
+	reading.Hash = reading.computeHash()
+
+	readingKey, err := ctx.GetStub().CreateCompositeKey(meterReadingIndex, []string{session.SessionID, fmt.Sprintf("%08d", reading.Sequence)})
+	if err != nil {
+		return nil, err
+	}
+	readingJSON, err := json.Marshal(reading)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(readingKey, readingJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	session.ReadingCount = reading.Sequence
+	session.LastReadingHash = reading.Hash
+	session.UpdatedAt = now
+
+	if !reading.Accepted {
+		session.Status = "disputed"
+		session.DisputeReason = reading.Reason
+		session.EndTime = now
+		session.Duration = int(now.Sub(session.StartTime).Minutes())
+
+		err = c.UpdateStationStatus(ctx, session.StationID, "available")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return reading, nil
+}
+
+// validateReading rejects readings below the last accepted one and readings the
+// station cannot have delivered since then at its rated power
+func validateReading(session *ChargingSession, station *ChargingStation, energy float64, now time.Time) error {
+	if energy < 0 {
+		return fmt.Errorf("energy %.3f kWh is negative", energy)
+	}
+
+	samples := sessionSamples(session)
+	last := samples[len(samples)-1]
+
+	if energy < last.Energy-1e-6 {
+		return fmt.Errorf("energy %.3f kWh is below the previous reading of %.3f kWh", energy, last.Energy)
+	}
+
+	elapsed := now.Sub(last.Time)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	limit := float64(station.PowerOutput)*elapsed.Hours()*meterTolerance + meterSlackKwh
+	if energy-last.Energy > limit {
+		return fmt.Errorf("%.3f kWh in %s exceeds the %d kW output of station %s",
+			energy-last.Energy, elapsed.Round(time.Second), station.PowerOutput, station.StationID)
+	}
+
+	return nil
+}
+
+// computeHash hashes the reading's content together with the previous entry's hash
+func (r *MeterReading) computeHash() string {
+	h := sha256.New()
+	for _, field := range []string{
+		r.PreviousHash,
+		r.SessionID,
+		strconv.Itoa(r.Sequence),
+		strconv.FormatFloat(r.Energy, 'f', -1, 64),
+		r.Time.UTC().Format(time.RFC3339Nano),
+		r.TxID,
+		r.SubmitterMSP,
+		r.SubmitterID,
+		strconv.FormatBool(r.Accepted),
+		r.Reason,
+	} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
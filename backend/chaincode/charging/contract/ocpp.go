@@ -0,0 +1,239 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// transactionIndex maps the OCPP transaction IDs of a station to charging sessions
+const transactionIndex = "stationId~transactionId"
+
+// IdTag represents an RFID card or token a user identifies with at OCPP charge points
+type IdTag struct {
+	DocType    string    `json:"docType"`
+	IdTag      string    `json:"idTag"`
+	UserID     string    `json:"userId"`
+	Status     string    `json:"status"`     // Accepted, Blocked
+	ExpiryDate string    `json:"expiryDate"` // RFC3339, empty for none
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// ==================== OCPP Identification ====================
+
+// RegisterIdTag links an idTag to a user
+func (c *ChargingContract) RegisterIdTag(ctx contractapi.TransactionContextInterface, idTag, userId, expiryDate string) error {
+	if idTag == "" || len(idTag) > 20 {
+		return fmt.Errorf("idTag must be between 1 and 20 characters")
+	}
+	if expiryDate != "" {
+		if _, err := time.Parse(time.RFC3339, expiryDate); err != nil {
+			return fmt.Errorf("invalid expiry date: %v", err)
+		}
+	}
+
+	existing, err := ctx.GetStub().GetState(idTagKey(idTag))
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("idTag %s is already registered", idTag)
+	}
+
+	now := time.Now()
+	tag := IdTag{
+		DocType:    "idTag",
+		IdTag:      idTag,
+		UserID:     userId,
+		Status:     "Accepted",
+		ExpiryDate: expiryDate,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	tagJSON, err := json.Marshal(tag)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(idTagKey(idTag), tagJSON)
+}
+
+// GetIdTag retrieves a registered idTag
+func (c *ChargingContract) GetIdTag(ctx contractapi.TransactionContextInterface, idTag string) (*IdTag, error) {
+	tagJSON, err := ctx.GetStub().GetState(idTagKey(idTag))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read idTag: %v", err)
+	}
+	if tagJSON == nil {
+		return nil, fmt.Errorf("idTag %s does not exist", idTag)
+	}
+
+	var tag IdTag
+	err = json.Unmarshal(tagJSON, &tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+// BlockIdTag prevents an idTag from starting new sessions
+func (c *ChargingContract) BlockIdTag(ctx contractapi.TransactionContextInterface, idTag string) error {
+	tag, err := c.GetIdTag(ctx, idTag)
+	if err != nil {
+		return err
+	}
+
+	tag.Status = "Blocked"
+	tag.UpdatedAt = time.Now()
+
+	tagJSON, err := json.Marshal(tag)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(idTagKey(idTag), tagJSON)
+}
+
+// AuthorizeIdTag returns the OCPP authorization status of an idTag:
+// Accepted, Blocked, Expired or Invalid
+func (c *ChargingContract) AuthorizeIdTag(ctx contractapi.TransactionContextInterface, idTag string) (string, error) {
+	tagJSON, err := ctx.GetStub().GetState(idTagKey(idTag))
+	if err != nil {
+		return "", err
+	}
+	if tagJSON == nil {
+		return "Invalid", nil
+	}
+
+	var tag IdTag
+	err = json.Unmarshal(tagJSON, &tag)
+	if err != nil {
+		return "", err
+	}
+
+	return idTagStatus(&tag, time.Now()), nil
+}
+
+// ==================== OCPP Credentials ====================
+
+// SetStationCredential stores the hash of the basic auth password a station's charge
+// point connects with. It is kept apart from the station record so that station
+// queries never return it.
+func (c *ChargingContract) SetStationCredential(ctx contractapi.TransactionContextInterface, stationId, passwordHash string) error {
+	if passwordHash == "" {
+		return fmt.Errorf("password hash is required")
+	}
+
+	exists, err := c.StationExists(ctx, stationId)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("charging station %s does not exist", stationId)
+	}
+
+	return ctx.GetStub().PutState(stationCredentialKey(stationId), []byte(passwordHash))
+}
+
+// GetStationCredential retrieves the password hash of a station's charge point
+func (c *ChargingContract) GetStationCredential(ctx contractapi.TransactionContextInterface, stationId string) (string, error) {
+	passwordHash, err := ctx.GetStub().GetState(stationCredentialKey(stationId))
+	if err != nil {
+		return "", fmt.Errorf("failed to read station credential: %v", err)
+	}
+	if passwordHash == nil {
+		return "", fmt.Errorf("OCPP credential of charging station %s does not exist", stationId)
+	}
+
+	return string(passwordHash), nil
+}
+
+// ==================== OCPP Sessions ====================
+
+// CreateOcppSession starts a session for an OCPP StartTransaction. The session belongs to
+// the owner of idTag, and meterStart is the station meter reading in kWh.
+func (c *ChargingContract) CreateOcppSession(ctx contractapi.TransactionContextInterface, sessionId, stationId, idTag string, transactionId int, meterStart float64) error {
+	tag, err := c.GetIdTag(ctx, idTag)
+	if err != nil {
+		return err
+	}
+	if status := idTagStatus(tag, time.Now()); status != "Accepted" {
+		return fmt.Errorf("idTag %s is not accepted: %s", idTag, status)
+	}
+
+	if meterStart < 0 {
+		return fmt.Errorf("meter start must not be negative")
+	}
+
+	indexKey, err := ctx.GetStub().CreateCompositeKey(transactionIndex, []string{stationId, strconv.Itoa(transactionId)})
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(indexKey)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("transaction %d already exists on station %s", transactionId, stationId)
+	}
+
+	session, err := c.newSession(ctx, sessionId, tag.UserID, stationId)
+	if err != nil {
+		return err
+	}
+	session.IdTag = idTag
+	session.TransactionID = transactionId
+	session.MeterStart = meterStart
+
+	err = ctx.GetStub().PutState(indexKey, []byte(sessionId))
+	if err != nil {
+		return err
+	}
+
+	return c.startSession(ctx, session)
+}
+
+// GetSessionByTransactionId retrieves the session of an OCPP transaction on a station
+func (c *ChargingContract) GetSessionByTransactionId(ctx contractapi.TransactionContextInterface, stationId string, transactionId int) (*ChargingSession, error) {
+	indexKey, err := ctx.GetStub().CreateCompositeKey(transactionIndex, []string{stationId, strconv.Itoa(transactionId)})
+	if err != nil {
+		return nil, err
+	}
+	sessionId, err := ctx.GetStub().GetState(indexKey)
+	if err != nil {
+		return nil, err
+	}
+	if sessionId == nil {
+		return nil, fmt.Errorf("transaction %d on station %s does not exist", transactionId, stationId)
+	}
+
+	return c.GetChargingSession(ctx, string(sessionId))
+}
+
+func idTagKey(idTag string) string {
+	return "idtag_" + idTag
+}
+
+func stationCredentialKey(stationId string) string {
+	return "ocppcredential_" + stationId
+}
+
+// idTagStatus returns the OCPP authorization status of a registered idTag at a time
+func idTagStatus(tag *IdTag, now time.Time) string {
+	if tag.Status != "Accepted" {
+		return tag.Status
+	}
+	if tag.ExpiryDate != "" {
+		expiry, err := time.Parse(time.RFC3339, tag.ExpiryDate)
+		if err == nil && !now.Before(expiry) {
+			return "Expired"
+		}
+	}
+	return "Accepted"
+}
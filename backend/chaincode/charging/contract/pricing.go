@@ -0,0 +1,297 @@
+package contract
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// EnergySample records the energy delivered by a session up to a point in time
+type EnergySample struct {
+	Time   time.Time `json:"time"`
+	Energy float64   `json:"energy"` // kWh since the session started
+}
+
+// ChargingFees are the time-based fees a station charges on top of energy
+type ChargingFees struct {
+	SessionFee       float64 `json:"sessionFee"`       // flat fee per session
+	PerMinuteFee     float64 `json:"perMinuteFee"`     // per minute plugged in
+	IdleFeePerMinute float64 `json:"idleFeePerMinute"` // per minute plugged in after energy delivery stopped
+	IdleGraceMinutes int     `json:"idleGraceMinutes"` // idle minutes not charged
+}
+
+// LineItem represents one component of a session's price
+type LineItem struct {
+	Type        string  `json:"type"` // energy, session_fee, time, idle
+	Description string  `json:"description"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit"` // kWh, session, minute
+	UnitPrice   float64 `json:"unitPrice"`
+	Amount      float64 `json:"amount"`
+}
+
+// ChargingQuote represents the price of a session as computed by the ledger
+type ChargingQuote struct {
+	SessionID       string     `json:"sessionId"`
+	StationID       string     `json:"stationId"`
+	StartTime       time.Time  `json:"startTime"`
+	EndTime         time.Time  `json:"endTime"`
+	EnergyConsumed  float64    `json:"energyConsumed"`
+	PricePerKwh     float64    `json:"pricePerKwh"` // rate in force at the end of the session
+	SurgeMultiplier float64    `json:"surgeMultiplier"`
+	IdleMinutes     int        `json:"idleMinutes"`
+	LineItems       []LineItem `json:"lineItems"`
+	TotalCost       float64    `json:"totalCost"`
+	Amount          float64    `json:"amount"` // amount due
+}
+
+// ==================== Pricing ====================
+
+// QuoteChargingSession returns the price of an active session if it were stopped now
+// with totalEnergy delivered
+func (c *ChargingContract) QuoteChargingSession(ctx contractapi.TransactionContextInterface, sessionId string, totalEnergy float64) (*ChargingQuote, error) {
+	session, err := c.GetChargingSession(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.Status != "active" {
+		return nil, fmt.Errorf("session %s is not active", sessionId)
+	}
+
+	return c.quoteSession(ctx, session, totalEnergy, quoteTime(time.Now()))
+}
+
+// maxQuoteAge bounds how long after a quote the session may be stopped at its price
+const maxQuoteAge = 5 * time.Minute
+
+// quoteTime is the end time a session stopped now is quoted at
+func quoteTime(now time.Time) time.Time {
+	return now.Truncate(time.Minute)
+}
+
+// quotedEndTime parses the end time a stopping session was quoted at. The stop
+// transaction prices the session at that time rather than its own, so that per-minute
+// and idle fees match the amount paid; stale quotes are rejected.
+func quotedEndTime(session *ChargingSession, endTime string, now time.Time) (time.Time, error) {
+	end, err := time.Parse(time.RFC3339, endTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid quoted end time: %v", err)
+	}
+
+	switch {
+	case end.After(now):
+		return time.Time{}, fmt.Errorf("quoted end time %s is in the future", endTime)
+	case now.Sub(end) > maxQuoteAge:
+		return time.Time{}, fmt.Errorf("quote at %s has expired, quote the session again", endTime)
+	case end.Before(session.StartTime):
+		return time.Time{}, fmt.Errorf("quoted end time %s is before the session started", endTime)
+	}
+	if samples := session.EnergySamples; len(samples) > 0 && end.Before(samples[len(samples)-1].Time) {
+		return time.Time{}, fmt.Errorf("quote at %s predates the last meter reading, quote the session again", endTime)
+	}
+
+	return end, nil
+}
+
+// quoteSession prices a session plugged in until end with totalEnergy delivered
+func (c *ChargingContract) quoteSession(ctx contractapi.TransactionContextInterface, session *ChargingSession, totalEnergy float64, end time.Time) (*ChargingQuote, error) {
+	schedule, surge, err := c.sessionSchedule(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := append(sessionSamples(session), EnergySample{Time: end, Energy: totalEnergy})
+	energyCost := calculateEnergyCost(schedule, surge, samples)
+
+	energyItem := LineItem{
+		Type:        "energy",
+		Description: "Energy delivered",
+		Quantity:    totalEnergy,
+		Unit:        "kWh",
+		Amount:      energyCost,
+	}
+	if totalEnergy > 0 {
+		energyItem.UnitPrice = roundAmount(energyCost / totalEnergy)
+	}
+	lineItems := []LineItem{energyItem}
+
+	fees := session.Fees
+	if fees.SessionFee > 0 {
+		lineItems = append(lineItems, LineItem{
+			Type:        "session_fee",
+			Description: "Session fee",
+			Quantity:    1,
+			Unit:        "session",
+			UnitPrice:   fees.SessionFee,
+			Amount:      roundAmount(fees.SessionFee),
+		})
+	}
+
+	minutes := wholeMinutes(end.Sub(session.StartTime))
+	if fees.PerMinuteFee > 0 && minutes > 0 {
+		lineItems = append(lineItems, LineItem{
+			Type:        "time",
+			Description: "Time plugged in",
+			Quantity:    float64(minutes),
+			Unit:        "minute",
+			UnitPrice:   fees.PerMinuteFee,
+			Amount:      roundAmount(float64(minutes) * fees.PerMinuteFee),
+		})
+	}
+
+	// Idle time runs from the last sample that added energy until the car is unplugged
+	idleMinutes := wholeMinutes(end.Sub(deliveryEnd(samples))) - fees.IdleGraceMinutes
+	if idleMinutes < 0 {
+		idleMinutes = 0
+	}
+	if fees.IdleFeePerMinute > 0 && idleMinutes > 0 {
+		lineItems = append(lineItems, LineItem{
+			Type:        "idle",
+			Description: fmt.Sprintf("Idle after charging (%d min grace)", fees.IdleGraceMinutes),
+			Quantity:    float64(idleMinutes),
+			Unit:        "minute",
+			UnitPrice:   fees.IdleFeePerMinute,
+			Amount:      roundAmount(float64(idleMinutes) * fees.IdleFeePerMinute),
+		})
+	}
+
+	total := 0.0
+	for _, item := range lineItems {
+		total += item.Amount
+	}
+	total = roundAmount(total)
+
+	return &ChargingQuote{
+		SessionID:       session.SessionID,
+		StationID:       session.StationID,
+		StartTime:       session.StartTime,
+		EndTime:         end,
+		EnergyConsumed:  totalEnergy,
+		PricePerKwh:     schedule.rateAt(end),
+		SurgeMultiplier: surge,
+		IdleMinutes:     idleMinutes,
+		LineItems:       lineItems,
+		TotalCost:       total,
+		Amount:          total,
+	}, nil
+}
+
+// sessionSchedule returns the tariff schedule and surge multiplier a session is priced with
+func (c *ChargingContract) sessionSchedule(ctx contractapi.TransactionContextInterface, session *ChargingSession) (*tariffSchedule, float64, error) {
+	station, err := c.GetChargingStation(ctx, session.StationID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The flat rate of the station when the session started applies outside any tariff
+	schedule, err := c.newTariffSchedule(ctx, station, session.PricePerKwh)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Sessions started before tariffs existed carry no multiplier
+	surge := session.SurgeMultiplier
+	if surge == 0 {
+		surge = 1
+	}
+
+	return schedule, surge, nil
+}
+
+// locationOccupancy returns the percentage of in-service stations at a location that are in use
+func (c *ChargingContract) locationOccupancy(ctx contractapi.TransactionContextInterface, location string) (float64, error) {
+	stations, err := c.QueryStationsByLocation(ctx, location)
+	if err != nil {
+		return 0, err
+	}
+
+	total, inUse := 0, 0
+	for _, station := range stations {
+		if station.Status == "maintenance" || station.Status == "out-of-service" {
+			continue
+		}
+		total++
+		if station.Status == "in-use" {
+			inUse++
+		}
+	}
+
+	if total == 0 {
+		return 0, nil
+	}
+	return roundAmount(float64(inUse) * 100 / float64(total)), nil
+}
+
+// sessionSamples returns the recorded energy samples of a session, starting with
+// zero energy at the start of the session
+func sessionSamples(session *ChargingSession) []EnergySample {
+	samples := []EnergySample{{Time: session.StartTime, Energy: 0}}
+	return append(samples, session.EnergySamples...)
+}
+
+// deliveryEnd returns the time of the last sample that added energy, or the first
+// sample if none did
+func deliveryEnd(samples []EnergySample) time.Time {
+	end := samples[0].Time
+	for i := 1; i < len(samples); i++ {
+		if samples[i].Energy > samples[i-1].Energy {
+			end = samples[i].Time
+		}
+	}
+	return end
+}
+
+// wholeMinutes returns the number of completed minutes in d, never negative
+func wholeMinutes(d time.Duration) int {
+	if d < 0 {
+		return 0
+	}
+	return int(d.Minutes())
+}
+
+// calculateEnergyCost prices the energy delivered between consecutive samples. The
+// energy of each interval is spread evenly over it, and each minute is priced at the
+// rate in force at that minute.
+func calculateEnergyCost(schedule *tariffSchedule, surge float64, samples []EnergySample) float64 {
+	cost := 0.0
+	for i := 1; i < len(samples); i++ {
+		from, to := samples[i-1].Time, samples[i].Time
+		energy := samples[i].Energy - samples[i-1].Energy
+		if energy <= 0 {
+			continue
+		}
+
+		span := to.Sub(from)
+		if span <= 0 {
+			cost += energy * schedule.rateAt(from)
+			continue
+		}
+
+		for t := from; t.Before(to); {
+			next := t.Truncate(time.Minute).Add(time.Minute)
+			if next.After(to) {
+				next = to
+			}
+			cost += energy * float64(next.Sub(t)) / float64(span) * schedule.rateAt(t)
+			t = next
+		}
+	}
+
+	return roundAmount(cost * surge)
+}
+
+// roundAmount rounds a monetary amount to cents
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// checkPaidAmount rejects a payment that differs from the amount computed by the ledger
+func checkPaidAmount(paidAmount, expected float64) error {
+	if math.Abs(roundAmount(paidAmount)-expected) >= 0.005 {
+		return fmt.Errorf("paid amount %.2f does not match the price %.2f", paidAmount, expected)
+	}
+	return nil
+}
@@ -0,0 +1,414 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// blackoutScopeIndex is the composite key namespace used to find the blackouts of a scope
+const blackoutScopeIndex = "scopeType~scopeId~blackoutId"
+
+// Blackout scope types
+const (
+	BlackoutScopeStation  = "station"
+	BlackoutScopeLocation = "location"
+)
+
+// OpeningHours opens a station on some days between two local times. Close before Open
+// wraps past midnight.
+type OpeningHours struct {
+	Days  []int  `json:"days"`  // 0 = Sunday ... 6 = Saturday, empty for every day
+	Open  string `json:"open"`  // HH:MM local time, inclusive
+	Close string `json:"close"` // HH:MM local time, exclusive
+}
+
+// Blackout closes the stations of a scope for a time range, such as street cleaning or
+// an event. Sessions already running are not stopped; new sessions are rejected.
+type Blackout struct {
+	DocType    string    `json:"docType"`
+	BlackoutID string    `json:"blackoutId"`
+	ScopeType  string    `json:"scopeType"` // station, location
+	ScopeID    string    `json:"scopeId"`
+	StartTime  time.Time `json:"startTime"`
+	EndTime    time.Time `json:"endTime"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// TimeWindow represents a half-open [StartTime, EndTime) time range
+type TimeWindow struct {
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+}
+
+// interval is a half-open [start, end) time range
+type interval struct {
+	start, end time.Time
+}
+
+// ==================== Opening Hours and Blackouts ====================
+
+// SetStationOpeningHours sets the opening hours of a station, in local time at
+// utcOffsetMinutes. A station without opening hours is always open.
+func (c *ChargingContract) SetStationOpeningHours(ctx contractapi.TransactionContextInterface, stationId string, utcOffsetMinutes int, openingHours []OpeningHours) error {
+	if err := validateOpeningHours(openingHours, utcOffsetMinutes); err != nil {
+		return err
+	}
+
+	station, err := c.GetChargingStation(ctx, stationId)
+	if err != nil {
+		return err
+	}
+
+	station.UTCOffsetMinutes = utcOffsetMinutes
+	station.OpeningHours = openingHours
+	station.UpdatedAt = time.Now()
+
+	return c.putStation(ctx, station)
+}
+
+// GetStationAvailability returns the windows within [from, to) during which a station is
+// open and not blacked out. Stations are not reserved ahead, so these are the times a
+// session can be started.
+func (c *ChargingContract) GetStationAvailability(ctx contractapi.TransactionContextInterface, stationId, fromStr, toStr string) ([]*TimeWindow, error) {
+	from, to, err := parseWindow(fromStr, toStr)
+	if err != nil {
+		return nil, err
+	}
+
+	station, err := c.GetChargingStation(ctx, stationId)
+	if err != nil {
+		return nil, err
+	}
+
+	windows := make([]*TimeWindow, 0)
+	if station.Status == "maintenance" || station.Status == "out-of-service" {
+		return windows, nil
+	}
+
+	open, err := c.serviceIntervals(ctx, station, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, window := range open {
+		windows = append(windows, &TimeWindow{StartTime: window.start.UTC(), EndTime: window.end.UTC()})
+	}
+
+	return windows, nil
+}
+
+// CreateBlackout closes the stations of a station or location scope from startTime to
+// endTime
+func (c *ChargingContract) CreateBlackout(ctx contractapi.TransactionContextInterface, blackoutId, scopeType, scopeId, startTimeStr, endTimeStr, reason string) error {
+	existing, err := ctx.GetStub().GetState(blackoutId)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("blackout %s already exists", blackoutId)
+	}
+
+	switch scopeType {
+	case BlackoutScopeStation:
+		_, err = c.GetChargingStation(ctx, scopeId)
+	case BlackoutScopeLocation:
+		if scopeId == "" {
+			err = fmt.Errorf("blackout scope id is required")
+		}
+	default:
+		err = fmt.Errorf("invalid blackout scope type: %s", scopeType)
+	}
+	if err != nil {
+		return err
+	}
+
+	start, end, err := parseWindow(startTimeStr, endTimeStr)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if !end.After(now) {
+		return fmt.Errorf("blackout must end in the future")
+	}
+
+	blackout := Blackout{
+		DocType:    "blackout",
+		BlackoutID: blackoutId,
+		ScopeType:  scopeType,
+		ScopeID:    scopeId,
+		StartTime:  start.UTC(),
+		EndTime:    end.UTC(),
+		Reason:     reason,
+		CreatedAt:  now,
+	}
+
+	blackoutJSON, err := json.Marshal(blackout)
+	if err != nil {
+		return err
+	}
+
+	indexKey, err := ctx.GetStub().CreateCompositeKey(blackoutScopeIndex, []string{scopeType, scopeId, blackoutId})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(blackoutId, blackoutJSON)
+}
+
+// GetBlackout retrieves a blackout by ID
+func (c *ChargingContract) GetBlackout(ctx contractapi.TransactionContextInterface, blackoutId string) (*Blackout, error) {
+	blackoutJSON, err := ctx.GetStub().GetState(blackoutId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blackout: %v", err)
+	}
+	if blackoutJSON == nil {
+		return nil, fmt.Errorf("blackout %s does not exist", blackoutId)
+	}
+
+	var blackout Blackout
+	err = json.Unmarshal(blackoutJSON, &blackout)
+	if err != nil {
+		return nil, err
+	}
+	if blackout.DocType != "blackout" {
+		return nil, fmt.Errorf("blackout %s does not exist", blackoutId)
+	}
+
+	return &blackout, nil
+}
+
+// DeleteBlackout lifts a blackout
+func (c *ChargingContract) DeleteBlackout(ctx contractapi.TransactionContextInterface, blackoutId string) error {
+	blackout, err := c.GetBlackout(ctx, blackoutId)
+	if err != nil {
+		return err
+	}
+
+	indexKey, err := ctx.GetStub().CreateCompositeKey(blackoutScopeIndex, []string{blackout.ScopeType, blackout.ScopeID, blackoutId})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(indexKey)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(blackoutId)
+}
+
+// GetBlackoutsByScope returns the blackouts of a scope, earliest first
+func (c *ChargingContract) GetBlackoutsByScope(ctx contractapi.TransactionContextInterface, scopeType, scopeId string) ([]*Blackout, error) {
+	blackoutIds, err := indexedIDs(ctx, blackoutScopeIndex, []string{scopeType, scopeId})
+	if err != nil {
+		return nil, err
+	}
+
+	blackouts := make([]*Blackout, 0, len(blackoutIds))
+	for _, blackoutId := range blackoutIds {
+		blackout, err := c.GetBlackout(ctx, blackoutId)
+		if err != nil {
+			return nil, err
+		}
+		blackouts = append(blackouts, blackout)
+	}
+
+	sort.SliceStable(blackouts, func(i, j int) bool {
+		return blackouts[i].StartTime.Before(blackouts[j].StartTime)
+	})
+
+	return blackouts, nil
+}
+
+// checkInService returns an error unless a station is open and not blacked out for the
+// whole of [start, end)
+func (c *ChargingContract) checkInService(ctx contractapi.TransactionContextInterface, station *ChargingStation, start, end time.Time) error {
+	if !covers(openIntervals(station.OpeningHours, station.UTCOffsetMinutes, start, end), start, end) {
+		return fmt.Errorf("charging station %s is closed", station.StationID)
+	}
+
+	blackouts, err := c.stationBlackouts(ctx, station)
+	if err != nil {
+		return err
+	}
+	for _, blackout := range blackouts {
+		if overlaps(blackout.StartTime, blackout.EndTime, start, end) {
+			return fmt.Errorf("charging station %s is closed from %s to %s: %s", station.StationID,
+				blackout.StartTime.Format(time.RFC3339), blackout.EndTime.Format(time.RFC3339), blackout.Reason)
+		}
+	}
+
+	return nil
+}
+
+// inService reports whether a station is open and not blacked out for the whole of
+// [start, end)
+func (c *ChargingContract) inService(ctx contractapi.TransactionContextInterface, station *ChargingStation, start, end time.Time) (bool, error) {
+	windows, err := c.serviceIntervals(ctx, station, start, end)
+	if err != nil {
+		return false, err
+	}
+	return covers(windows, start, end), nil
+}
+
+// serviceIntervals returns the intervals within [from, to) during which a station is
+// open and not blacked out, in order
+func (c *ChargingContract) serviceIntervals(ctx contractapi.TransactionContextInterface, station *ChargingStation, from, to time.Time) ([]interval, error) {
+	windows := openIntervals(station.OpeningHours, station.UTCOffsetMinutes, from, to)
+
+	blackouts, err := c.stationBlackouts(ctx, station)
+	if err != nil {
+		return nil, err
+	}
+	for _, blackout := range blackouts {
+		windows = subtractInterval(windows, blackout.StartTime, blackout.EndTime)
+	}
+
+	return windows, nil
+}
+
+// stationBlackouts returns the blackouts of a station and its location
+func (c *ChargingContract) stationBlackouts(ctx contractapi.TransactionContextInterface, station *ChargingStation) ([]*Blackout, error) {
+	blackouts, err := c.GetBlackoutsByScope(ctx, BlackoutScopeStation, station.StationID)
+	if err != nil {
+		return nil, err
+	}
+	located, err := c.GetBlackoutsByScope(ctx, BlackoutScopeLocation, station.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(blackouts, located...), nil
+}
+
+// validateOpeningHours checks the times and days of opening hours
+func validateOpeningHours(openingHours []OpeningHours, utcOffsetMinutes int) error {
+	if utcOffsetMinutes < -14*60 || utcOffsetMinutes > 14*60 {
+		return fmt.Errorf("invalid UTC offset: %d minutes", utcOffsetMinutes)
+	}
+
+	for _, hours := range openingHours {
+		open, err := parseClock(hours.Open)
+		if err != nil {
+			return fmt.Errorf("opening hours: %v", err)
+		}
+		closing, err := parseClock(hours.Close)
+		if err != nil {
+			return fmt.Errorf("opening hours: %v", err)
+		}
+		if open == closing {
+			return fmt.Errorf("opening hours: open and close time must differ")
+		}
+		for _, day := range hours.Days {
+			if day < 0 || day > 6 {
+				return fmt.Errorf("opening hours: invalid day %d", day)
+			}
+		}
+	}
+
+	return nil
+}
+
+// openIntervals returns the intervals within [from, to) covered by opening hours, in
+// order. No opening hours means always open.
+func openIntervals(openingHours []OpeningHours, utcOffsetMinutes int, from, to time.Time) []interval {
+	if len(openingHours) == 0 {
+		return []interval{{from, to}}
+	}
+
+	// Start a day early, for hours that wrap past midnight into the range
+	zone := time.FixedZone("", utcOffsetMinutes*60)
+	local := from.In(zone)
+	day := time.Date(local.Year(), local.Month(), local.Day()-1, 0, 0, 0, 0, zone)
+
+	var windows []interval
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		for _, hours := range openingHours {
+			if len(hours.Days) > 0 && !containsDay(hours.Days, int(day.Weekday())) {
+				continue
+			}
+			open, err1 := parseClock(hours.Open)
+			closing, err2 := parseClock(hours.Close)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+
+			start := day.Add(time.Duration(open) * time.Minute)
+			end := day.Add(time.Duration(closing) * time.Minute)
+			if closing <= open {
+				end = end.Add(24 * time.Hour)
+			}
+			if start.Before(from) {
+				start = from
+			}
+			if end.After(to) {
+				end = to
+			}
+			if start.Before(end) {
+				windows = append(windows, interval{start, end})
+			}
+		}
+	}
+
+	return mergeIntervals(windows)
+}
+
+// mergeIntervals sorts intervals and joins those that overlap or touch
+func mergeIntervals(windows []interval) []interval {
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].start.Before(windows[j].start)
+	})
+
+	merged := make([]interval, 0, len(windows))
+	for _, window := range windows {
+		last := len(merged) - 1
+		if last >= 0 && !window.start.After(merged[last].end) {
+			if window.end.After(merged[last].end) {
+				merged[last].end = window.end
+			}
+			continue
+		}
+		merged = append(merged, window)
+	}
+	return merged
+}
+
+// subtractInterval removes [start, end) from ordered intervals
+func subtractInterval(windows []interval, start, end time.Time) []interval {
+	remaining := make([]interval, 0, len(windows)+1)
+	for _, window := range windows {
+		if !overlaps(window.start, window.end, start, end) {
+			remaining = append(remaining, window)
+			continue
+		}
+		if window.start.Before(start) {
+			remaining = append(remaining, interval{window.start, start})
+		}
+		if window.end.After(end) {
+			remaining = append(remaining, interval{end, window.end})
+		}
+	}
+	return remaining
+}
+
+// covers reports whether ordered, merged intervals cover the whole of [start, end)
+func covers(windows []interval, start, end time.Time) bool {
+	for _, window := range windows {
+		if !window.start.After(start) && !window.end.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps reports whether the half-open ranges [aStart, aEnd) and [bStart, bEnd) intersect
+func overlaps(aStart, aEnd, bStart, bEnd time.Time) bool {
+	return aStart.Before(bEnd) && bStart.Before(aEnd)
+}
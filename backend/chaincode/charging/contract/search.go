@@ -0,0 +1,278 @@
+package contract
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// scanFactor bounds the index entries a search reads per call, as a multiple of
+	// the page size; a page of a sparse search can come back short, with a bookmark
+	scanFactor = 10
+)
+
+// errEndOfRange is returned by a match function once no later index entry can match
+var errEndOfRange = errors.New("end of range")
+
+// StationFilter selects stations. Empty fields match any station.
+type StationFilter struct {
+	Location      string `json:"location,omitempty" metadata:",optional"`
+	ConnectorType string `json:"connectorType,omitempty" metadata:",optional"`
+	Status        string `json:"status,omitempty" metadata:",optional"`
+	MinPower      int    `json:"minPower,omitempty" metadata:",optional"` // kW
+	MaxPower      int    `json:"maxPower,omitempty" metadata:",optional"` // kW, zero for no maximum
+	SortBy        string `json:"sortBy,omitempty" metadata:",optional"`   // location or power; any order when empty
+	OpenNow       bool   `json:"openNow,omitempty" metadata:",optional"`  // only stations open and not blacked out now
+}
+
+// SessionFilter selects the sessions of a user or of a station
+type SessionFilter struct {
+	UserID    string   `json:"userId,omitempty" metadata:",optional"`
+	StationID string   `json:"stationId,omitempty" metadata:",optional"`
+	Statuses  []string `json:"statuses,omitempty" metadata:",optional"` // any status when empty
+}
+
+// StationPage is a page of station search results
+type StationPage struct {
+	Stations     []*ChargingStation `json:"stations"`
+	NextBookmark string             `json:"nextBookmark"` // empty on the last page
+}
+
+// SessionPage is a page of session search results
+type SessionPage struct {
+	Sessions     []*ChargingSession `json:"sessions"`
+	NextBookmark string             `json:"nextBookmark"` // empty on the last page
+}
+
+// ==================== Search ====================
+
+// SearchStations returns a page of the stations matching all the criteria of a filter.
+// Pass the returned bookmark, with the same filter, to get the next page. Must be
+// evaluated, not submitted, as paginated queries are read-only.
+func (c *ChargingContract) SearchStations(ctx contractapi.TransactionContextInterface, filter StationFilter, pageSize int, bookmark string) (*StationPage, error) {
+	if filter.MinPower < 0 || filter.MaxPower < 0 || (filter.MaxPower > 0 && filter.MaxPower < filter.MinPower) {
+		return nil, fmt.Errorf("invalid power range")
+	}
+	index, attributes, err := stationSearchIndex(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	page := &StationPage{Stations: []*ChargingStation{}}
+	page.NextBookmark, err = searchIndex(ctx, index, attributes, clampPageSize(pageSize), bookmark, func(keyParts []string) (bool, error) {
+		// Entries of the power index sort by power
+		if index == stationPowerIndex {
+			if keyParts[0] < powerKey(filter.MinPower) {
+				return false, nil
+			}
+			if filter.MaxPower > 0 && keyParts[0] > powerKey(filter.MaxPower) {
+				return false, errEndOfRange
+			}
+		}
+
+		station, err := c.GetChargingStation(ctx, keyParts[len(keyParts)-1])
+		if err != nil {
+			return false, err
+		}
+		if !filter.matches(station) {
+			return false, nil
+		}
+		if filter.OpenNow {
+			open, err := c.inService(ctx, station, now, now.Add(time.Minute))
+			if err != nil || !open {
+				return false, err
+			}
+		}
+		page.Stations = append(page.Stations, station)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// SearchSessions returns a page of the sessions of a user or station, optionally
+// limited to some statuses. Pass the returned bookmark, with the same filter, to get the
+// next page. Must be evaluated, not submitted, as paginated queries are read-only.
+func (c *ChargingContract) SearchSessions(ctx contractapi.TransactionContextInterface, filter SessionFilter, pageSize int, bookmark string) (*SessionPage, error) {
+	var index string
+	var attributes []string
+	switch {
+	case filter.UserID != "" && len(filter.Statuses) == 1:
+		index, attributes = userStatusIndex, []string{filter.UserID, filter.Statuses[0]}
+	case filter.UserID != "":
+		index, attributes = userSessionIndex, []string{filter.UserID}
+	case filter.StationID != "":
+		index, attributes = stationSessionIndex, []string{filter.StationID}
+	default:
+		return nil, fmt.Errorf("a user or station is required to search sessions")
+	}
+
+	page := &SessionPage{Sessions: []*ChargingSession{}}
+	var err error
+	page.NextBookmark, err = searchIndex(ctx, index, attributes, clampPageSize(pageSize), bookmark, func(keyParts []string) (bool, error) {
+		session, err := c.GetChargingSession(ctx, keyParts[len(keyParts)-1])
+		if err != nil {
+			return false, err
+		}
+		if !filter.matches(session) {
+			return false, nil
+		}
+		page.Sessions = append(page.Sessions, session)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// stationSearchIndex picks the index a station search walks: the one giving the
+// requested order, or else the narrowest one the filter allows
+func stationSearchIndex(filter StationFilter) (string, []string, error) {
+	if filter.SortBy != "" && filter.SortBy != "location" && filter.SortBy != "power" {
+		return "", nil, fmt.Errorf("cannot sort stations by %q", filter.SortBy)
+	}
+
+	hasPowerRange := filter.MinPower > 0 || filter.MaxPower > 0
+	switch {
+	case filter.SortBy == "power":
+		return stationPowerIndex, []string{}, nil
+	case filter.Status != "" && filter.Location != "":
+		return stationStatusIndex, []string{filter.Status, filter.Location}, nil
+	case filter.Location != "":
+		return stationLocationIndex, []string{filter.Location}, nil
+	case filter.Status != "":
+		return stationStatusIndex, []string{filter.Status}, nil
+	case filter.SortBy == "location":
+		return stationLocationIndex, []string{}, nil
+	case filter.ConnectorType != "":
+		return stationConnectorIndex, []string{filter.ConnectorType}, nil
+	case hasPowerRange:
+		return stationPowerIndex, []string{}, nil
+	default:
+		return stationLocationIndex, []string{}, nil
+	}
+}
+
+func (filter StationFilter) matches(station *ChargingStation) bool {
+	if filter.Location != "" && station.Location != filter.Location {
+		return false
+	}
+	if filter.ConnectorType != "" && station.ConnectorType != filter.ConnectorType {
+		return false
+	}
+	if filter.Status != "" && station.Status != filter.Status {
+		return false
+	}
+	if station.PowerOutput < filter.MinPower {
+		return false
+	}
+	if filter.MaxPower > 0 && station.PowerOutput > filter.MaxPower {
+		return false
+	}
+	return true
+}
+
+func (filter SessionFilter) matches(session *ChargingSession) bool {
+	if filter.UserID != "" && session.UserID != filter.UserID {
+		return false
+	}
+	if filter.StationID != "" && session.StationID != filter.StationID {
+		return false
+	}
+	if len(filter.Statuses) == 0 {
+		return true
+	}
+	for _, status := range filter.Statuses {
+		if session.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// searchIndex walks the entries under a partial key of an index, a Fabric page at a time
+// from bookmark, and passes the attributes of each to match until match has accepted
+// pageSize of them or scanFactor pages' worth were read. Pages are only fetched as large
+// as the count still missing, so every entry read is consumed and Fabric's bookmark is
+// where the next call resumes. The returned bookmark is empty once the index is
+// exhausted, or when match returns errEndOfRange.
+func searchIndex(ctx contractapi.TransactionContextInterface, index string, attributes []string, pageSize int, bookmark string, match func(keyParts []string) (bool, error)) (string, error) {
+	accepted, scanned := 0, 0
+	for accepted < pageSize && scanned < pageSize*scanFactor {
+		limit := pageSize - accepted
+		fetched, matched, next, err := searchPage(ctx, index, attributes, limit, bookmark, match)
+		if errors.Is(err, errEndOfRange) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		accepted += matched
+		scanned += fetched
+		bookmark = next
+
+		if fetched < limit || bookmark == "" {
+			return "", nil
+		}
+	}
+
+	return bookmark, nil
+}
+
+// searchPage reads one Fabric page of index entries and returns how many were read and
+// accepted, and the bookmark of the following page
+func searchPage(ctx contractapi.TransactionContextInterface, index string, attributes []string, limit int, bookmark string, match func(keyParts []string) (bool, error)) (int, int, string, error) {
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(index, attributes, int32(limit), bookmark)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	defer resultsIterator.Close()
+
+	fetched, matched := 0, 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, 0, "", err
+		}
+		fetched++
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if len(compositeKeyParts) == 0 {
+			continue
+		}
+		ok, err := match(compositeKeyParts)
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if ok {
+			matched++
+		}
+	}
+
+	return fetched, matched, metadata.GetBookmark(), nil
+}
+
+// clampPageSize returns the default page size for sizes out of range
+func clampPageSize(pageSize int) int {
+	if pageSize < 1 {
+		return defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		return maxPageSize
+	}
+	return pageSize
+}
@@ -0,0 +1,386 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// tariffScopeIndex is the composite key namespace used to find the tariffs attached to a scope
+const tariffScopeIndex = "scopeType~scopeId~tariffId"
+
+// Tariff scope types, from the most to the least specific
+const (
+	TariffScopeStation  = "station"
+	TariffScopeLocation = "location"
+	TariffScopeOperator = "operator"
+)
+
+// Tariff represents a rate schedule attached to a station, a location or an operator.
+// Tariffs are never edited: a new version is created with a later EffectiveFrom and
+// takes over from then on, so past usage is always priced against the schedule that
+// was in force at the time.
+type Tariff struct {
+	DocType          string       `json:"docType"`
+	TariffID         string       `json:"tariffId"`
+	Name             string       `json:"name"`
+	ScopeType        string       `json:"scopeType"` // station, location, operator
+	ScopeID          string       `json:"scopeId"`
+	BaseRate         float64      `json:"baseRate"`         // per kWh
+	UTCOffsetMinutes int          `json:"utcOffsetMinutes"` // local time used by the rules
+	Rules            []TariffRule `json:"rules"`
+	SurgeTiers       []SurgeTier  `json:"surgeTiers"`
+	EffectiveFrom    string       `json:"effectiveFrom"`
+	EffectiveTo      string       `json:"effectiveTo"` // empty while in force
+	CreatedAt        time.Time    `json:"createdAt"`
+	UpdatedAt        time.Time    `json:"updatedAt"`
+}
+
+// TariffRule overrides the base rate on some days between two local times.
+// The first matching rule applies; EndTime before StartTime wraps past midnight.
+type TariffRule struct {
+	Name      string  `json:"name"`
+	Days      []int   `json:"days"`      // 0 = Sunday ... 6 = Saturday, empty for every day
+	StartTime string  `json:"startTime"` // HH:MM local time, inclusive
+	EndTime   string  `json:"endTime"`   // HH:MM local time, exclusive
+	Rate      float64 `json:"rate"`
+}
+
+// SurgeTier multiplies rates once occupancy reaches MinOccupancy percent
+type SurgeTier struct {
+	MinOccupancy float64 `json:"minOccupancy"`
+	Multiplier   float64 `json:"multiplier"`
+}
+
+// ==================== Tariff Management ====================
+
+// CreateTariff creates a tariff version for a scope, in force from effectiveFrom
+func (c *ChargingContract) CreateTariff(ctx contractapi.TransactionContextInterface, tariffId, name, scopeType, scopeId string, baseRate float64, utcOffsetMinutes int, rules []TariffRule, surgeTiers []SurgeTier, effectiveFrom string) error {
+	existing, err := ctx.GetStub().GetState(tariffId)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("tariff %s already exists", tariffId)
+	}
+
+	if scopeType != TariffScopeStation && scopeType != TariffScopeLocation && scopeType != TariffScopeOperator {
+		return fmt.Errorf("invalid tariff scope type: %s", scopeType)
+	}
+	if scopeId == "" {
+		return fmt.Errorf("tariff scope id is required")
+	}
+
+	// Usage already priced must not change, so schedules cannot start in the past
+	now := time.Now()
+	from, err := time.Parse(time.RFC3339, effectiveFrom)
+	if err != nil {
+		return fmt.Errorf("invalid effective from time: %v", err)
+	}
+	if from.Before(now.Truncate(time.Minute)) {
+		return fmt.Errorf("effective from time must not be in the past")
+	}
+
+	tariff := Tariff{
+		DocType:          "tariff",
+		TariffID:         tariffId,
+		Name:             name,
+		ScopeType:        scopeType,
+		ScopeID:          scopeId,
+		BaseRate:         baseRate,
+		UTCOffsetMinutes: utcOffsetMinutes,
+		Rules:            rules,
+		SurgeTiers:       surgeTiers,
+		EffectiveFrom:    formatTime(from),
+		EffectiveTo:      "",
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if tariff.Rules == nil {
+		tariff.Rules = []TariffRule{}
+	}
+	if tariff.SurgeTiers == nil {
+		tariff.SurgeTiers = []SurgeTier{}
+	}
+
+	err = validateTariff(&tariff)
+	if err != nil {
+		return err
+	}
+
+	tariffJSON, err := json.Marshal(tariff)
+	if err != nil {
+		return err
+	}
+
+	indexKey, err := ctx.GetStub().CreateCompositeKey(tariffScopeIndex, []string{scopeType, scopeId, tariffId})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(tariffId, tariffJSON)
+}
+
+// GetTariff retrieves a tariff by ID
+func (c *ChargingContract) GetTariff(ctx contractapi.TransactionContextInterface, tariffId string) (*Tariff, error) {
+	tariffJSON, err := ctx.GetStub().GetState(tariffId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tariff: %v", err)
+	}
+	if tariffJSON == nil {
+		return nil, fmt.Errorf("tariff %s does not exist", tariffId)
+	}
+
+	var tariff Tariff
+	err = json.Unmarshal(tariffJSON, &tariff)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tariff, nil
+}
+
+// RetireTariff ends a tariff at effectiveTo, which must not be in the past
+func (c *ChargingContract) RetireTariff(ctx contractapi.TransactionContextInterface, tariffId, effectiveTo string) error {
+	tariff, err := c.GetTariff(ctx, tariffId)
+	if err != nil {
+		return err
+	}
+	if tariff.EffectiveTo != "" {
+		return fmt.Errorf("tariff %s is already retired", tariffId)
+	}
+
+	now := time.Now()
+	to, err := time.Parse(time.RFC3339, effectiveTo)
+	if err != nil {
+		return fmt.Errorf("invalid effective to time: %v", err)
+	}
+	if to.Before(now.Truncate(time.Minute)) {
+		return fmt.Errorf("effective to time must not be in the past")
+	}
+	from, _ := time.Parse(time.RFC3339, tariff.EffectiveFrom)
+	if !to.After(from) {
+		return fmt.Errorf("effective to time must be after the effective from time")
+	}
+
+	tariff.EffectiveTo = formatTime(to)
+	tariff.UpdatedAt = now
+
+	tariffJSON, err := json.Marshal(tariff)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(tariffId, tariffJSON)
+}
+
+// GetTariffsByScope returns all tariff versions attached to a scope, oldest first
+func (c *ChargingContract) GetTariffsByScope(ctx contractapi.TransactionContextInterface, scopeType, scopeId string) ([]*Tariff, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(tariffScopeIndex, []string{scopeType, scopeId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	tariffs := make([]*Tariff, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, parts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil || len(parts) != 3 {
+			continue
+		}
+
+		tariff, err := c.GetTariff(ctx, parts[2])
+		if err != nil {
+			continue
+		}
+		tariffs = append(tariffs, tariff)
+	}
+
+	sort.Slice(tariffs, func(i, j int) bool {
+		return tariffs[i].EffectiveFrom < tariffs[j].EffectiveFrom
+	})
+
+	return tariffs, nil
+}
+
+// ==================== Tariff Evaluation ====================
+
+// tariffSchedule resolves the rate in force at any minute for one station
+type tariffSchedule struct {
+	scopes       [][]*Tariff // tariffs per scope, most specific first
+	fallbackRate float64     // used when no tariff is in force
+}
+
+// newTariffSchedule loads the tariffs of every scope a station belongs to
+func (c *ChargingContract) newTariffSchedule(ctx contractapi.TransactionContextInterface, station *ChargingStation, fallbackRate float64) (*tariffSchedule, error) {
+	schedule := &tariffSchedule{fallbackRate: fallbackRate}
+
+	scopes := [][2]string{
+		{TariffScopeStation, station.StationID},
+		{TariffScopeLocation, station.Location},
+		{TariffScopeOperator, station.OperatorID},
+	}
+	for _, scope := range scopes {
+		if scope[1] == "" {
+			continue
+		}
+		tariffs, err := c.GetTariffsByScope(ctx, scope[0], scope[1])
+		if err != nil {
+			return nil, err
+		}
+		schedule.scopes = append(schedule.scopes, tariffs)
+	}
+
+	return schedule, nil
+}
+
+// tariffAt returns the tariff in force at t, or nil if none is.
+// Within a scope the latest version that has started wins.
+func (s *tariffSchedule) tariffAt(t time.Time) *Tariff {
+	at := formatTime(t)
+	for _, tariffs := range s.scopes {
+		for i := len(tariffs) - 1; i >= 0; i-- {
+			tariff := tariffs[i]
+			if tariff.EffectiveFrom > at {
+				continue
+			}
+			if tariff.EffectiveTo != "" && tariff.EffectiveTo <= at {
+				continue
+			}
+			return tariff
+		}
+	}
+	return nil
+}
+
+// rateAt returns the rate in force at t
+func (s *tariffSchedule) rateAt(t time.Time) float64 {
+	tariff := s.tariffAt(t)
+	if tariff == nil {
+		return s.fallbackRate
+	}
+	return tariff.rateAt(t)
+}
+
+// surgeMultiplier returns the multiplier of the tariff in force at t for an occupancy in percent
+func (s *tariffSchedule) surgeMultiplier(t time.Time, occupancy float64) float64 {
+	tariff := s.tariffAt(t)
+	if tariff == nil {
+		return 1
+	}
+
+	multiplier, threshold := 1.0, -1.0
+	for _, tier := range tariff.SurgeTiers {
+		if occupancy >= tier.MinOccupancy && tier.MinOccupancy > threshold {
+			multiplier, threshold = tier.Multiplier, tier.MinOccupancy
+		}
+	}
+	return multiplier
+}
+
+// rateAt returns the rate of the first rule matching the local time of t, or the base rate
+func (t *Tariff) rateAt(at time.Time) float64 {
+	local := at.UTC().Add(time.Duration(t.UTCOffsetMinutes) * time.Minute)
+	weekday := int(local.Weekday())
+	minute := local.Hour()*60 + local.Minute()
+
+	for _, rule := range t.Rules {
+		if len(rule.Days) > 0 && !containsDay(rule.Days, weekday) {
+			continue
+		}
+		start, _ := parseClock(rule.StartTime)
+		end, _ := parseClock(rule.EndTime)
+		if start <= end && minute >= start && minute < end {
+			return rule.Rate
+		}
+		if start > end && (minute >= start || minute < end) {
+			return rule.Rate
+		}
+	}
+	return t.BaseRate
+}
+
+// validateTariff checks rates, rule times and surge tiers of a tariff
+func validateTariff(tariff *Tariff) error {
+	if tariff.BaseRate < 0 {
+		return fmt.Errorf("base rate must not be negative")
+	}
+	if tariff.UTCOffsetMinutes < -14*60 || tariff.UTCOffsetMinutes > 14*60 {
+		return fmt.Errorf("invalid UTC offset: %d minutes", tariff.UTCOffsetMinutes)
+	}
+
+	for _, rule := range tariff.Rules {
+		if rule.Rate < 0 {
+			return fmt.Errorf("rule %s: rate must not be negative", rule.Name)
+		}
+		start, err := parseClock(rule.StartTime)
+		if err != nil {
+			return fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+		end, err := parseClock(rule.EndTime)
+		if err != nil {
+			return fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+		if start == end {
+			return fmt.Errorf("rule %s: start and end time must differ", rule.Name)
+		}
+		for _, day := range rule.Days {
+			if day < 0 || day > 6 {
+				return fmt.Errorf("rule %s: invalid day %d", rule.Name, day)
+			}
+		}
+	}
+
+	for _, tier := range tariff.SurgeTiers {
+		if tier.MinOccupancy < 0 || tier.MinOccupancy > 100 {
+			return fmt.Errorf("surge tier occupancy must be between 0 and 100")
+		}
+		if tier.Multiplier < 1 {
+			return fmt.Errorf("surge tier multiplier must be at least 1")
+		}
+	}
+
+	return nil
+}
+
+// parseClock parses an HH:MM time of day into minutes after midnight
+func parseClock(clock string) (int, error) {
+	parts := strings.Split(clock, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", clock)
+	}
+	hours, err1 := strconv.Atoi(parts[0])
+	minutes, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || hours < 0 || hours > 24 || minutes < 0 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", clock)
+	}
+	return hours*60 + minutes, nil
+}
+
+func containsDay(days []int, day int) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// formatTime formats a time in UTC RFC3339 so that it sorts lexicographically
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,44 @@
+package contract
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Roles a caller may act with. The API passes the authenticated user's role;
+// backend workflows such as saga compensations act with RoleSystem.
+const (
+	RoleUser     = "user"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+	RoleSystem   = "system"
+)
+
+// authorize allows the owner of a resource, the operator running it, admins and the
+// system to act on it
+func authorize(callerId, callerRole, ownerId, operatorId string) error {
+	switch {
+	case callerRole == RoleAdmin || callerRole == RoleSystem:
+		return nil
+	case callerId != "" && callerId == ownerId:
+		return nil
+	case callerRole == RoleOperator && callerId != "" && callerId == operatorId:
+		return nil
+	}
+	return fmt.Errorf("access denied: %s is not allowed to act on this resource", callerId)
+}
+
+// authorizeBooking checks that the caller may act on a booking: its user, the
+// operator of its spot, or an admin
+func (c *ParkingContract) authorizeBooking(ctx contractapi.TransactionContextInterface, booking *Booking, callerId, callerRole string) error {
+	operatorId := ""
+	if callerRole == RoleOperator {
+		spot, err := c.GetParkingSpot(ctx, booking.SpotID)
+		if err != nil {
+			return err
+		}
+		operatorId = spot.OperatorID
+	}
+	return authorize(callerId, callerRole, booking.UserID, operatorId)
+}
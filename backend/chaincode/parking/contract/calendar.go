@@ -0,0 +1,289 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// reservationIndex is the composite key namespace of the per-spot reservation calendar.
+// Start times are stored in UTC RFC3339 so entries of a spot sort chronologically.
+const reservationIndex = "spotId~startTime~bookingId"
+
+// maxBookingDuration bounds how long a booking, including its extensions, may last
+const maxBookingDuration = 30 * 24 * time.Hour
+
+// Reservation represents an entry in a parking spot's reservation calendar
+type Reservation struct {
+	DocType   string `json:"docType"`
+	SpotID    string `json:"spotId"`
+	BookingID string `json:"bookingId"`
+	StartTime string `json:"startTime"`
+	EndTime   string `json:"endTime"`
+}
+
+// TimeWindow represents a half-open [StartTime, EndTime) time range
+type TimeWindow struct {
+	StartTime string `json:"startTime"`
+	EndTime   string `json:"endTime"`
+}
+
+// ==================== Reservation Calendar ====================
+
+// GetSpotCalendar returns the reservations of a spot overlapping [from, to)
+func (c *ParkingContract) GetSpotCalendar(ctx contractapi.TransactionContextInterface, spotId, fromStr, toStr string) ([]*Reservation, error) {
+	from, to, err := parseWindow(fromStr, toStr)
+	if err != nil {
+		return nil, err
+	}
+
+	reservations, err := c.getSpotReservations(ctx, spotId)
+	if err != nil {
+		return nil, err
+	}
+
+	overlapping := make([]*Reservation, 0)
+	for _, reservation := range reservations {
+		start, end, err := parseWindow(reservation.StartTime, reservation.EndTime)
+		if err != nil {
+			continue
+		}
+		if overlaps(start, end, from, to) {
+			overlapping = append(overlapping, reservation)
+		}
+	}
+
+	return overlapping, nil
+}
+
+// GetSpotAvailability returns the windows within [from, to) during which a spot is open,
+// not blacked out and not reserved
+func (c *ParkingContract) GetSpotAvailability(ctx contractapi.TransactionContextInterface, spotId, fromStr, toStr string) ([]*TimeWindow, error) {
+	from, to, err := parseWindow(fromStr, toStr)
+	if err != nil {
+		return nil, err
+	}
+
+	spot, err := c.GetParkingSpot(ctx, spotId)
+	if err != nil {
+		return nil, err
+	}
+
+	windows := make([]*TimeWindow, 0)
+	if spot.Status == "maintenance" {
+		return windows, nil
+	}
+
+	// Start from the hours the spot is open and not blacked out, and cut out reservations
+	free, err := c.serviceIntervals(ctx, spot, from, to)
+	if err != nil {
+		return nil, err
+	}
+	reservations, err := c.getSpotReservations(ctx, spotId)
+	if err != nil {
+		return nil, err
+	}
+	for _, reservation := range reservations {
+		start, end, err := parseWindow(reservation.StartTime, reservation.EndTime)
+		if err != nil {
+			continue
+		}
+		free = subtractInterval(free, start, end)
+	}
+
+	for _, window := range free {
+		windows = append(windows, newTimeWindow(window.start, window.end))
+	}
+
+	return windows, nil
+}
+
+// MigrateReservations builds calendar entries for confirmed and active bookings created
+// before the reservation calendar existed, and releases spots left in "reserved" status
+func (c *ParkingContract) MigrateReservations(ctx contractapi.TransactionContextInterface) (int, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("booking_", "booking_~")
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	migrated := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return migrated, err
+		}
+
+		var booking Booking
+		err = json.Unmarshal(queryResponse.Value, &booking)
+		if err != nil || booking.DocType != "booking" {
+			continue
+		}
+		if booking.Status != "confirmed" && booking.Status != "active" {
+			continue
+		}
+
+		err = c.putReservation(ctx, &booking)
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	spots, err := c.GetAllParkingSpots(ctx)
+	if err != nil {
+		return migrated, err
+	}
+	for _, spot := range spots {
+		if spot.Status == "reserved" {
+			err = c.UpdateSpotStatus(ctx, spot.SpotID, "available")
+			if err != nil {
+				return migrated, err
+			}
+		}
+	}
+
+	return migrated, nil
+}
+
+// getSpotReservations returns all calendar entries of a spot ordered by start time
+func (c *ParkingContract) getSpotReservations(ctx contractapi.TransactionContextInterface, spotId string) ([]*Reservation, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(reservationIndex, []string{spotId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	reservations := make([]*Reservation, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var reservation Reservation
+		err = json.Unmarshal(queryResponse.Value, &reservation)
+		if err != nil {
+			continue
+		}
+		reservations = append(reservations, &reservation)
+	}
+
+	sort.SliceStable(reservations, func(i, j int) bool {
+		return reservations[i].StartTime < reservations[j].StartTime
+	})
+
+	return reservations, nil
+}
+
+// findConflict returns the first reservation of a spot overlapping [start, end), ignoring excludeBookingId
+func (c *ParkingContract) findConflict(ctx contractapi.TransactionContextInterface, spotId string, start, end time.Time, excludeBookingId string) (*Reservation, error) {
+	reservations, err := c.getSpotReservations(ctx, spotId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reservation := range reservations {
+		if reservation.BookingID == excludeBookingId {
+			continue
+		}
+		resStart, resEnd, err := parseWindow(reservation.StartTime, reservation.EndTime)
+		if err != nil {
+			continue
+		}
+		if overlaps(resStart, resEnd, start, end) {
+			return reservation, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// putReservation writes (or rewrites) the calendar entry of a booking
+func (c *ParkingContract) putReservation(ctx contractapi.TransactionContextInterface, booking *Booking) error {
+	start, end, err := parseWindow(booking.StartTime, booking.EndTime)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(reservationIndex, []string{booking.SpotID, formatTime(start), booking.BookingID})
+	if err != nil {
+		return err
+	}
+
+	reservation := Reservation{
+		DocType:   "reservation",
+		SpotID:    booking.SpotID,
+		BookingID: booking.BookingID,
+		StartTime: formatTime(start),
+		EndTime:   formatTime(end),
+	}
+
+	reservationJSON, err := json.Marshal(reservation)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, reservationJSON)
+}
+
+// deleteReservation removes the calendar entry of a booking
+func (c *ParkingContract) deleteReservation(ctx contractapi.TransactionContextInterface, booking *Booking) error {
+	start, err := time.Parse(time.RFC3339, booking.StartTime)
+	if err != nil {
+		return fmt.Errorf("invalid start time format: %v", err)
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(reservationIndex, []string{booking.SpotID, formatTime(start), booking.BookingID})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(key)
+}
+
+// parseWindow parses an RFC3339 time range and checks that it is not empty
+func parseWindow(startStr, endStr string) (time.Time, time.Time, error) {
+	start, err := time.Parse(time.RFC3339, startStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid start time format: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339, endStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid end time format: %v", err)
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end time must be after start time")
+	}
+	return start, end, nil
+}
+
+// parseBookingWindow parses the time range of a booking, which may be at most
+// maxBookingDuration long
+func parseBookingWindow(startStr, endStr string) (time.Time, time.Time, error) {
+	start, end, err := parseWindow(startStr, endStr)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if end.Sub(start) > maxBookingDuration {
+		return time.Time{}, time.Time{}, fmt.Errorf("bookings may last at most %d days", int(maxBookingDuration.Hours()/24))
+	}
+	return start, end, nil
+}
+
+// overlaps reports whether the half-open ranges [aStart, aEnd) and [bStart, bEnd) intersect
+func overlaps(aStart, aEnd, bStart, bEnd time.Time) bool {
+	return aStart.Before(bEnd) && bStart.Before(aEnd)
+}
+
+// formatTime formats a time in UTC RFC3339 so that it sorts lexicographically
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func newTimeWindow(start, end time.Time) *TimeWindow {
+	return &TimeWindow{StartTime: formatTime(start), EndTime: formatTime(end)}
+}
@@ -0,0 +1,357 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// spotFacilityIndex lists the spots of each facility
+const spotFacilityIndex = "facilityId~spotId"
+
+// Facility represents a garage, lot or street zone grouping parking spots
+type Facility struct {
+	DocType          string         `json:"docType"`
+	FacilityID       string         `json:"facilityId"`
+	Name             string         `json:"name"`
+	Address          string         `json:"address"`
+	Polygon          []GeoPoint     `json:"polygon"`          // boundary, empty if not mapped
+	UTCOffsetMinutes int            `json:"utcOffsetMinutes"` // local time used by the opening hours
+	OpeningHours     []OpeningHours `json:"openingHours"`     // empty when always open
+	MaxHeight        float64        `json:"maxHeight"`        // meters, 0 for no limit
+	EntryRules       []string       `json:"entryRules"`
+	OperatorID       string         `json:"operatorId"`
+	IsActive         bool           `json:"isActive"`
+	CreatedAt        string         `json:"createdAt"`
+	UpdatedAt        string         `json:"updatedAt"`
+}
+
+// GeoPoint is a vertex of a facility's boundary
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// FacilityOccupancy counts the spots of a facility by what they are doing now
+type FacilityOccupancy struct {
+	FacilityID  string  `json:"facilityId"`
+	Name        string  `json:"name"`
+	Total       int     `json:"total"`     // spots in service
+	Available   int     `json:"available"` // free now
+	Occupied    int     `json:"occupied"`  // checked in
+	Reserved    int     `json:"reserved"`  // booked now, not checked in
+	Maintenance int     `json:"maintenance"`
+	Occupancy   float64 `json:"occupancy"` // percent of spots in service occupied or reserved
+	At          string  `json:"at"`
+}
+
+// ==================== Facility Management ====================
+
+// CreateFacility creates a parking facility
+func (c *ParkingContract) CreateFacility(ctx contractapi.TransactionContextInterface, facilityId, name, address string, polygon []GeoPoint, utcOffsetMinutes int, openingHours []OpeningHours, maxHeight float64, entryRules []string, operatorId string) error {
+	existing, err := ctx.GetStub().GetState(facilityId)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("facility %s already exists", facilityId)
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	facility := Facility{
+		DocType:    "facility",
+		FacilityID: facilityId,
+		OperatorID: operatorId,
+		IsActive:   true,
+		CreatedAt:  now,
+	}
+	setFacilityDetails(&facility, name, address, polygon, utcOffsetMinutes, openingHours, maxHeight, entryRules)
+	facility.UpdatedAt = now
+
+	if err := validateFacility(&facility); err != nil {
+		return err
+	}
+
+	return putFacility(ctx, &facility)
+}
+
+// UpdateFacility replaces the details of a facility. Its spots must still lie within
+// its boundary.
+func (c *ParkingContract) UpdateFacility(ctx contractapi.TransactionContextInterface, facilityId, name, address string, polygon []GeoPoint, utcOffsetMinutes int, openingHours []OpeningHours, maxHeight float64, entryRules []string) error {
+	facility, err := c.GetFacility(ctx, facilityId)
+	if err != nil {
+		return err
+	}
+
+	setFacilityDetails(facility, name, address, polygon, utcOffsetMinutes, openingHours, maxHeight, entryRules)
+	facility.UpdatedAt = time.Now().Format(time.RFC3339)
+
+	if err := validateFacility(facility); err != nil {
+		return err
+	}
+
+	spots, err := c.GetFacilitySpots(ctx, facilityId)
+	if err != nil {
+		return err
+	}
+	for _, spot := range spots {
+		if !facility.contains(spot) {
+			return fmt.Errorf("parking spot %s lies outside the new boundary of facility %s", spot.SpotID, facilityId)
+		}
+	}
+
+	return putFacility(ctx, facility)
+}
+
+// DeleteFacility deactivates a facility. Its spots keep referencing it, but no spot can
+// be added to it.
+func (c *ParkingContract) DeleteFacility(ctx contractapi.TransactionContextInterface, facilityId string) error {
+	facility, err := c.GetFacility(ctx, facilityId)
+	if err != nil {
+		return err
+	}
+
+	facility.IsActive = false
+	facility.UpdatedAt = time.Now().Format(time.RFC3339)
+
+	return putFacility(ctx, facility)
+}
+
+// GetFacility retrieves a facility by ID
+func (c *ParkingContract) GetFacility(ctx contractapi.TransactionContextInterface, facilityId string) (*Facility, error) {
+	facilityJSON, err := ctx.GetStub().GetState(facilityId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read facility: %v", err)
+	}
+	if facilityJSON == nil {
+		return nil, fmt.Errorf("facility %s does not exist", facilityId)
+	}
+
+	var facility Facility
+	err = json.Unmarshal(facilityJSON, &facility)
+	if err != nil {
+		return nil, err
+	}
+	if facility.DocType != "facility" {
+		return nil, fmt.Errorf("facility %s does not exist", facilityId)
+	}
+
+	return &facility, nil
+}
+
+// GetAllFacilities returns all facilities, active or not
+func (c *ParkingContract) GetAllFacilities(ctx contractapi.TransactionContextInterface) ([]*Facility, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("facility_", "facility_~")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	facilities := make([]*Facility, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var facility Facility
+		if err := json.Unmarshal(queryResponse.Value, &facility); err != nil {
+			continue
+		}
+		if facility.DocType == "facility" {
+			facilities = append(facilities, &facility)
+		}
+	}
+
+	return facilities, nil
+}
+
+// GetFacilitySpots returns the spots of a facility
+func (c *ParkingContract) GetFacilitySpots(ctx contractapi.TransactionContextInterface, facilityId string) ([]*ParkingSpot, error) {
+	return c.spotsByIndex(ctx, spotFacilityIndex, []string{facilityId})
+}
+
+// AssignSpotToFacility moves a spot into a facility, or out of any with an empty
+// facilityId
+func (c *ParkingContract) AssignSpotToFacility(ctx contractapi.TransactionContextInterface, spotId, facilityId string) error {
+	spot, err := c.GetParkingSpot(ctx, spotId)
+	if err != nil {
+		return err
+	}
+
+	spot.FacilityID = facilityId
+	if err := c.checkSpotFacility(ctx, spot); err != nil {
+		return err
+	}
+	spot.UpdatedAt = time.Now().Format(time.RFC3339)
+
+	return c.putSpot(ctx, spot)
+}
+
+// GetFacilityOccupancy counts the spots of a facility that are free, occupied, reserved
+// or under maintenance now
+func (c *ParkingContract) GetFacilityOccupancy(ctx contractapi.TransactionContextInterface, facilityId string) (*FacilityOccupancy, error) {
+	facility, err := c.GetFacility(ctx, facilityId)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.facilityOccupancy(ctx, facility, time.Now())
+}
+
+// GetFacilitiesOccupancy returns the occupancy of every active facility now
+func (c *ParkingContract) GetFacilitiesOccupancy(ctx contractapi.TransactionContextInterface) ([]*FacilityOccupancy, error) {
+	facilities, err := c.GetAllFacilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	occupancies := make([]*FacilityOccupancy, 0, len(facilities))
+	for _, facility := range facilities {
+		if !facility.IsActive {
+			continue
+		}
+		occupancy, err := c.facilityOccupancy(ctx, facility, now)
+		if err != nil {
+			return nil, err
+		}
+		occupancies = append(occupancies, occupancy)
+	}
+
+	return occupancies, nil
+}
+
+// facilityOccupancy counts the spots of a facility by what they are doing at a time
+func (c *ParkingContract) facilityOccupancy(ctx contractapi.TransactionContextInterface, facility *Facility, at time.Time) (*FacilityOccupancy, error) {
+	spots, err := c.GetFacilitySpots(ctx, facility.FacilityID)
+	if err != nil {
+		return nil, err
+	}
+
+	occupancy := &FacilityOccupancy{
+		FacilityID: facility.FacilityID,
+		Name:       facility.Name,
+		At:         formatTime(at),
+	}
+	for _, spot := range spots {
+		switch spot.Status {
+		case "maintenance":
+			occupancy.Maintenance++
+			continue
+		case "occupied":
+			occupancy.Occupied++
+		default:
+			conflict, err := c.findConflict(ctx, spot.SpotID, at, at.Add(time.Minute), "")
+			if err != nil {
+				return nil, err
+			}
+			if conflict != nil {
+				occupancy.Reserved++
+			} else {
+				occupancy.Available++
+			}
+		}
+		occupancy.Total++
+	}
+
+	if occupancy.Total > 0 {
+		occupancy.Occupancy = roundAmount(float64(occupancy.Occupied+occupancy.Reserved) * 100 / float64(occupancy.Total))
+	}
+	return occupancy, nil
+}
+
+// checkSpotFacility checks that the facility of a spot, if any, is active, run by the
+// spot's operator and contains the spot
+func (c *ParkingContract) checkSpotFacility(ctx contractapi.TransactionContextInterface, spot *ParkingSpot) error {
+	if spot.FacilityID == "" {
+		return nil
+	}
+
+	facility, err := c.GetFacility(ctx, spot.FacilityID)
+	if err != nil {
+		return err
+	}
+	if !facility.IsActive {
+		return fmt.Errorf("facility %s is not active", facility.FacilityID)
+	}
+	if facility.OperatorID != "" && spot.OperatorID != facility.OperatorID {
+		return fmt.Errorf("parking spot %s and facility %s have different operators", spot.SpotID, facility.FacilityID)
+	}
+	if !facility.contains(spot) {
+		return fmt.Errorf("parking spot %s lies outside facility %s", spot.SpotID, facility.FacilityID)
+	}
+	return nil
+}
+
+// contains reports whether a spot lies within the boundary of the facility. Facilities
+// without a boundary, and spots without coordinates, are not checked.
+func (f *Facility) contains(spot *ParkingSpot) bool {
+	if len(f.Polygon) == 0 || (spot.Latitude == 0 && spot.Longitude == 0) {
+		return true
+	}
+
+	// Cast a ray east of the spot and count the edges it crosses
+	inside := false
+	for i, j := 0, len(f.Polygon)-1; i < len(f.Polygon); j, i = i, i+1 {
+		a, b := f.Polygon[i], f.Polygon[j]
+		if (a.Latitude > spot.Latitude) != (b.Latitude > spot.Latitude) {
+			crossing := a.Longitude + (spot.Latitude-a.Latitude)*(b.Longitude-a.Longitude)/(b.Latitude-a.Latitude)
+			if spot.Longitude < crossing {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+func setFacilityDetails(facility *Facility, name, address string, polygon []GeoPoint, utcOffsetMinutes int, openingHours []OpeningHours, maxHeight float64, entryRules []string) {
+	facility.Name = name
+	facility.Address = address
+	facility.Polygon = polygon
+	facility.UTCOffsetMinutes = utcOffsetMinutes
+	facility.OpeningHours = openingHours
+	facility.MaxHeight = maxHeight
+	facility.EntryRules = entryRules
+
+	if facility.Polygon == nil {
+		facility.Polygon = []GeoPoint{}
+	}
+	if facility.OpeningHours == nil {
+		facility.OpeningHours = []OpeningHours{}
+	}
+	if facility.EntryRules == nil {
+		facility.EntryRules = []string{}
+	}
+}
+
+// validateFacility checks the boundary, height limit and opening hours of a facility
+func validateFacility(facility *Facility) error {
+	if facility.Name == "" {
+		return fmt.Errorf("facility name is required")
+	}
+	if facility.MaxHeight < 0 {
+		return fmt.Errorf("height limit must not be negative")
+	}
+	if len(facility.Polygon) > 0 && len(facility.Polygon) < 3 {
+		return fmt.Errorf("a facility boundary needs at least 3 points")
+	}
+	for _, point := range facility.Polygon {
+		if point.Latitude < -90 || point.Latitude > 90 || point.Longitude < -180 || point.Longitude > 180 {
+			return fmt.Errorf("invalid boundary point %f, %f", point.Latitude, point.Longitude)
+		}
+	}
+
+	return validateOpeningHours(facility.OpeningHours, facility.UTCOffsetMinutes)
+}
+
+func putFacility(ctx contractapi.TransactionContextInterface, facility *Facility) error {
+	facilityJSON, err := json.Marshal(facility)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(facility.FacilityID, facilityJSON)
+}
@@ -0,0 +1,321 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Composite-key indexes of spots and bookings. An entry's key holds the attributes the
+// record is looked up by, ending with its ID, and its value is empty; queries walk an
+// index and read only the records it lists. putSpot and putBooking keep them in step
+// with every write.
+const (
+	spotLocationIndex = "location~spotId"
+	spotStatusIndex   = "status~location~spotId"
+	spotTypeIndex     = "spotType~spotId"
+	spotPriceIndex    = "pricePerHour~spotId" // price in cents, zero-padded to sort numerically
+	userBookingIndex  = "userId~bookingId"
+	userStatusIndex   = "userId~status~bookingId"
+	spotBookingIndex  = "spotId~bookingId"
+)
+
+// priceKeyWidth is the width prices are zero-padded to in the price index
+const priceKeyWidth = 12
+
+// ReindexResult reports the progress of a reindex run
+type ReindexResult struct {
+	Scanned  int    `json:"scanned"`
+	Spots    int    `json:"spots"`
+	Bookings int    `json:"bookings"`
+	Bookmark string `json:"bookmark"` // empty when the last page was indexed
+}
+
+// ==================== Indexes ====================
+
+// ReindexParking writes the index entries of spots and bookings created before the
+// indexes existed. Records are scanned a page at a time; call again with the returned
+// bookmark until it is empty. Entries are rewritten as they are, so runs can be
+// repeated safely.
+func (c *ParkingContract) ReindexParking(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*ReindexResult, error) {
+	if pageSize < 1 || pageSize > 1000 {
+		pageSize = 100
+	}
+
+	// Paginated range queries are only allowed in read-only transactions, so pages are
+	// walked with a plain range query starting at the bookmark key. Range queries skip
+	// composite keys, so only records are scanned.
+	resultsIterator, err := ctx.GetStub().GetStateByRange(bookmark, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &ReindexResult{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if result.Scanned == pageSize {
+			result.Bookmark = queryResponse.Key
+			break
+		}
+		result.Scanned++
+
+		var doc struct {
+			DocType string `json:"docType"`
+		}
+		if err := json.Unmarshal(queryResponse.Value, &doc); err != nil {
+			continue
+		}
+
+		var keys []string
+		switch doc.DocType {
+		case "parkingSpot":
+			var spot ParkingSpot
+			if err := json.Unmarshal(queryResponse.Value, &spot); err != nil {
+				continue
+			}
+			keys, err = spotIndexKeys(ctx, &spot)
+			result.Spots++
+		case "booking":
+			var booking Booking
+			if err := json.Unmarshal(queryResponse.Value, &booking); err != nil {
+				continue
+			}
+			keys, err = bookingIndexKeys(ctx, &booking)
+			result.Bookings++
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := updateIndexes(ctx, nil, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// putSpot saves a spot and moves its index entries if an indexed attribute changed
+func (c *ParkingContract) putSpot(ctx contractapi.TransactionContextInterface, spot *ParkingSpot) error {
+	var oldKeys []string
+	previousJSON, err := ctx.GetStub().GetState(spot.SpotID)
+	if err != nil {
+		return err
+	}
+	if previousJSON != nil {
+		var previous ParkingSpot
+		if err := json.Unmarshal(previousJSON, &previous); err == nil && previous.DocType == "parkingSpot" {
+			oldKeys, err = spotIndexKeys(ctx, &previous)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	newKeys, err := spotIndexKeys(ctx, spot)
+	if err != nil {
+		return err
+	}
+
+	spotJSON, err := json.Marshal(spot)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(spot.SpotID, spotJSON); err != nil {
+		return err
+	}
+
+	return updateIndexes(ctx, oldKeys, newKeys)
+}
+
+// putBooking saves a booking and moves its index entries if an indexed attribute changed
+func (c *ParkingContract) putBooking(ctx contractapi.TransactionContextInterface, booking *Booking) error {
+	var oldKeys []string
+	previousJSON, err := ctx.GetStub().GetState(booking.BookingID)
+	if err != nil {
+		return err
+	}
+	if previousJSON != nil {
+		var previous Booking
+		if err := json.Unmarshal(previousJSON, &previous); err == nil && previous.DocType == "booking" {
+			oldKeys, err = bookingIndexKeys(ctx, &previous)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	newKeys, err := bookingIndexKeys(ctx, booking)
+	if err != nil {
+		return err
+	}
+
+	bookingJSON, err := json.Marshal(booking)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(booking.BookingID, bookingJSON); err != nil {
+		return err
+	}
+
+	return updateIndexes(ctx, oldKeys, newKeys)
+}
+
+// spotsByIndex returns the spots listed under a partial key of a spot index, in
+// index order
+func (c *ParkingContract) spotsByIndex(ctx contractapi.TransactionContextInterface, index string, attributes []string) ([]*ParkingSpot, error) {
+	ids, err := indexedIDs(ctx, index, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	var spots []*ParkingSpot
+	for _, spotId := range ids {
+		spot, err := c.GetParkingSpot(ctx, spotId)
+		if err != nil {
+			return nil, err
+		}
+		spots = append(spots, spot)
+	}
+
+	return spots, nil
+}
+
+// bookingsByIndex returns the bookings listed under a partial key of a booking index,
+// in index order
+func (c *ParkingContract) bookingsByIndex(ctx contractapi.TransactionContextInterface, index string, attributes []string) ([]*Booking, error) {
+	ids, err := indexedIDs(ctx, index, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookings []*Booking
+	for _, bookingId := range ids {
+		booking, err := c.GetBooking(ctx, bookingId)
+		if err != nil {
+			return nil, err
+		}
+		bookings = append(bookings, booking)
+	}
+
+	return bookings, nil
+}
+
+// indexedIDs returns the record IDs, the last attribute of each entry, listed under a
+// partial key of an index
+func indexedIDs(ctx contractapi.TransactionContextInterface, index string, attributes []string) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var ids []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) == 0 {
+			continue
+		}
+		ids = append(ids, compositeKeyParts[len(compositeKeyParts)-1])
+	}
+
+	return ids, nil
+}
+
+// spotIndexKeys returns the index entries of a spot
+func spotIndexKeys(ctx contractapi.TransactionContextInterface, spot *ParkingSpot) ([]string, error) {
+	entries := []indexEntry{
+		{spotLocationIndex, []string{spot.Location, spot.SpotID}},
+		{spotStatusIndex, []string{spot.Status, spot.Location, spot.SpotID}},
+		{spotTypeIndex, []string{spot.SpotType, spot.SpotID}},
+		{spotPriceIndex, []string{priceKey(spot.PricePerHour), spot.SpotID}},
+	}
+	entries = append(entries, geoIndexEntries(spotGeoIndex, spot.Latitude, spot.Longitude, spot.SpotID)...)
+	if spot.FacilityID != "" {
+		entries = append(entries, indexEntry{spotFacilityIndex, []string{spot.FacilityID, spot.SpotID}})
+	}
+	return compositeKeys(ctx, entries)
+}
+
+// bookingIndexKeys returns the index entries of a booking
+func bookingIndexKeys(ctx contractapi.TransactionContextInterface, booking *Booking) ([]string, error) {
+	return compositeKeys(ctx, []indexEntry{
+		{userBookingIndex, []string{booking.UserID, booking.BookingID}},
+		{userStatusIndex, []string{booking.UserID, booking.Status, booking.BookingID}},
+		{spotBookingIndex, []string{booking.SpotID, booking.BookingID}},
+	})
+}
+
+// indexEntry is the entry of a record in one index
+type indexEntry struct {
+	index      string
+	attributes []string
+}
+
+func compositeKeys(ctx contractapi.TransactionContextInterface, entries []indexEntry) ([]string, error) {
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		key, err := ctx.GetStub().CreateCompositeKey(entry.index, entry.attributes)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// updateIndexes deletes the entries of oldKeys that are not in newKeys and writes those
+// of newKeys that are not in oldKeys
+func updateIndexes(ctx contractapi.TransactionContextInterface, oldKeys, newKeys []string) error {
+	previous := make(map[string]bool, len(oldKeys))
+	for _, key := range oldKeys {
+		previous[key] = true
+	}
+	current := make(map[string]bool, len(newKeys))
+	for _, key := range newKeys {
+		current[key] = true
+	}
+
+	for _, key := range oldKeys {
+		if current[key] {
+			continue
+		}
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return err
+		}
+	}
+	for _, key := range newKeys {
+		if previous[key] {
+			continue
+		}
+		if err := ctx.GetStub().PutState(key, []byte{0x00}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// priceKey encodes an hourly price as zero-padded cents, so that the entries of the
+// price index sort by price
+func priceKey(price float64) string {
+	cents := int64(math.Round(price * 100))
+	if cents < 0 {
+		cents = 0
+	}
+	return fmt.Sprintf("%0*d", priceKeyWidth, cents)
+}
@@ -18,32 +18,40 @@ type ParkingSpot struct {
 	Longitude     float64  `json:"longitude"`
 	SpotType      string   `json:"spotType"` // standard, premium, disabled
 	PricePerHour  float64  `json:"pricePerHour"`
-	Status        string   `json:"status"` // available, occupied, reserved, maintenance
+	Status        string   `json:"status"` // available, occupied, maintenance
 	HasEVCharging bool     `json:"hasEVCharging"`
 	Features      []string `json:"features"`
 	OperatorID    string   `json:"operatorId"`
+	FacilityID    string   `json:"facilityId,omitempty" metadata:",optional"` // facility the spot belongs to, if any
 	CreatedAt     string   `json:"createdAt"`
 	UpdatedAt     string   `json:"updatedAt"`
+
+	// Opening hours, in local time at UTCOffsetMinutes; the facility's when empty
+	UTCOffsetMinutes int            `json:"utcOffsetMinutes,omitempty" metadata:",optional"`
+	OpeningHours     []OpeningHours `json:"openingHours,omitempty" metadata:",optional"`
 }
 
 // Booking represents a parking booking
 type Booking struct {
-	DocType        string  `json:"docType"`
-	BookingID      string  `json:"bookingId"`
-	UserID         string  `json:"userId"`
-	SpotID         string  `json:"spotId"`
-	StartTime      string  `json:"startTime"`
-	EndTime        string  `json:"endTime"`
-	ActualCheckIn  string  `json:"actualCheckIn"`
-	ActualCheckOut string  `json:"actualCheckOut"`
-	Duration       int     `json:"duration"` // hours
-	PricePerHour   float64 `json:"pricePerHour"`
-	TotalCost      float64 `json:"totalCost"`
-	Status         string  `json:"status"` // pending, confirmed, active, completed, cancelled
-	QRCode         string  `json:"qrCode"`
-	PaymentID      string  `json:"paymentId"`
-	CreatedAt      string  `json:"createdAt"`
-	UpdatedAt      string  `json:"updatedAt"`
+	DocType         string  `json:"docType"`
+	BookingID       string  `json:"bookingId"`
+	UserID          string  `json:"userId"`
+	SpotID          string  `json:"spotId"`
+	StartTime       string  `json:"startTime"`
+	EndTime         string  `json:"endTime"`
+	ActualCheckIn   string  `json:"actualCheckIn"`
+	ActualCheckOut  string  `json:"actualCheckOut"`
+	Duration        int     `json:"duration"` // hours
+	PricePerHour    float64 `json:"pricePerHour"`
+	SurgeMultiplier float64 `json:"surgeMultiplier"`
+	TotalCost       float64 `json:"totalCost"`
+	Status          string  `json:"status"` // pending, confirmed, active, completed, cancelled
+	QRCode          string  `json:"qrCode"`
+	PaymentID       string  `json:"paymentId"`
+	RefundPercent   int     `json:"refundPercent,omitempty" metadata:",optional"` // set when cancelled, from the refund policy
+	CancelledAt     string  `json:"cancelledAt,omitempty" metadata:",optional"`
+	CreatedAt       string  `json:"createdAt"`
+	UpdatedAt       string  `json:"updatedAt"`
 }
 
 // ParkingContract provides functions for managing parking spots and bookings
@@ -60,7 +68,7 @@ func (c *ParkingContract) InitLedger(ctx contractapi.TransactionContextInterface
 // ==================== Parking Spot Management ====================
 
 // CreateParkingSpot creates a new parking spot
-func (c *ParkingContract) CreateParkingSpot(ctx contractapi.TransactionContextInterface, spotId, spotNumber, location string, latitude, longitude float64, spotType string, pricePerHour float64, hasEVCharging bool, operatorId string) error {
+func (c *ParkingContract) CreateParkingSpot(ctx contractapi.TransactionContextInterface, spotId, spotNumber, location string, latitude, longitude float64, spotType string, pricePerHour float64, hasEVCharging bool, operatorId, facilityId string) error {
 	exists, err := c.SpotExists(ctx, spotId)
 	if err != nil {
 		return err
@@ -83,16 +91,15 @@ func (c *ParkingContract) CreateParkingSpot(ctx contractapi.TransactionContextIn
 		HasEVCharging: hasEVCharging,
 		Features:      []string{},
 		OperatorID:    operatorId,
+		FacilityID:    facilityId,
 		CreatedAt:     now,
 		UpdatedAt:     now,
 	}
-
-	spotJSON, err := json.Marshal(spot)
-	if err != nil {
+	if err := c.checkSpotFacility(ctx, &spot); err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(spotId, spotJSON)
+	return c.putSpot(ctx, &spot)
 }
 
 // GetParkingSpot retrieves a parking spot by ID
@@ -130,12 +137,18 @@ func (c *ParkingContract) UpdateParkingSpot(ctx contractapi.TransactionContextIn
 	spot.HasEVCharging = hasEVCharging
 	spot.UpdatedAt = time.Now().Format(time.RFC3339)
 
-	spotJSON, err := json.Marshal(spot)
-	if err != nil {
-		return err
+	// A spot must not be moved out of its facility
+	if spot.FacilityID != "" {
+		facility, err := c.GetFacility(ctx, spot.FacilityID)
+		if err != nil {
+			return err
+		}
+		if !facility.contains(spot) {
+			return fmt.Errorf("parking spot %s would lie outside facility %s", spotId, spot.FacilityID)
+		}
 	}
 
-	return ctx.GetStub().PutState(spotId, spotJSON)
+	return c.putSpot(ctx, spot)
 }
 
 // UpdateSpotStatus updates the status of a parking spot
@@ -148,12 +161,7 @@ func (c *ParkingContract) UpdateSpotStatus(ctx contractapi.TransactionContextInt
 	spot.Status = status
 	spot.UpdatedAt = time.Now().Format(time.RFC3339)
 
-	spotJSON, err := json.Marshal(spot)
-	if err != nil {
-		return err
-	}
-
-	return ctx.GetStub().PutState(spotId, spotJSON)
+	return c.putSpot(ctx, spot)
 }
 
 // DeleteParkingSpot marks a parking spot as unavailable (soft delete)
@@ -192,101 +200,49 @@ func (c *ParkingContract) GetAllParkingSpots(ctx contractapi.TransactionContextI
 	return spots, nil
 }
 
-// GetAvailableSpots returns available parking spots at a location
+// GetAvailableSpots returns the parking spots at a location that are available and in
+// service now
 func (c *ParkingContract) GetAvailableSpots(ctx contractapi.TransactionContextInterface, location string) ([]*ParkingSpot, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("spot_", "spot_~")
+	spots, err := c.spotsByIndex(ctx, spotStatusIndex, []string{"available", location})
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
 
-	var spots []*ParkingSpot
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
+	now := time.Now()
+	available := make([]*ParkingSpot, 0, len(spots))
+	for _, spot := range spots {
+		open, err := c.inService(ctx, spot, now, now.Add(time.Minute))
 		if err != nil {
 			return nil, err
 		}
-
-		var spot ParkingSpot
-		err = json.Unmarshal(queryResponse.Value, &spot)
-		if err != nil {
-			continue
-		}
-		
-		if spot.DocType == "parkingSpot" && spot.Location == location && spot.Status == "available" {
-			spots = append(spots, &spot)
+		if open {
+			available = append(available, spot)
 		}
 	}
 
-	return spots, nil
+	return available, nil
 }
 
 // QuerySpotsByLocation returns spots by location
 func (c *ParkingContract) QuerySpotsByLocation(ctx contractapi.TransactionContextInterface, location string) ([]*ParkingSpot, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("spot_", "spot_~")
-	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
-
-	var spots []*ParkingSpot
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
-		var spot ParkingSpot
-		err = json.Unmarshal(queryResponse.Value, &spot)
-		if err != nil {
-			continue
-		}
-		
-		if spot.DocType == "parkingSpot" && spot.Location == location {
-			spots = append(spots, &spot)
-		}
-	}
-
-	return spots, nil
+	return c.spotsByIndex(ctx, spotLocationIndex, []string{location})
 }
 
 // QuerySpotsByType returns spots by type
 func (c *ParkingContract) QuerySpotsByType(ctx contractapi.TransactionContextInterface, spotType string) ([]*ParkingSpot, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("spot_", "spot_~")
-	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
-
-	var spots []*ParkingSpot
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
-		var spot ParkingSpot
-		err = json.Unmarshal(queryResponse.Value, &spot)
-		if err != nil {
-			continue
-		}
-		
-		if spot.DocType == "parkingSpot" && spot.SpotType == spotType {
-			spots = append(spots, &spot)
-		}
-	}
-
-	return spots, nil
+	return c.spotsByIndex(ctx, spotTypeIndex, []string{spotType})
 }
 
 // QuerySpotsByPriceRange returns spots within a price range
 func (c *ParkingContract) QuerySpotsByPriceRange(ctx contractapi.TransactionContextInterface, minPrice, maxPrice float64) ([]*ParkingSpot, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("spot_", "spot_~")
+	// The price index sorts by price, so stop at the first spot above the range
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(spotPriceIndex, []string{})
 	if err != nil {
 		return nil, err
 	}
 	defer resultsIterator.Close()
 
+	minKey, maxKey := priceKey(minPrice), priceKey(maxPrice)
 	var spots []*ParkingSpot
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
@@ -294,15 +250,22 @@ func (c *ParkingContract) QuerySpotsByPriceRange(ctx contractapi.TransactionCont
 			return nil, err
 		}
 
-		var spot ParkingSpot
-		err = json.Unmarshal(queryResponse.Value, &spot)
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
 		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) < 2 || compositeKeyParts[0] < minKey {
 			continue
 		}
-		
-		if spot.DocType == "parkingSpot" && spot.PricePerHour >= minPrice && spot.PricePerHour <= maxPrice {
-			spots = append(spots, &spot)
+		if compositeKeyParts[0] > maxKey {
+			break
 		}
+
+		spot, err := c.GetParkingSpot(ctx, compositeKeyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		spots = append(spots, spot)
 	}
 
 	return spots, nil
@@ -319,61 +282,83 @@ func (c *ParkingContract) SpotExists(ctx contractapi.TransactionContextInterface
 
 // ==================== Booking Management ====================
 
-// CreateBooking creates a new parking booking
-func (c *ParkingContract) CreateBooking(ctx contractapi.TransactionContextInterface, bookingId, userId, spotId string, startTimeStr, endTimeStr string, totalCost float64, paymentId string) error {
+// CreateBooking creates a new parking booking. The price is computed by the ledger and
+// paidAmount, the amount charged by paymentId, must match it.
+func (c *ParkingContract) CreateBooking(ctx contractapi.TransactionContextInterface, bookingId, userId, spotId string, startTimeStr, endTimeStr string, paidAmount float64, paymentId string) error {
 	// Parse times
-	startTime, err := time.Parse(time.RFC3339, startTimeStr)
+	startTime, endTime, err := parseBookingWindow(startTimeStr, endTimeStr)
 	if err != nil {
-		return fmt.Errorf("invalid start time format: %v", err)
+		return err
 	}
-	endTime, err := time.Parse(time.RFC3339, endTimeStr)
+
+	existing, err := ctx.GetStub().GetState(bookingId)
 	if err != nil {
-		return fmt.Errorf("invalid end time format: %v", err)
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("booking %s already exists", bookingId)
 	}
 
-	// Verify spot exists and is available
+	// Verify spot exists and is not out of service
 	spot, err := c.GetParkingSpot(ctx, spotId)
 	if err != nil {
 		return err
 	}
-	if spot.Status != "available" {
+	if spot.Status == "maintenance" {
 		return fmt.Errorf("parking spot %s is not available", spotId)
 	}
+	if err := c.checkInService(ctx, spot, startTime, endTime); err != nil {
+		return err
+	}
 
-	duration := int(endTime.Sub(startTime).Hours())
-	now := time.Now().Format(time.RFC3339)
+	// Reject bookings overlapping a confirmed or active reservation
+	conflict, err := c.findConflict(ctx, spotId, startTime, endTime, "")
+	if err != nil {
+		return err
+	}
+	if conflict != nil {
+		return fmt.Errorf("parking spot %s is already reserved from %s to %s", spotId, conflict.StartTime, conflict.EndTime)
+	}
 
-	booking := Booking{
-		DocType:        "booking",
-		BookingID:      bookingId,
-		UserID:         userId,
-		SpotID:         spotId,
-		StartTime:      startTimeStr,
-		EndTime:        endTimeStr,
-		ActualCheckIn:  "",
-		ActualCheckOut: "",
-		Duration:       duration,
-		PricePerHour:   spot.PricePerHour,
-		TotalCost:      totalCost,
-		Status:         "confirmed",
-		QRCode:         fmt.Sprintf("QR_%s_%s", bookingId, spotId),
-		PaymentID:      paymentId,
-		CreatedAt:      now,
-		UpdatedAt:      now,
-	}
-
-	bookingJSON, err := json.Marshal(booking)
+	quote, err := c.QuoteBooking(ctx, spotId, startTimeStr, endTimeStr)
+	if err != nil {
+		return err
+	}
+	err = checkPaidAmount(paidAmount, quote.TotalCost)
 	if err != nil {
 		return err
 	}
 
-	// Update spot status to reserved
-	err = c.UpdateSpotStatus(ctx, spotId, "reserved")
+	duration := int(endTime.Sub(startTime).Hours())
+	now := time.Now().Format(time.RFC3339)
+
+	booking := Booking{
+		DocType:         "booking",
+		BookingID:       bookingId,
+		UserID:          userId,
+		SpotID:          spotId,
+		StartTime:       startTimeStr,
+		EndTime:         endTimeStr,
+		ActualCheckIn:   "",
+		ActualCheckOut:  "",
+		Duration:        duration,
+		PricePerHour:    spot.PricePerHour,
+		SurgeMultiplier: quote.SurgeMultiplier,
+		TotalCost:       quote.TotalCost,
+		Status:          "confirmed",
+		QRCode:          fmt.Sprintf("QR_%s_%s", bookingId, spotId),
+		PaymentID:       paymentId,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	// Add the booking to the spot's reservation calendar
+	err = c.putReservation(ctx, &booking)
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(bookingId, bookingJSON)
+	return c.putBooking(ctx, &booking)
 }
 
 // GetBooking retrieves a booking by ID
@@ -405,21 +390,20 @@ func (c *ParkingContract) UpdateBookingStatus(ctx contractapi.TransactionContext
 	booking.Status = status
 	booking.UpdatedAt = time.Now().Format(time.RFC3339)
 
-	bookingJSON, err := json.Marshal(booking)
-	if err != nil {
-		return err
-	}
-
-	return ctx.GetStub().PutState(bookingId, bookingJSON)
+	return c.putBooking(ctx, booking)
 }
 
-// CheckInBooking records check-in for a booking
-func (c *ParkingContract) CheckInBooking(ctx contractapi.TransactionContextInterface, bookingId string) error {
+// CheckInBooking records check-in for a booking on behalf of the caller
+func (c *ParkingContract) CheckInBooking(ctx contractapi.TransactionContextInterface, bookingId, callerId, callerRole string) error {
 	booking, err := c.GetBooking(ctx, bookingId)
 	if err != nil {
 		return err
 	}
 
+	if err := c.authorizeBooking(ctx, booking, callerId, callerRole); err != nil {
+		return err
+	}
+
 	if booking.Status != "confirmed" {
 		return fmt.Errorf("booking %s is not in confirmed status", bookingId)
 	}
@@ -429,27 +413,26 @@ func (c *ParkingContract) CheckInBooking(ctx contractapi.TransactionContextInter
 	booking.Status = "active"
 	booking.UpdatedAt = now
 
-	bookingJSON, err := json.Marshal(booking)
-	if err != nil {
-		return err
-	}
-
 	// Update spot status to occupied
 	err = c.UpdateSpotStatus(ctx, booking.SpotID, "occupied")
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(bookingId, bookingJSON)
+	return c.putBooking(ctx, booking)
 }
 
-// CheckOutBooking records check-out for a booking
-func (c *ParkingContract) CheckOutBooking(ctx contractapi.TransactionContextInterface, bookingId string) (*Booking, error) {
+// CheckOutBooking records check-out for a booking on behalf of the caller
+func (c *ParkingContract) CheckOutBooking(ctx contractapi.TransactionContextInterface, bookingId, callerId, callerRole string) (*Booking, error) {
 	booking, err := c.GetBooking(ctx, bookingId)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := c.authorizeBooking(ctx, booking, callerId, callerRole); err != nil {
+		return nil, err
+	}
+
 	if booking.Status != "active" {
 		return nil, fmt.Errorf("booking %s is not active", bookingId)
 	}
@@ -460,14 +443,26 @@ func (c *ParkingContract) CheckOutBooking(ctx contractapi.TransactionContextInte
 	booking.Status = "completed"
 	booking.UpdatedAt = nowStr
 
-	// Recalculate cost if overtime
+	// Recalculate cost if overtime, pricing the whole stay so that caps still apply
+	startTime, _ := time.Parse(time.RFC3339, booking.StartTime)
 	endTime, _ := time.Parse(time.RFC3339, booking.EndTime)
 	if now.After(endTime) {
-		extraHours := int(now.Sub(endTime).Hours()) + 1
-		booking.TotalCost += float64(extraHours) * booking.PricePerHour
+		rules, err := c.GetPricingRules(ctx)
+		if err != nil {
+			return nil, err
+		}
+		schedule, surge, err := c.bookingSchedule(ctx, booking)
+		if err != nil {
+			return nil, err
+		}
+		_, total := calculatePrice(schedule, surge, startTime, now, rules)
+		if total > booking.TotalCost {
+			booking.TotalCost = total
+		}
 	}
 
-	bookingJSON, err := json.Marshal(booking)
+	// Release the rest of the reserved window
+	err = c.deleteReservation(ctx, booking)
 	if err != nil {
 		return nil, err
 	}
@@ -478,7 +473,7 @@ func (c *ParkingContract) CheckOutBooking(ctx contractapi.TransactionContextInte
 		return nil, err
 	}
 
-	err = ctx.GetStub().PutState(bookingId, bookingJSON)
+	err = c.putBooking(ctx, booking)
 	if err != nil {
 		return nil, err
 	}
@@ -486,171 +481,151 @@ func (c *ParkingContract) CheckOutBooking(ctx contractapi.TransactionContextInte
 	return booking, nil
 }
 
-// ExtendBooking extends a booking
-func (c *ParkingContract) ExtendBooking(ctx contractapi.TransactionContextInterface, bookingId, newEndTimeStr string, additionalCost float64) error {
+// ExtendBooking extends a booking on behalf of its user, the operator of its spot, or
+// an admin. paidAmount must match the amount returned by QuoteExtension.
+func (c *ParkingContract) ExtendBooking(ctx contractapi.TransactionContextInterface, bookingId, newEndTimeStr string, paidAmount float64, callerId, callerRole string) error {
 	booking, err := c.GetBooking(ctx, bookingId)
 	if err != nil {
 		return err
 	}
 
-	newEndTime, err := time.Parse(time.RFC3339, newEndTimeStr)
+	if err := c.authorizeBooking(ctx, booking, callerId, callerRole); err != nil {
+		return err
+	}
+
+	if booking.Status != "confirmed" && booking.Status != "active" {
+		return fmt.Errorf("booking %s cannot be extended", bookingId)
+	}
+
+	startTime, newEndTime, err := parseBookingWindow(booking.StartTime, newEndTimeStr)
+	if err != nil {
+		return err
+	}
+	currentEndTime, err := time.Parse(time.RFC3339, booking.EndTime)
 	if err != nil {
 		return fmt.Errorf("invalid end time format: %v", err)
 	}
+	if !newEndTime.After(currentEndTime) {
+		return fmt.Errorf("new end time must be after the current end time")
+	}
+
+	// The extension must fall within opening hours and not run into a blackout or
+	// another reservation
+	spot, err := c.GetParkingSpot(ctx, booking.SpotID)
+	if err != nil {
+		return err
+	}
+	if err := c.checkInService(ctx, spot, currentEndTime, newEndTime); err != nil {
+		return err
+	}
+	conflict, err := c.findConflict(ctx, booking.SpotID, startTime, newEndTime, bookingId)
+	if err != nil {
+		return err
+	}
+	if conflict != nil {
+		return fmt.Errorf("parking spot %s is already reserved from %s to %s", booking.SpotID, conflict.StartTime, conflict.EndTime)
+	}
+
+	quote, err := c.QuoteExtension(ctx, bookingId, newEndTimeStr)
+	if err != nil {
+		return err
+	}
+	err = checkPaidAmount(paidAmount, quote.Amount)
+	if err != nil {
+		return err
+	}
 
-	startTime, _ := time.Parse(time.RFC3339, booking.StartTime)
 	booking.EndTime = newEndTimeStr
-	booking.TotalCost += additionalCost
+	booking.TotalCost = roundAmount(booking.TotalCost + quote.Amount)
 	booking.Duration = int(newEndTime.Sub(startTime).Hours())
 	booking.UpdatedAt = time.Now().Format(time.RFC3339)
 
-	bookingJSON, err := json.Marshal(booking)
+	err = c.putReservation(ctx, booking)
 	if err != nil {
 		return err
 	}
 
-	return ctx.GetStub().PutState(bookingId, bookingJSON)
+	return c.putBooking(ctx, booking)
 }
 
-// CancelBooking cancels a booking
-func (c *ParkingContract) CancelBooking(ctx contractapi.TransactionContextInterface, bookingId string) error {
+// CancelBooking cancels a booking and records the percent of its cost to refund,
+// according to the refund policy and how long before the start it was cancelled
+func (c *ParkingContract) CancelBooking(ctx contractapi.TransactionContextInterface, bookingId, callerId, callerRole string) error {
 	booking, err := c.GetBooking(ctx, bookingId)
 	if err != nil {
 		return err
 	}
 
+	if err := c.authorizeBooking(ctx, booking, callerId, callerRole); err != nil {
+		return err
+	}
+
 	if booking.Status == "completed" || booking.Status == "cancelled" {
 		return fmt.Errorf("booking %s cannot be cancelled", bookingId)
 	}
 
-	booking.Status = "cancelled"
-	booking.UpdatedAt = time.Now().Format(time.RFC3339)
-
-	bookingJSON, err := json.Marshal(booking)
+	policy, err := c.GetRefundPolicy(ctx)
 	if err != nil {
 		return err
 	}
-
-	// Update spot status to available
-	err = c.UpdateSpotStatus(ctx, booking.SpotID, "available")
+	startTime, err := time.Parse(time.RFC3339, booking.StartTime)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid start time: %v", err)
 	}
 
-	return ctx.GetStub().PutState(bookingId, bookingJSON)
-}
+	now := time.Now()
+	wasActive := booking.Status == "active"
+	booking.Status = "cancelled"
+	booking.RefundPercent = policy.refundPercent(startTime.Sub(now))
+	booking.CancelledAt = now.Format(time.RFC3339)
+	booking.UpdatedAt = now.Format(time.RFC3339)
 
-// GetUserBookings returns all bookings for a user
-func (c *ParkingContract) GetUserBookings(ctx contractapi.TransactionContextInterface, userId string) ([]*Booking, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("booking_", "booking_~")
+	// Free the reserved window
+	err = c.deleteReservation(ctx, booking)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer resultsIterator.Close()
 
-	var bookings []*Booking
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
+	// Only a checked-in booking holds the spot physically
+	if wasActive {
+		err = c.UpdateSpotStatus(ctx, booking.SpotID, "available")
 		if err != nil {
-			return nil, err
-		}
-
-		var booking Booking
-		err = json.Unmarshal(queryResponse.Value, &booking)
-		if err != nil {
-			continue
-		}
-		
-		if booking.DocType == "booking" && booking.UserID == userId {
-			bookings = append(bookings, &booking)
+			return err
 		}
 	}
 
-	return bookings, nil
+	return c.putBooking(ctx, booking)
+}
+
+// GetUserBookings returns all bookings for a user
+func (c *ParkingContract) GetUserBookings(ctx contractapi.TransactionContextInterface, userId string) ([]*Booking, error) {
+	return c.bookingsByIndex(ctx, userBookingIndex, []string{userId})
 }
 
 // GetSpotBookings returns all bookings for a parking spot
 func (c *ParkingContract) GetSpotBookings(ctx contractapi.TransactionContextInterface, spotId string) ([]*Booking, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("booking_", "booking_~")
-	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
-
-	var bookings []*Booking
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
-		var booking Booking
-		err = json.Unmarshal(queryResponse.Value, &booking)
-		if err != nil {
-			continue
-		}
-		
-		if booking.DocType == "booking" && booking.SpotID == spotId {
-			bookings = append(bookings, &booking)
-		}
-	}
-
-	return bookings, nil
+	return c.bookingsByIndex(ctx, spotBookingIndex, []string{spotId})
 }
 
 // GetActiveBookings returns active bookings for a user
 func (c *ParkingContract) GetActiveBookings(ctx contractapi.TransactionContextInterface, userId string) ([]*Booking, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("booking_", "booking_~")
-	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
-
-	var bookings []*Booking
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
-		var booking Booking
-		err = json.Unmarshal(queryResponse.Value, &booking)
-		if err != nil {
-			continue
-		}
-		
-		if booking.DocType == "booking" && booking.UserID == userId && (booking.Status == "confirmed" || booking.Status == "active") {
-			bookings = append(bookings, &booking)
-		}
-	}
-
-	return bookings, nil
+	return c.bookingsByStatus(ctx, userId, "confirmed", "active")
 }
 
 // GetBookingHistory returns completed/cancelled bookings for a user
 func (c *ParkingContract) GetBookingHistory(ctx contractapi.TransactionContextInterface, userId string) ([]*Booking, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("booking_", "booking_~")
-	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
+	return c.bookingsByStatus(ctx, userId, "completed", "cancelled")
+}
 
+// bookingsByStatus returns the bookings of a user in any of the given statuses
+func (c *ParkingContract) bookingsByStatus(ctx contractapi.TransactionContextInterface, userId string, statuses ...string) ([]*Booking, error) {
 	var bookings []*Booking
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
+	for _, status := range statuses {
+		matching, err := c.bookingsByIndex(ctx, userStatusIndex, []string{userId, status})
 		if err != nil {
 			return nil, err
 		}
-
-		var booking Booking
-		err = json.Unmarshal(queryResponse.Value, &booking)
-		if err != nil {
-			continue
-		}
-		
-		if booking.DocType == "booking" && booking.UserID == userId && (booking.Status == "completed" || booking.Status == "cancelled") {
-			bookings = append(bookings, &booking)
-		}
+		bookings = append(bookings, matching...)
 	}
 
 	return bookings, nil
@@ -0,0 +1,299 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// pricingRulesKey is the ledger key of the network-wide pricing rules
+const pricingRulesKey = "pricing_rules"
+
+// PricingRules configures how booking prices are computed from a spot's hourly rate
+type PricingRules struct {
+	DocType            string  `json:"docType"`
+	MinimumCharge      float64 `json:"minimumCharge"`      // lowest amount charged for a booking
+	GranularityMinutes int     `json:"granularityMinutes"` // billed time is rounded up to this step
+	DailyCap           float64 `json:"dailyCap"`           // maximum charged per 24h of a booking, 0 for none
+	UpdatedAt          string  `json:"updatedAt"`
+}
+
+// Quote represents the price of a booking window as computed by the ledger
+type Quote struct {
+	SpotID          string  `json:"spotId"`
+	BookingID       string  `json:"bookingId,omitempty" metadata:",optional"`
+	StartTime       string  `json:"startTime"`
+	EndTime         string  `json:"endTime"`
+	PricePerHour    float64 `json:"pricePerHour"`
+	Occupancy       float64 `json:"occupancy"` // percent of the location's spots taken at start
+	SurgeMultiplier float64 `json:"surgeMultiplier"`
+	BillableMinutes int     `json:"billableMinutes"`
+	TotalCost       float64 `json:"totalCost"` // price of the whole window
+	Amount          float64 `json:"amount"`    // amount due now
+}
+
+// defaultPricingRules bills per started hour, matching the original overtime charge
+func defaultPricingRules() *PricingRules {
+	return &PricingRules{
+		DocType:            "pricingRules",
+		MinimumCharge:      0,
+		GranularityMinutes: 60,
+		DailyCap:           0,
+	}
+}
+
+// ==================== Pricing ====================
+
+// SetPricingRules replaces the pricing rules applied to new bookings and extensions
+func (c *ParkingContract) SetPricingRules(ctx contractapi.TransactionContextInterface, minimumCharge float64, granularityMinutes int, dailyCap float64) error {
+	if minimumCharge < 0 || dailyCap < 0 {
+		return fmt.Errorf("minimum charge and daily cap must not be negative")
+	}
+	if granularityMinutes < 1 || granularityMinutes > 24*60 {
+		return fmt.Errorf("granularity must be between 1 and 1440 minutes")
+	}
+	if dailyCap > 0 && minimumCharge > dailyCap {
+		return fmt.Errorf("minimum charge must not exceed the daily cap")
+	}
+
+	rules := PricingRules{
+		DocType:            "pricingRules",
+		MinimumCharge:      minimumCharge,
+		GranularityMinutes: granularityMinutes,
+		DailyCap:           dailyCap,
+		UpdatedAt:          time.Now().Format(time.RFC3339),
+	}
+
+	rulesJSON, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(pricingRulesKey, rulesJSON)
+}
+
+// GetPricingRules returns the current pricing rules, or the defaults if none were set
+func (c *ParkingContract) GetPricingRules(ctx contractapi.TransactionContextInterface) (*PricingRules, error) {
+	rulesJSON, err := ctx.GetStub().GetState(pricingRulesKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pricing rules: %v", err)
+	}
+	if rulesJSON == nil {
+		return defaultPricingRules(), nil
+	}
+
+	var rules PricingRules
+	err = json.Unmarshal(rulesJSON, &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rules, nil
+}
+
+// QuoteBooking returns the price of booking a spot for [startTime, endTime).
+// Each minute is priced against the tariff in force at that minute; the surge
+// multiplier is set by the occupancy of the spot's location when the booking starts.
+func (c *ParkingContract) QuoteBooking(ctx contractapi.TransactionContextInterface, spotId, startTimeStr, endTimeStr string) (*Quote, error) {
+	startTime, endTime, err := parseBookingWindow(startTimeStr, endTimeStr)
+	if err != nil {
+		return nil, err
+	}
+
+	spot, err := c.GetParkingSpot(ctx, spotId)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := c.GetPricingRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, err := c.newTariffSchedule(ctx, spot, spot.PricePerHour)
+	if err != nil {
+		return nil, err
+	}
+
+	occupancy, err := c.locationOccupancy(ctx, spot.Location, startTime)
+	if err != nil {
+		return nil, err
+	}
+	surge := schedule.surgeMultiplier(startTime, occupancy)
+
+	minutes, total := calculatePrice(schedule, surge, startTime, endTime, rules)
+
+	return &Quote{
+		SpotID:          spotId,
+		StartTime:       startTimeStr,
+		EndTime:         endTimeStr,
+		PricePerHour:    schedule.rateAt(startTime),
+		Occupancy:       occupancy,
+		SurgeMultiplier: surge,
+		BillableMinutes: minutes,
+		TotalCost:       total,
+		Amount:          total,
+	}, nil
+}
+
+// QuoteExtension returns the additional amount due to move a booking's end to newEndTime.
+// The whole extended window is priced so that minimum charges and caps apply to the
+// booking as a whole, and what was already paid is deducted.
+func (c *ParkingContract) QuoteExtension(ctx contractapi.TransactionContextInterface, bookingId, newEndTimeStr string) (*Quote, error) {
+	booking, err := c.GetBooking(ctx, bookingId)
+	if err != nil {
+		return nil, err
+	}
+
+	startTime, newEndTime, err := parseBookingWindow(booking.StartTime, newEndTimeStr)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := c.GetPricingRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extensions keep the surge multiplier and flat rate the booking was made at
+	schedule, surge, err := c.bookingSchedule(ctx, booking)
+	if err != nil {
+		return nil, err
+	}
+	minutes, total := calculatePrice(schedule, surge, startTime, newEndTime, rules)
+
+	return &Quote{
+		SpotID:          booking.SpotID,
+		BookingID:       bookingId,
+		StartTime:       booking.StartTime,
+		EndTime:         newEndTimeStr,
+		PricePerHour:    booking.PricePerHour,
+		SurgeMultiplier: surge,
+		BillableMinutes: minutes,
+		TotalCost:       total,
+		Amount:          math.Max(roundAmount(total-booking.TotalCost), 0),
+	}, nil
+}
+
+// bookingSchedule returns the tariff schedule and surge multiplier a booking is priced with
+func (c *ParkingContract) bookingSchedule(ctx contractapi.TransactionContextInterface, booking *Booking) (*tariffSchedule, float64, error) {
+	spot, err := c.GetParkingSpot(ctx, booking.SpotID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	schedule, err := c.newTariffSchedule(ctx, spot, booking.PricePerHour)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Bookings made before tariffs existed carry no multiplier
+	surge := booking.SurgeMultiplier
+	if surge == 0 {
+		surge = 1
+	}
+
+	return schedule, surge, nil
+}
+
+// locationOccupancy returns the percentage of in-service spots at a location that are
+// occupied or reserved at the given time
+func (c *ParkingContract) locationOccupancy(ctx contractapi.TransactionContextInterface, location string, at time.Time) (float64, error) {
+	spots, err := c.QuerySpotsByLocation(ctx, location)
+	if err != nil {
+		return 0, err
+	}
+
+	total, taken := 0, 0
+	for _, spot := range spots {
+		if spot.Status == "maintenance" {
+			continue
+		}
+		total++
+
+		if spot.Status == "occupied" {
+			taken++
+			continue
+		}
+		conflict, err := c.findConflict(ctx, spot.SpotID, at, at.Add(time.Minute), "")
+		if err != nil {
+			return 0, err
+		}
+		if conflict != nil {
+			taken++
+		}
+	}
+
+	if total == 0 {
+		return 0, nil
+	}
+	return roundAmount(float64(taken) * 100 / float64(total)), nil
+}
+
+// calculatePrice returns the billable minutes and the price of [start, end). Each
+// billable minute is priced at the rate in force when it starts; the window is priced
+// in segments of minutes at one rate, split where the rate may change and at the end
+// of each day of the booking.
+func calculatePrice(schedule *tariffSchedule, surge float64, start, end time.Time, rules *PricingRules) (int, float64) {
+	granularity := rules.GranularityMinutes
+	if granularity < 1 {
+		granularity = 60
+	}
+
+	// Round the duration up to the billing granularity
+	minutes := int(math.Ceil(end.Sub(start).Minutes()))
+	if minutes%granularity != 0 {
+		minutes += granularity - minutes%granularity
+	}
+
+	// Each full day of the booking is capped separately, then the remainder
+	const minutesPerDay = 24 * 60
+	dayPrices := make([]float64, (minutes+minutesPerDay-1)/minutesPerDay)
+	for i := 0; i < minutes; {
+		minute := start.Add(time.Duration(i) * time.Minute)
+
+		next := (i/minutesPerDay + 1) * minutesPerDay
+		if next > minutes {
+			next = minutes
+		}
+		if change, ok := schedule.nextChange(minute); ok {
+			// The first minute starting at or after the change
+			if changeMinute := int(math.Ceil(change.Sub(start).Minutes())); changeMinute < next {
+				next = changeMinute
+			}
+		}
+
+		dayPrices[i/minutesPerDay] += float64(next-i) * schedule.rateAt(minute) / 60 * surge
+		i = next
+	}
+
+	price := 0.0
+	for _, dayPrice := range dayPrices {
+		if rules.DailyCap > 0 && dayPrice > rules.DailyCap {
+			dayPrice = rules.DailyCap
+		}
+		price += dayPrice
+	}
+
+	if price < rules.MinimumCharge {
+		price = rules.MinimumCharge
+	}
+
+	return minutes, roundAmount(price)
+}
+
+// roundAmount rounds a monetary amount to cents
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// checkPaidAmount rejects a payment that differs from the amount computed by the ledger
+func checkPaidAmount(paidAmount, expected float64) error {
+	if math.Abs(roundAmount(paidAmount)-expected) >= 0.005 {
+		return fmt.Errorf("paid amount %.2f does not match the price %.2f", paidAmount, expected)
+	}
+	return nil
+}
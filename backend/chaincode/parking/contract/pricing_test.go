@@ -0,0 +1,257 @@
+package contract
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalculatePrice(t *testing.T) {
+	// Friday 2 January 2026, midnight UTC
+	day := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	at := func(hours, minutes, seconds int) time.Time {
+		return day.Add(time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second)
+	}
+
+	// No tariff: the spot's flat rate of 6.00 per hour, 0.10 per minute
+	flat := &tariffSchedule{fallbackRate: 6}
+	withTariffs := func(tariffs ...*Tariff) *tariffSchedule {
+		return &tariffSchedule{scopes: [][]*Tariff{tariffs}, fallbackRate: 6}
+	}
+	peak := &Tariff{
+		BaseRate:      6,
+		Rules:         []TariffRule{{StartTime: "08:00", EndTime: "10:00", Rate: 12}},
+		EffectiveFrom: "2025-01-01T00:00:00Z",
+	}
+
+	tests := []struct {
+		name        string
+		schedule    *tariffSchedule
+		surge       float64
+		start, end  time.Time
+		rules       PricingRules
+		wantMinutes int
+		wantPrice   float64
+	}{
+		{
+			name:     "started hour is billed in full",
+			schedule: flat, start: at(10, 0, 0), end: at(11, 1, 0),
+			rules:       PricingRules{GranularityMinutes: 60},
+			wantMinutes: 120, wantPrice: 12,
+		},
+		{
+			name:     "exact hours",
+			schedule: flat, start: at(10, 0, 0), end: at(12, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60},
+			wantMinutes: 120, wantPrice: 12,
+		},
+		{
+			name:     "quarter hour granularity",
+			schedule: flat, start: at(10, 0, 0), end: at(11, 1, 0),
+			rules:       PricingRules{GranularityMinutes: 15},
+			wantMinutes: 75, wantPrice: 7.5,
+		},
+		{
+			name:     "per minute granularity",
+			schedule: flat, start: at(10, 0, 0), end: at(11, 1, 0),
+			rules:       PricingRules{GranularityMinutes: 1},
+			wantMinutes: 61, wantPrice: 6.1,
+		},
+		{
+			name:     "started minute is billed in full",
+			schedule: flat, start: at(10, 0, 0), end: at(10, 0, 30),
+			rules:       PricingRules{GranularityMinutes: 1},
+			wantMinutes: 1, wantPrice: 0.1,
+		},
+		{
+			name:     "unset granularity bills per hour",
+			schedule: flat, start: at(10, 0, 0), end: at(10, 20, 0),
+			rules:       PricingRules{},
+			wantMinutes: 60, wantPrice: 6,
+		},
+		{
+			name:     "minimum charge",
+			schedule: flat, start: at(10, 0, 0), end: at(10, 10, 0),
+			rules:       PricingRules{GranularityMinutes: 1, MinimumCharge: 5},
+			wantMinutes: 10, wantPrice: 5,
+		},
+		{
+			name:     "minimum charge below the price",
+			schedule: flat, start: at(10, 0, 0), end: at(11, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 1, MinimumCharge: 5},
+			wantMinutes: 60, wantPrice: 6,
+		},
+		{
+			name:     "daily cap",
+			schedule: flat, start: at(6, 0, 0), end: at(16, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60, DailyCap: 40},
+			wantMinutes: 600, wantPrice: 40,
+		},
+		{
+			name:     "daily cap applies to each day of the booking",
+			schedule: flat, start: at(6, 0, 0), end: at(36, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60, DailyCap: 40},
+			wantMinutes: 1800, wantPrice: 40 + 36,
+		},
+		{
+			name:     "daily cap on several full days",
+			schedule: flat, start: at(0, 0, 0), end: at(50, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60, DailyCap: 40},
+			wantMinutes: 3000, wantPrice: 40 + 40 + 12,
+		},
+		{
+			name:     "days are counted from the start of the booking",
+			schedule: flat, start: at(20, 0, 0), end: at(52, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60, DailyCap: 100},
+			wantMinutes: 1920, wantPrice: 100 + 48,
+		},
+		{
+			name:     "surge multiplier",
+			schedule: flat, surge: 1.5, start: at(10, 0, 0), end: at(12, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60},
+			wantMinutes: 120, wantPrice: 18,
+		},
+		{
+			name:     "cap applies after surge",
+			schedule: flat, surge: 2, start: at(6, 0, 0), end: at(16, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60, DailyCap: 40},
+			wantMinutes: 600, wantPrice: 40,
+		},
+		{
+			name:     "longest booking",
+			schedule: flat, start: at(0, 0, 0), end: at(30*24, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60},
+			wantMinutes: 30 * 24 * 60, wantPrice: 30 * 144,
+		},
+		{
+			name:     "time of day rule",
+			schedule: withTariffs(peak), start: at(7, 0, 0), end: at(9, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60},
+			wantMinutes: 120, wantPrice: 6 + 12,
+		},
+		{
+			name:     "rounded up time is priced at the rule in force",
+			schedule: withTariffs(peak), start: at(7, 0, 0), end: at(8, 10, 0),
+			rules:       PricingRules{GranularityMinutes: 60},
+			wantMinutes: 120, wantPrice: 6 + 12,
+		},
+		{
+			name:     "minute starting before a rule is priced before it",
+			schedule: withTariffs(peak), start: at(7, 59, 30), end: at(8, 1, 30),
+			rules:       PricingRules{GranularityMinutes: 1},
+			wantMinutes: 2, wantPrice: 0.1 + 0.2,
+		},
+		{
+			name:     "rule every day of a long booking",
+			schedule: withTariffs(peak), start: at(0, 0, 0), end: at(72, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60},
+			wantMinutes: 72 * 60, wantPrice: 3 * (22*6 + 2*12),
+		},
+		{
+			name: "rule in local time",
+			schedule: withTariffs(&Tariff{
+				BaseRate:         6,
+				UTCOffsetMinutes: 120,
+				Rules:            []TariffRule{{StartTime: "08:00", EndTime: "10:00", Rate: 12}},
+				EffectiveFrom:    "2025-01-01T00:00:00Z",
+			}),
+			start: at(6, 30, 0), end: at(8, 30, 0),
+			rules:       PricingRules{GranularityMinutes: 1},
+			wantMinutes: 120, wantPrice: 1.5*12 + 0.5*6,
+		},
+		{
+			name: "rule past midnight",
+			schedule: withTariffs(&Tariff{
+				BaseRate:      6,
+				Rules:         []TariffRule{{StartTime: "22:00", EndTime: "06:00", Rate: 3}},
+				EffectiveFrom: "2025-01-01T00:00:00Z",
+			}),
+			start: at(21, 0, 0), end: at(31, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60},
+			wantMinutes: 600, wantPrice: 6 + 8*3 + 6,
+		},
+		{
+			name: "weekday rule changes at midnight",
+			schedule: withTariffs(&Tariff{
+				BaseRate:      6,
+				Rules:         []TariffRule{{Days: []int{6}, StartTime: "00:00", EndTime: "24:00", Rate: 12}},
+				EffectiveFrom: "2025-01-01T00:00:00Z",
+			}),
+			start: at(23, 0, 0), end: at(25, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60},
+			wantMinutes: 120, wantPrice: 6 + 12,
+		},
+		{
+			name: "new tariff version",
+			schedule: withTariffs(
+				&Tariff{BaseRate: 6, EffectiveFrom: "2025-01-01T00:00:00Z", EffectiveTo: "2026-01-02T12:00:00Z"},
+				&Tariff{BaseRate: 12, EffectiveFrom: "2026-01-02T12:00:00Z"},
+			),
+			start: at(11, 0, 0), end: at(13, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60},
+			wantMinutes: 120, wantPrice: 6 + 12,
+		},
+		{
+			name: "flat rate before the first tariff",
+			schedule: withTariffs(
+				&Tariff{BaseRate: 12, EffectiveFrom: "2026-01-02T12:00:00Z"},
+			),
+			start: at(11, 0, 0), end: at(13, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60},
+			wantMinutes: 120, wantPrice: 6 + 12,
+		},
+		{
+			name: "cap with rules across days",
+			schedule: withTariffs(&Tariff{
+				BaseRate:      6,
+				Rules:         []TariffRule{{StartTime: "08:00", EndTime: "18:00", Rate: 12}},
+				EffectiveFrom: "2025-01-01T00:00:00Z",
+			}),
+			start: at(12, 0, 0), end: at(42, 0, 0),
+			rules:       PricingRules{GranularityMinutes: 60, DailyCap: 100},
+			wantMinutes: 1800, wantPrice: 100 + 6*12,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			surge := tt.surge
+			if surge == 0 {
+				surge = 1
+			}
+
+			minutes, price := calculatePrice(tt.schedule, surge, tt.start, tt.end, &tt.rules)
+			if minutes != tt.wantMinutes {
+				t.Errorf("billable minutes = %d, want %d", minutes, tt.wantMinutes)
+			}
+			if price != roundAmount(tt.wantPrice) {
+				t.Errorf("price = %.2f, want %.2f", price, tt.wantPrice)
+			}
+		})
+	}
+}
+
+func TestParseBookingWindow(t *testing.T) {
+	start := "2026-01-02T10:00:00Z"
+
+	tests := []struct {
+		name    string
+		end     string
+		wantErr bool
+	}{
+		{name: "an hour", end: "2026-01-02T11:00:00Z"},
+		{name: "longest booking", end: "2026-02-01T10:00:00Z"},
+		{name: "longer than 30 days", end: "2026-02-01T10:01:00Z", wantErr: true},
+		{name: "a year", end: "2027-01-02T10:00:00Z", wantErr: true},
+		{name: "empty", end: start, wantErr: true},
+		{name: "ends before it starts", end: "2026-01-02T09:00:00Z", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseBookingWindow(start, tt.end)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseBookingWindow(%s, %s) error = %v, want error %v", start, tt.end, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// refundPolicyKey is the ledger key of the cancellation refund policy
+const refundPolicyKey = "refund_policy"
+
+// RefundTier refunds Percent of what was paid for a booking cancelled at least
+// MinNoticeMinutes before it starts
+type RefundTier struct {
+	MinNoticeMinutes int `json:"minNoticeMinutes"`
+	Percent          int `json:"percent"`
+}
+
+// RefundPolicy decides how much of a cancelled booking is refunded
+type RefundPolicy struct {
+	DocType        string       `json:"docType"`
+	Tiers          []RefundTier `json:"tiers"`          // longest notice first
+	DefaultPercent int          `json:"defaultPercent"` // refunded when no tier applies, e.g. after the start
+	UpdatedAt      string       `json:"updatedAt,omitempty" metadata:",optional"`
+}
+
+// defaultRefundPolicy refunds in full up to an hour before the start, and half after
+func defaultRefundPolicy() *RefundPolicy {
+	return &RefundPolicy{
+		DocType:        "refundPolicy",
+		Tiers:          []RefundTier{{MinNoticeMinutes: 60, Percent: 100}},
+		DefaultPercent: 50,
+	}
+}
+
+// ==================== Refund Policy ====================
+
+// SetRefundPolicy replaces the refund policy applied to cancelled bookings
+func (c *ParkingContract) SetRefundPolicy(ctx contractapi.TransactionContextInterface, tiers []RefundTier, defaultPercent int) error {
+	if defaultPercent < 0 || defaultPercent > 100 {
+		return fmt.Errorf("default percent must be between 0 and 100")
+	}
+
+	seen := map[int]bool{}
+	for _, tier := range tiers {
+		if tier.Percent < 0 || tier.Percent > 100 {
+			return fmt.Errorf("refund percent must be between 0 and 100")
+		}
+		if seen[tier.MinNoticeMinutes] {
+			return fmt.Errorf("duplicate refund tier for %d minutes notice", tier.MinNoticeMinutes)
+		}
+		seen[tier.MinNoticeMinutes] = true
+	}
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].MinNoticeMinutes > tiers[j].MinNoticeMinutes
+	})
+
+	policy := RefundPolicy{
+		DocType:        "refundPolicy",
+		Tiers:          tiers,
+		DefaultPercent: defaultPercent,
+		UpdatedAt:      time.Now().Format(time.RFC3339),
+	}
+
+	policyJSON, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(refundPolicyKey, policyJSON)
+}
+
+// GetRefundPolicy returns the current refund policy, or the default if none was set
+func (c *ParkingContract) GetRefundPolicy(ctx contractapi.TransactionContextInterface) (*RefundPolicy, error) {
+	policyJSON, err := ctx.GetStub().GetState(refundPolicyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read refund policy: %v", err)
+	}
+	if policyJSON == nil {
+		return defaultRefundPolicy(), nil
+	}
+
+	var policy RefundPolicy
+	err = json.Unmarshal(policyJSON, &policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// refundPercent returns the percent refunded for a cancellation notice, which is
+// negative once the booking has started
+func (p *RefundPolicy) refundPercent(notice time.Duration) int {
+	for _, tier := range p.Tiers {
+		if notice >= time.Duration(tier.MinNoticeMinutes)*time.Minute {
+			return tier.Percent
+		}
+	}
+	return p.DefaultPercent
+}
@@ -0,0 +1,413 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// blackoutScopeIndex is the composite key namespace used to find the blackouts of a scope
+const blackoutScopeIndex = "scopeType~scopeId~blackoutId"
+
+// Blackout scope types
+const (
+	BlackoutScopeSpot     = "spot"
+	BlackoutScopeFacility = "facility"
+	BlackoutScopeLocation = "location"
+)
+
+// OpeningHours opens a spot or facility on some days between two local times. Close
+// before Open wraps past midnight.
+type OpeningHours struct {
+	Days  []int  `json:"days"`  // 0 = Sunday ... 6 = Saturday, empty for every day
+	Open  string `json:"open"`  // HH:MM local time, inclusive
+	Close string `json:"close"` // HH:MM local time, exclusive
+}
+
+// Blackout closes the spots of a scope for a time range, such as street cleaning or an
+// event. Bookings already made are kept; new bookings and extensions overlapping it are
+// rejected.
+type Blackout struct {
+	DocType    string `json:"docType"`
+	BlackoutID string `json:"blackoutId"`
+	ScopeType  string `json:"scopeType"` // spot, facility, location
+	ScopeID    string `json:"scopeId"`
+	StartTime  string `json:"startTime"`
+	EndTime    string `json:"endTime"`
+	Reason     string `json:"reason"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+// interval is a half-open [start, end) time range
+type interval struct {
+	start, end time.Time
+}
+
+// ==================== Opening Hours and Blackouts ====================
+
+// SetSpotOpeningHours sets the opening hours of a spot, in local time at utcOffsetMinutes.
+// A spot without opening hours has those of its facility, or is always open.
+func (c *ParkingContract) SetSpotOpeningHours(ctx contractapi.TransactionContextInterface, spotId string, utcOffsetMinutes int, openingHours []OpeningHours) error {
+	if err := validateOpeningHours(openingHours, utcOffsetMinutes); err != nil {
+		return err
+	}
+
+	spot, err := c.GetParkingSpot(ctx, spotId)
+	if err != nil {
+		return err
+	}
+
+	spot.UTCOffsetMinutes = utcOffsetMinutes
+	spot.OpeningHours = openingHours
+	spot.UpdatedAt = time.Now().Format(time.RFC3339)
+
+	return c.putSpot(ctx, spot)
+}
+
+// CreateBlackout closes the spots of a spot, facility or location scope from startTime
+// to endTime
+func (c *ParkingContract) CreateBlackout(ctx contractapi.TransactionContextInterface, blackoutId, scopeType, scopeId, startTimeStr, endTimeStr, reason string) error {
+	existing, err := ctx.GetStub().GetState(blackoutId)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("blackout %s already exists", blackoutId)
+	}
+
+	switch scopeType {
+	case BlackoutScopeSpot:
+		_, err = c.GetParkingSpot(ctx, scopeId)
+	case BlackoutScopeFacility:
+		_, err = c.GetFacility(ctx, scopeId)
+	case BlackoutScopeLocation:
+		if scopeId == "" {
+			err = fmt.Errorf("blackout scope id is required")
+		}
+	default:
+		err = fmt.Errorf("invalid blackout scope type: %s", scopeType)
+	}
+	if err != nil {
+		return err
+	}
+
+	start, end, err := parseWindow(startTimeStr, endTimeStr)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if !end.After(now) {
+		return fmt.Errorf("blackout must end in the future")
+	}
+
+	blackout := Blackout{
+		DocType:    "blackout",
+		BlackoutID: blackoutId,
+		ScopeType:  scopeType,
+		ScopeID:    scopeId,
+		StartTime:  formatTime(start),
+		EndTime:    formatTime(end),
+		Reason:     reason,
+		CreatedAt:  now.Format(time.RFC3339),
+	}
+
+	blackoutJSON, err := json.Marshal(blackout)
+	if err != nil {
+		return err
+	}
+
+	indexKey, err := ctx.GetStub().CreateCompositeKey(blackoutScopeIndex, []string{scopeType, scopeId, blackoutId})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(blackoutId, blackoutJSON)
+}
+
+// GetBlackout retrieves a blackout by ID
+func (c *ParkingContract) GetBlackout(ctx contractapi.TransactionContextInterface, blackoutId string) (*Blackout, error) {
+	blackoutJSON, err := ctx.GetStub().GetState(blackoutId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blackout: %v", err)
+	}
+	if blackoutJSON == nil {
+		return nil, fmt.Errorf("blackout %s does not exist", blackoutId)
+	}
+
+	var blackout Blackout
+	err = json.Unmarshal(blackoutJSON, &blackout)
+	if err != nil {
+		return nil, err
+	}
+	if blackout.DocType != "blackout" {
+		return nil, fmt.Errorf("blackout %s does not exist", blackoutId)
+	}
+
+	return &blackout, nil
+}
+
+// DeleteBlackout lifts a blackout
+func (c *ParkingContract) DeleteBlackout(ctx contractapi.TransactionContextInterface, blackoutId string) error {
+	blackout, err := c.GetBlackout(ctx, blackoutId)
+	if err != nil {
+		return err
+	}
+
+	indexKey, err := ctx.GetStub().CreateCompositeKey(blackoutScopeIndex, []string{blackout.ScopeType, blackout.ScopeID, blackoutId})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(indexKey)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(blackoutId)
+}
+
+// GetBlackoutsByScope returns the blackouts of a scope, earliest first
+func (c *ParkingContract) GetBlackoutsByScope(ctx contractapi.TransactionContextInterface, scopeType, scopeId string) ([]*Blackout, error) {
+	blackoutIds, err := indexedIDs(ctx, blackoutScopeIndex, []string{scopeType, scopeId})
+	if err != nil {
+		return nil, err
+	}
+
+	blackouts := make([]*Blackout, 0, len(blackoutIds))
+	for _, blackoutId := range blackoutIds {
+		blackout, err := c.GetBlackout(ctx, blackoutId)
+		if err != nil {
+			return nil, err
+		}
+		blackouts = append(blackouts, blackout)
+	}
+
+	sort.SliceStable(blackouts, func(i, j int) bool {
+		return blackouts[i].StartTime < blackouts[j].StartTime
+	})
+
+	return blackouts, nil
+}
+
+// checkInService returns an error unless a spot is open and not blacked out for the
+// whole of [start, end)
+func (c *ParkingContract) checkInService(ctx contractapi.TransactionContextInterface, spot *ParkingSpot, start, end time.Time) error {
+	hours, utcOffsetMinutes, err := c.spotOpeningHours(ctx, spot)
+	if err != nil {
+		return err
+	}
+	if !covers(openIntervals(hours, utcOffsetMinutes, start, end), start, end) {
+		return fmt.Errorf("parking spot %s is closed during part of the requested time", spot.SpotID)
+	}
+
+	blackouts, err := c.spotBlackouts(ctx, spot)
+	if err != nil {
+		return err
+	}
+	for _, blackout := range blackouts {
+		blackoutStart, blackoutEnd, err := parseWindow(blackout.StartTime, blackout.EndTime)
+		if err != nil {
+			continue
+		}
+		if overlaps(blackoutStart, blackoutEnd, start, end) {
+			return fmt.Errorf("parking spot %s is closed from %s to %s: %s", spot.SpotID, blackout.StartTime, blackout.EndTime, blackout.Reason)
+		}
+	}
+
+	return nil
+}
+
+// inService reports whether a spot is open and not blacked out for the whole of [start, end)
+func (c *ParkingContract) inService(ctx contractapi.TransactionContextInterface, spot *ParkingSpot, start, end time.Time) (bool, error) {
+	windows, err := c.serviceIntervals(ctx, spot, start, end)
+	if err != nil {
+		return false, err
+	}
+	return covers(windows, start, end), nil
+}
+
+// serviceIntervals returns the intervals within [from, to) during which a spot is open
+// and not blacked out, in order
+func (c *ParkingContract) serviceIntervals(ctx contractapi.TransactionContextInterface, spot *ParkingSpot, from, to time.Time) ([]interval, error) {
+	hours, utcOffsetMinutes, err := c.spotOpeningHours(ctx, spot)
+	if err != nil {
+		return nil, err
+	}
+	windows := openIntervals(hours, utcOffsetMinutes, from, to)
+
+	blackouts, err := c.spotBlackouts(ctx, spot)
+	if err != nil {
+		return nil, err
+	}
+	for _, blackout := range blackouts {
+		start, end, err := parseWindow(blackout.StartTime, blackout.EndTime)
+		if err != nil {
+			continue
+		}
+		windows = subtractInterval(windows, start, end)
+	}
+
+	return windows, nil
+}
+
+// spotOpeningHours returns the opening hours of a spot, falling back to those of its
+// facility
+func (c *ParkingContract) spotOpeningHours(ctx contractapi.TransactionContextInterface, spot *ParkingSpot) ([]OpeningHours, int, error) {
+	if len(spot.OpeningHours) > 0 || spot.FacilityID == "" {
+		return spot.OpeningHours, spot.UTCOffsetMinutes, nil
+	}
+
+	facility, err := c.GetFacility(ctx, spot.FacilityID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return facility.OpeningHours, facility.UTCOffsetMinutes, nil
+}
+
+// spotBlackouts returns the blackouts of a spot, its facility and its location
+func (c *ParkingContract) spotBlackouts(ctx contractapi.TransactionContextInterface, spot *ParkingSpot) ([]*Blackout, error) {
+	scopes := [][2]string{
+		{BlackoutScopeSpot, spot.SpotID},
+		{BlackoutScopeLocation, spot.Location},
+	}
+	if spot.FacilityID != "" {
+		scopes = append(scopes, [2]string{BlackoutScopeFacility, spot.FacilityID})
+	}
+
+	var blackouts []*Blackout
+	for _, scope := range scopes {
+		scoped, err := c.GetBlackoutsByScope(ctx, scope[0], scope[1])
+		if err != nil {
+			return nil, err
+		}
+		blackouts = append(blackouts, scoped...)
+	}
+
+	return blackouts, nil
+}
+
+// validateOpeningHours checks the times and days of opening hours
+func validateOpeningHours(openingHours []OpeningHours, utcOffsetMinutes int) error {
+	if utcOffsetMinutes < -14*60 || utcOffsetMinutes > 14*60 {
+		return fmt.Errorf("invalid UTC offset: %d minutes", utcOffsetMinutes)
+	}
+
+	for _, hours := range openingHours {
+		open, err := parseClock(hours.Open)
+		if err != nil {
+			return fmt.Errorf("opening hours: %v", err)
+		}
+		closing, err := parseClock(hours.Close)
+		if err != nil {
+			return fmt.Errorf("opening hours: %v", err)
+		}
+		if open == closing {
+			return fmt.Errorf("opening hours: open and close time must differ")
+		}
+		for _, day := range hours.Days {
+			if day < 0 || day > 6 {
+				return fmt.Errorf("opening hours: invalid day %d", day)
+			}
+		}
+	}
+
+	return nil
+}
+
+// openIntervals returns the intervals within [from, to) covered by opening hours, in
+// order. No opening hours means always open.
+func openIntervals(openingHours []OpeningHours, utcOffsetMinutes int, from, to time.Time) []interval {
+	if len(openingHours) == 0 {
+		return []interval{{from, to}}
+	}
+
+	// Start a day early, for hours that wrap past midnight into the range
+	zone := time.FixedZone("", utcOffsetMinutes*60)
+	local := from.In(zone)
+	day := time.Date(local.Year(), local.Month(), local.Day()-1, 0, 0, 0, 0, zone)
+
+	var windows []interval
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		for _, hours := range openingHours {
+			if len(hours.Days) > 0 && !containsDay(hours.Days, int(day.Weekday())) {
+				continue
+			}
+			open, err1 := parseClock(hours.Open)
+			closing, err2 := parseClock(hours.Close)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+
+			start := day.Add(time.Duration(open) * time.Minute)
+			end := day.Add(time.Duration(closing) * time.Minute)
+			if closing <= open {
+				end = end.Add(24 * time.Hour)
+			}
+			if start.Before(from) {
+				start = from
+			}
+			if end.After(to) {
+				end = to
+			}
+			if start.Before(end) {
+				windows = append(windows, interval{start, end})
+			}
+		}
+	}
+
+	return mergeIntervals(windows)
+}
+
+// mergeIntervals sorts intervals and joins those that overlap or touch
+func mergeIntervals(windows []interval) []interval {
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].start.Before(windows[j].start)
+	})
+
+	merged := make([]interval, 0, len(windows))
+	for _, window := range windows {
+		last := len(merged) - 1
+		if last >= 0 && !window.start.After(merged[last].end) {
+			if window.end.After(merged[last].end) {
+				merged[last].end = window.end
+			}
+			continue
+		}
+		merged = append(merged, window)
+	}
+	return merged
+}
+
+// subtractInterval removes [start, end) from ordered intervals
+func subtractInterval(windows []interval, start, end time.Time) []interval {
+	remaining := make([]interval, 0, len(windows)+1)
+	for _, window := range windows {
+		if !overlaps(window.start, window.end, start, end) {
+			remaining = append(remaining, window)
+			continue
+		}
+		if window.start.Before(start) {
+			remaining = append(remaining, interval{window.start, start})
+		}
+		if window.end.After(end) {
+			remaining = append(remaining, interval{end, window.end})
+		}
+	}
+	return remaining
+}
+
+// covers reports whether ordered, merged intervals cover the whole of [start, end)
+func covers(windows []interval, start, end time.Time) bool {
+	for _, window := range windows {
+		if !window.start.After(start) && !window.end.Before(end) {
+			return true
+		}
+	}
+	return false
+}
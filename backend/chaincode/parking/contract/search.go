@@ -0,0 +1,284 @@
+package contract
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// scanFactor bounds the index entries a search reads per call, as a multiple of
+	// the page size; a page of a sparse search can come back short, with a bookmark
+	scanFactor = 10
+)
+
+// errEndOfRange is returned by a match function once no later index entry can match
+var errEndOfRange = errors.New("end of range")
+
+// SpotFilter selects spots. Empty fields match any spot.
+type SpotFilter struct {
+	Location   string  `json:"location,omitempty" metadata:",optional"`
+	FacilityID string  `json:"facilityId,omitempty" metadata:",optional"`
+	SpotType   string  `json:"spotType,omitempty" metadata:",optional"`
+	Status     string  `json:"status,omitempty" metadata:",optional"`
+	MinPrice   float64 `json:"minPrice,omitempty" metadata:",optional"`
+	MaxPrice   float64 `json:"maxPrice,omitempty" metadata:",optional"` // zero for no maximum
+	SortBy     string  `json:"sortBy,omitempty" metadata:",optional"`   // location or price; any order when empty
+	OpenNow    bool    `json:"openNow,omitempty" metadata:",optional"`  // only spots open and not blacked out now
+}
+
+// BookingFilter selects the bookings of a user or of a spot
+type BookingFilter struct {
+	UserID   string   `json:"userId,omitempty" metadata:",optional"`
+	SpotID   string   `json:"spotId,omitempty" metadata:",optional"`
+	Statuses []string `json:"statuses,omitempty" metadata:",optional"` // any status when empty
+}
+
+// SpotPage is a page of spot search results
+type SpotPage struct {
+	Spots        []*ParkingSpot `json:"spots"`
+	NextBookmark string         `json:"nextBookmark"` // empty on the last page
+}
+
+// BookingPage is a page of booking search results
+type BookingPage struct {
+	Bookings     []*Booking `json:"bookings"`
+	NextBookmark string     `json:"nextBookmark"` // empty on the last page
+}
+
+// ==================== Search ====================
+
+// SearchSpots returns a page of the spots matching all the criteria of a filter. Pass
+// the returned bookmark, with the same filter, to get the next page. Must be evaluated,
+// not submitted, as paginated queries are read-only.
+func (c *ParkingContract) SearchSpots(ctx contractapi.TransactionContextInterface, filter SpotFilter, pageSize int, bookmark string) (*SpotPage, error) {
+	if filter.MinPrice < 0 || filter.MaxPrice < 0 || (filter.MaxPrice > 0 && filter.MaxPrice < filter.MinPrice) {
+		return nil, fmt.Errorf("invalid price range")
+	}
+	index, attributes, err := spotSearchIndex(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	page := &SpotPage{Spots: []*ParkingSpot{}}
+	page.NextBookmark, err = searchIndex(ctx, index, attributes, clampPageSize(pageSize), bookmark, func(keyParts []string) (bool, error) {
+		// Entries of the price index sort by price
+		if index == spotPriceIndex {
+			if keyParts[0] < priceKey(filter.MinPrice) {
+				return false, nil
+			}
+			if filter.MaxPrice > 0 && keyParts[0] > priceKey(filter.MaxPrice) {
+				return false, errEndOfRange
+			}
+		}
+
+		spot, err := c.GetParkingSpot(ctx, keyParts[len(keyParts)-1])
+		if err != nil {
+			return false, err
+		}
+		if !filter.matches(spot) {
+			return false, nil
+		}
+		if filter.OpenNow {
+			open, err := c.inService(ctx, spot, now, now.Add(time.Minute))
+			if err != nil || !open {
+				return false, err
+			}
+		}
+		page.Spots = append(page.Spots, spot)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// SearchBookings returns a page of the bookings of a user or spot, optionally limited to
+// some statuses. Pass the returned bookmark, with the same filter, to get the next page.
+// Must be evaluated, not submitted, as paginated queries are read-only.
+func (c *ParkingContract) SearchBookings(ctx contractapi.TransactionContextInterface, filter BookingFilter, pageSize int, bookmark string) (*BookingPage, error) {
+	var index string
+	var attributes []string
+	switch {
+	case filter.UserID != "" && len(filter.Statuses) == 1:
+		index, attributes = userStatusIndex, []string{filter.UserID, filter.Statuses[0]}
+	case filter.UserID != "":
+		index, attributes = userBookingIndex, []string{filter.UserID}
+	case filter.SpotID != "":
+		index, attributes = spotBookingIndex, []string{filter.SpotID}
+	default:
+		return nil, fmt.Errorf("a user or spot is required to search bookings")
+	}
+
+	page := &BookingPage{Bookings: []*Booking{}}
+	var err error
+	page.NextBookmark, err = searchIndex(ctx, index, attributes, clampPageSize(pageSize), bookmark, func(keyParts []string) (bool, error) {
+		booking, err := c.GetBooking(ctx, keyParts[len(keyParts)-1])
+		if err != nil {
+			return false, err
+		}
+		if !filter.matches(booking) {
+			return false, nil
+		}
+		page.Bookings = append(page.Bookings, booking)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// spotSearchIndex picks the index a spot search walks: the one giving the requested
+// order, or else the narrowest one the filter allows
+func spotSearchIndex(filter SpotFilter) (string, []string, error) {
+	if filter.SortBy != "" && filter.SortBy != "location" && filter.SortBy != "price" {
+		return "", nil, fmt.Errorf("cannot sort spots by %q", filter.SortBy)
+	}
+
+	hasPriceRange := filter.MinPrice > 0 || filter.MaxPrice > 0
+	switch {
+	case filter.SortBy == "price":
+		return spotPriceIndex, []string{}, nil
+	case filter.Status != "" && filter.Location != "":
+		return spotStatusIndex, []string{filter.Status, filter.Location}, nil
+	case filter.Location != "":
+		return spotLocationIndex, []string{filter.Location}, nil
+	case filter.FacilityID != "" && filter.SortBy == "":
+		return spotFacilityIndex, []string{filter.FacilityID}, nil
+	case filter.Status != "":
+		return spotStatusIndex, []string{filter.Status}, nil
+	case filter.SortBy == "location":
+		return spotLocationIndex, []string{}, nil
+	case filter.SpotType != "":
+		return spotTypeIndex, []string{filter.SpotType}, nil
+	case hasPriceRange:
+		return spotPriceIndex, []string{}, nil
+	default:
+		return spotLocationIndex, []string{}, nil
+	}
+}
+
+func (filter SpotFilter) matches(spot *ParkingSpot) bool {
+	if filter.Location != "" && spot.Location != filter.Location {
+		return false
+	}
+	if filter.FacilityID != "" && spot.FacilityID != filter.FacilityID {
+		return false
+	}
+	if filter.SpotType != "" && spot.SpotType != filter.SpotType {
+		return false
+	}
+	if filter.Status != "" && spot.Status != filter.Status {
+		return false
+	}
+	if spot.PricePerHour < filter.MinPrice {
+		return false
+	}
+	if filter.MaxPrice > 0 && spot.PricePerHour > filter.MaxPrice {
+		return false
+	}
+	return true
+}
+
+func (filter BookingFilter) matches(booking *Booking) bool {
+	if filter.UserID != "" && booking.UserID != filter.UserID {
+		return false
+	}
+	if filter.SpotID != "" && booking.SpotID != filter.SpotID {
+		return false
+	}
+	if len(filter.Statuses) == 0 {
+		return true
+	}
+	for _, status := range filter.Statuses {
+		if booking.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// searchIndex walks the entries under a partial key of an index, a Fabric page at a time
+// from bookmark, and passes the attributes of each to match until match has accepted
+// pageSize of them or scanFactor pages' worth were read. Pages are only fetched as large
+// as the count still missing, so every entry read is consumed and Fabric's bookmark is
+// where the next call resumes. The returned bookmark is empty once the index is
+// exhausted, or when match returns errEndOfRange.
+func searchIndex(ctx contractapi.TransactionContextInterface, index string, attributes []string, pageSize int, bookmark string, match func(keyParts []string) (bool, error)) (string, error) {
+	accepted, scanned := 0, 0
+	for accepted < pageSize && scanned < pageSize*scanFactor {
+		limit := pageSize - accepted
+		fetched, matched, next, err := searchPage(ctx, index, attributes, limit, bookmark, match)
+		if errors.Is(err, errEndOfRange) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		accepted += matched
+		scanned += fetched
+		bookmark = next
+
+		if fetched < limit || bookmark == "" {
+			return "", nil
+		}
+	}
+
+	return bookmark, nil
+}
+
+// searchPage reads one Fabric page of index entries and returns how many were read and
+// accepted, and the bookmark of the following page
+func searchPage(ctx contractapi.TransactionContextInterface, index string, attributes []string, limit int, bookmark string, match func(keyParts []string) (bool, error)) (int, int, string, error) {
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(index, attributes, int32(limit), bookmark)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	defer resultsIterator.Close()
+
+	fetched, matched := 0, 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, 0, "", err
+		}
+		fetched++
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if len(compositeKeyParts) == 0 {
+			continue
+		}
+		ok, err := match(compositeKeyParts)
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if ok {
+			matched++
+		}
+	}
+
+	return fetched, matched, metadata.GetBookmark(), nil
+}
+
+// clampPageSize returns the default page size for sizes out of range
+func clampPageSize(pageSize int) int {
+	if pageSize < 1 {
+		return defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		return maxPageSize
+	}
+	return pageSize
+}
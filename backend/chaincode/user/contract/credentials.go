@@ -0,0 +1,207 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Purposes of account tokens
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+)
+
+// AccountToken is a single-use token mailed to a user to reset their password or
+// verify their email. Only a keyed hash of it is stored, like session tokens.
+type AccountToken struct {
+	DocType   string    `json:"docType"`
+	TokenHash string    `json:"tokenHash"`
+	UserID    string    `json:"userId"`
+	Purpose   string    `json:"purpose"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	UsedAt    time.Time `json:"usedAt,omitempty"`
+}
+
+func accountTokenKey(tokenHash string) string {
+	return "accountToken_" + tokenHash
+}
+
+// CreateAccountToken stores a token for a user, valid for expiresInMinutes
+func (c *UserContract) CreateAccountToken(ctx contractapi.TransactionContextInterface, tokenHash, userId, purpose string, expiresInMinutes int) error {
+	if purpose != PurposePasswordReset && purpose != PurposeEmailVerification {
+		return fmt.Errorf("invalid token purpose %q", purpose)
+	}
+	if _, err := c.GetUser(ctx, userId); err != nil {
+		return err
+	}
+
+	existing, err := ctx.GetStub().GetState(accountTokenKey(tokenHash))
+	if err != nil {
+		return fmt.Errorf("failed to read token: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("token already exists")
+	}
+
+	now := time.Now()
+	token := AccountToken{
+		DocType:   "accountToken",
+		TokenHash: tokenHash,
+		UserID:    userId,
+		Purpose:   purpose,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(expiresInMinutes) * time.Minute),
+	}
+	return c.putAccountToken(ctx, &token)
+}
+
+// ResetPassword sets a new password hash for the user of a password reset token,
+// consuming the token and lifting any lockout
+func (c *UserContract) ResetPassword(ctx contractapi.TransactionContextInterface, tokenHash, newPasswordHash string) (*User, error) {
+	token, err := c.useAccountToken(ctx, tokenHash, PurposePasswordReset)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := c.GetUser(ctx, token.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user.PasswordHash = newPasswordHash
+	user.PasswordChangedAt = now
+	user.FailedLoginAttempts = 0
+	user.LockedUntil = time.Time{}
+	user.UpdatedAt = now
+
+	return user, c.putUser(ctx, user)
+}
+
+// ChangePassword sets a new password hash for a user. The backend checks the current
+// password before calling it.
+func (c *UserContract) ChangePassword(ctx contractapi.TransactionContextInterface, userId, newPasswordHash string) error {
+	user, err := c.GetUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user.PasswordHash = newPasswordHash
+	user.PasswordChangedAt = now
+	user.UpdatedAt = now
+
+	return c.putUser(ctx, user)
+}
+
+// VerifyEmail marks the email of the user of a verification token as verified,
+// consuming the token
+func (c *UserContract) VerifyEmail(ctx contractapi.TransactionContextInterface, tokenHash string) (*User, error) {
+	token, err := c.useAccountToken(ctx, tokenHash, PurposeEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := c.GetUser(ctx, token.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.EmailVerified = true
+	user.UpdatedAt = time.Now()
+
+	return user, c.putUser(ctx, user)
+}
+
+// RecordFailedLogin counts a failed login of a user. Reaching maxAttempts locks the
+// account for lockMinutes and resets the count.
+func (c *UserContract) RecordFailedLogin(ctx contractapi.TransactionContextInterface, userId string, maxAttempts, lockMinutes int) (*User, error) {
+	user, err := c.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user.FailedLoginAttempts++
+	if maxAttempts > 0 && user.FailedLoginAttempts >= maxAttempts {
+		user.LockedUntil = now.Add(time.Duration(lockMinutes) * time.Minute)
+		user.FailedLoginAttempts = 0
+	}
+	user.UpdatedAt = now
+
+	return user, c.putUser(ctx, user)
+}
+
+// RecordSuccessfulLogin resets the failed login count of a user
+func (c *UserContract) RecordSuccessfulLogin(ctx contractapi.TransactionContextInterface, userId string) error {
+	user, err := c.GetUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if user.FailedLoginAttempts == 0 {
+		return nil
+	}
+
+	user.FailedLoginAttempts = 0
+	user.UpdatedAt = time.Now()
+
+	return c.putUser(ctx, user)
+}
+
+// UnlockUser lifts the lockout of a user before it expires
+func (c *UserContract) UnlockUser(ctx contractapi.TransactionContextInterface, userId string) error {
+	user, err := c.GetUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	user.FailedLoginAttempts = 0
+	user.LockedUntil = time.Time{}
+	user.UpdatedAt = time.Now()
+
+	return c.putUser(ctx, user)
+}
+
+// useAccountToken consumes a token of the given purpose that is unused and unexpired
+func (c *UserContract) useAccountToken(ctx contractapi.TransactionContextInterface, tokenHash, purpose string) (*AccountToken, error) {
+	tokenJSON, err := ctx.GetStub().GetState(accountTokenKey(tokenHash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token: %v", err)
+	}
+	if tokenJSON == nil {
+		return nil, fmt.Errorf("invalid or expired token")
+	}
+
+	var token AccountToken
+	if err := json.Unmarshal(tokenJSON, &token); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if token.Purpose != purpose || !token.UsedAt.IsZero() || now.After(token.ExpiresAt) {
+		return nil, fmt.Errorf("invalid or expired token")
+	}
+
+	token.UsedAt = now
+	return &token, c.putAccountToken(ctx, &token)
+}
+
+func (c *UserContract) putAccountToken(ctx contractapi.TransactionContextInterface, token *AccountToken) error {
+	tokenJSON, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(accountTokenKey(token.TokenHash), tokenJSON)
+}
+
+func (c *UserContract) putUser(ctx contractapi.TransactionContextInterface, user *User) error {
+	userJSON, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(user.UserID, userJSON)
+}
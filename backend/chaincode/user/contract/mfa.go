@@ -0,0 +1,194 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// UserMFA holds the TOTP enrollment of a user. The backend encrypts the secret and
+// hashes the recovery codes before they reach the ledger, so neither is usable by
+// someone reading the channel.
+type UserMFA struct {
+	DocType            string    `json:"docType"`
+	UserID             string    `json:"userId"`
+	EncryptedSecret    string    `json:"encryptedSecret"`
+	Enabled            bool      `json:"enabled"`
+	RecoveryCodeHashes []string  `json:"recoveryCodeHashes"`
+	LastUsedStep       int64     `json:"lastUsedStep"` // TOTP time step of the last accepted code
+	EnrolledAt         time.Time `json:"enrolledAt"`
+	EnabledAt          time.Time `json:"enabledAt,omitempty"`
+}
+
+func mfaKey(userId string) string {
+	return "mfa_" + userId
+}
+
+// EnrollMFA stores a new, not yet enabled TOTP secret for a user, replacing any
+// pending enrollment
+func (c *UserContract) EnrollMFA(ctx contractapi.TransactionContextInterface, userId, encryptedSecret string) error {
+	if _, err := c.GetUser(ctx, userId); err != nil {
+		return err
+	}
+
+	existing, err := c.readMFA(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.Enabled {
+		return fmt.Errorf("MFA is already enabled for user %s", userId)
+	}
+
+	mfa := UserMFA{
+		DocType:            "mfa",
+		UserID:             userId,
+		EncryptedSecret:    encryptedSecret,
+		RecoveryCodeHashes: []string{},
+		EnrolledAt:         time.Now(),
+	}
+	return c.putMFA(ctx, &mfa)
+}
+
+// ActivateMFA enables the pending enrollment of a user once the backend verified a
+// code from it. step is the time step of that code.
+func (c *UserContract) ActivateMFA(ctx contractapi.TransactionContextInterface, userId string, step int64, recoveryCodeHashesJSON string) error {
+	mfa, err := c.GetMFA(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if mfa.Enabled {
+		return fmt.Errorf("MFA is already enabled for user %s", userId)
+	}
+
+	var hashes []string
+	if err := json.Unmarshal([]byte(recoveryCodeHashesJSON), &hashes); err != nil {
+		return fmt.Errorf("invalid recovery codes: %v", err)
+	}
+
+	mfa.Enabled = true
+	mfa.EnabledAt = time.Now()
+	mfa.LastUsedStep = step
+	mfa.RecoveryCodeHashes = hashes
+	if err := c.putMFA(ctx, mfa); err != nil {
+		return err
+	}
+
+	return c.setMFAEnabled(ctx, userId, true)
+}
+
+// GetMFA returns the TOTP enrollment of a user
+func (c *UserContract) GetMFA(ctx contractapi.TransactionContextInterface, userId string) (*UserMFA, error) {
+	mfa, err := c.readMFA(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if mfa == nil {
+		return nil, fmt.Errorf("user %s has not enrolled in MFA", userId)
+	}
+	return mfa, nil
+}
+
+// RecordMFAStep marks the TOTP time step of an accepted code as used. Codes of that
+// step or earlier are rejected afterwards, so an intercepted code cannot be replayed.
+func (c *UserContract) RecordMFAStep(ctx contractapi.TransactionContextInterface, userId string, step int64) error {
+	mfa, err := c.GetMFA(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !mfa.Enabled {
+		return fmt.Errorf("MFA is not enabled for user %s", userId)
+	}
+	if step <= mfa.LastUsedStep {
+		return fmt.Errorf("MFA code has already been used")
+	}
+
+	mfa.LastUsedStep = step
+	return c.putMFA(ctx, mfa)
+}
+
+// UseRecoveryCode consumes a recovery code of a user, identified by its hash
+func (c *UserContract) UseRecoveryCode(ctx contractapi.TransactionContextInterface, userId, codeHash string) error {
+	mfa, err := c.GetMFA(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !mfa.Enabled {
+		return fmt.Errorf("MFA is not enabled for user %s", userId)
+	}
+
+	for i, hash := range mfa.RecoveryCodeHashes {
+		if hash == codeHash {
+			mfa.RecoveryCodeHashes = append(mfa.RecoveryCodeHashes[:i], mfa.RecoveryCodeHashes[i+1:]...)
+			return c.putMFA(ctx, mfa)
+		}
+	}
+	return fmt.Errorf("invalid recovery code")
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of a user, invalidating the old ones
+func (c *UserContract) ReplaceRecoveryCodes(ctx contractapi.TransactionContextInterface, userId, recoveryCodeHashesJSON string) error {
+	mfa, err := c.GetMFA(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !mfa.Enabled {
+		return fmt.Errorf("MFA is not enabled for user %s", userId)
+	}
+
+	var hashes []string
+	if err := json.Unmarshal([]byte(recoveryCodeHashesJSON), &hashes); err != nil {
+		return fmt.Errorf("invalid recovery codes: %v", err)
+	}
+
+	mfa.RecoveryCodeHashes = hashes
+	return c.putMFA(ctx, mfa)
+}
+
+// DisableMFA removes the TOTP enrollment of a user
+func (c *UserContract) DisableMFA(ctx contractapi.TransactionContextInterface, userId string) error {
+	if _, err := c.GetMFA(ctx, userId); err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelState(mfaKey(userId)); err != nil {
+		return err
+	}
+	return c.setMFAEnabled(ctx, userId, false)
+}
+
+func (c *UserContract) readMFA(ctx contractapi.TransactionContextInterface, userId string) (*UserMFA, error) {
+	mfaJSON, err := ctx.GetStub().GetState(mfaKey(userId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MFA enrollment: %v", err)
+	}
+	if mfaJSON == nil {
+		return nil, nil
+	}
+
+	var mfa UserMFA
+	if err := json.Unmarshal(mfaJSON, &mfa); err != nil {
+		return nil, err
+	}
+	return &mfa, nil
+}
+
+func (c *UserContract) putMFA(ctx contractapi.TransactionContextInterface, mfa *UserMFA) error {
+	mfaJSON, err := json.Marshal(mfa)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(mfaKey(mfa.UserID), mfaJSON)
+}
+
+func (c *UserContract) setMFAEnabled(ctx contractapi.TransactionContextInterface, userId string, enabled bool) error {
+	user, err := c.GetUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	user.MFAEnabled = enabled
+	user.UpdatedAt = time.Now()
+
+	return c.putUser(ctx, user)
+}
@@ -0,0 +1,326 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Roles of the members of an organization. Owners and managers manage members; drivers
+// spend from the organization's wallet within the limits the wallet chaincode enforces.
+const (
+	OrgRoleOwner   = "owner"
+	OrgRoleManager = "manager"
+	OrgRoleDriver  = "driver"
+)
+
+// orgMemberIndex keys the members of each organization
+const orgMemberIndex = "orgMember~orgId~userId"
+
+// userOrgIndex lists the organizations of each user
+const userOrgIndex = "userOrg~userId~orgId"
+
+// Organization is a company whose members share a wallet, such as a fleet of drivers.
+// Its wallet is the wallet chaincode's wallet of the organization ID.
+type Organization struct {
+	DocType   string    `json:"docType"`
+	OrgID     string    `json:"orgId"`
+	Name      string    `json:"name"`
+	OwnerID   string    `json:"ownerId"`
+	IsActive  bool      `json:"isActive"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// OrgMember is the membership of a user in an organization
+type OrgMember struct {
+	DocType   string    `json:"docType"`
+	OrgID     string    `json:"orgId"`
+	UserID    string    `json:"userId"`
+	Role      string    `json:"role"`
+	AddedBy   string    `json:"addedBy"`
+	IsActive  bool      `json:"isActive"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CreateOrganization creates an organization owned by an active user
+func (c *UserContract) CreateOrganization(ctx contractapi.TransactionContextInterface, orgId, name, ownerId string) (*Organization, error) {
+	if name == "" {
+		return nil, fmt.Errorf("organization name is required")
+	}
+
+	existing, err := ctx.GetStub().GetState(orgId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read organization: %v", err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("organization %s already exists", orgId)
+	}
+
+	owner, err := c.GetUser(ctx, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	if !owner.IsActive {
+		return nil, fmt.Errorf("user %s is not active", ownerId)
+	}
+
+	now := time.Now()
+	org := Organization{
+		DocType:   "organization",
+		OrgID:     orgId,
+		Name:      name,
+		OwnerID:   ownerId,
+		IsActive:  true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	orgJSON, err := json.Marshal(org)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(orgId, orgJSON); err != nil {
+		return nil, err
+	}
+
+	member := OrgMember{
+		DocType:   "orgMember",
+		OrgID:     orgId,
+		UserID:    ownerId,
+		Role:      OrgRoleOwner,
+		AddedBy:   ownerId,
+		IsActive:  true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := c.putOrgMember(ctx, &member); err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// GetOrganization retrieves an organization by ID
+func (c *UserContract) GetOrganization(ctx contractapi.TransactionContextInterface, orgId string) (*Organization, error) {
+	orgJSON, err := ctx.GetStub().GetState(orgId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read organization: %v", err)
+	}
+	if orgJSON == nil {
+		return nil, fmt.Errorf("organization %s does not exist", orgId)
+	}
+
+	var org Organization
+	if err := json.Unmarshal(orgJSON, &org); err != nil {
+		return nil, err
+	}
+	if org.DocType != "organization" {
+		return nil, fmt.Errorf("organization %s does not exist", orgId)
+	}
+
+	return &org, nil
+}
+
+// AddOrgMember adds a user to an organization as a manager or driver, or changes the
+// role of a member. actorId must be an active owner or manager, and only the owner
+// may appoint managers or change their role.
+func (c *UserContract) AddOrgMember(ctx contractapi.TransactionContextInterface, orgId, userId, role, actorId string) (*OrgMember, error) {
+	if role != OrgRoleManager && role != OrgRoleDriver {
+		return nil, fmt.Errorf("invalid organization role %q", role)
+	}
+
+	actor, err := c.orgManager(ctx, orgId, actorId)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := c.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, fmt.Errorf("user %s is not active", userId)
+	}
+
+	now := time.Now()
+	member, err := c.GetOrgMember(ctx, orgId, userId)
+	if err != nil {
+		member = &OrgMember{
+			DocType:   "orgMember",
+			OrgID:     orgId,
+			UserID:    userId,
+			CreatedAt: now,
+		}
+	}
+	if member.Role == OrgRoleOwner {
+		return nil, fmt.Errorf("access denied: the owner's role cannot be changed")
+	}
+	if (role == OrgRoleManager || (member.IsActive && member.Role == OrgRoleManager)) && actor.Role != OrgRoleOwner {
+		return nil, fmt.Errorf("access denied: only the owner can appoint managers")
+	}
+
+	member.Role = role
+	member.AddedBy = actorId
+	member.IsActive = true
+	member.UpdatedAt = now
+	if err := c.putOrgMember(ctx, member); err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// RemoveOrgMember removes a user from an organization. actorId must be an active owner
+// or manager; only the owner may remove managers, and the owner cannot be removed.
+func (c *UserContract) RemoveOrgMember(ctx contractapi.TransactionContextInterface, orgId, userId, actorId string) error {
+	actor, err := c.orgManager(ctx, orgId, actorId)
+	if err != nil {
+		return err
+	}
+
+	member, err := c.GetOrgMember(ctx, orgId, userId)
+	if err != nil {
+		return err
+	}
+	if !member.IsActive {
+		return fmt.Errorf("user %s is not a member of organization %s", userId, orgId)
+	}
+	if member.Role == OrgRoleOwner {
+		return fmt.Errorf("access denied: the owner cannot be removed")
+	}
+	if member.Role == OrgRoleManager && actor.Role != OrgRoleOwner {
+		return fmt.Errorf("access denied: only the owner can remove managers")
+	}
+
+	member.IsActive = false
+	member.AddedBy = actorId
+	member.UpdatedAt = time.Now()
+	return c.putOrgMember(ctx, member)
+}
+
+// GetOrgMember retrieves the membership of a user in an organization, including a
+// past one
+func (c *UserContract) GetOrgMember(ctx contractapi.TransactionContextInterface, orgId, userId string) (*OrgMember, error) {
+	memberKey, err := ctx.GetStub().CreateCompositeKey(orgMemberIndex, []string{orgId, userId})
+	if err != nil {
+		return nil, err
+	}
+	memberJSON, err := ctx.GetStub().GetState(memberKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read member: %v", err)
+	}
+	if memberJSON == nil {
+		return nil, fmt.Errorf("user %s is not a member of organization %s", userId, orgId)
+	}
+
+	var member OrgMember
+	if err := json.Unmarshal(memberJSON, &member); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// GetOrgMembers returns the active members of an organization
+func (c *UserContract) GetOrgMembers(ctx contractapi.TransactionContextInterface, orgId string) ([]*OrgMember, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(orgMemberIndex, []string{orgId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	members := []*OrgMember{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var member OrgMember
+		if err := json.Unmarshal(queryResponse.Value, &member); err != nil {
+			return nil, err
+		}
+		if member.IsActive {
+			members = append(members, &member)
+		}
+	}
+
+	return members, nil
+}
+
+// GetUserOrganizations returns the organizations a user is an active member of
+func (c *UserContract) GetUserOrganizations(ctx contractapi.TransactionContextInterface, userId string) ([]*Organization, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(userOrgIndex, []string{userId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	organizations := []*Organization{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) < 2 {
+			continue
+		}
+
+		org, err := c.GetOrganization(ctx, compositeKeyParts[1])
+		if err == nil && org.IsActive {
+			organizations = append(organizations, org)
+		}
+	}
+
+	return organizations, nil
+}
+
+// orgManager returns the membership of actorId if they are an active owner or manager
+// of an active organization
+func (c *UserContract) orgManager(ctx contractapi.TransactionContextInterface, orgId, actorId string) (*OrgMember, error) {
+	org, err := c.GetOrganization(ctx, orgId)
+	if err != nil {
+		return nil, err
+	}
+	if !org.IsActive {
+		return nil, fmt.Errorf("organization %s is not active", orgId)
+	}
+
+	actor, err := c.GetOrgMember(ctx, orgId, actorId)
+	if err != nil || !actor.IsActive || (actor.Role != OrgRoleOwner && actor.Role != OrgRoleManager) {
+		return nil, fmt.Errorf("access denied: only owners and managers can manage members")
+	}
+	return actor, nil
+}
+
+// putOrgMember saves a membership and keeps the index of the user's organizations in
+// step with it
+func (c *UserContract) putOrgMember(ctx contractapi.TransactionContextInterface, member *OrgMember) error {
+	memberJSON, err := json.Marshal(member)
+	if err != nil {
+		return err
+	}
+	memberKey, err := ctx.GetStub().CreateCompositeKey(orgMemberIndex, []string{member.OrgID, member.UserID})
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(memberKey, memberJSON); err != nil {
+		return err
+	}
+
+	userKey, err := ctx.GetStub().CreateCompositeKey(userOrgIndex, []string{member.UserID, member.OrgID})
+	if err != nil {
+		return err
+	}
+	if !member.IsActive {
+		return ctx.GetStub().DelState(userKey)
+	}
+	return ctx.GetStub().PutState(userKey, []byte{0x00})
+}
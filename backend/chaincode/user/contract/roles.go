@@ -0,0 +1,257 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Roles a user can hold. The backend maps each one to its permissions.
+const (
+	RoleUser            = "user"
+	RoleOperator        = "operator"
+	RoleSupport         = "support"
+	RoleSecurityAuditor = "security-auditor"
+	RoleAdmin           = "admin"
+)
+
+// roleIndex lists the users holding each role
+const roleIndex = "role~userId"
+
+// roleAssignmentIndex keys the audit trail of role changes by user
+const roleAssignmentIndex = "roleAssignment~userId~assignmentId"
+
+// RoleAssignment records a change of a user's role and who made it
+type RoleAssignment struct {
+	DocType      string    `json:"docType"`
+	AssignmentID string    `json:"assignmentId"` // ID of the transaction that made it
+	UserID       string    `json:"userId"`
+	PreviousRole string    `json:"previousRole"`
+	Role         string    `json:"role"`
+	AssignedBy   string    `json:"assignedBy"` // admin, "bootstrap" for the first one, or "migration"
+	Reason       string    `json:"reason"`
+	AssignedAt   time.Time `json:"assignedAt"`
+}
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleOperator, RoleSupport, RoleSecurityAuditor, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// AssignRole changes the role of a user. Only an active admin may do so, and not for
+// their own account, so the last admin cannot demote themselves by mistake. The
+// change is recorded in the user's role history.
+func (c *UserContract) AssignRole(ctx contractapi.TransactionContextInterface, userId, role, assignedBy, reason string) (*RoleAssignment, error) {
+	if !IsValidRole(role) {
+		return nil, fmt.Errorf("invalid role %q", role)
+	}
+
+	admin, err := c.GetUser(ctx, assignedBy)
+	if err != nil || admin.Role != RoleAdmin || !admin.IsActive {
+		return nil, fmt.Errorf("access denied: only admins can assign roles")
+	}
+	if assignedBy == userId {
+		return nil, fmt.Errorf("access denied: admins cannot change their own role")
+	}
+
+	return c.assignRole(ctx, userId, role, assignedBy, reason)
+}
+
+// BootstrapAdmin makes a user the first admin. The user must have verified their email,
+// so that registering an address first is not enough to claim it. It fails once any
+// admin exists, after which admins are appointed with AssignRole. Only admins in the
+// role index count; admins who predate it are demoted by DemoteUnassignedRoles.
+func (c *UserContract) BootstrapAdmin(ctx contractapi.TransactionContextInterface, userId string) (*RoleAssignment, error) {
+	user, err := c.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !user.EmailVerified || !user.IsActive {
+		return nil, fmt.Errorf("access denied: the first admin must be an active account with a verified email")
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(roleIndex, []string{RoleAdmin})
+	if err != nil {
+		return nil, err
+	}
+	hasAdmin := resultsIterator.HasNext()
+	resultsIterator.Close()
+	if hasAdmin {
+		return nil, fmt.Errorf("access denied: an admin already exists")
+	}
+
+	return c.assignRole(ctx, userId, RoleAdmin, "bootstrap", "first admin")
+}
+
+// DemoteUnassignedRoles makes users again of the accounts whose role was not granted
+// through AssignRole or BootstrapAdmin, such as those that chose their own role when
+// registering before roles were assigned. Roles granted by those accounts are revoked
+// too, since they were never entitled to grant them. It returns the IDs of the users
+// demoted, and does nothing once every role has been granted.
+func (c *UserContract) DemoteUnassignedRoles(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	// Every user is in the email index, including those who predate the role index
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("email~userId", []string{})
+	if err != nil {
+		return nil, err
+	}
+	var userIds []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			resultsIterator.Close()
+			return nil, err
+		}
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil || len(compositeKeyParts) < 2 {
+			continue
+		}
+		userIds = append(userIds, compositeKeyParts[1])
+	}
+	resultsIterator.Close()
+
+	// The assignment each user holds their role by, if any
+	grants := map[string]*RoleAssignment{}
+	var privileged []string
+	for _, userId := range userIds {
+		user, err := c.GetUser(ctx, userId)
+		if err != nil || user.Role == RoleUser {
+			continue
+		}
+		history, err := c.GetRoleHistory(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+		if len(history) > 0 && history[len(history)-1].Role == user.Role {
+			grants[userId] = history[len(history)-1]
+		}
+		privileged = append(privileged, userId)
+	}
+
+	// Demote the accounts holding a role without a grant, then those granted their role
+	// by a demoted account, until no grant traces back to one
+	demoted := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for _, userId := range privileged {
+			if demoted[userId] {
+				continue
+			}
+			grant := grants[userId]
+			if grant == nil || demoted[grant.AssignedBy] {
+				demoted[userId] = true
+				changed = true
+			}
+		}
+	}
+
+	demotedIds := []string{}
+	for _, userId := range privileged {
+		if !demoted[userId] {
+			continue
+		}
+		if _, err := c.assignRole(ctx, userId, RoleUser, "migration", "role not granted by an admin"); err != nil {
+			return nil, err
+		}
+		demotedIds = append(demotedIds, userId)
+	}
+
+	return demotedIds, nil
+}
+
+// GetRoleHistory returns the role changes of a user, oldest first
+func (c *UserContract) GetRoleHistory(ctx contractapi.TransactionContextInterface, userId string) ([]*RoleAssignment, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(roleAssignmentIndex, []string{userId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	assignments := []*RoleAssignment{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var assignment RoleAssignment
+		if err := json.Unmarshal(queryResponse.Value, &assignment); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, &assignment)
+	}
+
+	// Assignment IDs are transaction IDs, so order by time rather than by key
+	sort.Slice(assignments, func(i, j int) bool {
+		return assignments[i].AssignedAt.Before(assignments[j].AssignedAt)
+	})
+
+	return assignments, nil
+}
+
+func (c *UserContract) assignRole(ctx contractapi.TransactionContextInterface, userId, role, assignedBy, reason string) (*RoleAssignment, error) {
+	user, err := c.GetUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	previousRole := user.Role
+	now := time.Now()
+	user.Role = role
+	user.UpdatedAt = now
+	if err := c.putUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	// Move the user to the new role in the index
+	if previousRole != "" {
+		oldIndexKey, err := ctx.GetStub().CreateCompositeKey(roleIndex, []string{previousRole, userId})
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.GetStub().DelState(oldIndexKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.indexRole(ctx, role, userId); err != nil {
+		return nil, err
+	}
+
+	assignment := RoleAssignment{
+		DocType:      "roleAssignment",
+		AssignmentID: ctx.GetStub().GetTxID(),
+		UserID:       userId,
+		PreviousRole: previousRole,
+		Role:         role,
+		AssignedBy:   assignedBy,
+		Reason:       reason,
+		AssignedAt:   now,
+	}
+	assignmentJSON, err := json.Marshal(assignment)
+	if err != nil {
+		return nil, err
+	}
+	assignmentKey, err := ctx.GetStub().CreateCompositeKey(roleAssignmentIndex, []string{userId, assignment.AssignmentID})
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(assignmentKey, assignmentJSON); err != nil {
+		return nil, err
+	}
+
+	return &assignment, nil
+}
+
+func (c *UserContract) indexRole(ctx contractapi.TransactionContextInterface, role, userId string) error {
+	indexKey, err := ctx.GetStub().CreateCompositeKey(roleIndex, []string{role, userId})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(indexKey, []byte{0x00})
+}
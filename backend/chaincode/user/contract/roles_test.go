@@ -0,0 +1,277 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// rolesFixture drives role changes in a test, each in a transaction of its own
+type rolesFixture struct {
+	t    *testing.T
+	c    *UserContract
+	ctx  *contractapi.TransactionContext
+	stub *shimtest.MockStub
+	txs  int
+}
+
+func newRolesFixture(t *testing.T) *rolesFixture {
+	t.Helper()
+
+	stub := shimtest.NewMockStub("user", nil)
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	return &rolesFixture{t: t, c: &UserContract{}, ctx: ctx, stub: stub}
+}
+
+// tx starts a new transaction, so that role assignments get IDs of their own
+func (f *rolesFixture) tx() {
+	f.txs++
+	f.stub.MockTransactionStart(fmt.Sprintf("tx%d", f.txs))
+}
+
+// register creates a user, verifying their email if verified is set
+func (f *rolesFixture) register(userId string, verified bool) {
+	f.t.Helper()
+	f.tx()
+	if err := f.c.CreateUser(f.ctx, userId, userId+"@example.com", "hash", "First", "Last", ""); err != nil {
+		f.t.Fatal(err)
+	}
+	if verified {
+		user, err := f.c.GetUser(f.ctx, userId)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		user.EmailVerified = true
+		if err := f.c.putUser(f.ctx, user); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+}
+
+// registerLegacy stores a user who chose their own role, as registration once allowed
+func (f *rolesFixture) registerLegacy(userId, role string) {
+	f.t.Helper()
+	f.tx()
+	user := User{DocType: "user", UserID: userId, Email: userId + "@example.com", Role: role, IsActive: true, CreatedAt: time.Now()}
+	userJSON, err := json.Marshal(user)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.stub.PutState(userId, userJSON)
+	emailIndexKey, _ := f.stub.CreateCompositeKey("email~userId", []string{user.Email, userId})
+	f.stub.PutState(emailIndexKey, []byte{0x00})
+}
+
+func (f *rolesFixture) bootstrap(userId string) {
+	f.t.Helper()
+	f.tx()
+	if _, err := f.c.BootstrapAdmin(f.ctx, userId); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *rolesFixture) assign(userId, role, assignedBy string) {
+	f.t.Helper()
+	f.tx()
+	if _, err := f.c.AssignRole(f.ctx, userId, role, assignedBy, "test"); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *rolesFixture) role(userId string) string {
+	f.t.Helper()
+	user, err := f.c.GetUser(f.ctx, userId)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return user.Role
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(f *rolesFixture)
+		userId   string
+		wantErr  bool
+		wantRole string
+	}{
+		{
+			name:     "verified account becomes the first admin",
+			setup:    func(f *rolesFixture) { f.register("alice", true) },
+			userId:   "alice",
+			wantRole: RoleAdmin,
+		},
+		{
+			name:     "unverified account cannot claim admin",
+			setup:    func(f *rolesFixture) { f.register("alice", false) },
+			userId:   "alice",
+			wantErr:  true,
+			wantRole: RoleUser,
+		},
+		{
+			name: "only while there is no admin",
+			setup: func(f *rolesFixture) {
+				f.register("alice", true)
+				f.register("bob", true)
+				f.bootstrap("alice")
+			},
+			userId:   "bob",
+			wantErr:  true,
+			wantRole: RoleUser,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newRolesFixture(t)
+			tt.setup(f)
+
+			f.tx()
+			_, err := f.c.BootstrapAdmin(f.ctx, tt.userId)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("BootstrapAdmin(%s) error = %v, want error %v", tt.userId, err, tt.wantErr)
+			}
+			if role := f.role(tt.userId); role != tt.wantRole {
+				t.Errorf("role = %s, want %s", role, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestDemoteUnassignedRoles(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(f *rolesFixture)
+		// role of each user after the migration
+		want        map[string]string
+		wantDemoted []string
+	}{
+		{
+			name: "self-chosen roles are demoted",
+			setup: func(f *rolesFixture) {
+				f.registerLegacy("mallory", RoleAdmin)
+				f.registerLegacy("oscar", RoleOperator)
+				f.registerLegacy("ursula", RoleUser)
+			},
+			want:        map[string]string{"mallory": RoleUser, "oscar": RoleUser, "ursula": RoleUser},
+			wantDemoted: []string{"mallory", "oscar"},
+		},
+		{
+			name: "granted roles are kept",
+			setup: func(f *rolesFixture) {
+				f.register("alice", true)
+				f.register("bob", false)
+				f.bootstrap("alice")
+				f.assign("bob", RoleOperator, "alice")
+			},
+			want:        map[string]string{"alice": RoleAdmin, "bob": RoleOperator},
+			wantDemoted: []string{},
+		},
+		{
+			name: "roles granted by a demoted account are revoked",
+			setup: func(f *rolesFixture) {
+				f.registerLegacy("mallory", RoleAdmin)
+				f.register("trent", false)
+				f.register("bob", false)
+				f.assign("trent", RoleAdmin, "mallory")
+				f.assign("bob", RoleOperator, "trent")
+			},
+			want:        map[string]string{"mallory": RoleUser, "trent": RoleUser, "bob": RoleUser},
+			wantDemoted: []string{"mallory", "trent", "bob"},
+		},
+		{
+			name: "role granted again by a legitimate admin is kept",
+			setup: func(f *rolesFixture) {
+				f.registerLegacy("mallory", RoleAdmin)
+				f.register("alice", true)
+				f.register("bob", false)
+				f.assign("bob", RoleOperator, "mallory")
+				f.bootstrap("alice")
+				f.assign("bob", RoleSupport, "alice")
+			},
+			want:        map[string]string{"mallory": RoleUser, "alice": RoleAdmin, "bob": RoleSupport},
+			wantDemoted: []string{"mallory"},
+		},
+		{
+			name: "role changed since its grant is demoted",
+			setup: func(f *rolesFixture) {
+				f.register("alice", true)
+				f.register("bob", false)
+				f.bootstrap("alice")
+				f.assign("bob", RoleOperator, "alice")
+
+				// Written directly, not through AssignRole
+				user, _ := f.c.GetUser(f.ctx, "bob")
+				user.Role = RoleAdmin
+				f.c.putUser(f.ctx, user)
+			},
+			want:        map[string]string{"alice": RoleAdmin, "bob": RoleUser},
+			wantDemoted: []string{"bob"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newRolesFixture(t)
+			tt.setup(f)
+
+			f.tx()
+			demoted, err := f.c.DemoteUnassignedRoles(f.ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameIds(demoted, tt.wantDemoted) {
+				t.Errorf("demoted %v, want %v", demoted, tt.wantDemoted)
+			}
+			for userId, want := range tt.want {
+				if role := f.role(userId); role != want {
+					t.Errorf("role of %s = %s, want %s", userId, role, want)
+				}
+			}
+
+			// Demoted admins no longer block the bootstrap of a verified account
+			admins, _ := f.stub.GetStateByPartialCompositeKey(roleIndex, []string{RoleAdmin})
+			for admins.HasNext() {
+				admin, _ := admins.Next()
+				_, parts, _ := f.stub.SplitCompositeKey(admin.Key)
+				if tt.want[parts[1]] != RoleAdmin {
+					t.Errorf("%s is still indexed as an admin", parts[1])
+				}
+			}
+			admins.Close()
+
+			// Running it again changes nothing
+			f.tx()
+			again, err := f.c.DemoteUnassignedRoles(f.ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(again) != 0 {
+				t.Errorf("second run demoted %v", again)
+			}
+		})
+	}
+}
+
+// sameIds reports whether two lists hold the same IDs, in any order
+func sameIds(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[string]int{}
+	for _, id := range a {
+		seen[id]++
+	}
+	for _, id := range b {
+		if seen[id] == 0 {
+			return false
+		}
+		seen[id]--
+	}
+	return true
+}
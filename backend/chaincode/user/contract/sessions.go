@@ -0,0 +1,233 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// tokenHashIndex maps the keyed hash of a session's current refresh token to it
+	tokenHashIndex = "tokenHash~sessionId"
+	// rotatedTokenHashIndex maps the hashes of refresh tokens replaced by RotateSession
+	// to their session, so that a replayed token can be detected
+	rotatedTokenHashIndex = "rotatedTokenHash~sessionId"
+	// userSessionIndex lists the sessions of a user, one per device they logged in from
+	userSessionIndex = "userId~sessionId"
+
+	// legacyTokenIndex and legacyRotatedTokenIndex held raw tokens before sessions
+	// stored hashes; PurgeLegacySessions removes them
+	legacyTokenIndex        = "token~sessionId"
+	legacyRotatedTokenIndex = "rotatedToken~sessionId"
+)
+
+// RotateSession replaces the refresh token hash of a session with newTokenHash, records
+// the device it was used from and extends the session by expiresInHours. Presenting a
+// token that was already rotated means it was copied: the session is revoked and
+// returned inactive.
+func (c *UserContract) RotateSession(ctx contractapi.TransactionContextInterface, oldTokenHash, newTokenHash, ipAddress, userAgent string, expiresInHours int) (*Session, error) {
+	if oldTokenHash == newTokenHash {
+		return nil, fmt.Errorf("new token must differ from the current one")
+	}
+
+	session, err := c.GetSession(ctx, oldTokenHash)
+	if err != nil {
+		sessionId, found, indexErr := c.findIndexedSession(ctx, rotatedTokenHashIndex, oldTokenHash)
+		if indexErr != nil {
+			return nil, indexErr
+		}
+		if !found {
+			return nil, err
+		}
+		return c.revokeSession(ctx, sessionId)
+	}
+
+	// Move the old token hash to the rotated index
+	oldIndexKey, err := ctx.GetStub().CreateCompositeKey(tokenHashIndex, []string{oldTokenHash, session.SessionID})
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().DelState(oldIndexKey); err != nil {
+		return nil, err
+	}
+	rotatedIndexKey, err := ctx.GetStub().CreateCompositeKey(rotatedTokenHashIndex, []string{oldTokenHash, session.SessionID})
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(rotatedIndexKey, []byte{0x00}); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session.TokenHash = newTokenHash
+	session.ExpiresAt = now.Add(time.Duration(expiresInHours) * time.Hour)
+	session.LastUsedAt = now
+	session.IPAddress = ipAddress
+	session.UserAgent = userAgent
+
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(session.SessionID, sessionJSON); err != nil {
+		return nil, err
+	}
+
+	newIndexKey, err := ctx.GetStub().CreateCompositeKey(tokenHashIndex, []string{newTokenHash, session.SessionID})
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(newIndexKey, []byte{0x00}); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// RevokeSession invalidates one of a user's sessions by ID, whatever its current token
+func (c *UserContract) RevokeSession(ctx contractapi.TransactionContextInterface, userId, sessionId string) error {
+	session, err := c.getSessionById(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	if session.UserID != userId {
+		return fmt.Errorf("access denied: session %s does not belong to user %s", sessionId, userId)
+	}
+
+	_, err = c.revokeSession(ctx, sessionId)
+	return err
+}
+
+// RevokeOtherSessions invalidates every active session of a user except keepSessionId,
+// logging out their other devices. It returns the IDs of the revoked sessions.
+func (c *UserContract) RevokeOtherSessions(ctx contractapi.TransactionContextInterface, userId, keepSessionId string) ([]string, error) {
+	sessions, err := c.GetActiveSessions(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := []string{}
+	for _, session := range sessions {
+		if session.SessionID == keepSessionId {
+			continue
+		}
+		if _, err := c.revokeSession(ctx, session.SessionID); err != nil {
+			return nil, err
+		}
+		revoked = append(revoked, session.SessionID)
+	}
+
+	return revoked, nil
+}
+
+// PurgeLegacySessions removes the indexes that held raw tokens and deactivates the
+// sessions they pointed to, whose current state is rewritten without the token. Those
+// users have to log in again. It returns the number of sessions purged.
+func (c *UserContract) PurgeLegacySessions(ctx contractapi.TransactionContextInterface) (int, error) {
+	purged := map[string]bool{}
+	for _, index := range []string{legacyTokenIndex, legacyRotatedTokenIndex} {
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{})
+		if err != nil {
+			return 0, err
+		}
+
+		var keys []string
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return 0, err
+			}
+			keys = append(keys, queryResponse.Key)
+		}
+		resultsIterator.Close()
+
+		for _, key := range keys {
+			_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(key)
+			if err != nil {
+				return 0, err
+			}
+			if err := ctx.GetStub().DelState(key); err != nil {
+				return 0, err
+			}
+			if len(compositeKeyParts) < 2 || purged[compositeKeyParts[1]] {
+				continue
+			}
+
+			// Re-marshalling through Session drops the legacy token field
+			if _, err := c.revokeSession(ctx, compositeKeyParts[1]); err != nil {
+				continue
+			}
+			purged[compositeKeyParts[1]] = true
+		}
+	}
+
+	return len(purged), nil
+}
+
+func (c *UserContract) revokeSession(ctx contractapi.TransactionContextInterface, sessionId string) (*Session, error) {
+	session, err := c.getSessionById(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	session.IsActive = false
+
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(sessionId, sessionJSON); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// getSessionById reads a session by ID, active or not
+func (c *UserContract) getSessionById(ctx contractapi.TransactionContextInterface, sessionId string) (*Session, error) {
+	sessionJSON, err := ctx.GetStub().GetState(sessionId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %v", err)
+	}
+	if sessionJSON == nil {
+		return nil, fmt.Errorf("session %s does not exist", sessionId)
+	}
+
+	var session Session
+	if err := json.Unmarshal(sessionJSON, &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// findIndexedSession returns the session ID a token hash is indexed under in index
+func (c *UserContract) findIndexedSession(ctx contractapi.TransactionContextInterface, index, tokenHash string) (string, bool, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{tokenHash})
+	if err != nil {
+		return "", false, err
+	}
+	defer resultsIterator.Close()
+
+	if !resultsIterator.HasNext() {
+		return "", false, nil
+	}
+
+	queryResponse, err := resultsIterator.Next()
+	if err != nil {
+		return "", false, err
+	}
+
+	_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+	if err != nil {
+		return "", false, err
+	}
+	if len(compositeKeyParts) < 2 {
+		return "", false, fmt.Errorf("invalid composite key format")
+	}
+
+	return compositeKeyParts[1], true, nil
+}
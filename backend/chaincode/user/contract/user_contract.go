@@ -21,19 +21,27 @@ type User struct {
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
 	IsActive    bool      `json:"isActive"`
+	MFAEnabled  bool      `json:"mfaEnabled"`
+
+	EmailVerified       bool      `json:"emailVerified"`
+	FailedLoginAttempts int       `json:"failedLoginAttempts"` // since the last successful login or lockout
+	LockedUntil         time.Time `json:"lockedUntil,omitempty"`
+	PasswordChangedAt   time.Time `json:"passwordChangedAt,omitempty"`
 }
 
-// Session represents a user session stored on blockchain
+// Session represents a user session stored on blockchain. Only a keyed hash of its
+// token is stored, so reading the channel does not allow impersonating the user.
 type Session struct {
-	DocType   string    `json:"docType"`
-	SessionID string    `json:"sessionId"`
-	UserID    string    `json:"userId"`
-	Token     string    `json:"token"`
-	CreatedAt time.Time `json:"createdAt"`
-	ExpiresAt time.Time `json:"expiresAt"`
-	IsActive  bool      `json:"isActive"`
-	IPAddress string    `json:"ipAddress"`
-	UserAgent string    `json:"userAgent"`
+	DocType    string    `json:"docType"`
+	SessionID  string    `json:"sessionId"`
+	UserID     string    `json:"userId"`
+	TokenHash  string    `json:"tokenHash"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	IsActive   bool      `json:"isActive"`
+	IPAddress  string    `json:"ipAddress"` // of the device, as of the last use
+	UserAgent  string    `json:"userAgent"`
 }
 
 // UserContract provides functions for managing users and sessions
@@ -47,8 +55,9 @@ func (c *UserContract) InitLedger(ctx contractapi.TransactionContextInterface) e
 	return nil
 }
 
-// CreateUser creates a new user on the blockchain
-func (c *UserContract) CreateUser(ctx contractapi.TransactionContextInterface, userId, email, passwordHash, firstName, lastName, phone, role string) error {
+// CreateUser creates a new user on the blockchain. Every account starts with the user
+// role; other roles are granted by an admin with AssignRole.
+func (c *UserContract) CreateUser(ctx contractapi.TransactionContextInterface, userId, email, passwordHash, firstName, lastName, phone string) error {
 	// Check if user already exists
 	exists, err := c.UserExists(ctx, userId)
 	if err != nil {
@@ -76,7 +85,7 @@ func (c *UserContract) CreateUser(ctx contractapi.TransactionContextInterface, u
 		FirstName:    firstName,
 		LastName:     lastName,
 		Phone:        phone,
-		Role:         role,
+		Role:         RoleUser,
 		CreatedAt:    now,
 		UpdatedAt:    now,
 		IsActive:     true,
@@ -98,7 +107,12 @@ func (c *UserContract) CreateUser(ctx contractapi.TransactionContextInterface, u
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(emailIndexKey, []byte{0x00})
+	err = ctx.GetStub().PutState(emailIndexKey, []byte{0x00})
+	if err != nil {
+		return err
+	}
+
+	return c.indexRole(ctx, RoleUser, userId)
 }
 
 // GetUser retrieves a user by ID
@@ -195,18 +209,19 @@ func (c *UserContract) AuthenticateUser(ctx contractapi.TransactionContextInterf
 }
 
 // CreateSession creates a new session on the blockchain
-func (c *UserContract) CreateSession(ctx contractapi.TransactionContextInterface, sessionId, userId, token, ipAddress, userAgent string, expiresInHours int) error {
+func (c *UserContract) CreateSession(ctx contractapi.TransactionContextInterface, sessionId, userId, tokenHash, ipAddress, userAgent string, expiresInHours int) error {
 	now := time.Now()
 	session := Session{
-		DocType:   "session",
-		SessionID: sessionId,
-		UserID:    userId,
-		Token:     token,
-		CreatedAt: now,
-		ExpiresAt: now.Add(time.Duration(expiresInHours) * time.Hour),
-		IsActive:  true,
-		IPAddress: ipAddress,
-		UserAgent: userAgent,
+		DocType:    "session",
+		SessionID:  sessionId,
+		UserID:     userId,
+		TokenHash:  tokenHash,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(time.Duration(expiresInHours) * time.Hour),
+		LastUsedAt: now,
+		IsActive:   true,
+		IPAddress:  ipAddress,
+		UserAgent:  userAgent,
 	}
 
 	sessionJSON, err := json.Marshal(session)
@@ -221,55 +236,34 @@ func (c *UserContract) CreateSession(ctx contractapi.TransactionContextInterface
 	}
 
 	// Create token index for lookup
-	tokenIndexKey, err := ctx.GetStub().CreateCompositeKey("token~sessionId", []string{token, sessionId})
+	tokenIndexKey, err := ctx.GetStub().CreateCompositeKey(tokenHashIndex, []string{tokenHash, sessionId})
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(tokenIndexKey, []byte{0x00})
-}
-
-// GetSession retrieves a session by token using composite key index
-func (c *UserContract) GetSession(ctx contractapi.TransactionContextInterface, token string) (*Session, error) {
-	// Use composite key index to find session by token
-	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("token~sessionId", []string{token})
+	err = ctx.GetStub().PutState(tokenIndexKey, []byte{0x00})
 	if err != nil {
-		return nil, err
-	}
-	defer resultsIterator.Close()
-
-	if !resultsIterator.HasNext() {
-		return nil, fmt.Errorf("session not found")
+		return err
 	}
 
-	// Get the composite key
-	queryResponse, err := resultsIterator.Next()
+	// Create user index for listing the user's sessions
+	userIndexKey, err := ctx.GetStub().CreateCompositeKey(userSessionIndex, []string{userId, sessionId})
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return ctx.GetStub().PutState(userIndexKey, []byte{0x00})
+}
 
-	// Split the composite key to get sessionId
-	_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+// GetSession retrieves a session by token hash using composite key index
+func (c *UserContract) GetSession(ctx contractapi.TransactionContextInterface, tokenHash string) (*Session, error) {
+	sessionId, found, err := c.findIndexedSession(ctx, tokenHashIndex, tokenHash)
 	if err != nil {
 		return nil, err
 	}
-
-	if len(compositeKeyParts) < 2 {
-		return nil, fmt.Errorf("invalid composite key format")
-	}
-
-	sessionId := compositeKeyParts[1]
-
-	// Get the session by sessionId
-	sessionJSON, err := ctx.GetStub().GetState(sessionId)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read session: %v", err)
-	}
-	if sessionJSON == nil {
-		return nil, fmt.Errorf("session %s does not exist", sessionId)
+	if !found {
+		return nil, fmt.Errorf("session not found")
 	}
 
-	var session Session
-	err = json.Unmarshal(sessionJSON, &session)
+	session, err := c.getSessionById(ctx, sessionId)
 	if err != nil {
 		return nil, err
 	}
@@ -284,12 +278,12 @@ func (c *UserContract) GetSession(ctx contractapi.TransactionContextInterface, t
 		return nil, fmt.Errorf("session has expired")
 	}
 
-	return &session, nil
+	return session, nil
 }
 
-// ValidateSession validates a session token and returns the associated user
-func (c *UserContract) ValidateSession(ctx contractapi.TransactionContextInterface, token string) (*User, error) {
-	session, err := c.GetSession(ctx, token)
+// ValidateSession validates a session token hash and returns the associated user
+func (c *UserContract) ValidateSession(ctx contractapi.TransactionContextInterface, tokenHash string) (*User, error) {
+	session, err := c.GetSession(ctx, tokenHash)
 	if err != nil {
 		return nil, err
 	}
@@ -298,8 +292,8 @@ func (c *UserContract) ValidateSession(ctx contractapi.TransactionContextInterfa
 }
 
 // DeleteSession invalidates a session (logout)
-func (c *UserContract) DeleteSession(ctx contractapi.TransactionContextInterface, token string) error {
-	session, err := c.GetSession(ctx, token)
+func (c *UserContract) DeleteSession(ctx contractapi.TransactionContextInterface, tokenHash string) error {
+	session, err := c.GetSession(ctx, tokenHash)
 	if err != nil {
 		return err
 	}
@@ -314,32 +308,38 @@ func (c *UserContract) DeleteSession(ctx contractapi.TransactionContextInterface
 	return ctx.GetStub().PutState(session.SessionID, sessionJSON)
 }
 
-// GetActiveSessions returns all active sessions for a user
+// GetActiveSessions returns the active, unexpired sessions of a user, one per device
 func (c *UserContract) GetActiveSessions(ctx contractapi.TransactionContextInterface, userId string) ([]*Session, error) {
-	queryString := fmt.Sprintf(`{"selector":{"docType":"session","userId":"%s","isActive":true}}`, userId)
-	
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(userSessionIndex, []string{userId})
 	if err != nil {
 		return nil, err
 	}
 	defer resultsIterator.Close()
 
-	var sessions []*Session
+	now := time.Now()
+	sessions := []*Session{}
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
 			return nil, err
 		}
 
-		var session Session
-		err = json.Unmarshal(queryResponse.Value, &session)
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) < 2 {
+			continue
+		}
+
+		session, err := c.getSessionById(ctx, compositeKeyParts[1])
 		if err != nil {
 			return nil, err
 		}
 
-		// Only include non-expired sessions
-		if time.Now().Before(session.ExpiresAt) {
-			sessions = append(sessions, &session)
+		// Only include active, non-expired sessions
+		if session.IsActive && now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
 		}
 	}
 
@@ -0,0 +1,35 @@
+package contract
+
+import "fmt"
+
+// Roles a caller may act with. The API passes the authenticated user's role;
+// backend workflows such as saga compensations act with RoleSystem.
+const (
+	RoleUser     = "user"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+	RoleSystem   = "system"
+)
+
+// authorize allows the owner of a resource, the operator running it, admins and the
+// system to act on it
+func authorize(callerId, callerRole, ownerId, operatorId string) error {
+	switch {
+	case callerRole == RoleAdmin || callerRole == RoleSystem:
+		return nil
+	case callerId != "" && callerId == ownerId:
+		return nil
+	case callerRole == RoleOperator && callerId != "" && callerId == operatorId:
+		return nil
+	}
+	return fmt.Errorf("access denied: %s is not allowed to act on this resource", callerId)
+}
+
+// authorizeBooking checks that the caller may act on a booking: its user, the
+
+// authorizeRefund checks that the caller may refund a payment: the operator credited
+// with it, an admin, or the system. Payers are refunded by cancelling their booking
+// or session, which applies the refund policy.
+func authorizeRefund(payment *Payment, callerId, callerRole string) error {
+	return authorize(callerId, callerRole, "", payment.OperatorID)
+}
@@ -0,0 +1,394 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// delegationIndex keys the spending delegations of each wallet by user
+	delegationIndex = "delegation~walletId~userId"
+	// delegatedSpendIndex keys what each user spent from a wallet in a month
+	delegatedSpendIndex = "delegatedSpend~walletId~userId~month"
+	// delegatedPaymentIndex lists the delegated payments and refunds of a wallet by month
+	delegatedPaymentIndex = "delegatedPayment~walletId~month~paymentId"
+)
+
+// monthLayout formats the calendar months, in UTC, that spending limits apply to
+const monthLayout = "2006-01"
+
+// SpendingDelegation lets a user other than its owner pay from a wallet, such as a
+// driver spending from their organization's wallet, within a monthly limit
+type SpendingDelegation struct {
+	DocType           string   `json:"docType"`
+	WalletID          string   `json:"walletId"`
+	UserID            string   `json:"userId"`
+	Currency          string   `json:"currency"`
+	MonthlyLimitMinor int64    `json:"monthlyLimitMinor"`
+	AllowedLocations  []string `json:"allowedLocations"` // spot or station IDs, empty for anywhere
+	IsActive          bool     `json:"isActive"`
+	UpdatedAt         string   `json:"updatedAt"`
+}
+
+// DelegatedStatement reports what the delegates of a wallet spent in a month
+type DelegatedStatement struct {
+	WalletID      string           `json:"walletId"`
+	Month         string           `json:"month"` // YYYY-MM, UTC
+	Currency      string           `json:"currency"`
+	SpentMinor    int64            `json:"spentMinor"`
+	RefundedMinor int64            `json:"refundedMinor"`
+	NetMinor      int64            `json:"netMinor"`
+	Users         []*DelegateSpend `json:"users"`
+	PaymentIDs    []string         `json:"paymentIds"`
+	RefundIDs     []string         `json:"refundIds"`
+}
+
+// DelegateSpend is one user's line of a delegated statement
+type DelegateSpend struct {
+	UserID            string `json:"userId"`
+	Payments          int    `json:"payments"`
+	SpentMinor        int64  `json:"spentMinor"`
+	RefundedMinor     int64  `json:"refundedMinor"`
+	NetMinor          int64  `json:"netMinor"`
+	MonthlyLimitMinor int64  `json:"monthlyLimitMinor"`
+}
+
+// ==================== Delegations ====================
+
+// SetDelegation lets a user pay from a wallet up to monthlyLimit, a decimal string in
+// the wallet's currency, per calendar month. allowedLocations restricts the spots and
+// stations they may pay for; empty allows any. The API checks that the caller manages
+// the organization owning the wallet.
+func (c *WalletContract) SetDelegation(ctx contractapi.TransactionContextInterface, walletId, userId, monthlyLimit string, allowedLocations []string) (*SpendingDelegation, error) {
+	wallet, err := c.GetWallet(ctx, walletId)
+	if err != nil {
+		return nil, err
+	}
+	if userId == wallet.UserID {
+		return nil, fmt.Errorf("the owner of wallet %s does not need a delegation", walletId)
+	}
+
+	limitMinor, err := parseAmount(monthlyLimit, wallet.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if limitMinor <= 0 {
+		return nil, fmt.Errorf("monthly limit must be positive")
+	}
+	if allowedLocations == nil {
+		allowedLocations = []string{}
+	}
+
+	delegation := SpendingDelegation{
+		DocType:           "delegation",
+		WalletID:          walletId,
+		UserID:            userId,
+		Currency:          wallet.Currency,
+		MonthlyLimitMinor: limitMinor,
+		AllowedLocations:  allowedLocations,
+		IsActive:          true,
+		UpdatedAt:         time.Now().Format(time.RFC3339),
+	}
+	if err := c.putDelegation(ctx, &delegation); err != nil {
+		return nil, err
+	}
+
+	return &delegation, nil
+}
+
+// RevokeDelegation stops a user from paying from a wallet. Their past payments stay
+// on the wallet's statements.
+func (c *WalletContract) RevokeDelegation(ctx contractapi.TransactionContextInterface, walletId, userId string) error {
+	delegation, err := c.GetDelegation(ctx, walletId, userId)
+	if err != nil {
+		return err
+	}
+
+	delegation.IsActive = false
+	delegation.UpdatedAt = time.Now().Format(time.RFC3339)
+	return c.putDelegation(ctx, delegation)
+}
+
+// GetDelegation retrieves the delegation of a user on a wallet
+func (c *WalletContract) GetDelegation(ctx contractapi.TransactionContextInterface, walletId, userId string) (*SpendingDelegation, error) {
+	delegationKey, err := ctx.GetStub().CreateCompositeKey(delegationIndex, []string{walletId, userId})
+	if err != nil {
+		return nil, err
+	}
+	delegationJSON, err := ctx.GetStub().GetState(delegationKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read delegation: %v", err)
+	}
+	if delegationJSON == nil {
+		return nil, fmt.Errorf("user %s has no delegation on wallet %s", userId, walletId)
+	}
+
+	var delegation SpendingDelegation
+	if err := json.Unmarshal(delegationJSON, &delegation); err != nil {
+		return nil, err
+	}
+	return &delegation, nil
+}
+
+// GetWalletDelegations returns the active delegations of a wallet
+func (c *WalletContract) GetWalletDelegations(ctx contractapi.TransactionContextInterface, walletId string) ([]*SpendingDelegation, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(delegationIndex, []string{walletId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	delegations := []*SpendingDelegation{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var delegation SpendingDelegation
+		if err := json.Unmarshal(queryResponse.Value, &delegation); err != nil {
+			return nil, err
+		}
+		if delegation.IsActive {
+			delegations = append(delegations, &delegation)
+		}
+	}
+
+	return delegations, nil
+}
+
+// GetDelegatedSpend returns what a user spent from a wallet in a month (YYYY-MM, UTC),
+// net of refunds, in minor units of the wallet's currency
+func (c *WalletContract) GetDelegatedSpend(ctx contractapi.TransactionContextInterface, walletId, userId, month string) (int64, error) {
+	if _, err := time.Parse(monthLayout, month); err != nil {
+		return 0, fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+	}
+	return c.delegatedSpend(ctx, walletId, userId, month)
+}
+
+// ==================== Delegated Payments ====================
+
+// ProcessDelegatedPayment charges a wallet for a payment made by spenderId under their
+// delegation, for a spot or station locationId. The amount is the sum of lineItems, or
+// amount, a decimal string in currency, when there are none. The payment fails if the
+// delegation is revoked, does not allow the location, or would exceed this month's limit.
+func (c *WalletContract) ProcessDelegatedPayment(ctx contractapi.TransactionContextInterface, paymentId, walletId, spenderId, locationId, amount, currency, operatorId, paymentType, referenceId, description string, lineItems []LineItem) (*Payment, error) {
+	wallet, err := c.walletForPayment(ctx, walletId, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	var amountMinor int64
+	if len(lineItems) > 0 {
+		for _, item := range lineItems {
+			if item.AmountMinor < 0 {
+				return nil, fmt.Errorf("line item %s must not be negative", item.Type)
+			}
+			amountMinor += item.AmountMinor
+		}
+	} else {
+		lineItems = nil
+		amountMinor, err = parseAmount(amount, wallet.Currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	delegation, err := c.GetDelegation(ctx, walletId, spenderId)
+	if err != nil || !delegation.IsActive {
+		return nil, fmt.Errorf("access denied: %s may not pay from wallet %s", spenderId, walletId)
+	}
+	if !allowsLocation(delegation, locationId) {
+		return nil, fmt.Errorf("access denied: %s may not pay for %s from wallet %s", spenderId, locationId, walletId)
+	}
+
+	month := time.Now().UTC().Format(monthLayout)
+	spentMinor, err := c.delegatedSpend(ctx, walletId, spenderId, month)
+	if err != nil {
+		return nil, err
+	}
+	if spentMinor+amountMinor > delegation.MonthlyLimitMinor {
+		return nil, fmt.Errorf("monthly limit exceeded: %s of %s %s left this month",
+			formatAmount(max(delegation.MonthlyLimitMinor-spentMinor, 0), wallet.Currency), formatAmount(delegation.MonthlyLimitMinor, wallet.Currency), wallet.Currency)
+	}
+
+	payment, err := c.processPayment(ctx, paymentId, wallet, amountMinor, operatorId, paymentType, referenceId, description, lineItems, spenderId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.putDelegatedSpend(ctx, walletId, spenderId, month, spentMinor+amountMinor); err != nil {
+		return nil, err
+	}
+	if err := indexDelegatedPayment(ctx, walletId, month, paymentId); err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// GetDelegatedStatement reports what the delegates of a wallet spent and were refunded
+// in a month (YYYY-MM, UTC)
+func (c *WalletContract) GetDelegatedStatement(ctx contractapi.TransactionContextInterface, walletId, month string) (*DelegatedStatement, error) {
+	if _, err := time.Parse(monthLayout, month); err != nil {
+		return nil, fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+	}
+
+	wallet, err := c.GetWallet(ctx, walletId)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(delegatedPaymentIndex, []string{walletId, month})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	statement := DelegatedStatement{
+		WalletID:   walletId,
+		Month:      month,
+		Currency:   wallet.Currency,
+		Users:      []*DelegateSpend{},
+		PaymentIDs: []string{},
+		RefundIDs:  []string{},
+	}
+	users := map[string]*DelegateSpend{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) < 3 {
+			continue
+		}
+
+		payment, err := c.GetPayment(ctx, compositeKeyParts[2])
+		if err != nil {
+			return nil, err
+		}
+
+		user, ok := users[payment.SpenderID]
+		if !ok {
+			user = &DelegateSpend{UserID: payment.SpenderID}
+			if delegation, err := c.GetDelegation(ctx, walletId, payment.SpenderID); err == nil {
+				user.MonthlyLimitMinor = delegation.MonthlyLimitMinor
+			}
+			users[payment.SpenderID] = user
+			statement.Users = append(statement.Users, user)
+		}
+
+		if payment.Type == "refund" {
+			user.RefundedMinor += payment.AmountMinor
+			statement.RefundedMinor += payment.AmountMinor
+			statement.RefundIDs = append(statement.RefundIDs, payment.PaymentID)
+		} else {
+			user.Payments++
+			user.SpentMinor += payment.AmountMinor
+			statement.SpentMinor += payment.AmountMinor
+			statement.PaymentIDs = append(statement.PaymentIDs, payment.PaymentID)
+		}
+		user.NetMinor = user.SpentMinor - user.RefundedMinor
+	}
+	statement.NetMinor = statement.SpentMinor - statement.RefundedMinor
+
+	sort.Slice(statement.Users, func(i, j int) bool {
+		return statement.Users[i].UserID < statement.Users[j].UserID
+	})
+
+	return &statement, nil
+}
+
+// refundDelegatedPayment gives back to its spender's monthly limit the refunded part
+// of a delegated payment, and lists the refund on the wallet's statement
+func (c *WalletContract) refundDelegatedPayment(ctx contractapi.TransactionContextInterface, payment, refund *Payment) error {
+	paidAt, err := time.Parse(time.RFC3339, payment.CreatedAt)
+	if err != nil {
+		return err
+	}
+	paidMonth := paidAt.UTC().Format(monthLayout)
+
+	spentMinor, err := c.delegatedSpend(ctx, payment.WalletID, payment.SpenderID, paidMonth)
+	if err != nil {
+		return err
+	}
+	if err := c.putDelegatedSpend(ctx, payment.WalletID, payment.SpenderID, paidMonth, max(spentMinor-refund.AmountMinor, 0)); err != nil {
+		return err
+	}
+
+	return indexDelegatedPayment(ctx, payment.WalletID, time.Now().UTC().Format(monthLayout), refund.PaymentID)
+}
+
+func (c *WalletContract) delegatedSpend(ctx contractapi.TransactionContextInterface, walletId, userId, month string) (int64, error) {
+	spendKey, err := ctx.GetStub().CreateCompositeKey(delegatedSpendIndex, []string{walletId, userId, month})
+	if err != nil {
+		return 0, err
+	}
+	spendJSON, err := ctx.GetStub().GetState(spendKey)
+	if err != nil {
+		return 0, err
+	}
+	if spendJSON == nil {
+		return 0, nil
+	}
+
+	var spentMinor int64
+	if err := json.Unmarshal(spendJSON, &spentMinor); err != nil {
+		return 0, err
+	}
+	return spentMinor, nil
+}
+
+func (c *WalletContract) putDelegatedSpend(ctx contractapi.TransactionContextInterface, walletId, userId, month string, spentMinor int64) error {
+	spendKey, err := ctx.GetStub().CreateCompositeKey(delegatedSpendIndex, []string{walletId, userId, month})
+	if err != nil {
+		return err
+	}
+	spendJSON, err := json.Marshal(spentMinor)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(spendKey, spendJSON)
+}
+
+func (c *WalletContract) putDelegation(ctx contractapi.TransactionContextInterface, delegation *SpendingDelegation) error {
+	delegationJSON, err := json.Marshal(delegation)
+	if err != nil {
+		return err
+	}
+	delegationKey, err := ctx.GetStub().CreateCompositeKey(delegationIndex, []string{delegation.WalletID, delegation.UserID})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(delegationKey, delegationJSON)
+}
+
+func indexDelegatedPayment(ctx contractapi.TransactionContextInterface, walletId, month, paymentId string) error {
+	indexKey, err := ctx.GetStub().CreateCompositeKey(delegatedPaymentIndex, []string{walletId, month, paymentId})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(indexKey, []byte{0x00})
+}
+
+// allowsLocation reports whether a delegation allows paying for a spot or station
+func allowsLocation(delegation *SpendingDelegation, locationId string) bool {
+	if len(delegation.AllowedLocations) == 0 {
+		return true
+	}
+	for _, allowed := range delegation.AllowedLocations {
+		if allowed == locationId {
+			return true
+		}
+	}
+	return false
+}
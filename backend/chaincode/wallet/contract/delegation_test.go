@@ -0,0 +1,119 @@
+package contract
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefundDelegatedPayment(t *testing.T) {
+	now := time.Now().UTC()
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	lastMonth := thisMonth.AddDate(0, -1, 0)
+	utcPlus3 := time.FixedZone("UTC+3", 3*60*60)
+
+	tests := []struct {
+		name      string
+		paidAt    string
+		paidMonth string // whose spend the refund gives back
+		spent     int64  // in the month paid, before the refund
+		refund    int64
+		want      int64 // in the month paid, after the refund
+		wantErr   bool
+	}{
+		{
+			name:      "partial refund frees part of the limit",
+			paidAt:    now.Format(time.RFC3339),
+			paidMonth: thisMonth.Format(monthLayout),
+			spent:     5000,
+			refund:    1200,
+			want:      3800,
+		},
+		{
+			name:      "full refund frees the whole payment",
+			paidAt:    now.Format(time.RFC3339),
+			paidMonth: thisMonth.Format(monthLayout),
+			spent:     2500,
+			refund:    2500,
+			want:      0,
+		},
+		{
+			name:      "spend never goes below zero",
+			paidAt:    now.Format(time.RFC3339),
+			paidMonth: thisMonth.Format(monthLayout),
+			spent:     300,
+			refund:    1000,
+			want:      0,
+		},
+		{
+			name:      "refund frees the limit of the month paid in",
+			paidAt:    lastMonth.Add(36 * time.Hour).Format(time.RFC3339),
+			paidMonth: lastMonth.Format(monthLayout),
+			spent:     9000,
+			refund:    2000,
+			want:      7000,
+		},
+		{
+			name:      "month paid in is taken in UTC",
+			paidAt:    thisMonth.Add(-30 * time.Minute).In(utcPlus3).Format(time.RFC3339),
+			paidMonth: lastMonth.Format(monthLayout),
+			spent:     1000,
+			refund:    1000,
+			want:      0,
+		},
+		{
+			name:      "invalid payment time",
+			paidAt:    "yesterday",
+			paidMonth: thisMonth.Format(monthLayout),
+			spent:     1000,
+			refund:    100,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, stub := newTestContext(t)
+			c := &WalletContract{}
+
+			// Spend of the current month, which refunds of earlier months leave alone
+			currentMonth := thisMonth.Format(monthLayout)
+			const currentSpent = 4000
+			if tt.paidMonth != currentMonth {
+				if err := c.putDelegatedSpend(ctx, "wallet1", "driver1", currentMonth, currentSpent); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := c.putDelegatedSpend(ctx, "wallet1", "driver1", tt.paidMonth, tt.spent); err != nil {
+				t.Fatal(err)
+			}
+
+			payment := &Payment{PaymentID: "pay1", WalletID: "wallet1", SpenderID: "driver1", CreatedAt: tt.paidAt}
+			refund := &Payment{PaymentID: "refund1", WalletID: "wallet1", SpenderID: "driver1", AmountMinor: tt.refund}
+			err := c.refundDelegatedPayment(ctx, payment, refund)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("refund succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("refund failed: %v", err)
+			}
+
+			if got, _ := c.delegatedSpend(ctx, "wallet1", "driver1", tt.paidMonth); got != tt.want {
+				t.Errorf("spent in %s = %d, want %d", tt.paidMonth, got, tt.want)
+			}
+			if tt.paidMonth != currentMonth {
+				if got, _ := c.delegatedSpend(ctx, "wallet1", "driver1", currentMonth); got != currentSpent {
+					t.Errorf("spent in %s = %d, want %d", currentMonth, got, currentSpent)
+				}
+			}
+
+			// The refund is listed on this month's statement, whenever the payment was made
+			indexKey, _ := stub.CreateCompositeKey(delegatedPaymentIndex, []string{"wallet1", currentMonth, "refund1"})
+			if stub.State[indexKey] == nil {
+				t.Errorf("refund not listed on the statement of %s", currentMonth)
+			}
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Account types of the double-entry journal
+const (
+	AccountWallet         = "wallet"           // a user's wallet, keyed by wallet ID
+	AccountOperator       = "operator_revenue" // revenue earned by a parking or charging operator
+	AccountPlatform       = "platform_fees"    // the platform's own revenue
+	AccountRefundClearing = "refund_clearing"  // refunds paid out, to be recovered from operators
+	AccountFunding        = "funding"          // money entering the system through top-ups
+)
+
+const (
+	// postingIndex keys the postings of each account by journal entry
+	postingIndex = "accountId~entryId"
+	// accountIndex lists the journal accounts other than wallets
+	accountIndex = "accountType~accountId"
+)
+
+// Account is a journal account other than a user wallet. Its balance is the sum of
+// the postings made to it; the funding account goes negative as money is brought in.
+type Account struct {
+	DocType      string `json:"docType"`
+	AccountID    string `json:"accountId"`
+	Type         string `json:"type"`
+	OwnerID      string `json:"ownerId,omitempty" metadata:",optional"` // operator ID of revenue accounts
+	Currency     string `json:"currency"`
+	BalanceMinor int64  `json:"balanceMinor"`
+	CreatedAt    string `json:"createdAt"`
+	UpdatedAt    string `json:"updatedAt"`
+}
+
+// Posting moves an amount into (positive) or out of (negative) an account
+type Posting struct {
+	AccountID   string `json:"accountId"`
+	AccountType string `json:"accountType"`
+	OwnerID     string `json:"ownerId,omitempty" metadata:",optional"`
+	AmountMinor int64  `json:"amountMinor"`
+}
+
+// JournalEntry is a balanced set of postings: its amounts always sum to zero, so money
+// is only ever moved between accounts
+type JournalEntry struct {
+	DocType     string    `json:"docType"`
+	EntryID     string    `json:"entryId"`
+	Type        string    `json:"type"`        // opening, topup, chargeback, payment, refund, settlement
+	ReferenceID string    `json:"referenceId"` // payment, transaction or statement ID
+	Currency    string    `json:"currency"`
+	Postings    []Posting `json:"postings"`
+	CreatedAt   string    `json:"createdAt"`
+}
+
+// Discrepancy is an account whose stored balance differs from its journal
+type Discrepancy struct {
+	AccountID       string `json:"accountId"`
+	AccountType     string `json:"accountType"`
+	Currency        string `json:"currency"`
+	BalanceMinor    int64  `json:"balanceMinor"`
+	JournalMinor    int64  `json:"journalMinor"`
+	DifferenceMinor int64  `json:"differenceMinor"`
+}
+
+// ReconciliationReport is the result of checking every balance against the journal
+type ReconciliationReport struct {
+	Balanced          bool          `json:"balanced"`
+	AccountsChecked   int           `json:"accountsChecked"`
+	EntriesChecked    int           `json:"entriesChecked"`
+	Discrepancies     []Discrepancy `json:"discrepancies"`
+	UnbalancedEntries []string      `json:"unbalancedEntries"`
+	CheckedAt         string        `json:"checkedAt"`
+}
+
+// ==================== Journal ====================
+
+// GetAccount retrieves a journal account by ID
+func (c *WalletContract) GetAccount(ctx contractapi.TransactionContextInterface, accountId string) (*Account, error) {
+	accountJSON, err := ctx.GetStub().GetState(accountId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account: %v", err)
+	}
+	if accountJSON == nil {
+		return nil, fmt.Errorf("account %s does not exist", accountId)
+	}
+
+	var account Account
+	err = json.Unmarshal(accountJSON, &account)
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// GetAccounts returns the journal accounts of a type
+func (c *WalletContract) GetAccounts(ctx contractapi.TransactionContextInterface, accountType string) ([]*Account, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(accountIndex, []string{accountType})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	accounts := []*Account{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) < 2 {
+			continue
+		}
+
+		account, err := c.GetAccount(ctx, compositeKeyParts[1])
+		if err == nil {
+			accounts = append(accounts, account)
+		}
+	}
+
+	return accounts, nil
+}
+
+// GetJournalEntry retrieves a journal entry by ID
+func (c *WalletContract) GetJournalEntry(ctx contractapi.TransactionContextInterface, entryId string) (*JournalEntry, error) {
+	entryJSON, err := ctx.GetStub().GetState(entryId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal entry: %v", err)
+	}
+	if entryJSON == nil {
+		return nil, fmt.Errorf("journal entry %s does not exist", entryId)
+	}
+
+	var entry JournalEntry
+	err = json.Unmarshal(entryJSON, &entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// Reconcile checks that every wallet and account balance equals the sum of its
+// postings, and that every journal entry balances
+func (c *WalletContract) Reconcile(ctx contractapi.TransactionContextInterface) (*ReconciliationReport, error) {
+	journal := map[string]int64{}
+	accountTypes := map[string]string{}
+	entries := map[string]int64{}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(postingIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) < 2 {
+			continue
+		}
+
+		var posting Posting
+		err = json.Unmarshal(queryResponse.Value, &posting)
+		if err != nil {
+			return nil, err
+		}
+		journal[posting.AccountID] += posting.AmountMinor
+		accountTypes[posting.AccountID] = posting.AccountType
+		entries[compositeKeyParts[1]] += posting.AmountMinor
+	}
+
+	report := &ReconciliationReport{
+		EntriesChecked:    len(entries),
+		Discrepancies:     []Discrepancy{},
+		UnbalancedEntries: []string{},
+		CheckedAt:         time.Now().Format(time.RFC3339),
+	}
+	checked := map[string]bool{}
+	check := func(accountId, accountType, currency string, balance int64) {
+		checked[accountId] = true
+		report.AccountsChecked++
+		if balance != journal[accountId] {
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{
+				AccountID:       accountId,
+				AccountType:     accountType,
+				Currency:        currency,
+				BalanceMinor:    balance,
+				JournalMinor:    journal[accountId],
+				DifferenceMinor: balance - journal[accountId],
+			})
+		}
+	}
+
+	wallets, err := c.getAllWallets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, wallet := range wallets {
+		check(wallet.WalletID, AccountWallet, wallet.Currency, wallet.BalanceMinor)
+	}
+
+	for _, accountType := range []string{AccountOperator, AccountPlatform, AccountRefundClearing, AccountFunding, AccountPayout} {
+		accounts, err := c.GetAccounts(ctx, accountType)
+		if err != nil {
+			return nil, err
+		}
+		for _, account := range accounts {
+			check(account.AccountID, account.Type, account.Currency, account.BalanceMinor)
+		}
+	}
+
+	// Postings to an account that no longer exists
+	for accountId, sum := range journal {
+		if !checked[accountId] {
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{
+				AccountID:       accountId,
+				AccountType:     accountTypes[accountId],
+				JournalMinor:    sum,
+				DifferenceMinor: -sum,
+			})
+		}
+	}
+
+	for entryId, sum := range entries {
+		if sum != 0 {
+			report.UnbalancedEntries = append(report.UnbalancedEntries, entryId)
+		}
+	}
+
+	sort.Slice(report.Discrepancies, func(i, j int) bool {
+		return report.Discrepancies[i].AccountID < report.Discrepancies[j].AccountID
+	})
+	sort.Strings(report.UnbalancedEntries)
+	report.Balanced = len(report.Discrepancies) == 0 && len(report.UnbalancedEntries) == 0
+
+	return report, nil
+}
+
+// postEntry records a journal entry and applies its postings to accounts other than
+// wallets. Wallet balances are updated and saved by the caller, which Reconcile checks.
+func (c *WalletContract) postEntry(ctx contractapi.TransactionContextInterface, entryType, referenceId, currency string, postings ...Posting) error {
+	var sum int64
+	for _, posting := range postings {
+		sum += posting.AmountMinor
+	}
+	if sum != 0 {
+		return fmt.Errorf("journal entry for %s does not balance: off by %d", referenceId, sum)
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	entry := JournalEntry{
+		DocType:     "journalEntry",
+		EntryID:     "journal_" + referenceId,
+		Type:        entryType,
+		ReferenceID: referenceId,
+		Currency:    currency,
+		Postings:    postings,
+		CreatedAt:   now,
+	}
+
+	for _, posting := range postings {
+		if posting.AccountType != AccountWallet {
+			if err := c.applyPosting(ctx, posting, currency, now); err != nil {
+				return err
+			}
+		}
+
+		postingKey, err := ctx.GetStub().CreateCompositeKey(postingIndex, []string{posting.AccountID, entry.EntryID})
+		if err != nil {
+			return err
+		}
+		postingJSON, err := json.Marshal(posting)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().PutState(postingKey, postingJSON)
+		if err != nil {
+			return err
+		}
+	}
+
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(entry.EntryID, entryJSON)
+}
+
+// applyPosting updates the balance of a non-wallet account, opening it on first use
+func (c *WalletContract) applyPosting(ctx contractapi.TransactionContextInterface, posting Posting, currency, now string) error {
+	accountJSON, err := ctx.GetStub().GetState(posting.AccountID)
+	if err != nil {
+		return fmt.Errorf("failed to read account: %v", err)
+	}
+
+	var account Account
+	if accountJSON == nil {
+		account = Account{
+			DocType:   "account",
+			AccountID: posting.AccountID,
+			Type:      posting.AccountType,
+			OwnerID:   posting.OwnerID,
+			Currency:  currency,
+			CreatedAt: now,
+		}
+
+		indexKey, err := ctx.GetStub().CreateCompositeKey(accountIndex, []string{posting.AccountType, posting.AccountID})
+		if err != nil {
+			return err
+		}
+		ctx.GetStub().PutState(indexKey, []byte{0x00})
+	} else if err := json.Unmarshal(accountJSON, &account); err != nil {
+		return err
+	}
+
+	if account.Currency != currency {
+		return fmt.Errorf("account %s holds %s, not %s", account.AccountID, account.Currency, currency)
+	}
+	account.BalanceMinor += posting.AmountMinor
+	account.UpdatedAt = now
+
+	accountJSON, err = json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(account.AccountID, accountJSON)
+}
+
+// getAllWallets returns every wallet on the ledger
+func (c *WalletContract) getAllWallets(ctx contractapi.TransactionContextInterface) ([]*Wallet, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("userId~walletId", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var wallets []*Wallet
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) < 2 {
+			continue
+		}
+
+		wallet, err := c.GetWallet(ctx, compositeKeyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, wallet)
+	}
+
+	return wallets, nil
+}
+
+// walletPosting moves an amount into or out of a user's wallet
+func walletPosting(wallet *Wallet, amountMinor int64) Posting {
+	return Posting{AccountID: wallet.WalletID, AccountType: AccountWallet, OwnerID: wallet.UserID, AmountMinor: amountMinor}
+}
+
+// systemPosting moves an amount into or out of a platform account in currency
+func systemPosting(accountType, currency string, amountMinor int64) Posting {
+	return Posting{AccountID: fmt.Sprintf("account_%s_%s", accountType, currency), AccountType: accountType, AmountMinor: amountMinor}
+}
+
+// operatorPosting moves an amount into or out of an operator's revenue account in currency
+func operatorPosting(operatorId, currency string, amountMinor int64) Posting {
+	return Posting{AccountID: operatorAccountId(operatorId, currency), AccountType: AccountOperator, OwnerID: operatorId, AmountMinor: amountMinor}
+}
+
+// operatorAccountId returns the ID of an operator's revenue account in currency
+func operatorAccountId(operatorId, currency string) string {
+	return fmt.Sprintf("account_%s_%s_%s", AccountOperator, operatorId, currency)
+}
+
+// hasPostings reports whether any journal entry has been posted to an account
+func hasPostings(ctx contractapi.TransactionContextInterface, accountId string) (bool, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(postingIndex, []string{accountId})
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+	return resultsIterator.HasNext(), nil
+}
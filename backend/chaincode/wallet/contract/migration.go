@@ -0,0 +1,174 @@
+package contract
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// MigrationResult reports the progress of a migration run
+type MigrationResult struct {
+	Scanned        int    `json:"scanned"`
+	Migrated       int    `json:"migrated"`
+	JournalsOpened int    `json:"journalsOpened"`
+	Bookmark       string `json:"bookmark"` // empty when the last page was migrated
+}
+
+// legacyWallet is a wallet holding its balance as a floating point amount
+type legacyWallet struct {
+	Wallet
+	Balance *float64 `json:"balance"`
+}
+
+// legacyLineItem is a line item holding a floating point amount
+type legacyLineItem struct {
+	LineItem
+	Amount float64 `json:"amount"`
+}
+
+// legacyPayment is a payment holding floating point amounts
+type legacyPayment struct {
+	Payment
+	Amount    *float64         `json:"amount"`
+	LineItems []legacyLineItem `json:"lineItems"`
+}
+
+// legacyTransaction is a transaction holding floating point amounts
+type legacyTransaction struct {
+	Transaction
+	Amount        *float64 `json:"amount"`
+	BalanceBefore float64  `json:"balanceBefore"`
+	BalanceAfter  float64  `json:"balanceAfter"`
+}
+
+// ==================== Migration ====================
+
+// MigrateAmounts converts wallet, payment and transaction records holding floating
+// point amounts to minor units, and opens the journal of wallets created before it
+// existed with their current balance. Records are scanned a page at a time; call again
+// with the returned bookmark until it is empty. Migrated records are skipped, so runs
+// can be repeated safely.
+func (c *WalletContract) MigrateAmounts(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*MigrationResult, error) {
+	if pageSize < 1 || pageSize > 1000 {
+		pageSize = 100
+	}
+
+	// Paginated range queries are only allowed in read-only transactions, so pages are
+	// walked with a plain range query starting at the bookmark key
+	resultsIterator, err := ctx.GetStub().GetStateByRange(bookmark, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &MigrationResult{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if result.Scanned == pageSize {
+			result.Bookmark = queryResponse.Key
+			break
+		}
+		result.Scanned++
+
+		record := queryResponse.Value
+		migrated, err := migrateRecord(record)
+		if err != nil {
+			continue
+		}
+		if migrated != nil {
+			err = ctx.GetStub().PutState(queryResponse.Key, migrated)
+			if err != nil {
+				return nil, err
+			}
+			result.Migrated++
+			record = migrated
+		}
+
+		opened, err := c.openJournal(ctx, record)
+		if err != nil {
+			return nil, err
+		}
+		if opened {
+			result.JournalsOpened++
+		}
+	}
+
+	return result, nil
+}
+
+// openJournal posts the opening balance of a wallet record that has no journal yet
+func (c *WalletContract) openJournal(ctx contractapi.TransactionContextInterface, recordJSON []byte) (bool, error) {
+	var wallet Wallet
+	if err := json.Unmarshal(recordJSON, &wallet); err != nil || wallet.DocType != "wallet" || wallet.BalanceMinor == 0 {
+		return false, nil
+	}
+
+	posted, err := hasPostings(ctx, wallet.WalletID)
+	if err != nil || posted {
+		return false, err
+	}
+
+	err = c.postEntry(ctx, "opening", "opening_"+wallet.WalletID, wallet.Currency,
+		walletPosting(&wallet, wallet.BalanceMinor),
+		systemPosting(AccountFunding, wallet.Currency, -wallet.BalanceMinor),
+	)
+	return err == nil, err
+}
+
+// migrateRecord returns the migrated form of a legacy record, or nil if the record
+// needs no migration
+func migrateRecord(recordJSON []byte) ([]byte, error) {
+	var doc struct {
+		DocType string `json:"docType"`
+	}
+	if err := json.Unmarshal(recordJSON, &doc); err != nil {
+		return nil, err
+	}
+
+	switch doc.DocType {
+	case "wallet":
+		var wallet legacyWallet
+		if err := json.Unmarshal(recordJSON, &wallet); err != nil || wallet.Balance == nil {
+			return nil, err
+		}
+		if wallet.Currency == "" {
+			wallet.Currency = defaultCurrency
+		}
+		wallet.BalanceMinor = toMinor(*wallet.Balance, wallet.Currency)
+		return json.Marshal(wallet.Wallet)
+
+	case "payment":
+		var payment legacyPayment
+		if err := json.Unmarshal(recordJSON, &payment); err != nil || payment.Amount == nil {
+			return nil, err
+		}
+		if payment.Currency == "" {
+			payment.Currency = defaultCurrency
+		}
+		payment.AmountMinor = toMinor(*payment.Amount, payment.Currency)
+		payment.Payment.LineItems = nil
+		for _, item := range payment.LineItems {
+			item.AmountMinor = toMinor(item.Amount, payment.Currency)
+			payment.Payment.LineItems = append(payment.Payment.LineItems, item.LineItem)
+		}
+		return json.Marshal(payment.Payment)
+
+	case "transaction":
+		var transaction legacyTransaction
+		if err := json.Unmarshal(recordJSON, &transaction); err != nil || transaction.Amount == nil {
+			return nil, err
+		}
+		if transaction.Currency == "" {
+			transaction.Currency = defaultCurrency
+		}
+		transaction.AmountMinor = toMinor(*transaction.Amount, transaction.Currency)
+		transaction.BalanceBeforeMinor = toMinor(transaction.BalanceBefore, transaction.Currency)
+		transaction.BalanceAfterMinor = toMinor(transaction.BalanceAfter, transaction.Currency)
+		return json.Marshal(transaction.Transaction)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,123 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// defaultCurrency is the currency of wallets created before currencies were explicit
+const defaultCurrency = "USD"
+
+// currencyExponents lists the supported ISO 4217 currencies and their number of
+// minor unit digits
+var currencyExponents = map[string]int{
+	"AED": 2,
+	"AUD": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"INR": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"MAD": 2,
+	"SAR": 2,
+	"SEK": 2,
+	"USD": 2,
+}
+
+// currencyExponent returns the number of minor unit digits of an ISO 4217 currency
+func currencyExponent(currency string) (int, error) {
+	exponent, ok := currencyExponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("unsupported currency %q", currency)
+	}
+	return exponent, nil
+}
+
+// parseAmount converts a decimal string such as "12.50" to minor units of currency.
+// Amounts with more decimals than the currency has are rejected rather than rounded.
+func parseAmount(amount, currency string) (int64, error) {
+	exponent, err := currencyExponent(currency)
+	if err != nil {
+		return 0, err
+	}
+
+	s := strings.TrimSpace(amount)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return 0, fmt.Errorf("invalid amount %q", amount)
+	}
+	// Trailing zeros beyond the currency's precision carry no value
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > exponent {
+		return 0, fmt.Errorf("amount %q has more than %d decimals for %s", amount, exponent, currency)
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	digits := whole + fraction
+	if digits == "" {
+		digits = "0"
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("invalid amount %q", amount)
+		}
+	}
+
+	minor, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %v", amount, err)
+	}
+	if negative {
+		minor = -minor
+	}
+	return minor, nil
+}
+
+// formatAmount converts minor units of currency to a decimal string
+func formatAmount(minor int64, currency string) string {
+	exponent, err := currencyExponent(currency)
+	if err != nil || exponent == 0 {
+		return strconv.FormatInt(minor, 10)
+	}
+
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	unit := int64(math.Pow10(exponent))
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/unit, exponent, minor%unit)
+}
+
+// toMinor converts a legacy floating point amount to minor units, rounding half away from zero
+func toMinor(amount float64, currency string) int64 {
+	exponent, err := currencyExponent(currency)
+	if err != nil {
+		exponent = 2
+	}
+	return int64(math.Round(amount * math.Pow10(exponent)))
+}
+
+// checkMigrated rejects records still holding floating point amounts
+func checkMigrated(recordJSON []byte, id string) error {
+	var legacy map[string]json.RawMessage
+	if err := json.Unmarshal(recordJSON, &legacy); err != nil {
+		return err
+	}
+	for _, field := range []string{"balance", "amount"} {
+		if _, ok := legacy[field]; ok {
+			return fmt.Errorf("record %s holds legacy amounts; run MigrateAmounts first", id)
+		}
+	}
+	return nil
+}
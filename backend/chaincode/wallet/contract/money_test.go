@@ -0,0 +1,80 @@
+package contract
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   string
+		currency string
+		want     int64
+		wantErr  bool
+	}{
+		{name: "whole", amount: "12", currency: "USD", want: 1200},
+		{name: "cents", amount: "12.50", currency: "USD", want: 1250},
+		{name: "one decimal", amount: "12.5", currency: "USD", want: 1250},
+		{name: "trailing zeros", amount: "12.5000", currency: "USD", want: 1250},
+		{name: "leading dot", amount: ".25", currency: "USD", want: 25},
+		{name: "trailing dot", amount: "7.", currency: "USD", want: 700},
+		{name: "spaces", amount: " 3.10 ", currency: "USD", want: 310},
+		{name: "plus sign", amount: "+1.01", currency: "USD", want: 101},
+		{name: "negative", amount: "-4.20", currency: "USD", want: -420},
+		{name: "zero", amount: "0", currency: "USD", want: 0},
+		{name: "no minor units", amount: "1500", currency: "JPY", want: 1500},
+		{name: "three decimals", amount: "1.234", currency: "KWD", want: 1234},
+		{name: "large", amount: "92233720368547758.07", currency: "USD", want: 9223372036854775807},
+		{name: "too many decimals", amount: "1.005", currency: "USD", wantErr: true},
+		{name: "decimals on zero-exponent currency", amount: "1.5", currency: "JPY", wantErr: true},
+		{name: "empty", amount: "", currency: "USD", wantErr: true},
+		{name: "dot only", amount: ".", currency: "USD", wantErr: true},
+		{name: "letters", amount: "1O.00", currency: "USD", wantErr: true},
+		{name: "exponent notation", amount: "1e3", currency: "USD", wantErr: true},
+		{name: "double sign", amount: "--1", currency: "USD", wantErr: true},
+		{name: "overflow", amount: "92233720368547758.08", currency: "USD", wantErr: true},
+		{name: "unsupported currency", amount: "1.00", currency: "XYZ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAmount(tt.amount, tt.currency)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseAmount(%q, %s) = %d, want error", tt.amount, tt.currency, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAmount(%q, %s) failed: %v", tt.amount, tt.currency, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseAmount(%q, %s) = %d, want %d", tt.amount, tt.currency, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToMinor(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   float64
+		currency string
+		want     int64
+	}{
+		{name: "cents", amount: 12.5, currency: "USD", want: 1250},
+		{name: "binary fraction", amount: 0.1 + 0.2, currency: "USD", want: 30},
+		{name: "half rounds up", amount: 0.125, currency: "USD", want: 13},
+		{name: "negative half rounds away from zero", amount: -0.125, currency: "USD", want: -13},
+		{name: "below half rounds down", amount: 19.994, currency: "USD", want: 1999},
+		{name: "no minor units", amount: 1499.6, currency: "JPY", want: 1500},
+		{name: "three decimals", amount: 1.2345, currency: "KWD", want: 1235},
+		{name: "unsupported currency uses cents", amount: 3.21, currency: "XYZ", want: 321},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toMinor(tt.amount, tt.currency); got != tt.want {
+				t.Errorf("toMinor(%v, %s) = %d, want %d", tt.amount, tt.currency, got, tt.want)
+			}
+		})
+	}
+}
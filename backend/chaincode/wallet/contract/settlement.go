@@ -0,0 +1,504 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// AccountPayout accumulates the revenue paid out to operators, leaving the system
+const AccountPayout = "payouts"
+
+const (
+	// unsettledIndex lists the payments credited to an operator account since its last settlement
+	unsettledIndex = "unsettled~accountId~entryId"
+	// unrecoveredIndex lists the refunds of an operator's payments not yet recovered from it
+	unrecoveredIndex = "unrecovered~accountId~refundId"
+	// statementIndex keys the settlement statements of an operator account by period end
+	statementIndex = "accountId~periodEnd~statementId"
+)
+
+// CommissionRate is the platform's share of operator payments, in basis points
+type CommissionRate struct {
+	DocType     string `json:"docType"`
+	OperatorID  string `json:"operatorId,omitempty" metadata:",optional"` // empty for the default rate
+	BasisPoints int    `json:"basisPoints"`
+	UpdatedAt   string `json:"updatedAt"`
+}
+
+// SettlementStatement reports what an operator earned in a period and what was paid out
+type SettlementStatement struct {
+	DocType               string   `json:"docType"`
+	StatementID           string   `json:"statementId"`
+	OperatorID            string   `json:"operatorId"`
+	Currency              string   `json:"currency"`
+	PeriodStart           string   `json:"periodStart"`
+	PeriodEnd             string   `json:"periodEnd"`
+	OpeningBalanceMinor   int64    `json:"openingBalanceMinor"` // carried over from the previous period
+	GrossMinor            int64    `json:"grossMinor"`          // paid by users
+	CommissionMinor       int64    `json:"commissionMinor"`     // kept by the platform
+	RefundsMinor          int64    `json:"refundsMinor"`        // refunded to users
+	RefundCommissionMinor int64    `json:"refundCommissionMinor"`
+	NetMinor              int64    `json:"netMinor"` // gross - commission - refunds + refund commission
+	PayoutMinor           int64    `json:"payoutMinor"`
+	ClosingBalanceMinor   int64    `json:"closingBalanceMinor"` // carried over when refunds exceed revenue
+	PaymentIDs            []string `json:"paymentIds"`
+	RefundIDs             []string `json:"refundIds"`
+	CreatedAt             string   `json:"createdAt"`
+}
+
+// OperatorRevenue is an operator's revenue in one currency
+type OperatorRevenue struct {
+	OperatorID             string `json:"operatorId"`
+	Currency               string `json:"currency"`
+	PendingMinor           int64  `json:"pendingMinor"` // what the next settlement would pay out
+	PendingGrossMinor      int64  `json:"pendingGrossMinor"`
+	PendingCommissionMinor int64  `json:"pendingCommissionMinor"`
+	PendingRefundsMinor    int64  `json:"pendingRefundsMinor"` // net of the refunded commission
+	UnsettledPayments      int    `json:"unsettledPayments"`
+	UnrecoveredRefunds     int    `json:"unrecoveredRefunds"`
+	BalanceMinor           int64  `json:"balanceMinor"` // of the operator's revenue account
+	SettledMinor           int64  `json:"settledMinor"` // paid out so far
+	Statements             int    `json:"statements"`
+	LastPeriodEnd          string `json:"lastPeriodEnd,omitempty" metadata:",optional"`
+}
+
+// ==================== Commission ====================
+
+// SetCommission sets the platform commission in basis points (1/100 of a percent).
+// An empty operatorId sets the default rate; otherwise the rate overrides it for
+// that operator's future payments.
+func (c *WalletContract) SetCommission(ctx contractapi.TransactionContextInterface, operatorId string, basisPoints int) error {
+	if basisPoints < 0 || basisPoints > 10000 {
+		return fmt.Errorf("commission must be between 0 and 10000 basis points")
+	}
+
+	rate := CommissionRate{
+		DocType:     "commission",
+		OperatorID:  operatorId,
+		BasisPoints: basisPoints,
+		UpdatedAt:   time.Now().Format(time.RFC3339),
+	}
+	rateJSON, err := json.Marshal(rate)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(commissionKey(operatorId), rateJSON)
+}
+
+// GetCommission returns the commission rate applied to an operator's payments
+func (c *WalletContract) GetCommission(ctx contractapi.TransactionContextInterface, operatorId string) (*CommissionRate, error) {
+	for _, key := range []string{commissionKey(operatorId), commissionKey("")} {
+		rateJSON, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commission: %v", err)
+		}
+		if rateJSON == nil {
+			continue
+		}
+
+		var rate CommissionRate
+		err = json.Unmarshal(rateJSON, &rate)
+		if err != nil {
+			return nil, err
+		}
+		return &rate, nil
+	}
+
+	return &CommissionRate{DocType: "commission", BasisPoints: 0}, nil
+}
+
+// ==================== Settlement ====================
+
+// SettleOperator closes an operator's revenue period ending at periodEnd (RFC3339). It
+// recovers the refunds of the operator's payments from its revenue, pays the remaining
+// balance out and records a statement. Payments and refunds made after periodEnd stay
+// pending for the next period. Statement IDs are chosen by the caller, so a settlement
+// run can be retried safely.
+func (c *WalletContract) SettleOperator(ctx contractapi.TransactionContextInterface, statementId, operatorId, currency, periodEnd string) (*SettlementStatement, error) {
+	existing, err := ctx.GetStub().GetState(statementId)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("statement %s already exists", statementId)
+	}
+
+	end, err := time.Parse(time.RFC3339, periodEnd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid period end: %v", err)
+	}
+	periodEnd = end.UTC().Format(time.RFC3339)
+
+	accountId := operatorAccountId(operatorId, currency)
+	account, err := c.GetAccount(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &SettlementStatement{
+		DocType:     "settlementStatement",
+		StatementID: statementId,
+		OperatorID:  operatorId,
+		Currency:    currency,
+		PeriodStart: account.CreatedAt,
+		PeriodEnd:   periodEnd,
+		PaymentIDs:  []string{},
+		RefundIDs:   []string{},
+		CreatedAt:   time.Now().Format(time.RFC3339),
+	}
+
+	previous, err := c.lastStatement(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		if periodEnd <= previous.PeriodEnd {
+			return nil, fmt.Errorf("period ending %s is already settled", periodEnd)
+		}
+		statement.PeriodStart = previous.PeriodEnd
+	}
+
+	// Everything pending, to tell the balance carried over from the revenue held for
+	// unsettled payments, including those after the period
+	unsettled, err := c.pendingRevenue(ctx, accountId, time.Time{}, false)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := c.pendingRevenue(ctx, accountId, end, true)
+	if err != nil {
+		return nil, err
+	}
+	statement.GrossMinor = pending.gross
+	statement.CommissionMinor = pending.commission
+	statement.RefundsMinor = pending.refunds
+	statement.RefundCommissionMinor = pending.refundCommission
+	statement.PaymentIDs = append(statement.PaymentIDs, pending.paymentIds...)
+	statement.RefundIDs = append(statement.RefundIDs, pending.refundIds...)
+	statement.NetMinor = pending.gross - pending.commission - pending.refunds + pending.refundCommission
+
+	// The operator account holds the net revenue of unsettled payments on top of the
+	// balance carried over; refunds are still held in the clearing account
+	statement.OpeningBalanceMinor = account.BalanceMinor - (unsettled.gross - unsettled.commission)
+	balance := statement.OpeningBalanceMinor + statement.NetMinor
+	if balance > 0 {
+		statement.PayoutMinor = balance
+	}
+	statement.ClosingBalanceMinor = balance - statement.PayoutMinor
+
+	// One entry per settlement: an account can only be posted to once per transaction
+	postings := []Posting{
+		operatorPosting(operatorId, currency, -(pending.refunds-pending.refundCommission)-statement.PayoutMinor),
+	}
+	if pending.refundCommission != 0 {
+		postings = append(postings, systemPosting(AccountPlatform, currency, -pending.refundCommission))
+	}
+	if pending.refunds != 0 {
+		postings = append(postings, systemPosting(AccountRefundClearing, currency, pending.refunds))
+	}
+	if statement.PayoutMinor != 0 {
+		postings = append(postings, systemPosting(AccountPayout, currency, statement.PayoutMinor))
+	}
+	if len(postings) > 1 {
+		err = c.postEntry(ctx, "settlement", statementId, currency, postings...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statementJSON, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+	indexKey, err := ctx.GetStub().CreateCompositeKey(statementIndex, []string{accountId, periodEnd, statementId})
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return nil, err
+	}
+
+	return statement, ctx.GetStub().PutState(statementId, statementJSON)
+}
+
+// GetSettlementStatement retrieves a settlement statement by ID
+func (c *WalletContract) GetSettlementStatement(ctx contractapi.TransactionContextInterface, statementId string) (*SettlementStatement, error) {
+	statementJSON, err := ctx.GetStub().GetState(statementId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read statement: %v", err)
+	}
+	if statementJSON == nil {
+		return nil, fmt.Errorf("statement %s does not exist", statementId)
+	}
+
+	var statement SettlementStatement
+	err = json.Unmarshal(statementJSON, &statement)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statement, nil
+}
+
+// GetOperatorStatements returns an operator's settlement statements in all currencies, oldest first
+func (c *WalletContract) GetOperatorStatements(ctx contractapi.TransactionContextInterface, operatorId string) ([]*SettlementStatement, error) {
+	accounts, err := c.operatorAccounts(ctx, operatorId)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []*SettlementStatement{}
+	for _, account := range accounts {
+		accountStatements, err := c.accountStatements(ctx, account.AccountID)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, accountStatements...)
+	}
+
+	return statements, nil
+}
+
+// GetOperatorRevenue returns an operator's pending and settled revenue in each currency
+func (c *WalletContract) GetOperatorRevenue(ctx contractapi.TransactionContextInterface, operatorId string) ([]*OperatorRevenue, error) {
+	accounts, err := c.operatorAccounts(ctx, operatorId)
+	if err != nil {
+		return nil, err
+	}
+
+	revenues := []*OperatorRevenue{}
+	for _, account := range accounts {
+		pending, err := c.pendingRevenue(ctx, account.AccountID, time.Time{}, false)
+		if err != nil {
+			return nil, err
+		}
+		statements, err := c.accountStatements(ctx, account.AccountID)
+		if err != nil {
+			return nil, err
+		}
+
+		revenue := &OperatorRevenue{
+			OperatorID:             operatorId,
+			Currency:               account.Currency,
+			PendingGrossMinor:      pending.gross,
+			PendingCommissionMinor: pending.commission,
+			PendingRefundsMinor:    pending.refunds - pending.refundCommission,
+			UnsettledPayments:      len(pending.paymentIds),
+			UnrecoveredRefunds:     len(pending.refundIds),
+			BalanceMinor:           account.BalanceMinor,
+			Statements:             len(statements),
+		}
+		if balance := account.BalanceMinor - revenue.PendingRefundsMinor; balance > 0 {
+			revenue.PendingMinor = balance
+		}
+		for _, statement := range statements {
+			revenue.SettledMinor += statement.PayoutMinor
+			revenue.LastPeriodEnd = statement.PeriodEnd
+		}
+		revenues = append(revenues, revenue)
+	}
+
+	return revenues, nil
+}
+
+// pendingRevenueTotals sums the payments and refunds of an operator account since its last settlement
+type pendingRevenueTotals struct {
+	gross, commission         int64
+	refunds, refundCommission int64
+	paymentIds, refundIds     []string
+}
+
+// pendingRevenue sums the unsettled payments and unrecovered refunds of an operator
+// account made until a time, or all of them if until is zero, removing them from the
+// pending indexes when settle is set
+func (c *WalletContract) pendingRevenue(ctx contractapi.TransactionContextInterface, accountId string, until time.Time, settle bool) (*pendingRevenueTotals, error) {
+	totals := &pendingRevenueTotals{}
+
+	err := c.scanIndex(ctx, unsettledIndex, accountId, settle, func(entryId string) (bool, error) {
+		entry, err := c.GetJournalEntry(ctx, entryId)
+		if err != nil {
+			return false, err
+		}
+		if included, err := madeUntil(entry.CreatedAt, until); !included || err != nil {
+			return false, err
+		}
+		for _, posting := range entry.Postings {
+			switch posting.AccountType {
+			case AccountWallet:
+				totals.gross -= posting.AmountMinor
+			case AccountPlatform:
+				totals.commission += posting.AmountMinor
+			}
+		}
+		totals.paymentIds = append(totals.paymentIds, entry.ReferenceID)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.scanIndex(ctx, unrecoveredIndex, accountId, settle, func(refundId string) (bool, error) {
+		refund, err := c.GetPayment(ctx, refundId)
+		if err != nil {
+			return false, err
+		}
+		if included, err := madeUntil(refund.CreatedAt, until); !included || err != nil {
+			return false, err
+		}
+		totals.refunds += refund.AmountMinor
+		totals.refundCommission += refund.CommissionMinor
+		totals.refundIds = append(totals.refundIds, refundId)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+// madeUntil reports whether a payment or journal entry created at createdAt (RFC3339)
+// was made by until, or until is zero
+func madeUntil(createdAt string, until time.Time) (bool, error) {
+	if until.IsZero() {
+		return true, nil
+	}
+	created, err := time.Parse(time.RFC3339, createdAt)
+	if err != nil {
+		return false, fmt.Errorf("invalid creation time %q: %v", createdAt, err)
+	}
+	return !created.After(until), nil
+}
+
+// scanIndex calls fn with the last key attribute of each entry of an index for
+// accountId. When remove is set, the entries fn reports as taken are deleted.
+func (c *WalletContract) scanIndex(ctx contractapi.TransactionContextInterface, index, accountId string, remove bool, fn func(id string) (bool, error)) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{accountId})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+		if len(compositeKeyParts) < 2 {
+			continue
+		}
+
+		taken, err := fn(compositeKeyParts[1])
+		if err != nil {
+			return err
+		}
+		if remove && taken {
+			err = ctx.GetStub().DelState(queryResponse.Key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// markPending adds an ID to a pending index of an operator account
+func markPending(ctx contractapi.TransactionContextInterface, index, accountId, id string) error {
+	indexKey, err := ctx.GetStub().CreateCompositeKey(index, []string{accountId, id})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(indexKey, []byte{0x00})
+}
+
+// operatorAccounts returns an operator's revenue accounts
+func (c *WalletContract) operatorAccounts(ctx contractapi.TransactionContextInterface, operatorId string) ([]*Account, error) {
+	accounts, err := c.GetAccounts(ctx, AccountOperator)
+	if err != nil {
+		return nil, err
+	}
+
+	operatorAccounts := []*Account{}
+	for _, account := range accounts {
+		if account.OwnerID == operatorId {
+			operatorAccounts = append(operatorAccounts, account)
+		}
+	}
+	return operatorAccounts, nil
+}
+
+// accountStatements returns the settlement statements of an operator account, oldest first
+func (c *WalletContract) accountStatements(ctx contractapi.TransactionContextInterface, accountId string) ([]*SettlementStatement, error) {
+	statements := []*SettlementStatement{}
+	err := c.scanStatements(ctx, accountId, func(statement *SettlementStatement) {
+		statements = append(statements, statement)
+	})
+	return statements, err
+}
+
+// lastStatement returns the latest settlement statement of an operator account, if any
+func (c *WalletContract) lastStatement(ctx contractapi.TransactionContextInterface, accountId string) (*SettlementStatement, error) {
+	var last *SettlementStatement
+	err := c.scanStatements(ctx, accountId, func(statement *SettlementStatement) {
+		last = statement
+	})
+	return last, err
+}
+
+// scanStatements calls fn with each settlement statement of an operator account in period order
+func (c *WalletContract) scanStatements(ctx contractapi.TransactionContextInterface, accountId string, fn func(*SettlementStatement)) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(statementIndex, []string{accountId})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return err
+		}
+		if len(compositeKeyParts) < 3 {
+			continue
+		}
+
+		statement, err := c.GetSettlementStatement(ctx, compositeKeyParts[2])
+		if err != nil {
+			return err
+		}
+		fn(statement)
+	}
+
+	return nil
+}
+
+// commission returns the platform's share of a payment to an operator, rounded half up
+func (c *WalletContract) commission(ctx contractapi.TransactionContextInterface, operatorId string, amountMinor int64) (int64, error) {
+	rate, err := c.GetCommission(ctx, operatorId)
+	if err != nil {
+		return 0, err
+	}
+	return (amountMinor*int64(rate.BasisPoints) + 5000) / 10000, nil
+}
+
+// commissionKey returns the ledger key of an operator's commission rate, or of the default
+func commissionKey(operatorId string) string {
+	if operatorId == "" {
+		return "commission_default"
+	}
+	return "commission_" + operatorId
+}
@@ -0,0 +1,287 @@
+package contract
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// settlementFixture drives an operator's payments and settlements in a test
+type settlementFixture struct {
+	t     *testing.T
+	c     *WalletContract
+	ctx   *contractapi.TransactionContext
+	stub  *shimtest.MockStub
+	start time.Time // end of the first settlement period
+}
+
+func (f *settlementFixture) pay(paymentId, amount string) {
+	f.t.Helper()
+	_, err := f.c.ProcessPayment(f.ctx, paymentId, "wallet1", amount, "USD", "operator1", "parking", "booking_"+paymentId, "")
+	if err != nil {
+		f.t.Fatalf("payment %s failed: %v", paymentId, err)
+	}
+}
+
+func (f *settlementFixture) refund(paymentId, amount, refundId string) {
+	f.t.Helper()
+	_, err := f.c.RefundPayment(f.ctx, paymentId, amount, refundId, "", RoleSystem)
+	if err != nil {
+		f.t.Fatalf("refund %s failed: %v", refundId, err)
+	}
+}
+
+// settle settles the period ending period hours after the first one ends
+func (f *settlementFixture) settle(period int) *SettlementStatement {
+	f.t.Helper()
+	periodEnd := f.periodEnd(period)
+	statement, err := f.c.SettleOperator(f.ctx, "statement_"+periodEnd, "operator1", "USD", periodEnd)
+	if err != nil {
+		f.t.Fatalf("settlement of period ending %s failed: %v", periodEnd, err)
+	}
+	return statement
+}
+
+func (f *settlementFixture) periodEnd(period int) string {
+	return f.start.Add(time.Duration(period) * time.Hour).UTC().Format(time.RFC3339)
+}
+
+// after places a payment or refund a minute after the end of a period
+func (f *settlementFixture) after(key string, period int) {
+	f.t.Helper()
+	setCreatedAt(f.t, f.stub, key, f.start.Add(time.Duration(period)*time.Hour+time.Minute).Format(time.RFC3339))
+}
+
+func newSettlementFixture(t *testing.T) *settlementFixture {
+	t.Helper()
+
+	ctx, stub := newTestContext(t)
+	f := &settlementFixture{
+		t:     t,
+		c:     &WalletContract{},
+		ctx:   ctx,
+		stub:  stub,
+		start: time.Now().UTC().Truncate(time.Hour).Add(time.Hour),
+	}
+
+	// 10% commission
+	if err := f.c.SetCommission(ctx, "", 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.c.CreateWallet(ctx, "wallet1", "user1", "100.00", "USD"); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestSettleOperator(t *testing.T) {
+	tests := []struct {
+		name string
+		// run makes payments and earlier settlements, and returns the statement checked
+		run  func(f *settlementFixture) *SettlementStatement
+		want SettlementStatement
+		// what stays pending after the statement
+		wantUnsettled, wantUnrecovered int
+		wantPendingMinor               int64
+	}{
+		{
+			name: "payments less commission are paid out",
+			run: func(f *settlementFixture) *SettlementStatement {
+				f.pay("pay1", "20.00")
+				f.pay("pay2", "10.00")
+				return f.settle(0)
+			},
+			want: SettlementStatement{
+				GrossMinor:      3000,
+				CommissionMinor: 300,
+				NetMinor:        2700,
+				PayoutMinor:     2700,
+				PaymentIDs:      []string{"pay1", "pay2"},
+				RefundIDs:       []string{},
+			},
+		},
+		{
+			name: "partial refund is recovered less its commission",
+			run: func(f *settlementFixture) *SettlementStatement {
+				f.pay("pay1", "20.00")
+				f.refund("pay1", "5.00", "refund1")
+				return f.settle(0)
+			},
+			want: SettlementStatement{
+				GrossMinor:            2000,
+				CommissionMinor:       200,
+				RefundsMinor:          500,
+				RefundCommissionMinor: 50,
+				NetMinor:              1350,
+				PayoutMinor:           1350,
+				PaymentIDs:            []string{"pay1"},
+				RefundIDs:             []string{"refund1"},
+			},
+		},
+		{
+			name: "refund of a settled payment carries a negative balance",
+			run: func(f *settlementFixture) *SettlementStatement {
+				f.pay("pay1", "20.00")
+				f.settle(0)
+				f.refund("pay1", "20.00", "refund1")
+				return f.settle(1)
+			},
+			want: SettlementStatement{
+				RefundsMinor:          2000,
+				RefundCommissionMinor: 200,
+				NetMinor:              -1800,
+				ClosingBalanceMinor:   -1800,
+				PaymentIDs:            []string{},
+				RefundIDs:             []string{"refund1"},
+			},
+		},
+		{
+			name: "negative balance is recovered from the next period",
+			run: func(f *settlementFixture) *SettlementStatement {
+				f.pay("pay1", "20.00")
+				f.settle(0)
+				f.refund("pay1", "20.00", "refund1")
+				f.settle(1)
+				f.pay("pay2", "30.00")
+				return f.settle(2)
+			},
+			want: SettlementStatement{
+				OpeningBalanceMinor: -1800,
+				GrossMinor:          3000,
+				CommissionMinor:     300,
+				NetMinor:            2700,
+				PayoutMinor:         900,
+				PaymentIDs:          []string{"pay2"},
+				RefundIDs:           []string{},
+			},
+		},
+		{
+			name: "payments and refunds after the period end stay pending",
+			run: func(f *settlementFixture) *SettlementStatement {
+				f.pay("pay1", "20.00")
+				f.pay("pay2", "10.00")
+				f.after("journal_pay2", 0)
+				f.refund("pay1", "5.00", "refund1")
+				f.after("refund1", 0)
+				return f.settle(0)
+			},
+			want: SettlementStatement{
+				GrossMinor:      2000,
+				CommissionMinor: 200,
+				NetMinor:        1800,
+				PayoutMinor:     1800,
+				PaymentIDs:      []string{"pay1"},
+				RefundIDs:       []string{},
+			},
+			wantUnsettled:    1,
+			wantUnrecovered:  1,
+			wantPendingMinor: 900 - 450,
+		},
+		{
+			name: "late items are settled with the next period",
+			run: func(f *settlementFixture) *SettlementStatement {
+				f.pay("pay1", "20.00")
+				f.pay("pay2", "10.00")
+				f.after("journal_pay2", 0)
+				f.refund("pay1", "5.00", "refund1")
+				f.after("refund1", 0)
+				f.settle(0)
+				return f.settle(1)
+			},
+			want: SettlementStatement{
+				GrossMinor:            1000,
+				CommissionMinor:       100,
+				RefundsMinor:          500,
+				RefundCommissionMinor: 50,
+				NetMinor:              450,
+				PayoutMinor:           450,
+				PaymentIDs:            []string{"pay2"},
+				RefundIDs:             []string{"refund1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newSettlementFixture(t)
+			got := tt.run(f)
+
+			checks := []struct {
+				field     string
+				got, want int64
+			}{
+				{"opening balance", got.OpeningBalanceMinor, tt.want.OpeningBalanceMinor},
+				{"gross", got.GrossMinor, tt.want.GrossMinor},
+				{"commission", got.CommissionMinor, tt.want.CommissionMinor},
+				{"refunds", got.RefundsMinor, tt.want.RefundsMinor},
+				{"refund commission", got.RefundCommissionMinor, tt.want.RefundCommissionMinor},
+				{"net", got.NetMinor, tt.want.NetMinor},
+				{"payout", got.PayoutMinor, tt.want.PayoutMinor},
+				{"closing balance", got.ClosingBalanceMinor, tt.want.ClosingBalanceMinor},
+			}
+			for _, check := range checks {
+				if check.got != check.want {
+					t.Errorf("%s = %d, want %d", check.field, check.got, check.want)
+				}
+			}
+			if !equalIds(got.PaymentIDs, tt.want.PaymentIDs) {
+				t.Errorf("payments = %v, want %v", got.PaymentIDs, tt.want.PaymentIDs)
+			}
+			if !equalIds(got.RefundIDs, tt.want.RefundIDs) {
+				t.Errorf("refunds = %v, want %v", got.RefundIDs, tt.want.RefundIDs)
+			}
+
+			revenues, err := f.c.GetOperatorRevenue(f.ctx, "operator1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(revenues) != 1 {
+				t.Fatalf("got %d revenue accounts, want 1", len(revenues))
+			}
+			revenue := revenues[0]
+			if revenue.UnsettledPayments != tt.wantUnsettled || revenue.UnrecoveredRefunds != tt.wantUnrecovered {
+				t.Errorf("pending = %d payments, %d refunds, want %d, %d",
+					revenue.UnsettledPayments, revenue.UnrecoveredRefunds, tt.wantUnsettled, tt.wantUnrecovered)
+			}
+			if revenue.PendingMinor != tt.wantPendingMinor {
+				t.Errorf("pending revenue = %d, want %d", revenue.PendingMinor, tt.wantPendingMinor)
+			}
+
+			report, err := f.c.Reconcile(f.ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.Balanced {
+				t.Errorf("ledger does not balance: %+v", report)
+			}
+		})
+	}
+}
+
+func TestSettleOperatorRejectsSettledPeriods(t *testing.T) {
+	f := newSettlementFixture(t)
+	f.pay("pay1", "20.00")
+	f.settle(1)
+
+	for _, period := range []int{0, 1} {
+		periodEnd := f.periodEnd(period)
+		_, err := f.c.SettleOperator(f.ctx, "statement_again_"+periodEnd, "operator1", "USD", periodEnd)
+		if err == nil {
+			t.Errorf("period ending %s settled twice", periodEnd)
+		}
+	}
+}
+
+func equalIds(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package contract
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// newTestContext returns a transaction context backed by an in-memory world state
+func newTestContext(t *testing.T) (*contractapi.TransactionContext, *shimtest.MockStub) {
+	t.Helper()
+
+	stub := shimtest.NewMockStub("wallet", nil)
+	stub.MockTransactionStart("test")
+
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	return ctx, stub
+}
+
+// setCreatedAt rewrites the creation time of a record, to place it in time
+func setCreatedAt(t *testing.T, stub *shimtest.MockStub, key, createdAt string) {
+	t.Helper()
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(stub.State[key], &record); err != nil {
+		t.Fatalf("failed to read %s: %v", key, err)
+	}
+	record["createdAt"] = createdAt
+
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stub.PutState(key, recordJSON); err != nil {
+		t.Fatal(err)
+	}
+}
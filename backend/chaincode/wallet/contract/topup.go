@@ -0,0 +1,254 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Top-up statuses. A top-up is pending until the payment provider confirms or fails
+// it; a completed top-up can still be charged back.
+const (
+	TopUpPending     = "pending"
+	TopUpCompleted   = "completed"
+	TopUpFailed      = "failed"
+	TopUpChargedBack = "charged_back"
+)
+
+// topUpIndex lists the top-ups of each user
+const topUpIndex = "userId~topUpId"
+
+// TopUp is money a user pays in through an external payment provider
+type TopUp struct {
+	DocType       string `json:"docType"`
+	TopUpID       string `json:"topUpId"`
+	WalletID      string `json:"walletId"`
+	UserID        string `json:"userId"`
+	AmountMinor   int64  `json:"amountMinor"` // minor units of Currency
+	Currency      string `json:"currency"`
+	Provider      string `json:"provider"`
+	ProviderRef   string `json:"providerRef"` // the provider's payment intent ID
+	Status        string `json:"status"`
+	TransactionID string `json:"transactionId,omitempty" metadata:",optional"` // wallet credit, once completed
+	FailureReason string `json:"failureReason,omitempty" metadata:",optional"`
+	CreatedAt     string `json:"createdAt"`
+	UpdatedAt     string `json:"updatedAt"`
+	CompletedAt   string `json:"completedAt,omitempty" metadata:",optional"`
+}
+
+// ==================== Top-ups ====================
+
+// CreateTopUp records a pending top-up of a wallet. amount is a decimal string in the
+// wallet's currency. The wallet is only credited once ConfirmTopUp is called.
+func (c *WalletContract) CreateTopUp(ctx contractapi.TransactionContextInterface, topUpId, walletId, amount, provider, providerRef string) (*TopUp, error) {
+	existing, err := ctx.GetStub().GetState(topUpId)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("top-up %s already exists", topUpId)
+	}
+
+	wallet, err := c.GetWallet(ctx, walletId)
+	if err != nil {
+		return nil, err
+	}
+
+	amountMinor, err := parseAmount(amount, wallet.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if amountMinor <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	topUp := &TopUp{
+		DocType:     "topUp",
+		TopUpID:     topUpId,
+		WalletID:    walletId,
+		UserID:      wallet.UserID,
+		AmountMinor: amountMinor,
+		Currency:    wallet.Currency,
+		Provider:    provider,
+		ProviderRef: providerRef,
+		Status:      TopUpPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	indexKey, err := ctx.GetStub().CreateCompositeKey(topUpIndex, []string{wallet.UserID, topUpId})
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return nil, err
+	}
+
+	return topUp, c.putTopUp(ctx, topUp)
+}
+
+// ConfirmTopUp completes a pending top-up and credits the wallet with AddFunds.
+// Confirming a completed top-up again does nothing, as providers may repeat webhooks.
+func (c *WalletContract) ConfirmTopUp(ctx contractapi.TransactionContextInterface, topUpId string) (*TopUp, error) {
+	topUp, err := c.GetTopUp(ctx, topUpId)
+	if err != nil {
+		return nil, err
+	}
+	switch topUp.Status {
+	case TopUpCompleted, TopUpChargedBack:
+		return topUp, nil
+	case TopUpFailed:
+		return nil, fmt.Errorf("top-up %s has failed", topUpId)
+	}
+
+	topUp.TransactionID = "credit_" + topUpId
+	err = c.AddFunds(ctx, topUp.WalletID, formatAmount(topUp.AmountMinor, topUp.Currency), topUp.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	topUp.Status = TopUpCompleted
+	topUp.UpdatedAt = now
+	topUp.CompletedAt = now
+
+	return topUp, c.putTopUp(ctx, topUp)
+}
+
+// FailTopUp marks a pending top-up as failed; the wallet is never credited
+func (c *WalletContract) FailTopUp(ctx contractapi.TransactionContextInterface, topUpId, reason string) (*TopUp, error) {
+	topUp, err := c.GetTopUp(ctx, topUpId)
+	if err != nil {
+		return nil, err
+	}
+	switch topUp.Status {
+	case TopUpFailed:
+		return topUp, nil
+	case TopUpCompleted, TopUpChargedBack:
+		return nil, fmt.Errorf("top-up %s is already %s", topUpId, topUp.Status)
+	}
+
+	topUp.Status = TopUpFailed
+	topUp.FailureReason = reason
+	topUp.UpdatedAt = time.Now().Format(time.RFC3339)
+
+	return topUp, c.putTopUp(ctx, topUp)
+}
+
+// ChargebackTopUp reverses a completed top-up whose payment the provider took back.
+// The amount is debited even if that leaves the wallet negative; a negative wallet
+// cannot pay until it is topped up again.
+func (c *WalletContract) ChargebackTopUp(ctx contractapi.TransactionContextInterface, topUpId, reason string) (*TopUp, error) {
+	topUp, err := c.GetTopUp(ctx, topUpId)
+	if err != nil {
+		return nil, err
+	}
+	switch topUp.Status {
+	case TopUpChargedBack:
+		return topUp, nil
+	case TopUpPending, TopUpFailed:
+		return nil, fmt.Errorf("top-up %s is %s and cannot be charged back", topUpId, topUp.Status)
+	}
+
+	wallet, err := c.GetWallet(ctx, topUp.WalletID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	balanceBefore := wallet.BalanceMinor
+	wallet.BalanceMinor -= topUp.AmountMinor
+	wallet.LastUpdated = now
+
+	transactionId := "chargeback_" + topUpId
+	err = c.recordTransaction(ctx, transactionId, wallet, "debit", topUp.AmountMinor, balanceBefore, "Top-up charged back", "")
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.postEntry(ctx, "chargeback", transactionId, wallet.Currency,
+		walletPosting(wallet, -topUp.AmountMinor),
+		systemPosting(AccountFunding, wallet.Currency, topUp.AmountMinor),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	walletJSON, err := json.Marshal(wallet)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(wallet.WalletID, walletJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	topUp.Status = TopUpChargedBack
+	topUp.FailureReason = reason
+	topUp.UpdatedAt = now
+
+	return topUp, c.putTopUp(ctx, topUp)
+}
+
+// GetTopUp retrieves a top-up by ID
+func (c *WalletContract) GetTopUp(ctx contractapi.TransactionContextInterface, topUpId string) (*TopUp, error) {
+	topUpJSON, err := ctx.GetStub().GetState(topUpId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read top-up: %v", err)
+	}
+	if topUpJSON == nil {
+		return nil, fmt.Errorf("top-up %s does not exist", topUpId)
+	}
+
+	var topUp TopUp
+	err = json.Unmarshal(topUpJSON, &topUp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &topUp, nil
+}
+
+// GetUserTopUps returns all top-ups of a user
+func (c *WalletContract) GetUserTopUps(ctx contractapi.TransactionContextInterface, userId string) ([]*TopUp, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(topUpIndex, []string{userId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	topUps := []*TopUp{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) < 2 {
+			continue
+		}
+
+		topUp, err := c.GetTopUp(ctx, compositeKeyParts[1])
+		if err == nil {
+			topUps = append(topUps, topUp)
+		}
+	}
+
+	return topUps, nil
+}
+
+func (c *WalletContract) putTopUp(ctx contractapi.TransactionContextInterface, topUp *TopUp) error {
+	topUpJSON, err := json.Marshal(topUp)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(topUp.TopUpID, topUpJSON)
+}
@@ -10,43 +10,60 @@ import (
 
 // Wallet represents a user's wallet
 type Wallet struct {
-	DocType     string  `json:"docType"`
-	WalletID    string  `json:"walletId"`
-	UserID      string  `json:"userId"`
-	Balance     float64 `json:"balance"`
-	Currency    string  `json:"currency"`
-	CreatedAt   string  `json:"createdAt"`
-	LastUpdated string  `json:"lastUpdated"`
+	DocType      string `json:"docType"`
+	WalletID     string `json:"walletId"`
+	UserID       string `json:"userId"`
+	BalanceMinor int64  `json:"balanceMinor"` // minor units of Currency
+	Currency     string `json:"currency"`     // ISO 4217
+	CreatedAt    string `json:"createdAt"`
+	LastUpdated  string `json:"lastUpdated"`
 }
 
 // Payment represents a payment transaction
 type Payment struct {
-	DocType     string  `json:"docType"`
-	PaymentID   string  `json:"paymentId"`
-	WalletID    string  `json:"walletId"`
-	UserID      string  `json:"userId"`
-	Amount      float64 `json:"amount"`
-	Type        string  `json:"type"` // parking, charging, refund, topup
-	ReferenceID string  `json:"referenceId"` // bookingId or sessionId
-	Status      string  `json:"status"` // pending, completed, failed, refunded
+	DocType         string     `json:"docType"`
+	PaymentID       string     `json:"paymentId"`
+	WalletID        string     `json:"walletId"`
+	UserID          string     `json:"userId"`
+	AmountMinor     int64      `json:"amountMinor"` // minor units of Currency
+	Currency        string     `json:"currency"`
+	Type            string     `json:"type"`        // parking, charging, refund, topup
+	ReferenceID     string     `json:"referenceId"` // bookingId or sessionId
+	Status          string     `json:"status"`      // pending, completed, failed, partially_refunded, refunded
+	Description     string     `json:"description"`
+	OperatorID      string     `json:"operatorId,omitempty" metadata:",optional"`      // credited with the payment
+	SpenderID       string     `json:"spenderId,omitempty" metadata:",optional"`       // delegate who paid from the wallet
+	CommissionMinor int64      `json:"commissionMinor,omitempty" metadata:",optional"` // platform's share
+	RefundedMinor   int64      `json:"refundedMinor,omitempty" metadata:",optional"`   // refunded so far
+	LineItems       []LineItem `json:"lineItems,omitempty" metadata:",optional"`
+	CreatedAt       string     `json:"createdAt"`
+	CompletedAt     string     `json:"completedAt,omitempty"`
+}
+
+// LineItem represents one component of an itemized payment
+type LineItem struct {
+	Type        string  `json:"type"`
 	Description string  `json:"description"`
-	CreatedAt   string  `json:"createdAt"`
-	CompletedAt string  `json:"completedAt,omitempty"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit"`
+	UnitPrice   float64 `json:"unitPrice"`
+	AmountMinor int64   `json:"amountMinor"` // minor units of the payment's currency
 }
 
 // Transaction represents a wallet transaction record
 type Transaction struct {
-	DocType       string  `json:"docType"`
-	TransactionID string  `json:"transactionId"`
-	WalletID      string  `json:"walletId"`
-	UserID        string  `json:"userId"`
-	Type          string  `json:"type"` // debit, credit
-	Amount        float64 `json:"amount"`
-	BalanceBefore float64 `json:"balanceBefore"`
-	BalanceAfter  float64 `json:"balanceAfter"`
-	Description   string  `json:"description"`
-	PaymentID     string  `json:"paymentId"`
-	Timestamp     string  `json:"timestamp"`
+	DocType            string `json:"docType"`
+	TransactionID      string `json:"transactionId"`
+	WalletID           string `json:"walletId"`
+	UserID             string `json:"userId"`
+	Type               string `json:"type"`        // debit, credit
+	AmountMinor        int64  `json:"amountMinor"` // minor units of Currency
+	Currency           string `json:"currency"`
+	BalanceBeforeMinor int64  `json:"balanceBeforeMinor"`
+	BalanceAfterMinor  int64  `json:"balanceAfterMinor"`
+	Description        string `json:"description"`
+	PaymentID          string `json:"paymentId"`
+	Timestamp          string `json:"timestamp"`
 }
 
 // WalletContract provides functions for managing wallets and payments
@@ -62,8 +79,17 @@ func (c *WalletContract) InitLedger(ctx contractapi.TransactionContextInterface)
 
 // ==================== Wallet Management ====================
 
-// CreateWallet creates a new wallet for a user
-func (c *WalletContract) CreateWallet(ctx contractapi.TransactionContextInterface, walletId, userId string, initialBalance float64) error {
+// CreateWallet creates a new wallet for a user in an ISO 4217 currency. initialBalance
+// is a decimal string in that currency.
+func (c *WalletContract) CreateWallet(ctx contractapi.TransactionContextInterface, walletId, userId, initialBalance, currency string) error {
+	balance, err := parseAmount(initialBalance, currency)
+	if err != nil {
+		return err
+	}
+	if balance < 0 {
+		return fmt.Errorf("initial balance must not be negative")
+	}
+
 	// Check if user already has a wallet
 	exists, err := c.UserHasWallet(ctx, userId)
 	if err != nil {
@@ -75,13 +101,13 @@ func (c *WalletContract) CreateWallet(ctx contractapi.TransactionContextInterfac
 
 	now := time.Now().Format(time.RFC3339)
 	wallet := Wallet{
-		DocType:     "wallet",
-		WalletID:    walletId,
-		UserID:      userId,
-		Balance:     initialBalance,
-		Currency:    "USD",
-		CreatedAt:   now,
-		LastUpdated: now,
+		DocType:      "wallet",
+		WalletID:     walletId,
+		UserID:       userId,
+		BalanceMinor: balance,
+		Currency:     currency,
+		CreatedAt:    now,
+		LastUpdated:  now,
 	}
 
 	walletJSON, err := json.Marshal(wallet)
@@ -96,6 +122,17 @@ func (c *WalletContract) CreateWallet(ctx contractapi.TransactionContextInterfac
 	}
 	ctx.GetStub().PutState(userIndexKey, []byte{0x00})
 
+	// The initial balance is brought in like a top-up
+	if balance > 0 {
+		err = c.postEntry(ctx, "opening", "opening_"+walletId, currency,
+			walletPosting(&wallet, balance),
+			systemPosting(AccountFunding, currency, -balance),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
 	return ctx.GetStub().PutState(walletId, walletJSON)
 }
 
@@ -108,6 +145,9 @@ func (c *WalletContract) GetWallet(ctx contractapi.TransactionContextInterface,
 	if walletJSON == nil {
 		return nil, fmt.Errorf("wallet %s does not exist", walletId)
 	}
+	if err := checkMigrated(walletJSON, walletId); err != nil {
+		return nil, err
+	}
 
 	var wallet Wallet
 	err = json.Unmarshal(walletJSON, &wallet)
@@ -150,10 +190,15 @@ func (c *WalletContract) GetWalletByUserId(ctx contractapi.TransactionContextInt
 	return nil, fmt.Errorf("wallet for user %s not found", userId)
 }
 
-// AddFunds adds funds to a wallet
-func (c *WalletContract) AddFunds(ctx contractapi.TransactionContextInterface, walletId string, amount float64, transactionId string) error {
-	if amount <= 0 {
-		return fmt.Errorf("amount must be positive")
+// AddFunds adds funds to a wallet. amount is a decimal string in the wallet's currency.
+func (c *WalletContract) AddFunds(ctx contractapi.TransactionContextInterface, walletId, amount, transactionId string) error {
+	// Transaction IDs are chosen by the caller; never credit twice for the same one
+	existing, err := ctx.GetStub().GetState(transactionId)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("transaction %s already exists", transactionId)
 	}
 
 	wallet, err := c.GetWallet(ctx, walletId)
@@ -161,8 +206,16 @@ func (c *WalletContract) AddFunds(ctx contractapi.TransactionContextInterface, w
 		return err
 	}
 
-	balanceBefore := wallet.Balance
-	wallet.Balance += amount
+	amountMinor, err := parseAmount(amount, wallet.Currency)
+	if err != nil {
+		return err
+	}
+	if amountMinor <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+
+	balanceBefore := wallet.BalanceMinor
+	wallet.BalanceMinor += amountMinor
 	wallet.LastUpdated = time.Now().Format(time.RFC3339)
 
 	walletJSON, err := json.Marshal(wallet)
@@ -171,7 +224,15 @@ func (c *WalletContract) AddFunds(ctx contractapi.TransactionContextInterface, w
 	}
 
 	// Record transaction
-	err = c.recordTransaction(ctx, transactionId, walletId, wallet.UserID, "credit", amount, balanceBefore, wallet.Balance, "Funds added to wallet", "")
+	err = c.recordTransaction(ctx, transactionId, wallet, "credit", amountMinor, balanceBefore, "Funds added to wallet", "")
+	if err != nil {
+		return err
+	}
+
+	err = c.postEntry(ctx, "topup", transactionId, wallet.Currency,
+		walletPosting(wallet, amountMinor),
+		systemPosting(AccountFunding, wallet.Currency, -amountMinor),
+	)
 	if err != nil {
 		return err
 	}
@@ -179,22 +240,26 @@ func (c *WalletContract) AddFunds(ctx contractapi.TransactionContextInterface, w
 	return ctx.GetStub().PutState(walletId, walletJSON)
 }
 
-// GetBalance returns the balance of a wallet
-func (c *WalletContract) GetBalance(ctx contractapi.TransactionContextInterface, walletId string) (float64, error) {
+// GetBalance returns the balance of a wallet in minor units of its currency
+func (c *WalletContract) GetBalance(ctx contractapi.TransactionContextInterface, walletId string) (int64, error) {
 	wallet, err := c.GetWallet(ctx, walletId)
 	if err != nil {
 		return 0, err
 	}
-	return wallet.Balance, nil
+	return wallet.BalanceMinor, nil
 }
 
-// ValidateBalance checks if wallet has sufficient funds
-func (c *WalletContract) ValidateBalance(ctx contractapi.TransactionContextInterface, walletId string, amount float64) (bool, error) {
+// ValidateBalance checks if wallet has sufficient funds for a decimal amount in its currency
+func (c *WalletContract) ValidateBalance(ctx contractapi.TransactionContextInterface, walletId, amount string) (bool, error) {
 	wallet, err := c.GetWallet(ctx, walletId)
 	if err != nil {
 		return false, err
 	}
-	return wallet.Balance >= amount, nil
+	amountMinor, err := parseAmount(amount, wallet.Currency)
+	if err != nil {
+		return false, err
+	}
+	return wallet.BalanceMinor >= amountMinor, nil
 }
 
 // UserHasWallet checks if a user already has a wallet
@@ -208,36 +273,107 @@ func (c *WalletContract) UserHasWallet(ctx contractapi.TransactionContextInterfa
 
 // ==================== Payment Processing ====================
 
-// ProcessPayment processes a payment
-func (c *WalletContract) ProcessPayment(ctx contractapi.TransactionContextInterface, paymentId, walletId string, amount float64, paymentType, referenceId, description string) (*Payment, error) {
-	if amount <= 0 {
-		return nil, fmt.Errorf("amount must be positive")
+// ProcessPayment processes a payment. amount is a decimal string in currency, which
+// must be the wallet's currency; an empty currency means the wallet's. The payment is
+// credited to the revenue account of operatorId, or to the platform if it is empty.
+func (c *WalletContract) ProcessPayment(ctx contractapi.TransactionContextInterface, paymentId, walletId, amount, currency, operatorId, paymentType, referenceId, description string) (*Payment, error) {
+	wallet, err := c.walletForPayment(ctx, walletId, currency)
+	if err != nil {
+		return nil, err
 	}
 
+	amountMinor, err := parseAmount(amount, wallet.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.processPayment(ctx, paymentId, wallet, amountMinor, operatorId, paymentType, referenceId, description, nil, "")
+}
+
+// ProcessItemizedPayment processes a payment whose amount is the sum of its line items.
+// The line items are kept on the payment and shown on its receipt.
+func (c *WalletContract) ProcessItemizedPayment(ctx contractapi.TransactionContextInterface, paymentId, walletId, currency, operatorId, paymentType, referenceId, description string, lineItems []LineItem) (*Payment, error) {
+	if len(lineItems) == 0 {
+		return nil, fmt.Errorf("at least one line item is required")
+	}
+
+	wallet, err := c.walletForPayment(ctx, walletId, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	var amountMinor int64
+	for _, item := range lineItems {
+		if item.AmountMinor < 0 {
+			return nil, fmt.Errorf("line item %s must not be negative", item.Type)
+		}
+		amountMinor += item.AmountMinor
+	}
+
+	return c.processPayment(ctx, paymentId, wallet, amountMinor, operatorId, paymentType, referenceId, description, lineItems, "")
+}
+
+// walletForPayment returns the wallet a payment in currency is charged to
+func (c *WalletContract) walletForPayment(ctx contractapi.TransactionContextInterface, walletId, currency string) (*Wallet, error) {
 	wallet, err := c.GetWallet(ctx, walletId)
 	if err != nil {
 		return nil, err
 	}
+	if currency != "" && currency != wallet.Currency {
+		return nil, fmt.Errorf("payment currency %s does not match wallet currency %s", currency, wallet.Currency)
+	}
+	return wallet, nil
+}
 
-	if wallet.Balance < amount {
-		return nil, fmt.Errorf("insufficient balance: have %.2f, need %.2f", wallet.Balance, amount)
+// processPayment debits a wallet, credits the operator or platform and records the
+// payment. spenderId is the delegate paying from someone else's wallet, if any.
+func (c *WalletContract) processPayment(ctx contractapi.TransactionContextInterface, paymentId string, wallet *Wallet, amountMinor int64, operatorId, paymentType, referenceId, description string, lineItems []LineItem, spenderId string) (*Payment, error) {
+	if amountMinor <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	// Payment IDs are chosen by the caller; never charge twice for the same one
+	existing, err := ctx.GetStub().GetState(paymentId)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("payment %s already exists", paymentId)
+	}
+
+	if wallet.BalanceMinor < amountMinor {
+		return nil, fmt.Errorf("insufficient balance: have %s, need %s %s",
+			formatAmount(wallet.BalanceMinor, wallet.Currency), formatAmount(amountMinor, wallet.Currency), wallet.Currency)
+	}
+
+	var commissionMinor int64
+	if operatorId != "" {
+		commissionMinor, err = c.commission(ctx, operatorId, amountMinor)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	now := time.Now().Format(time.RFC3339)
 
 	// Create payment record
 	payment := Payment{
-		DocType:     "payment",
-		PaymentID:   paymentId,
-		WalletID:    walletId,
-		UserID:      wallet.UserID,
-		Amount:      amount,
-		Type:        paymentType,
-		ReferenceID: referenceId,
-		Status:      "completed",
-		Description: description,
-		CreatedAt:   now,
-		CompletedAt: now,
+		DocType:         "payment",
+		PaymentID:       paymentId,
+		WalletID:        wallet.WalletID,
+		UserID:          wallet.UserID,
+		SpenderID:       spenderId,
+		AmountMinor:     amountMinor,
+		Currency:        wallet.Currency,
+		Type:            paymentType,
+		ReferenceID:     referenceId,
+		OperatorID:      operatorId,
+		Status:          "completed",
+		Description:     description,
+		LineItems:       lineItems,
+		CommissionMinor: commissionMinor,
+		CreatedAt:       now,
+		CompletedAt:     now,
 	}
 
 	paymentJSON, err := json.Marshal(payment)
@@ -246,8 +382,8 @@ func (c *WalletContract) ProcessPayment(ctx contractapi.TransactionContextInterf
 	}
 
 	// Deduct from wallet
-	balanceBefore := wallet.Balance
-	wallet.Balance -= amount
+	balanceBefore := wallet.BalanceMinor
+	wallet.BalanceMinor -= amountMinor
 	wallet.LastUpdated = now
 
 	walletJSON, err := json.Marshal(wallet)
@@ -257,7 +393,26 @@ func (c *WalletContract) ProcessPayment(ctx contractapi.TransactionContextInterf
 
 	// Record transaction
 	transactionId := fmt.Sprintf("tx_%s", paymentId)
-	err = c.recordTransaction(ctx, transactionId, walletId, wallet.UserID, "debit", amount, balanceBefore, wallet.Balance, description, paymentId)
+	err = c.recordTransaction(ctx, transactionId, wallet, "debit", amountMinor, balanceBefore, description, paymentId)
+	if err != nil {
+		return nil, err
+	}
+
+	// The operator is credited with the payment less the platform's commission
+	postings := []Posting{walletPosting(wallet, -amountMinor)}
+	if operatorId == "" {
+		postings = append(postings, systemPosting(AccountPlatform, wallet.Currency, amountMinor))
+	} else {
+		postings = append(postings, operatorPosting(operatorId, wallet.Currency, amountMinor-payment.CommissionMinor))
+		if payment.CommissionMinor > 0 {
+			postings = append(postings, systemPosting(AccountPlatform, wallet.Currency, payment.CommissionMinor))
+		}
+		err = markPending(ctx, unsettledIndex, operatorAccountId(operatorId, wallet.Currency), "journal_"+paymentId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = c.postEntry(ctx, "payment", paymentId, wallet.Currency, postings...)
 	if err != nil {
 		return nil, err
 	}
@@ -275,8 +430,24 @@ func (c *WalletContract) ProcessPayment(ctx contractapi.TransactionContextInterf
 	}
 	ctx.GetStub().PutState(userPaymentIndexKey, []byte{0x00})
 
+	// Delegates find the payments they made among their own
+	if spenderId != "" {
+		spenderPaymentIndexKey, err := ctx.GetStub().CreateCompositeKey("userId~paymentId", []string{spenderId, paymentId})
+		if err != nil {
+			return nil, err
+		}
+		ctx.GetStub().PutState(spenderPaymentIndexKey, []byte{0x00})
+	}
+
+	// Create composite key for querying the payments of a booking or session
+	referenceIndexKey, err := ctx.GetStub().CreateCompositeKey("referenceId~paymentId", []string{referenceId, paymentId})
+	if err != nil {
+		return nil, err
+	}
+	ctx.GetStub().PutState(referenceIndexKey, []byte{0x00})
+
 	// Save updated wallet
-	err = ctx.GetStub().PutState(walletId, walletJSON)
+	err = ctx.GetStub().PutState(wallet.WalletID, walletJSON)
 	if err != nil {
 		return nil, err
 	}
@@ -284,8 +455,10 @@ func (c *WalletContract) ProcessPayment(ctx contractapi.TransactionContextInterf
 	return &payment, nil
 }
 
-// RefundPayment refunds a payment
-func (c *WalletContract) RefundPayment(ctx contractapi.TransactionContextInterface, paymentId string, refundAmount float64, refundPaymentId string) (*Payment, error) {
+// RefundPayment refunds part or all of a payment. refundAmount is a decimal string in
+// the payment's currency; a payment can be refunded several times up to its amount.
+// Only the operator credited with the payment, admins and the system may refund it.
+func (c *WalletContract) RefundPayment(ctx contractapi.TransactionContextInterface, paymentId, refundAmount, refundPaymentId, callerId, callerRole string) (*Payment, error) {
 	// Get original payment
 	paymentJSON, err := ctx.GetStub().GetState(paymentId)
 	if err != nil {
@@ -294,6 +467,9 @@ func (c *WalletContract) RefundPayment(ctx contractapi.TransactionContextInterfa
 	if paymentJSON == nil {
 		return nil, fmt.Errorf("payment %s not found", paymentId)
 	}
+	if err := checkMigrated(paymentJSON, paymentId); err != nil {
+		return nil, err
+	}
 
 	var originalPayment Payment
 	err = json.Unmarshal(paymentJSON, &originalPayment)
@@ -301,12 +477,35 @@ func (c *WalletContract) RefundPayment(ctx contractapi.TransactionContextInterfa
 		return nil, err
 	}
 
+	if err := authorizeRefund(&originalPayment, callerId, callerRole); err != nil {
+		return nil, err
+	}
+
+	existingRefund, err := ctx.GetStub().GetState(refundPaymentId)
+	if err != nil {
+		return nil, err
+	}
+	if existingRefund != nil {
+		return nil, fmt.Errorf("payment %s already exists", refundPaymentId)
+	}
+
+	if originalPayment.Type == "refund" {
+		return nil, fmt.Errorf("payment %s is a refund and cannot be refunded", paymentId)
+	}
 	if originalPayment.Status == "refunded" {
 		return nil, fmt.Errorf("payment %s has already been refunded", paymentId)
 	}
 
-	if refundAmount > originalPayment.Amount {
-		return nil, fmt.Errorf("refund amount cannot exceed original payment amount")
+	refundMinor, err := parseAmount(refundAmount, originalPayment.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if refundMinor <= 0 {
+		return nil, fmt.Errorf("refund amount must be positive")
+	}
+	refundable := originalPayment.AmountMinor - originalPayment.RefundedMinor
+	if refundMinor > refundable {
+		return nil, fmt.Errorf("refund amount exceeds the %s %s still refundable", formatAmount(refundable, originalPayment.Currency), originalPayment.Currency)
 	}
 
 	// Get wallet
@@ -323,13 +522,18 @@ func (c *WalletContract) RefundPayment(ctx contractapi.TransactionContextInterfa
 		PaymentID:   refundPaymentId,
 		WalletID:    originalPayment.WalletID,
 		UserID:      originalPayment.UserID,
-		Amount:      refundAmount,
+		SpenderID:   originalPayment.SpenderID,
+		AmountMinor: refundMinor,
+		Currency:    originalPayment.Currency,
 		Type:        "refund",
 		ReferenceID: paymentId,
-		Status:      "completed",
-		Description: fmt.Sprintf("Refund for payment %s", paymentId),
-		CreatedAt:   now,
-		CompletedAt: now,
+		OperatorID:  originalPayment.OperatorID,
+		// The platform returns its commission on the refunded part
+		CommissionMinor: refundedCommission(&originalPayment, originalPayment.RefundedMinor+refundMinor) - refundedCommission(&originalPayment, originalPayment.RefundedMinor),
+		Status:          "completed",
+		Description:     fmt.Sprintf("Refund for payment %s", paymentId),
+		CreatedAt:       now,
+		CompletedAt:     now,
 	}
 
 	refundJSON, err := json.Marshal(refundPayment)
@@ -338,8 +542,8 @@ func (c *WalletContract) RefundPayment(ctx contractapi.TransactionContextInterfa
 	}
 
 	// Add funds back to wallet
-	balanceBefore := wallet.Balance
-	wallet.Balance += refundAmount
+	balanceBefore := wallet.BalanceMinor
+	wallet.BalanceMinor += refundMinor
 	wallet.LastUpdated = now
 
 	walletJSON, err := json.Marshal(wallet)
@@ -347,8 +551,12 @@ func (c *WalletContract) RefundPayment(ctx contractapi.TransactionContextInterfa
 		return nil, err
 	}
 
-	// Update original payment status
-	originalPayment.Status = "refunded"
+	// Several partial refunds may follow each other until the whole payment is refunded
+	originalPayment.RefundedMinor += refundMinor
+	originalPayment.Status = "partially_refunded"
+	if originalPayment.RefundedMinor == originalPayment.AmountMinor {
+		originalPayment.Status = "refunded"
+	}
 	originalPaymentJSON, err := json.Marshal(originalPayment)
 	if err != nil {
 		return nil, err
@@ -356,7 +564,22 @@ func (c *WalletContract) RefundPayment(ctx contractapi.TransactionContextInterfa
 
 	// Record transaction
 	transactionId := fmt.Sprintf("tx_%s", refundPaymentId)
-	err = c.recordTransaction(ctx, transactionId, wallet.WalletID, wallet.UserID, "credit", refundAmount, balanceBefore, wallet.Balance, refundPayment.Description, refundPaymentId)
+	err = c.recordTransaction(ctx, transactionId, wallet, "credit", refundMinor, balanceBefore, refundPayment.Description, refundPaymentId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refunds of operator payments are paid out of the clearing account and recovered
+	// from the operator at its next settlement; the platform refunds its own directly
+	refundFrom := systemPosting(AccountPlatform, wallet.Currency, -refundMinor)
+	if originalPayment.OperatorID != "" {
+		refundFrom = systemPosting(AccountRefundClearing, wallet.Currency, -refundMinor)
+		err = markPending(ctx, unrecoveredIndex, operatorAccountId(originalPayment.OperatorID, wallet.Currency), refundPaymentId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = c.postEntry(ctx, "refund", refundPaymentId, wallet.Currency, walletPosting(wallet, refundMinor), refundFrom)
 	if err != nil {
 		return nil, err
 	}
@@ -374,6 +597,20 @@ func (c *WalletContract) RefundPayment(ctx contractapi.TransactionContextInterfa
 	}
 	ctx.GetStub().PutState(userPaymentIndexKey, []byte{0x00})
 
+	// A refund of a delegated payment goes back to the wallet and frees up the
+	// delegate's monthly limit
+	if originalPayment.SpenderID != "" {
+		spenderPaymentIndexKey, err := ctx.GetStub().CreateCompositeKey("userId~paymentId", []string{originalPayment.SpenderID, refundPaymentId})
+		if err != nil {
+			return nil, err
+		}
+		ctx.GetStub().PutState(spenderPaymentIndexKey, []byte{0x00})
+
+		if err := c.refundDelegatedPayment(ctx, &originalPayment, &refundPayment); err != nil {
+			return nil, err
+		}
+	}
+
 	err = ctx.GetStub().PutState(paymentId, originalPaymentJSON)
 	if err != nil {
 		return nil, err
@@ -395,6 +632,9 @@ func (c *WalletContract) GetPayment(ctx contractapi.TransactionContextInterface,
 	if paymentJSON == nil {
 		return nil, fmt.Errorf("payment %s does not exist", paymentId)
 	}
+	if err := checkMigrated(paymentJSON, paymentId); err != nil {
+		return nil, err
+	}
 
 	var payment Payment
 	err = json.Unmarshal(paymentJSON, &payment)
@@ -412,20 +652,23 @@ func (c *WalletContract) GetPaymentReceipt(ctx contractapi.TransactionContextInt
 
 // ==================== Transaction Management ====================
 
-// recordTransaction is a helper function to record transactions
-func (c *WalletContract) recordTransaction(ctx contractapi.TransactionContextInterface, transactionId, walletId, userId, txType string, amount, balanceBefore, balanceAfter float64, description, paymentId string) error {
+// recordTransaction is a helper function to record transactions. wallet holds the
+// balance after the transaction.
+func (c *WalletContract) recordTransaction(ctx contractapi.TransactionContextInterface, transactionId string, wallet *Wallet, txType string, amountMinor, balanceBefore int64, description, paymentId string) error {
+	walletId, userId := wallet.WalletID, wallet.UserID
 	transaction := Transaction{
-		DocType:       "transaction",
-		TransactionID: transactionId,
-		WalletID:      walletId,
-		UserID:        userId,
-		Type:          txType,
-		Amount:        amount,
-		BalanceBefore: balanceBefore,
-		BalanceAfter:  balanceAfter,
-		Description:   description,
-		PaymentID:     paymentId,
-		Timestamp:     time.Now().Format(time.RFC3339),
+		DocType:            "transaction",
+		TransactionID:      transactionId,
+		WalletID:           walletId,
+		UserID:             userId,
+		Type:               txType,
+		AmountMinor:        amountMinor,
+		Currency:           wallet.Currency,
+		BalanceBeforeMinor: balanceBefore,
+		BalanceAfterMinor:  wallet.BalanceMinor,
+		Description:        description,
+		PaymentID:          paymentId,
+		Timestamp:          time.Now().Format(time.RFC3339),
 	}
 
 	transactionJSON, err := json.Marshal(transaction)
@@ -458,6 +701,9 @@ func (c *WalletContract) GetTransaction(ctx contractapi.TransactionContextInterf
 	if transactionJSON == nil {
 		return nil, fmt.Errorf("transaction %s does not exist", transactionId)
 	}
+	if err := checkMigrated(transactionJSON, transactionId); err != nil {
+		return nil, err
+	}
 
 	var transaction Transaction
 	err = json.Unmarshal(transactionJSON, &transaction)
@@ -576,16 +822,16 @@ func (c *WalletContract) QueryTransactionsByType(ctx contractapi.TransactionCont
 	return transactions, nil
 }
 
-// GetTotalSpent returns total amount spent by a user
-func (c *WalletContract) GetTotalSpent(ctx contractapi.TransactionContextInterface, userId string) (float64, error) {
+// GetTotalSpent returns total amount spent by a user in minor units of their wallet's currency
+func (c *WalletContract) GetTotalSpent(ctx contractapi.TransactionContextInterface, userId string) (int64, error) {
 	transactions, err := c.QueryTransactionsByType(ctx, userId, "debit")
 	if err != nil {
 		return 0, err
 	}
 
-	var totalSpent float64
+	var totalSpent int64
 	for _, tx := range transactions {
-		totalSpent += tx.Amount
+		totalSpent += tx.AmountMinor
 	}
 
 	return totalSpent, nil
@@ -626,3 +872,43 @@ func (c *WalletContract) GetUserPayments(ctx contractapi.TransactionContextInter
 
 	return payments, nil
 }
+
+// GetReferencePayments returns the payments made for a booking or charging session,
+// including extensions, but not their refunds
+func (c *WalletContract) GetReferencePayments(ctx contractapi.TransactionContextInterface, referenceId string) ([]*Payment, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("referenceId~paymentId", []string{referenceId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	payments := []*Payment{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) < 2 {
+			continue
+		}
+
+		payment, err := c.GetPayment(ctx, compositeKeyParts[1])
+		if err == nil {
+			payments = append(payments, payment)
+		}
+	}
+
+	return payments, nil
+}
+
+// refundedCommission returns the share of a payment's commission returned once
+// refundedMinor of it has been refunded. Partial refunds return the difference, so
+// their commissions add up to the payment's commission when it is fully refunded.
+func refundedCommission(payment *Payment, refundedMinor int64) int64 {
+	return payment.CommissionMinor * refundedMinor / payment.AmountMinor
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
 	"os"
+	"time"
 
 	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/api"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/auth"
 	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/config"
 	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/idempotency"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/mail"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/payments"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/saga"
 )
 
 func main() {
@@ -23,8 +30,65 @@ func main() {
 	}
 	defer fabricClient.Close()
 
+	// Initialize the saga coordinator for cross-channel workflows
+	sagaStore, err := saga.NewFileStore(cfg.SagaStoreDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize saga store: %v", err)
+	}
+	// Finished sagas are kept for 30 days, so that a request or a charge point message
+	// replayed in that time gets the recorded outcome instead of running again
+	sagaCoordinator := saga.NewCoordinator(sagaStore, 30*24*time.Hour)
+
+	// Keep responses to Idempotency-Key requests for a day so clients can retry safely
+	idempotencyStore, err := idempotency.NewFileStore(cfg.IdempotencyStoreDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize idempotency store: %v", err)
+	}
+	idempotencyKeys := idempotency.NewRegistry(idempotencyStore, 24*time.Hour)
+
+	// Initialize the payment provider collecting wallet top-ups
+	paymentProvider, err := payments.New(cfg.PaymentProvider, cfg.PaymentWebhookSecret)
+	if err != nil {
+		log.Fatalf("Failed to initialize payment provider: %v", err)
+	}
+
+	// Revoked access tokens are rejected until they expire, across restarts
+	revocationList, err := auth.NewRevocationList(cfg.RevocationListPath)
+	if err != nil {
+		log.Fatalf("Failed to initialize revocation list: %v", err)
+	}
+
+	// Accounts that chose their own role before roles were granted by admins go back to
+	// being users; access tokens carry the role, so theirs are revoked
+	demotedResult, err := fabricClient.GetUserContract().SubmitTransaction("DemoteUnassignedRoles")
+	if err != nil {
+		log.Fatalf("Failed to demote unassigned roles: %v", err)
+	}
+	var demoted []string
+	if err := json.Unmarshal(demotedResult, &demoted); err != nil {
+		log.Fatalf("Failed to parse demoted users: %v", err)
+	}
+	for _, userId := range demoted {
+		if err := revocationList.RevokeUser(userId, cfg.AccessTokenTTL); err != nil {
+			log.Fatalf("Failed to revoke the access tokens of user %s: %v", userId, err)
+		}
+		log.Printf("Demoted user %s, whose role was not granted by an admin", userId)
+	}
+
+	// TOTP secrets are encrypted before they are stored on the ledger
+	mfaSecrets, err := auth.NewSecretBox(cfg.MFAEncryptionKey)
+	if err != nil {
+		log.Fatalf("Failed to initialize MFA encryption: %v", err)
+	}
+
+	// Password reset and verification links are mailed through the configured mailer
+	mailer, err := mail.New(cfg.Mailer, cfg.MailFrom, cfg.MailDir, cfg.SMTPAddr)
+	if err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+
 	// Initialize and start the API server
-	server := api.NewServer(cfg, fabricClient)
+	server := api.NewServer(cfg, fabricClient, sagaCoordinator, idempotencyKeys, paymentProvider, revocationList, mfaSecrets, mailer)
 	
 	port := os.Getenv("PORT")
 	if port == "" {
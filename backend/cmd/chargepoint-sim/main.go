@@ -0,0 +1,172 @@
+// Command chargepoint-sim simulates an OCPP 1.6J charge point for local testing. It
+// connects to the central system, charges a vehicle for the given duration while
+// reporting meter values, and stops the transaction.
+//
+//	go run ./cmd/chargepoint-sim -id station_123 -password <issued password> -idtag RFID0001
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/ocpp"
+)
+
+// chargePoint is a connected simulated charge point
+type chargePoint struct {
+	conn   *websocket.Conn
+	nextId int
+}
+
+func main() {
+	url := flag.String("url", "ws://localhost:8080/ocpp", "central system URL, without the charge point ID")
+	id := flag.String("id", "", "charge point ID (the charging station ID)")
+	password := flag.String("password", "", "basic auth password issued for the station")
+	idTag := flag.String("idtag", "", "idTag presented to start charging")
+	power := flag.Float64("power", 22, "charging power in kW")
+	duration := flag.Duration("duration", 2*time.Minute, "how long to charge")
+	interval := flag.Duration("interval", 15*time.Second, "meter value interval")
+	meterStart := flag.Int("meter", 0, "meter register at start in Wh")
+	flag.Parse()
+
+	if *id == "" || *password == "" || *idTag == "" {
+		log.Fatal("-id, -password and -idtag are required")
+	}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte(*id + ":" + *password))
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+credentials)
+
+	dialer := websocket.Dialer{Subprotocols: []string{ocpp.Subprotocol}}
+	conn, _, err := dialer.Dial(*url+"/"+*id, header)
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	cp := &chargePoint{conn: conn}
+
+	var boot ocpp.BootNotificationResponse
+	cp.call(ocpp.ActionBootNotification, ocpp.BootNotificationRequest{
+		ChargePointVendor: "CityFlow",
+		ChargePointModel:  "Simulator",
+	}, &boot)
+	if boot.Status != "Accepted" {
+		log.Fatalf("Boot notification %s; is %s a charging station on the ledger?", boot.Status, *id)
+	}
+
+	cp.status("Available")
+
+	var auth ocpp.AuthorizeResponse
+	cp.call(ocpp.ActionAuthorize, ocpp.AuthorizeRequest{IdTag: *idTag}, &auth)
+	if auth.IdTagInfo.Status != ocpp.AuthorizationAccepted {
+		log.Fatalf("idTag %s: %s", *idTag, auth.IdTagInfo.Status)
+	}
+
+	cp.status("Preparing")
+
+	var start ocpp.StartTransactionResponse
+	cp.call(ocpp.ActionStartTransaction, ocpp.StartTransactionRequest{
+		ConnectorID: 1,
+		IdTag:       *idTag,
+		MeterStart:  *meterStart,
+		Timestamp:   time.Now().UTC(),
+	}, &start)
+	if start.IdTagInfo.Status != ocpp.AuthorizationAccepted {
+		log.Fatalf("Transaction rejected: %s", start.IdTagInfo.Status)
+	}
+	transactionId := start.TransactionID
+
+	cp.status("Charging")
+
+	// Advance the meter at the configured power until the duration is over
+	begin := time.Now()
+	meter := float64(*meterStart)
+	last := begin
+	ticker := time.NewTicker(*interval)
+	for now := range ticker.C {
+		meter += *power * 1000 * now.Sub(last).Hours()
+		last = now
+
+		cp.call(ocpp.ActionMeterValues, ocpp.MeterValuesRequest{
+			ConnectorID:   1,
+			TransactionID: &transactionId,
+			MeterValue: []ocpp.MeterValue{{
+				Timestamp: now.UTC(),
+				SampledValue: []ocpp.SampledValue{{
+					Value:     strconv.FormatFloat(meter, 'f', 0, 64),
+					Context:   "Sample.Periodic",
+					Measurand: ocpp.MeasurandEnergyImport,
+					Unit:      "Wh",
+				}},
+			}},
+		}, &ocpp.MeterValuesResponse{})
+
+		if now.Sub(begin) >= *duration {
+			break
+		}
+	}
+	ticker.Stop()
+
+	cp.status("Finishing")
+
+	cp.call(ocpp.ActionStopTransaction, ocpp.StopTransactionRequest{
+		IdTag:         *idTag,
+		MeterStop:     int(meter),
+		Timestamp:     time.Now().UTC(),
+		TransactionID: transactionId,
+		Reason:        "Local",
+	}, &ocpp.StopTransactionResponse{})
+
+	cp.status("Available")
+
+	log.Printf("Delivered %.3f kWh in transaction %d", (meter-float64(*meterStart))/1000, transactionId)
+}
+
+// call sends a request and decodes the result into response, exiting on any error
+func (cp *chargePoint) call(action string, request, response interface{}) {
+	cp.nextId++
+	uniqueId := strconv.Itoa(cp.nextId)
+
+	frame, err := ocpp.NewCall(uniqueId, action, request)
+	if err != nil {
+		log.Fatalf("%s: %v", action, err)
+	}
+	log.Printf("-> %s", frame)
+	if err := cp.conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+		log.Fatalf("%s: %v", action, err)
+	}
+
+	_, data, err := cp.conn.ReadMessage()
+	if err != nil {
+		log.Fatalf("%s: %v", action, err)
+	}
+	log.Printf("<- %s", data)
+
+	resultId, payload, err := ocpp.ParseResult(data)
+	if err != nil {
+		log.Fatalf("%s: %v", action, err)
+	}
+	if resultId != uniqueId {
+		log.Fatalf("%s: expected result %s, got %s", action, uniqueId, resultId)
+	}
+	if err := json.Unmarshal(payload, response); err != nil {
+		log.Fatalf("%s: %v", action, err)
+	}
+}
+
+// status reports the status of connector 1
+func (cp *chargePoint) status(status string) {
+	cp.call(ocpp.ActionStatusNotification, ocpp.StatusNotificationRequest{
+		ConnectorID: 1,
+		ErrorCode:   "NoError",
+		Status:      status,
+	}, &ocpp.StatusNotificationResponse{})
+}
@@ -0,0 +1,81 @@
+// Command payment-sim plays the fake payment provider for local testing. It sends the
+// backend a signed webhook reporting the outcome of a top-up's payment, as a real
+// provider would once the user paid.
+//
+//	go run ./cmd/payment-sim -topup topup_123 -intent fake_pi_456 -amount 25.00 -currency USD
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/money"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/payments"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:8080/api/v1/payments/webhook/fake", "webhook URL of the backend")
+	secret := flag.String("secret", "", "webhook secret (PAYMENT_WEBHOOK_SECRET of the backend)")
+	topUpId := flag.String("topup", "", "top-up ID returned by /wallet/add-funds")
+	intent := flag.String("intent", "", "providerRef of the top-up")
+	amount := flag.String("amount", "", "amount paid, as a decimal string")
+	currency := flag.String("currency", money.DefaultCurrency, "currency paid")
+	event := flag.String("event", "succeeded", "outcome to report: succeeded, failed or chargeback")
+	reason := flag.String("reason", "", "failure or chargeback reason")
+	flag.Parse()
+
+	if *secret == "" || *topUpId == "" || *intent == "" || *amount == "" {
+		log.Fatal("-secret, -topup, -intent and -amount are required")
+	}
+	switch *event {
+	case payments.EventSucceeded, payments.EventFailed, payments.EventChargeback:
+	default:
+		log.Fatalf("unknown event %q", *event)
+	}
+
+	amountMinor, err := money.Parse(*amount, *currency)
+	if err != nil {
+		log.Fatalf("Invalid amount: %v", err)
+	}
+
+	body, err := json.Marshal(payments.FakeEvent{
+		ID:   "evt_" + uuid.New().String(),
+		Type: "payment." + *event,
+		Data: payments.FakeEventData{
+			Intent:      *intent,
+			TopUpID:     *topUpId,
+			AmountMinor: amountMinor,
+			Currency:    *currency,
+			Reason:      *reason,
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to encode event: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, *url, bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(payments.FakeSignatureHeader, payments.SignFake(*secret, body, time.Now()))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("Failed to send webhook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	response, _ := io.ReadAll(resp.Body)
+	log.Printf("%s: %s", resp.Status, response)
+	if resp.StatusCode != http.StatusOK {
+		log.Fatal("webhook was not accepted")
+	}
+}
@@ -0,0 +1,33 @@
+// Command totp plays an authenticator app for scripts. It prints the TOTP code of a
+// secret enrolled at /auth/mfa/enroll.
+//
+//	go run ./cmd/totp -secret JBSWY3DPEHPK3PXP
+//
+// By default it prints the code of the next period, which the backend already accepts
+// and which a login earlier in the current period has not used up.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/auth"
+)
+
+func main() {
+	secret := flag.String("secret", "", "base32 TOTP secret returned by /auth/mfa/enroll")
+	offset := flag.Duration("offset", 30*time.Second, "how far from now to take the code")
+	flag.Parse()
+
+	if *secret == "" {
+		log.Fatal("-secret is required")
+	}
+
+	code, err := auth.TOTPCode(*secret, time.Now().Add(*offset))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(code)
+}
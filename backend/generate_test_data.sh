@@ -2,6 +2,8 @@
 
 # CityFlow Test Data Generation Script
 # This script generates comprehensive test data for the CityFlow system
+# Requires: backend started by start.sh and `jq` installed; see seed_admin.sh for how
+# the admin account below is set up
 
 set -e
 
@@ -9,6 +11,9 @@ API_URL="http://localhost:8080/api/v1"
 ADMIN_TOKEN=""
 USER_TOKEN=""
 
+API_BASE="$API_URL"
+source "$(dirname "${BASH_SOURCE[0]}")/seed_admin.sh"
+
 # Colors for output
 GREEN='\033[0;32m'
 BLUE='\033[0;34m'
@@ -33,31 +38,12 @@ fi
 echo ""
 
 # Create Admin User
-echo -e "${YELLOW}[2/8]${NC} Creating admin user..."
-ADMIN_RESPONSE=$(curl -s -X POST "$API_URL/auth/register" \
-  -H "Content-Type: application/json" \
-  -d '{
-    "email": "admin@cityflow.com",
-    "password": "admin123",
-    "firstName": "Admin",
-    "lastName": "User",
-    "phone": "+1234567890",
-    "role": "admin"
-  }' 2>/dev/null || echo '{"error":"User may already exist"}')
-
-if echo "$ADMIN_RESPONSE" | grep -q "token"; then
-    ADMIN_TOKEN=$(echo "$ADMIN_RESPONSE" | grep -o '"token":"[^"]*' | cut -d'"' -f4)
-    echo -e "${GREEN}✓ Admin user created: admin@cityflow.com / admin123${NC}"
+echo -e "${YELLOW}[2/8]${NC} Setting up admin user..."
+if admin_login; then
+    echo -e "${GREEN}✓ Logged in as admin: ${ADMIN_EMAIL} / ${ADMIN_PASSWORD}${NC}"
 else
-    # Try to login if already exists
-    ADMIN_RESPONSE=$(curl -s -X POST "$API_URL/auth/login" \
-      -H "Content-Type: application/json" \
-      -d '{
-        "email": "admin@cityflow.com",
-        "password": "admin123"
-      }')
-    ADMIN_TOKEN=$(echo "$ADMIN_RESPONSE" | grep -o '"token":"[^"]*' | cut -d'"' -f4)
-    echo -e "${YELLOW}⚠ Admin user already exists, logged in${NC}"
+    echo -e "${RED}✗ Could not log in as admin${NC}"
+    exit 1
 fi
 echo ""
 
@@ -267,7 +253,7 @@ echo -e "${BLUE}║                    Test Data Created Successfully
 echo -e "${BLUE}╚════════════════════════════════════════════════════════════════════╝${NC}"
 echo ""
 echo -e "${GREEN}Users Created:${NC}"
-echo -e "  Admin:  ${YELLOW}admin@cityflow.com${NC} / ${YELLOW}admin123${NC}"
+echo -e "  Admin:  ${YELLOW}${ADMIN_EMAIL}${NC} / ${YELLOW}${ADMIN_PASSWORD}${NC} (TOTP secret in data/seed-admin-totp-secret)"
 echo -e "  User 1: ${YELLOW}john.doe@example.com${NC} / ${YELLOW}password123${NC}"
 echo -e "  User 2: ${YELLOW}jane.smith@example.com${NC} / ${YELLOW}password123${NC}"
 echo -e "  User 3: ${YELLOW}bob.wilson@example.com${NC} / ${YELLOW}password123${NC}"
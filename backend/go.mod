@@ -5,6 +5,7 @@ go 1.21
 require (
 	github.com/gin-gonic/gin v1.9.1
 	github.com/google/uuid v1.4.0
+	github.com/gorilla/websocket v1.5.1
 	github.com/hyperledger/fabric-gateway v1.4.0
 	golang.org/x/crypto v0.16.0
 	google.golang.org/grpc v1.59.0
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/auth"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/mail"
+)
+
+// Purposes of account tokens, as the user chaincode names them
+const (
+	purposePasswordReset     = "password_reset"
+	purposeEmailVerification = "email_verification"
+)
+
+// accountLink describes the email carrying the link of an account token
+type accountLink struct {
+	ttl      time.Duration
+	validity string // ttl, for people
+	path     string // frontend page using the token
+	subject  string
+	text     string
+}
+
+var accountLinks = map[string]accountLink{
+	purposePasswordReset: {
+		ttl:      time.Hour,
+		validity: "1 hour",
+		path:     "/reset-password",
+		subject:  "Reset your CityFlow password",
+		text:     "Someone asked to reset the password of your CityFlow account. If it was you, open this link",
+	},
+	purposeEmailVerification: {
+		ttl:      48 * time.Hour,
+		validity: "48 hours",
+		path:     "/verify-email",
+		subject:  "Verify your CityFlow email",
+		text:     "Welcome to CityFlow! Confirm your email address by opening this link",
+	},
+}
+
+// ForgotPasswordRequest asks for a password reset link
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest sets a new password with the token of a reset link
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required,min=6"`
+}
+
+// ChangePasswordRequest sets a new password given the current one
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required,min=6"`
+}
+
+// VerifyEmailRequest carries the token of a verification link
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ForgotPassword mails a password reset link. It responds the same whether or not
+// the email is registered, so it cannot be used to find accounts.
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contract := h.fabricClient.GetUserContract()
+	if userResult, err := contract.EvaluateTransaction("GetUserByEmail", req.Email); err == nil {
+		var user User
+		if json.Unmarshal(userResult, &user) == nil && user.IsActive {
+			if err := h.sendAccountLink(c, &user, purposePasswordReset); err != nil {
+				log.Printf("auth: failed to send password reset email to user %s: %v", user.UserID, err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the email is registered, a reset link has been sent"})
+}
+
+// ResetPassword sets a new password with the token of a reset link. Every session of
+// the user is revoked, and any lockout lifted.
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	contract := h.fabricClient.GetUserContract()
+	userResult, err := contract.SubmitTransaction("ResetPassword", auth.HashAccountToken(h.settings.TokenKey, req.Token), string(hashedPassword))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+		return
+	}
+
+	var user User
+	json.Unmarshal(userResult, &user)
+
+	// Whoever knew the old password is logged out everywhere
+	if err := h.revokeOtherSessions(user.UserID, ""); err != nil {
+		log.Printf("auth: failed to revoke sessions of user %s after password reset: %v", user.UserID, err)
+	}
+	if err := h.revoked.RevokeUser(user.UserID, h.tokens.TTL()); err != nil {
+		log.Printf("auth: failed to revoke access tokens of user %s after password reset: %v", user.UserID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset, log in with the new password"})
+}
+
+// ChangePassword sets a new password for the current user given the current one. The
+// user's other sessions are revoked.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contract := h.fabricClient.GetUserContract()
+	userResult, err := contract.EvaluateTransaction("GetUser", claims.Subject)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	var user User
+	if err := json.Unmarshal(userResult, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user data"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	if _, err := contract.SubmitTransaction("ChangePassword", user.UserID, string(hashedPassword)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	if err := h.revokeOtherSessions(user.UserID, claims.SessionID); err != nil {
+		log.Printf("auth: failed to revoke sessions of user %s after password change: %v", user.UserID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
+
+// VerifyEmail marks the email of a user as verified with the token of a verification link
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contract := h.fabricClient.GetUserContract()
+	userResult, err := contract.SubmitTransaction("VerifyEmail", auth.HashAccountToken(h.settings.TokenKey, req.Token))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+	var user User
+	if err := json.Unmarshal(userResult, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user data"})
+		return
+	}
+
+	// The configured account becomes the first admin once it has proven it owns the
+	// address; the ledger refuses once one exists
+	if h.settings.BootstrapAdminEmail != "" && strings.EqualFold(user.Email, h.settings.BootstrapAdminEmail) {
+		if _, err := contract.SubmitTransaction("BootstrapAdmin", user.UserID); err != nil {
+			log.Printf("auth: did not make user %s the first admin: %v", user.UserID, err)
+		} else {
+			// Access tokens carry the role; the admin refreshes or logs in again to get it
+			if err := h.revoked.RevokeUser(user.UserID, h.tokens.TTL()); err != nil {
+				log.Printf("auth: failed to revoke the access tokens of user %s: %v", user.UserID, err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// ResendVerification mails a new verification link to the current user
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	contract := h.fabricClient.GetUserContract()
+	userResult, err := contract.EvaluateTransaction("GetUser", claims.Subject)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	var user User
+	if err := json.Unmarshal(userResult, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user data"})
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already verified"})
+		return
+	}
+
+	if err := h.sendAccountLink(c, &user, purposeEmailVerification); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// isLocked responds 423 and returns true if the account is locked after repeated
+// failed logins
+func (h *AuthHandler) isLocked(c *gin.Context, user *User) bool {
+	if !time.Now().Before(user.LockedUntil) {
+		return false
+	}
+	c.JSON(http.StatusLocked, gin.H{
+		"error":       "Account is locked after too many failed logins, try again later or reset your password",
+		"lockedUntil": user.LockedUntil,
+	})
+	return true
+}
+
+// failLogin counts a failed login on the ledger and responds 401 with message, or 423
+// if this failure locked the account
+func (h *AuthHandler) failLogin(c *gin.Context, userId, message string) {
+	contract := h.fabricClient.GetUserContract()
+	userResult, err := contract.SubmitTransaction(
+		"RecordFailedLogin",
+		userId,
+		strconv.Itoa(h.settings.MaxFailedLogins),
+		strconv.Itoa(int(h.settings.LockoutDuration.Minutes())),
+	)
+	if err != nil {
+		log.Printf("auth: failed to record failed login of user %s: %v", userId, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": message})
+		return
+	}
+
+	var user User
+	json.Unmarshal(userResult, &user)
+	if h.isLocked(c, &user) {
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": message})
+}
+
+// sendAccountLink stores a new account token for a user and mails them the link to use it
+func (h *AuthHandler) sendAccountLink(c *gin.Context, user *User, purpose string) error {
+	token, err := auth.NewAccountToken()
+	if err != nil {
+		return err
+	}
+
+	link := accountLinks[purpose]
+
+	contract := h.fabricClient.GetUserContract()
+	_, err = contract.SubmitTransaction(
+		"CreateAccountToken",
+		auth.HashAccountToken(h.settings.TokenKey, token),
+		user.UserID,
+		purpose,
+		strconv.Itoa(int(link.ttl.Minutes())),
+	)
+	if err != nil {
+		return err
+	}
+
+	linkURL := h.settings.AppURL + link.path + "?token=" + url.QueryEscape(token)
+	return h.mailer.Send(c.Request.Context(), mail.Message{
+		To:      user.Email,
+		Subject: link.subject,
+		Body: fmt.Sprintf("Hello %s,\n\n%s within %s:\n\n%s\n\nIf you did not ask for this, ignore this email.\n",
+			user.FirstName, link.text, link.validity, linkURL),
+	})
+}
+
+// revokeOtherSessions revokes every session of a user except keepSessionId, on the
+// ledger and in the revocation list
+func (h *AuthHandler) revokeOtherSessions(userId, keepSessionId string) error {
+	result, err := h.fabricClient.GetUserContract().SubmitTransaction("RevokeOtherSessions", userId, keepSessionId)
+	if err != nil {
+		return err
+	}
+
+	var revoked []string
+	json.Unmarshal(result, &revoked)
+	for _, sessionId := range revoked {
+		if err := h.revoked.RevokeSession(sessionId, h.tokens.TTL()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
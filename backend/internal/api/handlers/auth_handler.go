@@ -2,24 +2,59 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/auth"
 	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/idempotency"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/mail"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/money"
 )
 
+// AuthSettings are the account policies of AuthHandler
+type AuthSettings struct {
+	// RefreshTTL is how long the ledger session of a refresh token lasts
+	RefreshTTL time.Duration
+	// TokenKey keys the hashes under which the ledger stores refresh tokens, account
+	// tokens and MFA recovery codes
+	TokenKey string
+	// MaxFailedLogins in a row lock an account for LockoutDuration
+	MaxFailedLogins int
+	LockoutDuration time.Duration
+	// AppURL is the base URL of the frontend, for links in email
+	AppURL string
+	// BootstrapAdminEmail becomes the first admin once it is verified, while there is none
+	BootstrapAdminEmail string
+}
+
 // AuthHandler handles authentication endpoints
 type AuthHandler struct {
 	fabricClient *fabric.Client
+	tokens       *auth.TokenIssuer
+	revoked      *auth.RevocationList
+	mfaSecrets   *auth.SecretBox
+	mailer       mail.Mailer
+	settings     AuthSettings
 }
 
-// NewAuthHandler creates a new auth handler
-func NewAuthHandler(fabricClient *fabric.Client) *AuthHandler {
+// NewAuthHandler creates a new auth handler issuing access tokens with tokens and
+// refresh tokens backed by ledger sessions. TOTP secrets are sealed with mfaSecrets
+// before they reach the ledger, and account links are sent with mailer.
+func NewAuthHandler(fabricClient *fabric.Client, tokens *auth.TokenIssuer, revoked *auth.RevocationList, mfaSecrets *auth.SecretBox, mailer mail.Mailer, settings AuthSettings) *AuthHandler {
 	return &AuthHandler{
 		fabricClient: fabricClient,
+		tokens:       tokens,
+		revoked:      revoked,
+		mfaSecrets:   mfaSecrets,
+		mailer:       mailer,
+		settings:     settings,
 	}
 }
 
@@ -30,7 +65,6 @@ type RegisterRequest struct {
 	FirstName string `json:"firstName" binding:"required"`
 	LastName  string `json:"lastName" binding:"required"`
 	Phone     string `json:"phone"`
-	Role      string `json:"role"`
 }
 
 // LoginRequest represents login request
@@ -49,13 +83,36 @@ type User struct {
 	Phone        string `json:"phone"`
 	Role         string `json:"role"`
 	IsActive     bool   `json:"isActive"`
+	MFAEnabled   bool   `json:"mfaEnabled"`
+
+	EmailVerified       bool      `json:"emailVerified"`
+	FailedLoginAttempts int       `json:"failedLoginAttempts"`
+	LockedUntil         time.Time `json:"lockedUntil"`
+}
+
+// RefreshRequest represents refresh token request
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// SessionInfo is a login session as shown to its user, one per device
+type SessionInfo struct {
+	SessionID  string    `json:"sessionId"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	IPAddress  string    `json:"ipAddress"`
+	UserAgent  string    `json:"userAgent"`
+	Current    bool      `json:"current"` // the session of the requesting access token
 }
 
 // AuthResponse represents authentication response
 type AuthResponse struct {
-	Token   string          `json:"token"`
-	User    json.RawMessage `json:"user"`
-	Message string          `json:"message,omitempty"`
+	Token        string          `json:"token"` // access token
+	RefreshToken string          `json:"refreshToken"`
+	ExpiresIn    int             `json:"expiresIn"` // access token lifetime in seconds
+	User         json.RawMessage `json:"user"`
+	Message      string          `json:"message,omitempty"`
 }
 
 // Register handles user registration
@@ -74,21 +131,9 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	}
 
 	// Generate user ID
-	userId := "user_" + uuid.New().String()
-
-	// Set default role if not provided
-	userRole := req.Role
-	if userRole == "" {
-		userRole = "user"
-	}
+	userId := idempotency.NewID(c, "user_")
 
-	// Validate role - only allow user or admin
-	if userRole != "user" && userRole != "admin" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role. Must be 'user' or 'admin'"})
-		return
-	}
-
-	// Create user on blockchain
+	// Create user on blockchain; every account starts as a user and admins grant roles
 	contract := h.fabricClient.GetUserContract()
 	_, err = contract.SubmitTransaction(
 		"CreateUser",
@@ -98,7 +143,6 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		req.FirstName,
 		req.LastName,
 		req.Phone,
-		userRole,
 	)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -107,16 +151,22 @@ func (h *AuthHandler) Register(c *gin.Context) {
 
 	// Create wallet for user
 	walletContract := h.fabricClient.GetWalletContract()
-	walletId := "wallet_" + uuid.New().String()
-	_, err = walletContract.SubmitTransaction("CreateWallet", walletId, userId, "0")
+	walletId := idempotency.NewID(c, "wallet_")
+	_, err = walletContract.SubmitTransaction("CreateWallet", walletId, userId, "0", money.DefaultCurrency)
 	if err != nil {
 		// Log error but don't fail registration
 		// Wallet can be created later
 	}
 
+	// Registration succeeds even if the mail fails; the user can ask for a new link
+	newUser := User{UserID: userId, Email: req.Email, FirstName: req.FirstName}
+	if err := h.sendAccountLink(c, &newUser, purposeEmailVerification); err != nil {
+		log.Printf("auth: failed to send verification email to user %s: %v", userId, err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "User registered successfully",
-		"role":    userRole,
+		"role":    auth.RoleUser,
 		"userId":  userId,
 	})
 }
@@ -141,16 +191,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// Parse user data
-	var user struct {
-		UserID       string `json:"userId"`
-		Email        string `json:"email"`
-		PasswordHash string `json:"passwordHash"`
-		FirstName    string `json:"firstName"`
-		LastName     string `json:"lastName"`
-		Phone        string `json:"phone"`
-		Role         string `json:"role"`
-		IsActive     bool   `json:"isActive"`
-	}
+	var user User
 	if err := json.Unmarshal(userResult, &user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user data"})
 		return
@@ -160,71 +201,285 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is inactive"})
 		return
 	}
+	if h.isLocked(c, &user) {
+		return
+	}
 
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		h.failLogin(c, user.UserID, "Invalid credentials")
+		return
+	}
+
+	// With MFA enabled the password only earns a challenge, exchanged for tokens
+	// at /auth/login/mfa with a code
+	if user.MFAEnabled {
+		mfaToken, err := h.tokens.IssueChallenge(user.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start MFA challenge"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfaRequired": true,
+			"mfaToken":    mfaToken,
+			"message":     "MFA code required",
+		})
 		return
 	}
 
-	// Create session on blockchain
+	h.startSession(c, &user, "Login successful")
+}
+
+// startSession creates the ledger session of a new refresh token for a user who
+// completed login, and responds with the tokens
+func (h *AuthHandler) startSession(c *gin.Context, user *User, message string) {
+	contract := h.fabricClient.GetUserContract()
+	if user.FailedLoginAttempts > 0 {
+		if _, err := contract.SubmitTransaction("RecordSuccessfulLogin", user.UserID); err != nil {
+			log.Printf("auth: failed to reset failed logins of user %s: %v", user.UserID, err)
+		}
+	}
 	sessionId := "session_" + uuid.New().String()
-	token := uuid.New().String() + uuid.New().String() // Generate secure token
+	refreshToken, err := auth.NewRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
 
 	_, err = contract.SubmitTransaction(
 		"CreateSession",
 		sessionId,
 		user.UserID,
-		token,
+		auth.HashRefreshToken(h.settings.TokenKey, refreshToken),
 		c.ClientIP(),
 		c.GetHeader("User-Agent"),
-		"12", // 12 hours expiry
+		h.refreshHours(),
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
 
-	// Remove password hash from response
-	user.PasswordHash = ""
-	
-	// Create response with properly formatted user
-	userResponse := map[string]interface{}{
-		"userId":    user.UserID,
-		"email":     user.Email,
-		"firstName": user.FirstName,
-		"lastName":  user.LastName,
-		"phone":     user.Phone,
-		"role":      user.Role,
-		"isActive":  user.IsActive,
+	h.respondWithTokens(c, user, sessionId, refreshToken, message)
+}
+
+// Refresh exchanges a refresh token for a new access token. The refresh token is
+// rotated: the response carries its replacement, and reusing the old one revokes the
+// session.
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token":   token,
-		"user":    userResponse,
-		"message": "Login successful",
-	})
+	refreshToken, err := auth.NewRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	contract := h.fabricClient.GetUserContract()
+	sessionResult, err := contract.SubmitTransaction(
+		"RotateSession",
+		auth.HashRefreshToken(h.settings.TokenKey, req.RefreshToken),
+		auth.HashRefreshToken(h.settings.TokenKey, refreshToken),
+		c.ClientIP(),
+		c.GetHeader("User-Agent"),
+		h.refreshHours(),
+	)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+
+	var session struct {
+		SessionID string `json:"sessionId"`
+		UserID    string `json:"userId"`
+		IsActive  bool   `json:"isActive"`
+	}
+	json.Unmarshal(sessionResult, &session)
+
+	// A rotated token was replayed: the ledger revoked its session, so do the same
+	// for the access tokens already issued
+	if !session.IsActive {
+		h.revoked.RevokeSession(session.SessionID, h.tokens.TTL())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, session revoked"})
+		return
+	}
+
+	userResult, err := contract.EvaluateTransaction("GetUser", session.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	var user User
+	if err := json.Unmarshal(userResult, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user data"})
+		return
+	}
+	if !user.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is inactive"})
+		return
+	}
+
+	h.respondWithTokens(c, &user, session.SessionID, refreshToken, "Token refreshed")
 }
 
-// Logout handles user logout
+// Logout revokes the current session: its refresh token on the ledger and its
+// access tokens in the revocation list
 func (h *AuthHandler) Logout(c *gin.Context) {
-	token, exists := c.Get("token")
+	value, exists := c.Get("claims")
 	if !exists {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No token found"})
 		return
 	}
+	claims := value.(*auth.Claims)
 
-	// Delete session from blockchain
+	// Revoke session on blockchain
 	contract := h.fabricClient.GetUserContract()
-	_, err := contract.SubmitTransaction("DeleteSession", token.(string))
+	_, err := contract.SubmitTransaction("RevokeSession", claims.Subject, claims.SessionID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
 		return
 	}
 
+	if err := h.revoked.RevokeSession(claims.SessionID, h.tokens.TTL()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
+// ListSessions returns the active sessions of the current user, one per device
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	contract := h.fabricClient.GetUserContract()
+	result, err := contract.EvaluateTransaction("GetActiveSessions", claims.Subject)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	var sessions []SessionInfo
+	if err := json.Unmarshal(result, &sessions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse sessions"})
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionID == claims.SessionID
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions, "count": len(sessions)})
+}
+
+// RevokeSession logs one of the current user's devices out: its refresh token on the
+// ledger and its access tokens in the revocation list
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+	sessionId := c.Param("id")
+
+	contract := h.fabricClient.GetUserContract()
+	if _, err := contract.SubmitTransaction("RevokeSession", claims.Subject, sessionId); err != nil {
+		if isForbidden(err) {
+			forbidden(c, "session")
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	if err := h.revoked.RevokeSession(sessionId, h.tokens.TTL()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked", "sessionId": sessionId})
+}
+
+// RevokeOtherSessions logs out every device of the current user except this one
+func (h *AuthHandler) RevokeOtherSessions(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	contract := h.fabricClient.GetUserContract()
+	result, err := contract.SubmitTransaction("RevokeOtherSessions", claims.Subject, claims.SessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	var revoked []string
+	json.Unmarshal(result, &revoked)
+	for _, sessionId := range revoked {
+		if err := h.revoked.RevokeSession(sessionId, h.tokens.TTL()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked", "revoked": revoked, "count": len(revoked)})
+}
+
+// PurgeLegacySessions removes the sessions stored with raw tokens before the ledger
+// kept hashes (admin only). Their users have to log in again.
+func (h *AuthHandler) PurgeLegacySessions(c *gin.Context) {
+	contract := h.fabricClient.GetUserContract()
+	result, err := contract.SubmitTransaction("PurgeLegacySessions")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge legacy sessions"})
+		return
+	}
+
+	purged, _ := strconv.Atoi(string(result))
+	c.JSON(http.StatusOK, gin.H{"message": "Legacy sessions purged", "count": purged})
+}
+
+// respondWithTokens issues an access token for a session and responds with it, the
+// session's refresh token and the user
+func (h *AuthHandler) respondWithTokens(c *gin.Context, user *User, sessionId, refreshToken, message string) {
+	accessToken, _, err := h.tokens.Issue(auth.Claims{
+		Subject:   user.UserID,
+		SessionID: sessionId,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Role:      user.Role,
+		MFA:       user.MFAEnabled,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+		return
+	}
+
+	// Create response with properly formatted user, without password hash
+	userResponse := map[string]interface{}{
+		"userId":        user.UserID,
+		"email":         user.Email,
+		"firstName":     user.FirstName,
+		"lastName":      user.LastName,
+		"phone":         user.Phone,
+		"role":          user.Role,
+		"isActive":      user.IsActive,
+		"mfaEnabled":    user.MFAEnabled,
+		"emailVerified": user.EmailVerified,
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":        accessToken,
+		"refreshToken": refreshToken,
+		"expiresIn":    int(h.tokens.TTL().Seconds()),
+		"user":         userResponse,
+		"message":      message,
+	})
+}
+
+// refreshHours returns the session lifetime in the whole hours the ledger expects
+func (h *AuthHandler) refreshHours() string {
+	return strconv.Itoa(int(h.settings.RefreshTTL.Hours()))
+}
+
 // GetCurrentUser returns the current authenticated user
 func (h *AuthHandler) GetCurrentUser(c *gin.Context) {
 	userData, exists := c.Get("user")
@@ -240,16 +495,24 @@ func (h *AuthHandler) GetCurrentUser(c *gin.Context) {
 		return
 	}
 
+	// The access token only carries the identity; read the rest of the profile
+	contract := h.fabricClient.GetUserContract()
+	if result, err := contract.EvaluateTransaction("GetUser", user.UserID); err == nil {
+		json.Unmarshal(result, &user)
+	}
+
 	// Return formatted user (without password hash)
 	c.JSON(http.StatusOK, gin.H{
 		"user": gin.H{
-			"userId":    user.UserID,
-			"email":     user.Email,
-			"firstName": user.FirstName,
-			"lastName":  user.LastName,
-			"phone":     user.Phone,
-			"role":      user.Role,
-			"isActive":  user.IsActive,
+			"userId":        user.UserID,
+			"email":         user.Email,
+			"firstName":     user.FirstName,
+			"lastName":      user.LastName,
+			"phone":         user.Phone,
+			"role":          user.Role,
+			"isActive":      user.IsActive,
+			"mfaEnabled":    user.MFAEnabled,
+			"emailVerified": user.EmailVerified,
 		},
 	})
 }
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/auth"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
+)
+
+// systemRole is the caller role of backend workflows, such as saga refunds, acting on
+// resources after the request that started them was authorized
+const systemRole = "system"
+
+// caller is the authenticated user a request acts for
+type caller struct {
+	UserID string `json:"userId"`
+	Role   string `json:"role"`
+}
+
+// currentCaller returns the user set in the context by the auth middleware
+func currentCaller(c *gin.Context) caller {
+	userData, _ := c.Get("user")
+	var user caller
+	json.Unmarshal([]byte(userData.(string)), &user)
+	return user
+}
+
+// canAccess reports whether the caller may act on a resource: its owner, the operator
+// running it, or an admin
+func (u caller) canAccess(ownerId, operatorId string) bool {
+	switch {
+	case u.Role == "admin":
+		return true
+	case u.UserID != "" && u.UserID == ownerId:
+		return true
+	case u.Role == "operator" && u.UserID != "" && u.UserID == operatorId:
+		return true
+	}
+	return false
+}
+
+// hasPermission reports whether the caller's role grants a permission, which staff
+// roles can only use with MFA enabled, as in middleware.RequirePermission
+func hasPermission(c *gin.Context, permission auth.Permission) bool {
+	claims := c.MustGet("claims").(*auth.Claims)
+	if auth.RequiresMFA(claims.Role) && !claims.MFA {
+		return false
+	}
+	return auth.HasPermission(claims.Role, permission)
+}
+
+// isSelfOr reports whether the caller is the user userId or holds a permission over
+// other users
+func isSelfOr(c *gin.Context, userId string, permission auth.Permission) bool {
+	return currentCaller(c).UserID == userId || hasPermission(c, permission)
+}
+
+// forbidden responds 403 to a caller acting on a resource it may not access
+func forbidden(c *gin.Context, resource string) {
+	c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to access this " + resource})
+}
+
+// isForbidden reports whether a ledger error is an authorization failure
+func isForbidden(err error) bool {
+	return strings.Contains(err.Error(), "access denied")
+}
+
+// ledgerError responds to an error of a state-changing ledger transaction, with 403
+// for authorization failures
+func ledgerError(c *gin.Context, err error) {
+	if isForbidden(err) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// authorizeBooking loads a booking and checks that the caller may act on it. It
+// responds 404 or 403 and returns false otherwise.
+func authorizeBooking(c *gin.Context, fabricClient *fabric.Client, bookingId string) (*bookingRecord, bool) {
+	result, err := fabricClient.GetParkingContract().EvaluateTransaction("GetBooking", bookingId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return nil, false
+	}
+
+	var booking bookingRecord
+	json.Unmarshal(result, &booking)
+
+	user := currentCaller(c)
+	operatorId := ""
+	if user.Role == "operator" {
+		spotResult, err := fabricClient.GetParkingContract().EvaluateTransaction("GetParkingSpot", booking.SpotID)
+		if err == nil {
+			var spot struct {
+				OperatorID string `json:"operatorId"`
+			}
+			json.Unmarshal(spotResult, &spot)
+			operatorId = spot.OperatorID
+		}
+	}
+	if !user.canAccess(booking.UserID, operatorId) {
+		forbidden(c, "booking")
+		return nil, false
+	}
+
+	booking.raw = result
+	return &booking, true
+}
+
+// authorizeSession loads a charging session and checks that the caller may act on it.
+// It responds 404 or 403 and returns false otherwise.
+func authorizeSession(c *gin.Context, fabricClient *fabric.Client, sessionId string) (*sessionRecord, bool) {
+	result, err := fabricClient.GetChargingContract().EvaluateTransaction("GetChargingSession", sessionId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Charging session not found"})
+		return nil, false
+	}
+
+	var session sessionRecord
+	json.Unmarshal(result, &session)
+
+	user := currentCaller(c)
+	operatorId := ""
+	if user.Role == "operator" {
+		operatorId, _ = sessionOperator(fabricClient, sessionId)
+	}
+	if !user.canAccess(session.UserID, operatorId) {
+		forbidden(c, "charging session")
+		return nil, false
+	}
+
+	session.raw = result
+	return &session, true
+}
+
+// authorizePayment loads a payment and checks that the caller may see it: the payer,
+// the member who spent it from an organization's wallet, the operator credited with
+// it, or an admin. It responds 404 or 403 and returns false otherwise.
+func authorizePayment(c *gin.Context, fabricClient *fabric.Client, paymentId string) (*paymentRecord, bool) {
+	contract := fabricClient.GetWalletContract()
+	result, err := contract.EvaluateTransaction("GetPayment", paymentId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return nil, false
+	}
+
+	var payment paymentRecord
+	json.Unmarshal(result, &payment)
+
+	payerId := ""
+	if walletResult, err := contract.EvaluateTransaction("GetWallet", payment.WalletID); err == nil {
+		var wallet struct {
+			UserID string `json:"userId"`
+		}
+		json.Unmarshal(walletResult, &wallet)
+		payerId = wallet.UserID
+	}
+
+	user := currentCaller(c)
+	if !user.canAccess(payerId, payment.OperatorID) && (payment.SpenderID == "" || payment.SpenderID != user.UserID) {
+		forbidden(c, "payment")
+		return nil, false
+	}
+
+	return &payment, true
+}
+
+// authorizeOrganization checks that the caller is an active member of an organization,
+// or one of its owners and managers if managersOnly, and returns their membership. It
+// responds 403 and returns false otherwise.
+func authorizeOrganization(c *gin.Context, fabricClient *fabric.Client, orgId string, managersOnly bool) (*orgMember, bool) {
+	result, err := fabricClient.GetUserContract().EvaluateTransaction("GetOrgMember", orgId, currentCaller(c).UserID)
+	if err != nil {
+		forbidden(c, "organization")
+		return nil, false
+	}
+
+	var member orgMember
+	json.Unmarshal(result, &member)
+	if !member.IsActive || (managersOnly && !member.isManager()) {
+		forbidden(c, "organization")
+		return nil, false
+	}
+
+	return &member, true
+}
@@ -1,25 +1,34 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/idempotency"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/money"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/ocpp"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/saga"
 )
 
 // ChargingHandler handles charging station and session endpoints
 type ChargingHandler struct {
 	fabricClient *fabric.Client
+	sagas        *saga.Coordinator
 }
 
 // NewChargingHandler creates a new charging handler
-func NewChargingHandler(fabricClient *fabric.Client) *ChargingHandler {
+func NewChargingHandler(fabricClient *fabric.Client, sagas *saga.Coordinator) *ChargingHandler {
 	return &ChargingHandler{
 		fabricClient: fabricClient,
+		sagas:        sagas,
 	}
 }
 
@@ -58,8 +67,55 @@ type UpdateSessionRequest struct {
 
 // StopSessionRequest represents stop charging session request
 type StopSessionRequest struct {
-	SessionID   string  `json:"sessionId" binding:"required"`
-	TotalEnergy float64 `json:"totalEnergy" binding:"required"`
+	SessionID      string  `json:"sessionId" binding:"required"`
+	TotalEnergy    float64 `json:"totalEnergy" binding:"required"`
+	OrganizationID string  `json:"organizationId"` // pay from this organization's wallet
+}
+
+// StationFilter selects charging stations in a search; empty fields match any station
+type StationFilter struct {
+	Location      string `json:"location,omitempty"`
+	ConnectorType string `json:"connectorType,omitempty"`
+	Status        string `json:"status,omitempty"`
+	MinPower      int    `json:"minPower,omitempty"`
+	MaxPower      int    `json:"maxPower,omitempty"`
+	SortBy        string `json:"sortBy,omitempty"` // location or power
+	OpenNow       bool   `json:"openNow,omitempty"`
+}
+
+// SessionFilter selects the charging sessions of a user in a search
+type SessionFilter struct {
+	UserID    string   `json:"userId"`
+	StationID string   `json:"stationId,omitempty"`
+	Statuses  []string `json:"statuses,omitempty"`
+}
+
+// NearbyStationFilter selects charging stations in a nearby search
+type NearbyStationFilter struct {
+	ConnectorType string `json:"connectorType,omitempty"`
+	MinPower      int    `json:"minPower,omitempty"`
+	From          string `json:"from,omitempty"`
+	To            string `json:"to,omitempty"`
+}
+
+// RegisterIdTagRequest represents register idTag request
+type RegisterIdTagRequest struct {
+	IdTag      string `json:"idTag" binding:"required"`
+	ExpiryDate string `json:"expiryDate"` // RFC3339, empty for none
+}
+
+// meterReading holds the meter log fields the handlers inspect
+type meterReading struct {
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason"`
+}
+
+// StationFeesRequest represents set station fees request
+type StationFeesRequest struct {
+	SessionFee       float64 `json:"sessionFee"`
+	PerMinuteFee     float64 `json:"perMinuteFee"`
+	IdleFeePerMinute float64 `json:"idleFeePerMinute"`
+	IdleGraceMinutes int     `json:"idleGraceMinutes"`
 }
 
 // ==================== Charging Station Endpoints ====================
@@ -72,7 +128,7 @@ func (h *ChargingHandler) CreateStation(c *gin.Context) {
 		return
 	}
 
-	stationId := "station_" + uuid.New().String()
+	stationId := idempotency.NewID(c, "station_")
 
 	contract := h.fabricClient.GetChargingContract()
 	_, err := contract.SubmitTransaction(
@@ -156,71 +212,197 @@ func (h *ChargingHandler) DeleteStation(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Charging station deleted successfully"})
 }
 
-// GetAllStations returns all charging stations
-func (h *ChargingHandler) GetAllStations(c *gin.Context) {
+// SetStationFees sets the session, per-minute and idle fees of a charging station
+func (h *ChargingHandler) SetStationFees(c *gin.Context) {
+	stationId := c.Param("id")
+
+	var req StationFeesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	contract := h.fabricClient.GetChargingContract()
-	result, err := contract.EvaluateTransaction("GetAllChargingStations")
+	_, err := contract.SubmitTransaction(
+		"SetStationFees",
+		stationId,
+		fmt.Sprintf("%f", req.SessionFee),
+		fmt.Sprintf("%f", req.PerMinuteFee),
+		fmt.Sprintf("%f", req.IdleFeePerMinute),
+		strconv.Itoa(req.IdleGraceMinutes),
+	)
 	if err != nil {
-		fmt.Printf("GetAllChargingStations error: %v\n", err)
-		// Check if it's a LevelDB vs CouchDB issue
-		if fmt.Sprintf("%v", err) != "" {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to retrieve charging stations. Ensure the chaincode supports your database type (LevelDB requires GetStateByRange, not GetQueryResult).",
-				"details": err.Error(),
-			})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"stations": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"message": "Station fees updated successfully"})
 }
 
-// GetAvailableStations returns available stations at a location
-func (h *ChargingHandler) GetAvailableStations(c *gin.Context) {
-	location := c.Query("location")
-	if location == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Location is required"})
+// ResetStationCredential issues a new basic auth password for a station's charge point,
+// replacing any earlier one. The password is only returned here; the ledger keeps its hash.
+func (h *ChargingHandler) ResetStationCredential(c *gin.Context) {
+	stationId := c.Param("id")
+
+	contract := h.fabricClient.GetChargingContract()
+	result, err := contract.EvaluateTransaction("GetChargingStation", stationId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Charging station not found"})
 		return
 	}
 
-	contract := h.fabricClient.GetChargingContract()
-	result, err := contract.EvaluateTransaction("GetAvailableStations", location)
+	var station struct {
+		OperatorID string `json:"operatorId"`
+	}
+	json.Unmarshal(result, &station)
+	if !currentCaller(c).canAccess("", station.OperatorID) {
+		forbidden(c, "charging station")
+		return
+	}
+
+	password, err := ocpp.NewPassword()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"stations": json.RawMessage(result)})
+	_, err = contract.SubmitTransaction("SetStationCredential", stationId, string(passwordHash))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Station credential issued",
+		"chargePointId": stationId,
+		"password":      password,
+	})
 }
 
-// SearchStations searches for charging stations
-func (h *ChargingHandler) SearchStations(c *gin.Context) {
-	location := c.Query("location")
-	connectorType := c.Query("connectorType")
-	minPower := c.Query("minPower")
-	maxPower := c.Query("maxPower")
+// SetStationOpeningHours sets the opening hours of a charging station
+func (h *ChargingHandler) SetStationOpeningHours(c *gin.Context) {
+	stationId := c.Param("id")
+
+	var req OpeningHoursRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contract := h.fabricClient.GetChargingContract()
+	_, err := contract.SubmitTransaction(
+		"SetStationOpeningHours",
+		stationId,
+		strconv.Itoa(req.UTCOffsetMinutes),
+		openingHoursJSON(req.OpeningHours),
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Opening hours updated successfully"})
+}
+
+// GetStationAvailability returns the time windows a charging station is open and not
+// blacked out
+func (h *ChargingHandler) GetStationAvailability(c *gin.Context) {
+	stationId := c.Param("id")
+
+	// Default to the next 24 hours
+	now := time.Now().UTC().Truncate(time.Minute)
+	from := c.DefaultQuery("from", now.Format(time.RFC3339))
+	to := c.DefaultQuery("to", now.Add(24*time.Hour).Format(time.RFC3339))
 
 	contract := h.fabricClient.GetChargingContract()
-	var result []byte
-	var err error
+	result, err := contract.EvaluateTransaction("GetStationAvailability", stationId, from, to)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stationId":    stationId,
+		"from":         from,
+		"to":           to,
+		"availability": json.RawMessage(result),
+	})
+}
+
+// GetAllStations returns a page of all charging stations, ordered by location
+func (h *ChargingHandler) GetAllStations(c *gin.Context) {
+	respondPage(c, h.fabricClient.GetChargingContract(), "SearchStations", "stations", StationFilter{SortBy: "location"})
+}
+
+// GetAvailableStations returns a page of the stations at a location that are available
+// and open now
+func (h *ChargingHandler) GetAvailableStations(c *gin.Context) {
+	location := c.Query("location")
+	if location == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Location is required"})
+		return
+	}
 
-	if location != "" {
-		result, err = contract.EvaluateTransaction("QueryStationsByLocation", location)
-	} else if connectorType != "" {
-		result, err = contract.EvaluateTransaction("QueryStationsByConnectorType", connectorType)
-	} else if minPower != "" && maxPower != "" {
-		result, err = contract.EvaluateTransaction("QueryStationsByPowerOutput", minPower, maxPower)
-	} else {
-		result, err = contract.EvaluateTransaction("GetAllChargingStations")
+	respondPage(c, h.fabricClient.GetChargingContract(), "SearchStations", "stations", StationFilter{Location: location, Status: "available", OpenNow: true})
+}
+
+// SearchStations returns a page of the charging stations matching all the given criteria
+func (h *ChargingHandler) SearchStations(c *gin.Context) {
+	filter := StationFilter{
+		Location:      c.Query("location"),
+		ConnectorType: c.Query("connectorType"),
+		Status:        c.Query("status"),
+		SortBy:        c.Query("sortBy"),
+	}
+	var ok bool
+	if filter.MinPower, ok = intQuery(c, "minPower"); !ok {
+		return
 	}
+	if filter.MaxPower, ok = intQuery(c, "maxPower"); !ok {
+		return
+	}
+
+	respondPage(c, h.fabricClient.GetChargingContract(), "SearchStations", "stations", filter)
+}
 
+// GetNearbyStations returns the charging stations within a radius of a point, nearest
+// first, with their distance in meters. Without from and to only stations available now
+// are returned; with them, stations in service.
+func (h *ChargingHandler) GetNearbyStations(c *gin.Context) {
+	area, ok := nearbyArea(c)
+	if !ok {
+		return
+	}
+	limit, ok := limitQuery(c)
+	if !ok {
+		return
+	}
+	filter := NearbyStationFilter{
+		ConnectorType: c.Query("connectorType"),
+		From:          c.Query("from"),
+		To:            c.Query("to"),
+	}
+	if filter.MinPower, ok = intQuery(c, "minPower"); !ok {
+		return
+	}
+	filterJSON, err := json.Marshal(filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	contract := h.fabricClient.GetChargingContract()
+	result, err := contract.EvaluateTransaction("FindNearbyStations", append(area, string(filterJSON), limit)...)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"stations": json.RawMessage(result)})
 }
 
@@ -241,7 +423,7 @@ func (h *ChargingHandler) StartSession(c *gin.Context) {
 	}
 	json.Unmarshal([]byte(userData.(string)), &user)
 
-	sessionId := "charging_session_" + uuid.New().String()
+	sessionId := idempotency.NewID(c, "charging_session_")
 
 	contract := h.fabricClient.GetChargingContract()
 	_, err := contract.SubmitTransaction(
@@ -261,18 +443,14 @@ func (h *ChargingHandler) StartSession(c *gin.Context) {
 	})
 }
 
-// GetSession returns a charging session by ID
+// GetSession returns a charging session by ID to its user, the station's operator or an admin
 func (h *ChargingHandler) GetSession(c *gin.Context) {
-	sessionId := c.Param("id")
-
-	contract := h.fabricClient.GetChargingContract()
-	result, err := contract.EvaluateTransaction("GetChargingSession", sessionId)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Charging session not found"})
+	session, ok := authorizeSession(c, h.fabricClient, c.Param("id"))
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"session": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"session": session.raw})
 }
 
 // UpdateSession updates session progress
@@ -285,18 +463,42 @@ func (h *ChargingHandler) UpdateSession(c *gin.Context) {
 		return
 	}
 
+	if _, ok := authorizeSession(c, h.fabricClient, sessionId); !ok {
+		return
+	}
+	user := currentCaller(c)
+
 	contract := h.fabricClient.GetChargingContract()
-	_, err := contract.SubmitTransaction(
+	result, err := contract.SubmitTransaction(
 		"UpdateSessionProgress",
 		sessionId,
 		fmt.Sprintf("%f", req.EnergyConsumed),
+		user.UserID,
+		user.Role,
 	)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		status := http.StatusBadRequest
+		if isForbidden(err) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	var reading meterReading
+	json.Unmarshal(result, &reading)
+	if !reading.Accepted {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Meter reading rejected, session flagged for dispute: " + reading.Reason,
+			"reading": json.RawMessage(result),
+		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Session updated successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Session updated successfully",
+		"reading": json.RawMessage(result),
+	})
 }
 
 // StopSession stops a charging session and processes payment
@@ -307,85 +509,298 @@ func (h *ChargingHandler) StopSession(c *gin.Context) {
 		return
 	}
 
-	// Get session to calculate cost
-	contract := h.fabricClient.GetChargingContract()
-	sessionResult, err := contract.EvaluateTransaction("GetChargingSession", req.SessionID)
+	// Get session to find the paying user
+	session, ok := authorizeSession(c, h.fabricClient, req.SessionID)
+	if !ok {
+		return
+	}
+
+	user := currentCaller(c)
+	paymentId := idempotency.NewID(c, "payment_")
+	refundId := idempotency.NewID(c, "refund_")
+	sagaId := idempotency.NewID(c, "saga_")
+	stopSaga, err := stopChargingSession(context.WithoutCancel(c.Request.Context()), h.fabricClient, h.sagas, req.SessionID, session.UserID, req.OrganizationID, req.TotalEnergy, paymentId, refundId, sagaId, user.UserID, user.Role)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		status := http.StatusBadRequest
+		if isForbidden(err) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error(), "sagaId": sagaId})
 		return
 	}
+	result := []byte(stopSaga.Data["session"])
 
-	var session struct {
-		UserID      string  `json:"userId"`
-		PricePerKwh float64 `json:"pricePerKwh"`
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Charging session stopped successfully",
+		"session":   json.RawMessage(result),
+		"paymentId": paymentId,
+	})
+}
+
+// walletLineItem is a line item of an itemized wallet payment
+type walletLineItem struct {
+	Type        string  `json:"type"`
+	Description string  `json:"description"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit"`
+	UnitPrice   float64 `json:"unitPrice"`
+	AmountMinor int64   `json:"amountMinor"`
+}
+
+// stopChargingSession prices a session on the ledger, then charges the user's wallet, or
+// that of organizationId if set, and stops the session as one saga so that a failure on
+// the charging channel always ends with the payment refunded. The ledger checks that
+// callerId, acting with callerRole, may stop the session.
+func stopChargingSession(ctx context.Context, fabricClient *fabric.Client, sagas *saga.Coordinator, sessionId, userId, organizationId string, totalEnergy float64, paymentId, refundId, sagaId, callerId, callerRole string) (*saga.Saga, error) {
+	// The ledger prices the session against its tariff schedule
+	contract := fabricClient.GetChargingContract()
+	quoteResult, err := contract.EvaluateTransaction("QuoteChargingSession", sessionId, fmt.Sprintf("%f", totalEnergy))
+	if err != nil {
+		return nil, err
 	}
-	json.Unmarshal(sessionResult, &session)
 
-	totalCost := req.TotalEnergy * session.PricePerKwh
+	var price struct {
+		EndTime   time.Time `json:"endTime"`
+		Amount    float64   `json:"amount"`
+		LineItems []struct {
+			Type        string  `json:"type"`
+			Description string  `json:"description"`
+			Quantity    float64 `json:"quantity"`
+			Unit        string  `json:"unit"`
+			UnitPrice   float64 `json:"unitPrice"`
+			Amount      float64 `json:"amount"`
+		} `json:"lineItems"`
+	}
+	json.Unmarshal(quoteResult, &price)
 
-	// Process payment
-	paymentId := "payment_" + uuid.New().String()
-	walletContract := h.fabricClient.GetWalletContract()
-	walletResult, err := walletContract.EvaluateTransaction("GetWalletByUserId", session.UserID)
+	// The station's operator is credited with the payment
+	stationId, err := sessionStation(fabricClient, sessionId)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Wallet not found"})
-		return
+		return nil, err
+	}
+	operatorId, err := stationOperator(fabricClient, stationId)
+	if err != nil {
+		return nil, err
+	}
+
+	wallet, err := payingWallet(fabricClient, userId, organizationId)
+	if err != nil {
+		return nil, fmt.Errorf("wallet not found")
+	}
+
+	// The wallet bills each line item in minor units of its currency
+	amountMinor := money.FromFloat(price.Amount, wallet.Currency)
+	var lineItems []walletLineItem
+	if len(price.LineItems) > 0 {
+		amountMinor = 0
+	}
+	for _, item := range price.LineItems {
+		itemMinor := money.FromFloat(item.Amount, wallet.Currency)
+		amountMinor += itemMinor
+		lineItems = append(lineItems, walletLineItem{
+			Type:        item.Type,
+			Description: item.Description,
+			Quantity:    item.Quantity,
+			Unit:        item.Unit,
+			UnitPrice:   item.UnitPrice,
+			AmountMinor: itemMinor,
+		})
+	}
+	var lineItemsJSON []byte
+	if len(lineItems) > 0 {
+		lineItemsJSON, err = json.Marshal(lineItems)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	var wallet struct {
-		WalletID string `json:"walletId"`
+	return sagas.Execute(ctx, SagaStopCharging, sagaId, map[string]string{
+		"userId":      userId,
+		"walletId":    wallet.WalletID,
+		"spenderId":   wallet.SpenderID,
+		"locationId":  stationId,
+		"sessionId":   sessionId,
+		"totalEnergy": fmt.Sprintf("%f", totalEnergy),
+		"endTime":     price.EndTime.Format(time.RFC3339),
+		"price":       fmt.Sprintf("%f", price.Amount),
+		"amount":      money.Format(amountMinor, wallet.Currency),
+		"currency":    wallet.Currency,
+		"operatorId":  operatorId,
+		"lineItems":   string(lineItemsJSON),
+		"paymentId":   paymentId,
+		"refundId":    refundId,
+		"paymentType": "charging",
+		"referenceId": sessionId,
+		"description": "Charging session payment",
+		"callerId":    callerId,
+		"callerRole":  callerRole,
+	})
+}
+
+// sessionOperator returns the ID of the operator running a session's station
+func sessionOperator(fabricClient *fabric.Client, sessionId string) (string, error) {
+	stationId, err := sessionStation(fabricClient, sessionId)
+	if err != nil {
+		return "", err
 	}
-	json.Unmarshal(walletResult, &wallet)
+	return stationOperator(fabricClient, stationId)
+}
 
-	_, err = walletContract.SubmitTransaction(
-		"ProcessPayment",
-		paymentId,
-		wallet.WalletID,
-		fmt.Sprintf("%f", totalCost),
-		"charging",
-		req.SessionID,
-		"Charging session payment",
-	)
+// sessionStation returns the ID of the station a session charges at
+func sessionStation(fabricClient *fabric.Client, sessionId string) (string, error) {
+	sessionResult, err := fabricClient.GetChargingContract().EvaluateTransaction("GetChargingSession", sessionId)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment failed: " + err.Error()})
+		return "", err
+	}
+
+	var session struct {
+		StationID string `json:"stationId"`
+	}
+	if err := json.Unmarshal(sessionResult, &session); err != nil {
+		return "", err
+	}
+	return session.StationID, nil
+}
+
+// stationOperator returns the ID of the operator running a station
+func stationOperator(fabricClient *fabric.Client, stationId string) (string, error) {
+	stationResult, err := fabricClient.GetChargingContract().EvaluateTransaction("GetChargingStation", stationId)
+	if err != nil {
+		return "", err
+	}
+
+	var station struct {
+		OperatorID string `json:"operatorId"`
+	}
+	if err := json.Unmarshal(stationResult, &station); err != nil {
+		return "", err
+	}
+	return station.OperatorID, nil
+}
+
+// QuoteSession returns the ledger price of stopping a session now
+func (h *ChargingHandler) QuoteSession(c *gin.Context) {
+	sessionId := c.Param("id")
+	totalEnergy := c.Query("totalEnergy")
+	if totalEnergy == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "totalEnergy is required"})
 		return
 	}
 
-	// Stop session
-	result, err := contract.SubmitTransaction(
-		"StopChargingSession",
-		req.SessionID,
-		fmt.Sprintf("%f", req.TotalEnergy),
-		paymentId,
-	)
+	if _, ok := authorizeSession(c, h.fabricClient, sessionId); !ok {
+		return
+	}
+
+	contract := h.fabricClient.GetChargingContract()
+	result, err := contract.EvaluateTransaction("QuoteChargingSession", sessionId, totalEnergy)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{"quote": json.RawMessage(result)})
+}
+
+// GetSessionReadings returns the meter log of a session and whether its hash chain is intact
+func (h *ChargingHandler) GetSessionReadings(c *gin.Context) {
+	sessionId := c.Param("id")
+
+	if _, ok := authorizeSession(c, h.fabricClient, sessionId); !ok {
+		return
+	}
+
+	contract := h.fabricClient.GetChargingContract()
+	result, err := contract.EvaluateTransaction("GetMeterReadings", sessionId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	verified := true
+	verifyError := ""
+	if _, err := contract.EvaluateTransaction("VerifyMeterReadings", sessionId); err != nil {
+		verified = false
+		verifyError = err.Error()
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message":   "Charging session stopped successfully",
-		"session":   json.RawMessage(result),
-		"paymentId": paymentId,
+		"readings":    json.RawMessage(result),
+		"verified":    verified,
+		"verifyError": verifyError,
 	})
 }
 
-// CancelSession cancels a charging session
+// CancelSession cancels a charging session and refunds what the refund policy grants.
+// The refund is retried in the background if the wallet channel is unavailable.
 func (h *ChargingHandler) CancelSession(c *gin.Context) {
 	sessionId := c.Param("id")
 
-	contract := h.fabricClient.GetChargingContract()
-	_, err := contract.SubmitTransaction("CancelSession", sessionId)
+	if _, ok := authorizeSession(c, h.fabricClient, sessionId); !ok {
+		return
+	}
+	user := currentCaller(c)
+
+	sagaId := idempotency.NewID(c, "saga_")
+	cancelSaga, err := h.sagas.Execute(context.WithoutCancel(c.Request.Context()), SagaCancelSession, sagaId, map[string]string{
+		"sessionId":  sessionId,
+		"callerId":   user.UserID,
+		"callerRole": user.Role,
+	})
+	if err != nil && (cancelSaga == nil || cancelSaga.Status != saga.StatusRunning) {
+		status := http.StatusBadRequest
+		if isForbidden(err) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error(), "sagaId": sagaId})
+		return
+	}
+
+	refundPercent, _ := strconv.Atoi(cancelSaga.Data["refundPercent"])
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":       "Session cancelled, refund pending",
+			"refundPercent": refundPercent,
+			"sagaId":        sagaId,
+		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Session cancelled successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Session cancelled successfully",
+		"refundPercent": refundPercent,
+	})
 }
 
-// GetUserSessions returns all sessions for the current user
+// GetUserSessions returns a page of the charging sessions of the current user,
+// optionally of one station and in some statuses
 func (h *ChargingHandler) GetUserSessions(c *gin.Context) {
+	h.respondSessions(c, statusesQuery(c)...)
+}
+
+// GetActiveSessions returns a page of the active sessions of the current user
+func (h *ChargingHandler) GetActiveSessions(c *gin.Context) {
+	h.respondSessions(c, "active")
+}
+
+// GetSessionHistory returns a page of the session history of the current user
+func (h *ChargingHandler) GetSessionHistory(c *gin.Context) {
+	h.respondSessions(c, "completed", "cancelled")
+}
+
+// respondSessions responds with a page of the current user's sessions in any of the
+// given statuses, or in any status if none are given
+func (h *ChargingHandler) respondSessions(c *gin.Context, statuses ...string) {
+	filter := SessionFilter{
+		UserID:    currentCaller(c).UserID,
+		StationID: c.Query("stationId"),
+		Statuses:  statuses,
+	}
+
+	respondPage(c, h.fabricClient.GetChargingContract(), "SearchSessions", "sessions", filter)
+}
+
+// GetEnergyStats returns energy consumption statistics
+func (h *ChargingHandler) GetEnergyStats(c *gin.Context) {
 	userData, _ := c.Get("user")
 	var user struct {
 		UserID string `json:"userId"`
@@ -393,17 +808,25 @@ func (h *ChargingHandler) GetUserSessions(c *gin.Context) {
 	json.Unmarshal([]byte(userData.(string)), &user)
 
 	contract := h.fabricClient.GetChargingContract()
-	result, err := contract.EvaluateTransaction("GetUserSessions", user.UserID)
+	result, err := contract.EvaluateTransaction("GetTotalEnergyConsumed", user.UserID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"sessions": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"totalEnergyConsumed": string(result)})
 }
 
-// GetActiveSessions returns active sessions for the current user
-func (h *ChargingHandler) GetActiveSessions(c *gin.Context) {
+// ==================== OCPP idTag Endpoints ====================
+
+// RegisterIdTag links an RFID card or token to the current user for OCPP charge points
+func (h *ChargingHandler) RegisterIdTag(c *gin.Context) {
+	var req RegisterIdTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	userData, _ := c.Get("user")
 	var user struct {
 		UserID string `json:"userId"`
@@ -411,47 +834,49 @@ func (h *ChargingHandler) GetActiveSessions(c *gin.Context) {
 	json.Unmarshal([]byte(userData.(string)), &user)
 
 	contract := h.fabricClient.GetChargingContract()
-	result, err := contract.EvaluateTransaction("GetActiveSessions", user.UserID)
+	_, err := contract.SubmitTransaction("RegisterIdTag", req.IdTag, user.UserID, req.ExpiryDate)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"sessions": json.RawMessage(result)})
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "idTag registered successfully",
+		"idTag":   req.IdTag,
+	})
 }
 
-// GetSessionHistory returns session history for the current user
-func (h *ChargingHandler) GetSessionHistory(c *gin.Context) {
-	userData, _ := c.Get("user")
-	var user struct {
-		UserID string `json:"userId"`
-	}
-	json.Unmarshal([]byte(userData.(string)), &user)
+// BlockIdTag blocks an idTag from starting new sessions
+func (h *ChargingHandler) BlockIdTag(c *gin.Context) {
+	idTag := c.Param("idTag")
 
 	contract := h.fabricClient.GetChargingContract()
-	result, err := contract.EvaluateTransaction("GetSessionHistory", user.UserID)
+	_, err := contract.SubmitTransaction("BlockIdTag", idTag)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"sessions": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"message": "idTag blocked successfully"})
 }
 
-// GetEnergyStats returns energy consumption statistics
-func (h *ChargingHandler) GetEnergyStats(c *gin.Context) {
-	userData, _ := c.Get("user")
-	var user struct {
-		UserID string `json:"userId"`
+// Reindex writes the index entries of a page of stations and sessions created before
+// the charging indexes existed. Call again with the returned bookmark until it is empty.
+func (h *ChargingHandler) Reindex(c *gin.Context) {
+	var req ReindexRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		req = ReindexRequest{}
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = 100
 	}
-	json.Unmarshal([]byte(userData.(string)), &user)
 
 	contract := h.fabricClient.GetChargingContract()
-	result, err := contract.EvaluateTransaction("GetTotalEnergyConsumed", user.UserID)
+	result, err := contract.SubmitTransaction("ReindexCharging", strconv.Itoa(req.PageSize), req.Bookmark)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"totalEnergyConsumed": string(result)})
+	c.JSON(http.StatusOK, gin.H{"reindex": json.RawMessage(result)})
 }
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/idempotency"
+)
+
+// FacilityHandler handles parking facilities, the garages, lots and street zones spots
+// are grouped in
+type FacilityHandler struct {
+	fabricClient *fabric.Client
+}
+
+// NewFacilityHandler creates a new facility handler
+func NewFacilityHandler(fabricClient *fabric.Client) *FacilityHandler {
+	return &FacilityHandler{
+		fabricClient: fabricClient,
+	}
+}
+
+// GeoPoint represents a vertex of a facility's boundary
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// FacilityDetails are the fields of a facility that can be changed after creation
+type FacilityDetails struct {
+	Name             string         `json:"name" binding:"required"`
+	Address          string         `json:"address"`
+	Polygon          []GeoPoint     `json:"polygon"` // boundary, at least 3 points if set
+	UTCOffsetMinutes int            `json:"utcOffsetMinutes"`
+	OpeningHours     []OpeningHours `json:"openingHours"` // empty when always open
+	MaxHeight        float64        `json:"maxHeight"`    // meters, 0 for no limit
+	EntryRules       []string       `json:"entryRules"`
+}
+
+// CreateFacilityRequest represents create facility request
+type CreateFacilityRequest struct {
+	FacilityDetails
+	OperatorID string `json:"operatorId"`
+}
+
+// AssignFacilityRequest moves a spot into a facility, or out of any with an empty ID
+type AssignFacilityRequest struct {
+	FacilityID string `json:"facilityId"`
+}
+
+// CreateFacility creates a new parking facility
+func (h *FacilityHandler) CreateFacility(c *gin.Context) {
+	var req CreateFacilityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	facilityId := idempotency.NewID(c, "facility_")
+
+	args := append([]string{facilityId}, req.FacilityDetails.args()...)
+	args = append(args, req.OperatorID)
+
+	contract := h.fabricClient.GetParkingContract()
+	_, err := contract.SubmitTransaction("CreateFacility", args...)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Facility created successfully",
+		"facilityId": facilityId,
+	})
+}
+
+// GetFacility returns a facility by ID
+func (h *FacilityHandler) GetFacility(c *gin.Context) {
+	facilityId := c.Param("id")
+
+	contract := h.fabricClient.GetParkingContract()
+	result, err := contract.EvaluateTransaction("GetFacility", facilityId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Facility not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"facility": json.RawMessage(result)})
+}
+
+// GetAllFacilities returns all facilities, including deactivated ones
+func (h *FacilityHandler) GetAllFacilities(c *gin.Context) {
+	contract := h.fabricClient.GetParkingContract()
+	result, err := contract.EvaluateTransaction("GetAllFacilities")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"facilities": json.RawMessage(result)})
+}
+
+// UpdateFacility replaces the details of a facility
+func (h *FacilityHandler) UpdateFacility(c *gin.Context) {
+	facilityId := c.Param("id")
+
+	var req FacilityDetails
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contract := h.fabricClient.GetParkingContract()
+	_, err := contract.SubmitTransaction("UpdateFacility", append([]string{facilityId}, req.args()...)...)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Facility updated successfully"})
+}
+
+// DeleteFacility deactivates a facility
+func (h *FacilityHandler) DeleteFacility(c *gin.Context) {
+	facilityId := c.Param("id")
+
+	contract := h.fabricClient.GetParkingContract()
+	_, err := contract.SubmitTransaction("DeleteFacility", facilityId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Facility deleted successfully"})
+}
+
+// GetFacilitySpots returns a page of the spots of a facility, optionally in one status
+func (h *FacilityHandler) GetFacilitySpots(c *gin.Context) {
+	filter := SpotFilter{FacilityID: c.Param("id"), Status: c.Query("status")}
+	respondPage(c, h.fabricClient.GetParkingContract(), "SearchSpots", "spots", filter)
+}
+
+// AssignSpot moves a spot into a facility, or out of any
+func (h *FacilityHandler) AssignSpot(c *gin.Context) {
+	spotId := c.Param("id")
+
+	var req AssignFacilityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contract := h.fabricClient.GetParkingContract()
+	_, err := contract.SubmitTransaction("AssignSpotToFacility", spotId, req.FacilityID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Parking spot facility updated successfully"})
+}
+
+// GetFacilityOccupancy returns how many spots of a facility are free, occupied,
+// reserved or under maintenance now
+func (h *FacilityHandler) GetFacilityOccupancy(c *gin.Context) {
+	facilityId := c.Param("id")
+
+	contract := h.fabricClient.GetParkingContract()
+	result, err := contract.EvaluateTransaction("GetFacilityOccupancy", facilityId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Facility not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"occupancy": json.RawMessage(result)})
+}
+
+// GetFacilitiesOccupancy returns the occupancy of every active facility now
+func (h *FacilityHandler) GetFacilitiesOccupancy(c *gin.Context) {
+	contract := h.fabricClient.GetParkingContract()
+	result, err := contract.EvaluateTransaction("GetFacilitiesOccupancy")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"facilities": json.RawMessage(result)})
+}
+
+// args returns the chaincode arguments of facility details, in the order CreateFacility
+// and UpdateFacility take them
+func (d FacilityDetails) args() []string {
+	if d.Polygon == nil {
+		d.Polygon = []GeoPoint{}
+	}
+	if d.EntryRules == nil {
+		d.EntryRules = []string{}
+	}
+
+	polygonJSON, _ := json.Marshal(d.Polygon)
+	entryRulesJSON, _ := json.Marshal(d.EntryRules)
+
+	return []string{
+		d.Name,
+		d.Address,
+		string(polygonJSON),
+		strconv.Itoa(d.UTCOffsetMinutes),
+		openingHoursJSON(d.OpeningHours),
+		fmt.Sprintf("%f", d.MaxHeight),
+		string(entryRulesJSON),
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultRadius is the radius, in meters, of nearby searches when none is requested;
+// the chaincode allows up to 10 km
+const defaultRadius = 500
+
+// nearbyArea reads the lat, lng and radius query parameters of a nearby search as
+// transaction arguments. It responds 400 and returns false if they are missing or not
+// numbers.
+func nearbyArea(c *gin.Context) ([]string, bool) {
+	if c.Query("lat") == "" || c.Query("lng") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lat and lng are required"})
+		return nil, false
+	}
+	latitude, ok := floatQuery(c, "lat")
+	if !ok {
+		return nil, false
+	}
+	longitude, ok := floatQuery(c, "lng")
+	if !ok {
+		return nil, false
+	}
+	radius, ok := floatQuery(c, "radius")
+	if !ok {
+		return nil, false
+	}
+	if radius == 0 {
+		radius = defaultRadius
+	}
+
+	return []string{
+		fmt.Sprintf("%f", latitude),
+		fmt.Sprintf("%f", longitude),
+		fmt.Sprintf("%f", radius),
+	}, true
+}
+
+// limitQuery reads the limit query parameter of a nearby search, defaulting to the
+// default page size
+func limitQuery(c *gin.Context) (string, bool) {
+	limit, ok := intQuery(c, "limit")
+	if !ok {
+		return "", false
+	}
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	return strconv.Itoa(limit), true
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/auth"
+)
+
+// mfaIssuer names the account in authenticator apps
+const mfaIssuer = "CityFlow"
+
+// errInvalidMFACode is returned for a TOTP or recovery code that was wrong or used
+var errInvalidMFACode = errors.New("invalid MFA code")
+
+// MFACodeRequest carries a TOTP code, or a recovery code where one is accepted
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// MFALoginRequest represents the second step of a login with MFA
+type MFALoginRequest struct {
+	MFAToken string `json:"mfaToken" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// mfaRecord is the TOTP enrollment of a user as stored on the ledger
+type mfaRecord struct {
+	EncryptedSecret    string   `json:"encryptedSecret"`
+	Enabled            bool     `json:"enabled"`
+	RecoveryCodeHashes []string `json:"recoveryCodeHashes"`
+}
+
+// LoginMFA completes a login with MFA: it exchanges the challenge from Login and a
+// TOTP or recovery code for tokens
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	challenge, err := h.tokens.VerifyChallenge(req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA challenge, log in again"})
+		return
+	}
+
+	contract := h.fabricClient.GetUserContract()
+	userResult, err := contract.EvaluateTransaction("GetUser", challenge.Subject)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+	var user User
+	if err := json.Unmarshal(userResult, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user data"})
+		return
+	}
+	if !user.IsActive {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is inactive"})
+		return
+	}
+	if h.isLocked(c, &user) {
+		return
+	}
+
+	// Wrong codes count towards the lockout like wrong passwords
+	if err := h.verifySecondFactor(challenge.Subject, req.Code, true); err != nil {
+		h.failLogin(c, user.UserID, "Invalid MFA code")
+		return
+	}
+
+	h.startSession(c, &user, "Login successful")
+}
+
+// GetMFAStatus returns whether the current user has MFA enabled, and how many
+// recovery codes they have left
+func (h *AuthHandler) GetMFAStatus(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	mfa, err := h.getMFA(claims.Subject)
+	if err != nil || !mfa.Enabled {
+		c.JSON(http.StatusOK, gin.H{"enabled": false, "required": auth.RequiresMFA(claims.Role)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                true,
+		"required":               auth.RequiresMFA(claims.Role),
+		"recoveryCodesRemaining": len(mfa.RecoveryCodeHashes),
+	})
+}
+
+// EnrollMFA generates a TOTP secret for the current user. It only takes effect once
+// confirmed with a code at /auth/mfa/activate.
+func (h *AuthHandler) EnrollMFA(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enroll in MFA"})
+		return
+	}
+	sealed, err := h.mfaSecrets.Seal(secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enroll in MFA"})
+		return
+	}
+
+	contract := h.fabricClient.GetUserContract()
+	if _, err := contract.SubmitTransaction("EnrollMFA", claims.Subject, sealed); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":     secret,
+		"otpauthUrl": auth.TOTPURI(mfaIssuer, claims.Email, secret),
+		"message":    "Add the secret to an authenticator app, then confirm with a code",
+	})
+}
+
+// ActivateMFA enables the pending enrollment of the current user with a code from it.
+// It responds with the recovery codes, shown only this once, and an access token
+// reflecting MFA.
+func (h *AuthHandler) ActivateMFA(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mfa, err := h.getMFA(claims.Subject)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Enroll in MFA first"})
+		return
+	}
+	if mfa.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+		return
+	}
+
+	secret, err := h.mfaSecrets.Open(mfa.EncryptedSecret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read MFA secret"})
+		return
+	}
+	step, ok := auth.ValidateTOTP(secret, req.Code, time.Now())
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid MFA code"})
+		return
+	}
+
+	codes, hashes, err := h.newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	contract := h.fabricClient.GetUserContract()
+	if _, err := contract.SubmitTransaction("ActivateMFA", claims.Subject, strconv.FormatInt(step, 10), hashes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Replace the access token so that staff routes accept it right away
+	upgraded := *claims
+	upgraded.MFA = true
+	accessToken, _, err := h.tokens.Issue(upgraded)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "MFA enabled",
+		"recoveryCodes": codes,
+		"token":         accessToken,
+		"expiresIn":     int(h.tokens.TTL().Seconds()),
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the current user, given a
+// TOTP code
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.verifySecondFactor(claims.Subject, req.Code, false); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA code"})
+		return
+	}
+
+	codes, hashes, err := h.newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	contract := h.fabricClient.GetUserContract()
+	if _, err := contract.SubmitTransaction("ReplaceRecoveryCodes", claims.Subject, hashes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Recovery codes replaced", "recoveryCodes": codes})
+}
+
+// DisableMFA removes the TOTP enrollment of the current user, given a TOTP or
+// recovery code. Staff must keep MFA enabled.
+func (h *AuthHandler) DisableMFA(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+	if auth.RequiresMFA(claims.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Staff accounts must keep MFA enabled"})
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.verifySecondFactor(claims.Subject, req.Code, true); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA code"})
+		return
+	}
+
+	contract := h.fabricClient.GetUserContract()
+	if _, err := contract.SubmitTransaction("DisableMFA", claims.Subject); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "MFA disabled"})
+}
+
+// verifySecondFactor checks a TOTP code of a user with MFA enabled and records it as
+// used. With allowRecovery, a recovery code is accepted instead and consumed.
+func (h *AuthHandler) verifySecondFactor(userId, code string, allowRecovery bool) error {
+	mfa, err := h.getMFA(userId)
+	if err != nil || !mfa.Enabled {
+		return errInvalidMFACode
+	}
+
+	contract := h.fabricClient.GetUserContract()
+
+	secret, err := h.mfaSecrets.Open(mfa.EncryptedSecret)
+	if err != nil {
+		return err
+	}
+	if step, ok := auth.ValidateTOTP(secret, code, time.Now()); ok {
+		// The ledger rejects a step already used, so a code works only once
+		if _, err := contract.SubmitTransaction("RecordMFAStep", userId, strconv.FormatInt(step, 10)); err != nil {
+			return errInvalidMFACode
+		}
+		return nil
+	}
+
+	if !allowRecovery {
+		return errInvalidMFACode
+	}
+	if _, err := contract.SubmitTransaction("UseRecoveryCode", userId, auth.HashRecoveryCode(h.settings.TokenKey, code)); err != nil {
+		return errInvalidMFACode
+	}
+	return nil
+}
+
+// getMFA reads the TOTP enrollment of a user
+func (h *AuthHandler) getMFA(userId string) (*mfaRecord, error) {
+	result, err := h.fabricClient.GetUserContract().EvaluateTransaction("GetMFA", userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var mfa mfaRecord
+	if err := json.Unmarshal(result, &mfa); err != nil {
+		return nil, err
+	}
+	return &mfa, nil
+}
+
+// newRecoveryCodes generates recovery codes, returning them for the user and their
+// hashes as the JSON array the ledger stores
+func (h *AuthHandler) newRecoveryCodes() ([]string, string, error) {
+	codes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		return nil, "", err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(h.settings.TokenKey, code)
+	}
+	hashesJSON, err := json.Marshal(hashes)
+	if err != nil {
+		return nil, "", err
+	}
+	return codes, string(hashesJSON), nil
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/ocpp"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/saga"
+)
+
+// ocppNamespace is the namespace of the IDs derived from OCPP transactions
+var ocppNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://cityflow.com/ocpp"))
+
+// OCPPHandler maps the requests of OCPP charge points onto the charging chaincode.
+// The charge point ID is the ID of the charging station on the ledger.
+type OCPPHandler struct {
+	fabricClient *fabric.Client
+	sagas        *saga.Coordinator
+}
+
+// NewOCPPHandler creates a new OCPP handler
+func NewOCPPHandler(fabricClient *fabric.Client, sagas *saga.Coordinator) *OCPPHandler {
+	return &OCPPHandler{
+		fabricClient: fabricClient,
+		sagas:        sagas,
+	}
+}
+
+// ocppSession holds the session fields the OCPP handler needs
+type ocppSession struct {
+	SessionID  string  `json:"sessionId"`
+	UserID     string  `json:"userId"`
+	IdTag      string  `json:"idTag"`
+	Status     string  `json:"status"`
+	MeterStart float64 `json:"meterStart"` // kWh
+}
+
+// AuthenticateChargePoint checks the password of a charge point against the hash stored
+// for its station. Stations without a credential cannot connect.
+func (h *OCPPHandler) AuthenticateChargePoint(ctx context.Context, chargePointId, password string) (bool, error) {
+	contract := h.fabricClient.GetChargingContract()
+	passwordHash, err := contract.EvaluateTransaction("GetStationCredential", chargePointId)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return bcrypt.CompareHashAndPassword(passwordHash, []byte(password)) == nil, nil
+}
+
+// BootNotification accepts charge points registered as charging stations
+func (h *OCPPHandler) BootNotification(ctx context.Context, chargePointId string, req *ocpp.BootNotificationRequest) (*ocpp.BootNotificationResponse, error) {
+	status := "Accepted"
+
+	contract := h.fabricClient.GetChargingContract()
+	if _, err := contract.EvaluateTransaction("GetChargingStation", chargePointId); err != nil {
+		if !isNotFound(err) {
+			return nil, err
+		}
+		status = "Rejected"
+	}
+
+	return &ocpp.BootNotificationResponse{
+		Status:      status,
+		CurrentTime: time.Now().UTC(),
+		Interval:    ocpp.HeartbeatInterval,
+	}, nil
+}
+
+// StatusNotification updates the station status. Statuses of a transaction in
+// progress are left to the session, which already marks the station in use.
+func (h *OCPPHandler) StatusNotification(ctx context.Context, chargePointId string, req *ocpp.StatusNotificationRequest) error {
+	var status string
+	switch req.Status {
+	case "Available":
+		status = "available"
+	case "Unavailable":
+		status = "maintenance"
+	case "Faulted":
+		status = "out-of-service"
+	default:
+		return nil
+	}
+
+	contract := h.fabricClient.GetChargingContract()
+	_, err := contract.SubmitTransaction("UpdateStationStatus", chargePointId, status)
+	return err
+}
+
+// Authorize checks an idTag against the ledger
+func (h *OCPPHandler) Authorize(ctx context.Context, chargePointId string, req *ocpp.AuthorizeRequest) (*ocpp.IdTagInfo, error) {
+	status, err := h.authorize(req.IdTag)
+	if err != nil {
+		return nil, err
+	}
+	return &ocpp.IdTagInfo{Status: status}, nil
+}
+
+// StartTransaction starts a charging session for the owner of the idTag
+func (h *OCPPHandler) StartTransaction(ctx context.Context, chargePointId string, req *ocpp.StartTransactionRequest) (*ocpp.StartTransactionResponse, error) {
+	status, err := h.authorize(req.IdTag)
+	if err != nil {
+		return nil, err
+	}
+	// The charge point must stop a transaction that is not accepted
+	if status != ocpp.AuthorizationAccepted {
+		return &ocpp.StartTransactionResponse{IdTagInfo: ocpp.IdTagInfo{Status: status}}, nil
+	}
+
+	transactionId, err := newTransactionId()
+	if err != nil {
+		return nil, err
+	}
+	sessionId := ocppID("charging_session_", chargePointId, transactionId)
+
+	contract := h.fabricClient.GetChargingContract()
+	_, err = contract.SubmitTransaction(
+		"CreateOcppSession",
+		sessionId,
+		chargePointId,
+		req.IdTag,
+		strconv.Itoa(transactionId),
+		fmt.Sprintf("%f", float64(req.MeterStart)/1000),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ocpp.StartTransactionResponse{
+		TransactionID: transactionId,
+		IdTagInfo:     ocpp.IdTagInfo{Status: ocpp.AuthorizationAccepted},
+	}, nil
+}
+
+// MeterValues records the energy delivered so far from the meter's energy register
+func (h *OCPPHandler) MeterValues(ctx context.Context, chargePointId string, req *ocpp.MeterValuesRequest) error {
+	// Meter values outside a transaction carry no session progress
+	if req.TransactionID == nil {
+		return nil
+	}
+
+	reading, ok := latestEnergyReading(req.MeterValue)
+	if !ok {
+		return nil
+	}
+
+	session, err := h.getSession(chargePointId, *req.TransactionID)
+	if err != nil {
+		return err
+	}
+	if session.Status != "active" {
+		return nil
+	}
+
+	contract := h.fabricClient.GetChargingContract()
+	result, err := contract.SubmitTransaction(
+		"UpdateSessionProgress",
+		session.SessionID,
+		fmt.Sprintf("%f", deliveredEnergy(reading, session.MeterStart)),
+		chargePointId,
+		systemRole,
+	)
+	if err != nil {
+		return err
+	}
+
+	// The reading is logged either way; the charge point cannot act on a dispute
+	var logged meterReading
+	json.Unmarshal(result, &logged)
+	if !logged.Accepted {
+		log.Printf("OCPP %s transaction %d: meter reading rejected, session %s disputed: %s",
+			chargePointId, *req.TransactionID, session.SessionID, logged.Reason)
+	}
+	return nil
+}
+
+// StopTransaction charges the user's wallet and stops the session
+func (h *OCPPHandler) StopTransaction(ctx context.Context, chargePointId string, req *ocpp.StopTransactionRequest) (*ocpp.StopTransactionResponse, error) {
+	session, err := h.getSession(chargePointId, req.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Charge points resend StopTransaction until it is answered
+	if session.Status == "active" {
+		paymentId := ocppID("payment_", chargePointId, req.TransactionID)
+		refundId := ocppID("refund_", chargePointId, req.TransactionID)
+		sagaId := ocppID("saga_", chargePointId, req.TransactionID)
+		_, err = stopChargingSession(ctx, h.fabricClient, h.sagas, session.SessionID, session.UserID, "", deliveredEnergy(float64(req.MeterStop), session.MeterStart), paymentId, refundId, sagaId, chargePointId, systemRole)
+		if err != nil {
+			return nil, fmt.Errorf("saga %s: %v", sagaId, err)
+		}
+	}
+
+	response := &ocpp.StopTransactionResponse{}
+	if req.IdTag != "" {
+		status, err := h.authorize(req.IdTag)
+		if err != nil {
+			return nil, err
+		}
+		response.IdTagInfo = &ocpp.IdTagInfo{Status: status}
+	}
+	return response, nil
+}
+
+func (h *OCPPHandler) authorize(idTag string) (string, error) {
+	contract := h.fabricClient.GetChargingContract()
+	result, err := contract.EvaluateTransaction("AuthorizeIdTag", idTag)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+func (h *OCPPHandler) getSession(chargePointId string, transactionId int) (*ocppSession, error) {
+	contract := h.fabricClient.GetChargingContract()
+	result, err := contract.EvaluateTransaction("GetSessionByTransactionId", chargePointId, strconv.Itoa(transactionId))
+	if err != nil {
+		return nil, err
+	}
+
+	var session ocppSession
+	if err := json.Unmarshal(result, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// latestEnergyReading returns the last energy register reading in Wh
+func latestEnergyReading(meterValues []ocpp.MeterValue) (float64, bool) {
+	reading, found := 0.0, false
+	for _, meterValue := range meterValues {
+		if value, ok := meterValue.EnergyWh(); ok {
+			reading, found = value, true
+		}
+	}
+	return reading, found
+}
+
+// deliveredEnergy converts a meter reading in Wh to the kWh delivered since meterStart
+func deliveredEnergy(readingWh, meterStart float64) float64 {
+	return math.Max(readingWh/1000-meterStart, 0)
+}
+
+// ocppID returns the ledger ID such as "payment_<uuid>" of a record created for an OCPP
+// transaction. The UUID is derived from the charge point, transaction and prefix, so a
+// request the charge point resends reuses the IDs of the first attempt.
+func ocppID(prefix, chargePointId string, transactionId int) string {
+	return prefix + uuid.NewSHA1(ocppNamespace, []byte(chargePointId+"/"+strconv.Itoa(transactionId)+"/"+prefix)).String()
+}
+
+// newTransactionId returns a random positive OCPP transaction ID
+func newTransactionId() (int, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint32(b[:])%math.MaxInt32) + 1, nil
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/settlement"
+)
+
+// OperatorHandler handles operator revenue, commission and settlement endpoints
+type OperatorHandler struct {
+	fabricClient *fabric.Client
+	scheduler    *settlement.Scheduler
+}
+
+// NewOperatorHandler creates a new operator handler
+func NewOperatorHandler(fabricClient *fabric.Client, scheduler *settlement.Scheduler) *OperatorHandler {
+	return &OperatorHandler{
+		fabricClient: fabricClient,
+		scheduler:    scheduler,
+	}
+}
+
+// SetCommissionRequest represents a request to set the platform commission. An empty
+// operator ID sets the default rate of operators without their own.
+type SetCommissionRequest struct {
+	OperatorID  string `json:"operatorId"`
+	BasisPoints *int   `json:"basisPoints" binding:"required"` // 100 = 1%
+}
+
+// ==================== Operator Endpoints ====================
+
+// GetRevenue returns the operator's pending and settled revenue in each currency
+func (h *OperatorHandler) GetRevenue(c *gin.Context) {
+	operatorId := currentOperator(c)
+
+	contract := h.fabricClient.GetWalletContract()
+	result, err := contract.EvaluateTransaction("GetOperatorRevenue", operatorId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"operatorId": operatorId, "revenue": decimalRecord(result)})
+}
+
+// GetStatements returns the operator's settlement statements
+func (h *OperatorHandler) GetStatements(c *gin.Context) {
+	operatorId := currentOperator(c)
+
+	contract := h.fabricClient.GetWalletContract()
+	result, err := contract.EvaluateTransaction("GetOperatorStatements", operatorId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"operatorId": operatorId, "statements": decimalRecord(result)})
+}
+
+// GetStatement returns one of the operator's settlement statements
+func (h *OperatorHandler) GetStatement(c *gin.Context) {
+	statementId := c.Param("id")
+
+	contract := h.fabricClient.GetWalletContract()
+	result, err := contract.EvaluateTransaction("GetSettlementStatement", statementId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Statement not found"})
+		return
+	}
+
+	var statement struct {
+		OperatorID string `json:"operatorId"`
+	}
+	json.Unmarshal(result, &statement)
+	if statement.OperatorID != currentOperator(c) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Statement not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"statement": decimalRecord(result)})
+}
+
+// ==================== Admin Endpoints ====================
+
+// GetCommission returns the commission rate applied to an operator (admin only)
+func (h *OperatorHandler) GetCommission(c *gin.Context) {
+	contract := h.fabricClient.GetWalletContract()
+	result, err := contract.EvaluateTransaction("GetCommission", c.Query("operatorId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"commission": json.RawMessage(result)})
+}
+
+// SetCommission sets the default or an operator's commission rate (admin only)
+func (h *OperatorHandler) SetCommission(c *gin.Context) {
+	var req SetCommissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if *req.BasisPoints < 0 || *req.BasisPoints > 10000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "basisPoints must be between 0 and 10000"})
+		return
+	}
+
+	contract := h.fabricClient.GetWalletContract()
+	_, err := contract.SubmitTransaction("SetCommission", req.OperatorID, strconv.Itoa(*req.BasisPoints))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Commission updated",
+		"operatorId":  req.OperatorID,
+		"basisPoints": *req.BasisPoints,
+	})
+}
+
+// RunSettlement settles every operator for the last completed period now, rather than
+// waiting for the scheduler (admin only)
+func (h *OperatorHandler) RunSettlement(c *gin.Context) {
+	result, err := h.scheduler.RunOnce(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resultJSON, _ := json.Marshal(result)
+	c.JSON(http.StatusOK, gin.H{"settlement": decimalRecord(resultJSON)})
+}
+
+// currentOperator returns the operator whose revenue is requested: the current user,
+// or for admins the operatorId query parameter if set
+func currentOperator(c *gin.Context) string {
+	userData, _ := c.Get("user")
+	var user struct {
+		UserID string `json:"userId"`
+		Role   string `json:"role"`
+	}
+	json.Unmarshal([]byte(userData.(string)), &user)
+
+	if operatorId := c.Query("operatorId"); operatorId != "" && user.Role == "admin" {
+		return operatorId
+	}
+	return user.UserID
+}
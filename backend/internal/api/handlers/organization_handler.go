@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/idempotency"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/money"
+)
+
+// Roles of organization members, as the user chaincode names them
+const (
+	orgRoleOwner   = "owner"
+	orgRoleManager = "manager"
+	orgRoleDriver  = "driver"
+)
+
+// OrganizationHandler handles fleet organizations, their members and the spending
+// limits of members on the organization's wallet. Memberships live on the user
+// channel and spending delegations on the wallet channel.
+type OrganizationHandler struct {
+	fabricClient *fabric.Client
+}
+
+// NewOrganizationHandler creates a new organization handler
+func NewOrganizationHandler(fabricClient *fabric.Client) *OrganizationHandler {
+	return &OrganizationHandler{
+		fabricClient: fabricClient,
+	}
+}
+
+// CreateOrganizationRequest creates an organization owned by the current user
+type CreateOrganizationRequest struct {
+	Name     string `json:"name" binding:"required"`
+	Currency string `json:"currency"` // ISO 4217 code of its wallet, USD by default
+}
+
+// AddMemberRequest adds a registered user to an organization
+type AddMemberRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	MemberSettings
+}
+
+// MemberSettings are the role of a member and their limits on the organization's wallet
+type MemberSettings struct {
+	Role             string   `json:"role" binding:"required"`         // manager or driver
+	MonthlyLimit     string   `json:"monthlyLimit" binding:"required"` // decimal, in the wallet's currency
+	AllowedLocations []string `json:"allowedLocations"`                // spot or station IDs, empty for anywhere
+}
+
+// orgMember holds the membership fields the handlers inspect
+type orgMember struct {
+	OrgID    string `json:"orgId"`
+	UserID   string `json:"userId"`
+	Role     string `json:"role"`
+	IsActive bool   `json:"isActive"`
+}
+
+func (m *orgMember) isManager() bool {
+	return m.Role == orgRoleOwner || m.Role == orgRoleManager
+}
+
+// payer is the wallet a booking or charging session is paid from. SpenderID is set
+// when a member pays from their organization's wallet under its spending limits.
+type payer struct {
+	WalletID  string `json:"walletId"`
+	Currency  string `json:"currency"`
+	SpenderID string `json:"-"`
+}
+
+// payingWallet returns the wallet of userId, or that of organizationId if set, which
+// userId then spends from as a member
+func payingWallet(fabricClient *fabric.Client, userId, organizationId string) (*payer, error) {
+	ownerId := userId
+	if organizationId != "" {
+		ownerId = organizationId
+	}
+
+	result, err := fabricClient.GetWalletContract().EvaluateTransaction("GetWalletByUserId", ownerId)
+	if err != nil {
+		return nil, err
+	}
+
+	var wallet payer
+	if err := json.Unmarshal(result, &wallet); err != nil {
+		return nil, err
+	}
+	if organizationId != "" {
+		wallet.SpenderID = userId
+	}
+	return &wallet, nil
+}
+
+// extensionPayer returns the wallet that pays to extend what paymentId paid for: the
+// same organization wallet and member if it was spent from one, or the wallet of userId
+func extensionPayer(fabricClient *fabric.Client, userId, paymentId string) (*payer, error) {
+	if paymentId != "" {
+		result, err := fabricClient.GetWalletContract().EvaluateTransaction("GetPayment", paymentId)
+		if err != nil {
+			return nil, err
+		}
+		var payment paymentRecord
+		json.Unmarshal(result, &payment)
+		if payment.SpenderID != "" {
+			return &payer{WalletID: payment.WalletID, Currency: payment.Currency, SpenderID: payment.SpenderID}, nil
+		}
+	}
+	return payingWallet(fabricClient, userId, "")
+}
+
+// ==================== Organizations ====================
+
+// CreateOrganization creates an organization owned by the current user, and its wallet
+func (h *OrganizationHandler) CreateOrganization(c *gin.Context) {
+	var req CreateOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = money.DefaultCurrency
+	}
+	if _, err := money.Parse("0", req.Currency); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := currentCaller(c)
+	orgId := idempotency.NewID(c, "org_")
+
+	userContract := h.fabricClient.GetUserContract()
+	result, err := userContract.SubmitTransaction("CreateOrganization", orgId, req.Name, user.UserID)
+	if err != nil {
+		// A retry of a request whose organization was already created
+		existing, getErr := userContract.EvaluateTransaction("GetOrganization", orgId)
+		if getErr != nil {
+			ledgerError(c, err)
+			return
+		}
+		result = existing
+	}
+
+	// The wallet belongs to the organization ID, like a user's belongs to theirs
+	walletContract := h.fabricClient.GetWalletContract()
+	walletId := idempotency.NewID(c, "wallet_")
+	if _, err := walletContract.SubmitTransaction("CreateWallet", walletId, orgId, "0", req.Currency); err != nil {
+		if _, getErr := walletContract.EvaluateTransaction("GetWalletByUserId", orgId); getErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Organization created but its wallet could not be: " + err.Error(), "organizationId": orgId})
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Organization created successfully",
+		"organization": json.RawMessage(result),
+		"walletId":     walletId,
+		"currency":     req.Currency,
+	})
+}
+
+// GetOrganizations returns the organizations the current user is a member of
+func (h *OrganizationHandler) GetOrganizations(c *gin.Context) {
+	contract := h.fabricClient.GetUserContract()
+	result, err := contract.EvaluateTransaction("GetUserOrganizations", currentCaller(c).UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"organizations": json.RawMessage(result)})
+}
+
+// GetOrganization returns an organization to its members, with its wallet for managers
+func (h *OrganizationHandler) GetOrganization(c *gin.Context) {
+	orgId := c.Param("id")
+	member, ok := authorizeOrganization(c, h.fabricClient, orgId, false)
+	if !ok {
+		return
+	}
+
+	result, err := h.fabricClient.GetUserContract().EvaluateTransaction("GetOrganization", orgId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+
+	response := gin.H{"organization": json.RawMessage(result), "role": member.Role}
+	if member.isManager() {
+		if walletResult, err := h.fabricClient.GetWalletContract().EvaluateTransaction("GetWalletByUserId", orgId); err == nil {
+			response["wallet"] = decimalRecord(walletResult)
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ==================== Members ====================
+
+// GetMembers returns the members of an organization and their spending limits (managers)
+func (h *OrganizationHandler) GetMembers(c *gin.Context) {
+	orgId := c.Param("id")
+	if _, ok := authorizeOrganization(c, h.fabricClient, orgId, true); !ok {
+		return
+	}
+
+	members, err := h.fabricClient.GetUserContract().EvaluateTransaction("GetOrgMembers", orgId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	wallet, err := payingWallet(h.fabricClient, "", orgId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization wallet not found"})
+		return
+	}
+	delegations, err := h.fabricClient.GetWalletContract().EvaluateTransaction("GetWalletDelegations", wallet.WalletID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"members":     json.RawMessage(members),
+		"delegations": decimalRecord(delegations),
+	})
+}
+
+// GetMember returns a member and what they spent from the organization's wallet this
+// month, to the member themselves or a manager
+func (h *OrganizationHandler) GetMember(c *gin.Context) {
+	orgId, userId := c.Param("id"), c.Param("userId")
+	caller, ok := authorizeOrganization(c, h.fabricClient, orgId, false)
+	if !ok {
+		return
+	}
+	if caller.UserID != userId && !caller.isManager() {
+		forbidden(c, "member")
+		return
+	}
+
+	member, err := h.fabricClient.GetUserContract().EvaluateTransaction("GetOrgMember", orgId, userId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	response := gin.H{"member": json.RawMessage(member)}
+	wallet, err := payingWallet(h.fabricClient, userId, orgId)
+	if err == nil {
+		contract := h.fabricClient.GetWalletContract()
+		if delegation, err := contract.EvaluateTransaction("GetDelegation", wallet.WalletID, userId); err == nil {
+			response["delegation"] = decimalRecord(delegation)
+		}
+
+		month := time.Now().UTC().Format("2006-01")
+		if spent, err := contract.EvaluateTransaction("GetDelegatedSpend", wallet.WalletID, userId, month); err == nil {
+			if spentMinor, err := strconv.ParseInt(string(spent), 10, 64); err == nil {
+				response["month"] = month
+				response["spentThisMonth"] = money.Format(spentMinor, wallet.Currency)
+				response["currency"] = wallet.Currency
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// AddMember adds a registered user to an organization with a spending limit (managers)
+func (h *OrganizationHandler) AddMember(c *gin.Context) {
+	var req AddMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userResult, err := h.fabricClient.GetUserContract().EvaluateTransaction("GetUserByEmail", req.Email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No user is registered with this email"})
+		return
+	}
+	var user struct {
+		UserID string `json:"userId"`
+	}
+	json.Unmarshal(userResult, &user)
+
+	h.setMember(c, c.Param("id"), user.UserID, req.MemberSettings, http.StatusCreated)
+}
+
+// UpdateMember changes the role or spending limits of a member (managers)
+func (h *OrganizationHandler) UpdateMember(c *gin.Context) {
+	var req MemberSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.setMember(c, c.Param("id"), c.Param("userId"), req, http.StatusOK)
+}
+
+// RemoveMember removes a member from an organization and revokes their spending on its
+// wallet (managers). Only the owner may remove managers.
+func (h *OrganizationHandler) RemoveMember(c *gin.Context) {
+	orgId, userId := c.Param("id"), c.Param("userId")
+	caller, ok := authorizeOrganization(c, h.fabricClient, orgId, true)
+	if !ok {
+		return
+	}
+
+	userContract := h.fabricClient.GetUserContract()
+	memberResult, err := userContract.EvaluateTransaction("GetOrgMember", orgId, userId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	var member orgMember
+	json.Unmarshal(memberResult, &member)
+	// Checked here too so that spending is not revoked for a removal the ledger refuses
+	if member.Role == orgRoleOwner || (member.Role == orgRoleManager && caller.Role != orgRoleOwner) {
+		forbidden(c, "member")
+		return
+	}
+
+	// Stop the spending first, so a failure never leaves a removed member able to pay
+	if wallet, err := payingWallet(h.fabricClient, userId, orgId); err == nil {
+		_, err := h.fabricClient.GetWalletContract().SubmitTransaction("RevokeDelegation", wallet.WalletID, userId)
+		if err != nil && !isNotFound(err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if _, err := userContract.SubmitTransaction("RemoveOrgMember", orgId, userId, caller.UserID); err != nil {
+		ledgerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// setMember records a membership on the user channel, then the member's spending limits
+// on the organization's wallet. Both are idempotent, so a failed request can be retried.
+func (h *OrganizationHandler) setMember(c *gin.Context, orgId, userId string, settings MemberSettings, status int) {
+	caller, ok := authorizeOrganization(c, h.fabricClient, orgId, true)
+	if !ok {
+		return
+	}
+	if settings.Role != orgRoleManager && settings.Role != orgRoleDriver {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role. Must be 'manager' or 'driver'"})
+		return
+	}
+
+	wallet, err := payingWallet(h.fabricClient, userId, orgId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization wallet not found"})
+		return
+	}
+	if limitMinor, err := money.Parse(settings.MonthlyLimit, wallet.Currency); err != nil || limitMinor <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalidAmount(settings.MonthlyLimit, err)})
+		return
+	}
+	if settings.AllowedLocations == nil {
+		settings.AllowedLocations = []string{}
+	}
+	locationsJSON, err := json.Marshal(settings.AllowedLocations)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	member, err := h.fabricClient.GetUserContract().SubmitTransaction("AddOrgMember", orgId, userId, settings.Role, caller.UserID)
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+
+	delegation, err := h.fabricClient.GetWalletContract().SubmitTransaction(
+		"SetDelegation",
+		wallet.WalletID,
+		userId,
+		settings.MonthlyLimit,
+		string(locationsJSON),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Member saved but their spending limit could not be: " + err.Error()})
+		return
+	}
+
+	c.JSON(status, gin.H{
+		"message":    "Member saved successfully",
+		"member":     json.RawMessage(member),
+		"delegation": decimalRecord(delegation),
+	})
+}
+
+// ==================== Statements ====================
+
+// GetStatement returns what the members spent from the organization's wallet in a
+// month, YYYY-MM in UTC (managers)
+func (h *OrganizationHandler) GetStatement(c *gin.Context) {
+	orgId := c.Param("id")
+	if _, ok := authorizeOrganization(c, h.fabricClient, orgId, true); !ok {
+		return
+	}
+
+	wallet, err := payingWallet(h.fabricClient, "", orgId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization wallet not found"})
+		return
+	}
+
+	result, err := h.fabricClient.GetWalletContract().EvaluateTransaction("GetDelegatedStatement", wallet.WalletID, c.Param("month"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"statement": decimalRecord(result)})
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// defaultPageSize is the page size of list endpoints when none is requested; the
+// chaincode caps it at 100
+const defaultPageSize = 20
+
+// pageQuery reads the pageSize and bookmark query parameters of a list endpoint. It
+// responds 400 and returns false if the page size is not a number.
+func pageQuery(c *gin.Context) (string, string, bool) {
+	pageSize := defaultPageSize
+	if param := c.Query("pageSize"); param != "" {
+		parsed, err := strconv.Atoi(param)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid page size %q", param)})
+			return "", "", false
+		}
+		pageSize = parsed
+	}
+	return strconv.Itoa(pageSize), c.Query("bookmark"), true
+}
+
+// floatQuery reads a numeric query parameter, zero when absent. It responds 400 and
+// returns false if the parameter is not a number.
+func floatQuery(c *gin.Context, param string) (float64, bool) {
+	value := c.Query(param)
+	if value == "" {
+		return 0, true
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s %q", param, value)})
+		return 0, false
+	}
+	return parsed, true
+}
+
+// intQuery reads an integer query parameter, zero when absent. It responds 400 and
+// returns false if the parameter is not an integer.
+func intQuery(c *gin.Context, param string) (int, bool) {
+	value := c.Query(param)
+	if value == "" {
+		return 0, true
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s %q", param, value)})
+		return 0, false
+	}
+	return parsed, true
+}
+
+// statusesQuery reads the comma-separated status query parameter of a list endpoint
+func statusesQuery(c *gin.Context) []string {
+	var statuses []string
+	for _, status := range strings.Split(c.Query("status"), ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// respondPage evaluates a paginated search of the chaincode with filter and the page
+// parameters of the request, and responds with the results under key and the bookmark
+// of the next page, empty on the last one
+func respondPage(c *gin.Context, contract *client.Contract, function, key string, filter interface{}) {
+	pageSize, bookmark, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+	filterJSON, err := json.Marshal(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := contract.EvaluateTransaction(function, string(filterJSON), pageSize, bookmark)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var results map[string]json.RawMessage
+	if err := json.Unmarshal(result, &results); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var nextBookmark string
+	json.Unmarshal(results["nextBookmark"], &nextBookmark)
+
+	c.JSON(http.StatusOK, gin.H{key: results[key], "nextBookmark": nextBookmark})
+}
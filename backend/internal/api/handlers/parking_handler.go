@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -8,20 +9,24 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 
 	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/idempotency"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/money"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/saga"
 )
 
 // ParkingHandler handles parking spot and booking endpoints
 type ParkingHandler struct {
 	fabricClient *fabric.Client
+	sagas        *saga.Coordinator
 }
 
 // NewParkingHandler creates a new parking handler
-func NewParkingHandler(fabricClient *fabric.Client) *ParkingHandler {
+func NewParkingHandler(fabricClient *fabric.Client, sagas *saga.Coordinator) *ParkingHandler {
 	return &ParkingHandler{
 		fabricClient: fabricClient,
+		sagas:        sagas,
 	}
 }
 
@@ -35,6 +40,7 @@ type CreateSpotRequest struct {
 	PricePerHour  float64 `json:"pricePerHour" binding:"required"`
 	HasEVCharging bool    `json:"hasEVCharging"`
 	OperatorID    string  `json:"operatorId"`
+	FacilityID    string  `json:"facilityId"`
 }
 
 // UpdateSpotRequest represents update parking spot request
@@ -50,17 +56,61 @@ type UpdateSpotRequest struct {
 
 // CreateBookingRequest represents create booking request
 type CreateBookingRequest struct {
-	SpotID    string  `json:"spotId" binding:"required"`
-	StartTime string  `json:"startTime" binding:"required"`
-	EndTime   string  `json:"endTime" binding:"required"`
-	TotalCost float64 `json:"totalCost"`
+	SpotID         string `json:"spotId" binding:"required"`
+	StartTime      string `json:"startTime" binding:"required"`
+	EndTime        string `json:"endTime" binding:"required"`
+	OrganizationID string `json:"organizationId"` // pay from this organization's wallet
 }
 
 // ExtendBookingRequest represents extend booking request
 type ExtendBookingRequest struct {
-	BookingID      string  `json:"bookingId" binding:"required"`
-	NewEndTime     string  `json:"newEndTime" binding:"required"`
-	AdditionalCost float64 `json:"additionalCost" binding:"required"`
+	BookingID  string `json:"bookingId" binding:"required"`
+	NewEndTime string `json:"newEndTime" binding:"required"`
+}
+
+// PricingRulesRequest represents update pricing rules request
+type PricingRulesRequest struct {
+	MinimumCharge      float64 `json:"minimumCharge"`
+	GranularityMinutes int     `json:"granularityMinutes" binding:"required"`
+	DailyCap           float64 `json:"dailyCap"`
+}
+
+// ReindexRequest represents a request to index a page of parking or charging records
+type ReindexRequest struct {
+	PageSize int    `json:"pageSize"`
+	Bookmark string `json:"bookmark"`
+}
+
+// SpotFilter selects spots in a search; empty fields match any spot
+type SpotFilter struct {
+	Location   string  `json:"location,omitempty"`
+	FacilityID string  `json:"facilityId,omitempty"`
+	SpotType   string  `json:"spotType,omitempty"`
+	Status     string  `json:"status,omitempty"`
+	MinPrice   float64 `json:"minPrice,omitempty"`
+	MaxPrice   float64 `json:"maxPrice,omitempty"`
+	SortBy     string  `json:"sortBy,omitempty"` // location or price
+	OpenNow    bool    `json:"openNow,omitempty"`
+}
+
+// BookingFilter selects the bookings of a user in a search
+type BookingFilter struct {
+	UserID   string   `json:"userId"`
+	SpotID   string   `json:"spotId,omitempty"`
+	Statuses []string `json:"statuses,omitempty"`
+}
+
+// NearbySpotFilter selects spots in a nearby search
+type NearbySpotFilter struct {
+	SpotType   string `json:"spotType,omitempty"`
+	EVCharging bool   `json:"evCharging,omitempty"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+}
+
+// quote holds the fields of a ledger price quote used by the handlers
+type quote struct {
+	Amount float64 `json:"amount"`
 }
 
 // CheckInRequest represents check-in request
@@ -83,7 +133,7 @@ func (h *ParkingHandler) CreateSpot(c *gin.Context) {
 		return
 	}
 
-	spotId := "spot_" + uuid.New().String()
+	spotId := idempotency.NewID(c, "spot_")
 
 	contract := h.fabricClient.GetParkingContract()
 	_, err := contract.SubmitTransaction(
@@ -97,6 +147,7 @@ func (h *ParkingHandler) CreateSpot(c *gin.Context) {
 		fmt.Sprintf("%f", req.PricePerHour),
 		strconv.FormatBool(req.HasEVCharging),
 		req.OperatorID,
+		req.FacilityID,
 	)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -153,33 +204,52 @@ func (h *ParkingHandler) UpdateSpot(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Parking spot updated successfully"})
 }
 
-// DeleteSpot marks a parking spot as maintenance
-func (h *ParkingHandler) DeleteSpot(c *gin.Context) {
+// SetSpotOpeningHours sets the opening hours of a parking spot
+func (h *ParkingHandler) SetSpotOpeningHours(c *gin.Context) {
 	spotId := c.Param("id")
 
+	var req OpeningHoursRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	contract := h.fabricClient.GetParkingContract()
-	_, err := contract.SubmitTransaction("DeleteParkingSpot", spotId)
+	_, err := contract.SubmitTransaction(
+		"SetSpotOpeningHours",
+		spotId,
+		strconv.Itoa(req.UTCOffsetMinutes),
+		openingHoursJSON(req.OpeningHours),
+	)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Parking spot deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Opening hours updated successfully"})
 }
 
-// GetAllSpots returns all parking spots
-func (h *ParkingHandler) GetAllSpots(c *gin.Context) {
+// DeleteSpot marks a parking spot as maintenance
+func (h *ParkingHandler) DeleteSpot(c *gin.Context) {
+	spotId := c.Param("id")
+
 	contract := h.fabricClient.GetParkingContract()
-	result, err := contract.EvaluateTransaction("GetAllParkingSpots")
+	_, err := contract.SubmitTransaction("DeleteParkingSpot", spotId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"spots": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"message": "Parking spot deleted successfully"})
+}
+
+// GetAllSpots returns a page of all parking spots, ordered by location
+func (h *ParkingHandler) GetAllSpots(c *gin.Context) {
+	respondPage(c, h.fabricClient.GetParkingContract(), "SearchSpots", "spots", SpotFilter{SortBy: "location"})
 }
 
-// GetAvailableSpots returns available spots at a location
+// GetAvailableSpots returns a page of the spots at a location that are available and open
+// now
 func (h *ParkingHandler) GetAvailableSpots(c *gin.Context) {
 	location := c.Query("location")
 	if location == "" {
@@ -187,43 +257,104 @@ func (h *ParkingHandler) GetAvailableSpots(c *gin.Context) {
 		return
 	}
 
-	contract := h.fabricClient.GetParkingContract()
-	result, err := contract.EvaluateTransaction("GetAvailableSpots", location)
+	respondPage(c, h.fabricClient.GetParkingContract(), "SearchSpots", "spots", SpotFilter{Location: location, Status: "available", OpenNow: true})
+}
+
+// SearchSpots returns a page of the parking spots matching all the given criteria
+func (h *ParkingHandler) SearchSpots(c *gin.Context) {
+	filter := SpotFilter{
+		Location:   c.Query("location"),
+		FacilityID: c.Query("facilityId"),
+		SpotType:   c.Query("type"),
+		Status:     c.Query("status"),
+		SortBy:     c.Query("sortBy"),
+	}
+	var ok bool
+	if filter.MinPrice, ok = floatQuery(c, "minPrice"); !ok {
+		return
+	}
+	if filter.MaxPrice, ok = floatQuery(c, "maxPrice"); !ok {
+		return
+	}
+
+	respondPage(c, h.fabricClient.GetParkingContract(), "SearchSpots", "spots", filter)
+}
+
+// GetNearbySpots returns the spots within a radius of a point, nearest first, with their
+// distance in meters. Without from and to only spots available now are returned; with
+// them, spots free for the whole window.
+func (h *ParkingHandler) GetNearbySpots(c *gin.Context) {
+	area, ok := nearbyArea(c)
+	if !ok {
+		return
+	}
+	limit, ok := limitQuery(c)
+	if !ok {
+		return
+	}
+	filterJSON, err := json.Marshal(NearbySpotFilter{
+		SpotType:   c.Query("type"),
+		EVCharging: c.Query("ev") == "true",
+		From:       c.Query("from"),
+		To:         c.Query("to"),
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	contract := h.fabricClient.GetParkingContract()
+	result, err := contract.EvaluateTransaction("FindNearbySpots", append(area, string(filterJSON), limit)...)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"spots": json.RawMessage(result)})
 }
 
-// SearchSpots searches for parking spots
-func (h *ParkingHandler) SearchSpots(c *gin.Context) {
-	location := c.Query("location")
-	spotType := c.Query("type")
-	minPrice := c.Query("minPrice")
-	maxPrice := c.Query("maxPrice")
+// GetSpotAvailability returns the free time windows of a parking spot
+func (h *ParkingHandler) GetSpotAvailability(c *gin.Context) {
+	spotId := c.Param("id")
+
+	// Default to the next 24 hours
+	now := time.Now().UTC().Truncate(time.Minute)
+	from := c.DefaultQuery("from", now.Format(time.RFC3339))
+	to := c.DefaultQuery("to", now.Add(24*time.Hour).Format(time.RFC3339))
 
 	contract := h.fabricClient.GetParkingContract()
-	var result []byte
-	var err error
+	result, err := contract.EvaluateTransaction("GetSpotAvailability", spotId, from, to)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"spotId":       spotId,
+		"from":         from,
+		"to":           to,
+		"availability": json.RawMessage(result),
+	})
+}
 
-	if location != "" {
-		result, err = contract.EvaluateTransaction("QuerySpotsByLocation", location)
-	} else if spotType != "" {
-		result, err = contract.EvaluateTransaction("QuerySpotsByType", spotType)
-	} else if minPrice != "" && maxPrice != "" {
-		result, err = contract.EvaluateTransaction("QuerySpotsByPriceRange", minPrice, maxPrice)
-	} else {
-		result, err = contract.EvaluateTransaction("GetAllParkingSpots")
+// QuoteBooking returns the ledger price of booking a spot for a time window
+func (h *ParkingHandler) QuoteBooking(c *gin.Context) {
+	spotId := c.Param("id")
+	startTime := c.Query("startTime")
+	endTime := c.Query("endTime")
+	if startTime == "" || endTime == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "startTime and endTime are required"})
+		return
 	}
 
+	contract := h.fabricClient.GetParkingContract()
+	result, err := contract.EvaluateTransaction("QuoteBooking", spotId, startTime, endTime)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"spots": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"quote": json.RawMessage(result)})
 }
 
 // ==================== Booking Endpoints ====================
@@ -243,76 +374,59 @@ func (h *ParkingHandler) CreateBooking(c *gin.Context) {
 	}
 	json.Unmarshal([]byte(userData.(string)), &user)
 
-	bookingId := "booking_" + uuid.New().String()
-	paymentId := "payment_" + uuid.New().String()
-
-	// Calculate total cost if not provided
-	if req.TotalCost == 0 {
-		// Parse timestamps
-		startTime, err1 := time.Parse(time.RFC3339, req.StartTime)
-		endTime, err2 := time.Parse(time.RFC3339, req.EndTime)
-		
-		if err1 == nil && err2 == nil {
-			// Get spot details to retrieve price per hour
-			parkingContract := h.fabricClient.GetParkingContract()
-			spotResult, err := parkingContract.EvaluateTransaction("GetParkingSpotById", req.SpotID)
-			if err == nil {
-				var spot struct {
-					PricePerHour float64 `json:"pricePerHour"`
-				}
-				json.Unmarshal(spotResult, &spot)
-				
-				// Calculate hours and total cost
-				hours := endTime.Sub(startTime).Hours()
-				req.TotalCost = hours * spot.PricePerHour
-			}
-		}
-	}
+	bookingId := idempotency.NewID(c, "booking_")
+	paymentId := idempotency.NewID(c, "payment_")
 
-	// Process payment first
-	walletContract := h.fabricClient.GetWalletContract()
-	walletResult, err := walletContract.EvaluateTransaction("GetWalletByUserId", user.UserID)
+	// The ledger computes the price; the booking transaction checks it again
+	parkingContract := h.fabricClient.GetParkingContract()
+	quoteResult, err := parkingContract.EvaluateTransaction("QuoteBooking", req.SpotID, req.StartTime, req.EndTime)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Wallet not found. Please create a wallet first."})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var wallet struct {
-		WalletID string `json:"walletId"`
+	var price quote
+	json.Unmarshal(quoteResult, &price)
+
+	// The spot's operator is credited with the payment
+	operatorId, err := h.spotOperator(req.SpotID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	json.Unmarshal(walletResult, &wallet)
 
-	_, err = walletContract.SubmitTransaction(
-		"ProcessPayment",
-		paymentId,
-		wallet.WalletID,
-		fmt.Sprintf("%f", req.TotalCost),
-		"parking",
-		bookingId,
-		"Parking booking payment",
-	)
+	// Process payment first
+	wallet, err := payingWallet(h.fabricClient, user.UserID, req.OrganizationID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment failed: " + err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Wallet not found. Please create a wallet first."})
 		return
 	}
 
-	// Create booking
-	contract := h.fabricClient.GetParkingContract()
-	_, err = contract.SubmitTransaction(
-		"CreateBooking",
-		bookingId,
-		user.UserID,
-		req.SpotID,
-		req.StartTime,
-		req.EndTime,
-		fmt.Sprintf("%f", req.TotalCost),
-		paymentId,
-	)
+	// Charge the wallet and create the booking as one saga so that a failure
+	// on the parking channel always ends with the payment refunded
+	amount := money.Format(money.FromFloat(price.Amount, wallet.Currency), wallet.Currency)
+	sagaId := idempotency.NewID(c, "saga_")
+	_, err = h.sagas.Execute(context.WithoutCancel(c.Request.Context()), SagaCreateBooking, sagaId, map[string]string{
+		"userId":      user.UserID,
+		"walletId":    wallet.WalletID,
+		"spenderId":   wallet.SpenderID,
+		"locationId":  req.SpotID,
+		"spotId":      req.SpotID,
+		"startTime":   req.StartTime,
+		"endTime":     req.EndTime,
+		"price":       fmt.Sprintf("%f", price.Amount),
+		"amount":      amount,
+		"currency":    wallet.Currency,
+		"operatorId":  operatorId,
+		"bookingId":   bookingId,
+		"paymentId":   paymentId,
+		"refundId":    idempotency.NewID(c, "refund_"),
+		"paymentType": "parking",
+		"referenceId": bookingId,
+		"description": "Parking booking payment",
+	})
 	if err != nil {
-		// Refund payment if booking fails
-		refundId := "refund_" + uuid.New().String()
-		walletContract.SubmitTransaction("RefundPayment", paymentId, fmt.Sprintf("%f", req.TotalCost), refundId)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "sagaId": sagaId})
 		return
 	}
 
@@ -320,21 +434,19 @@ func (h *ParkingHandler) CreateBooking(c *gin.Context) {
 		"message":   "Booking created successfully",
 		"bookingId": bookingId,
 		"paymentId": paymentId,
+		"totalCost": amount,
+		"currency":  wallet.Currency,
 	})
 }
 
-// GetBooking returns a booking by ID
+// GetBooking returns a booking by ID to its user, the spot's operator or an admin
 func (h *ParkingHandler) GetBooking(c *gin.Context) {
-	bookingId := c.Param("id")
-
-	contract := h.fabricClient.GetParkingContract()
-	result, err := contract.EvaluateTransaction("GetBooking", bookingId)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+	booking, ok := authorizeBooking(c, h.fabricClient, c.Param("id"))
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"booking": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"booking": booking.raw})
 }
 
 // CheckIn handles booking check-in
@@ -345,10 +457,15 @@ func (h *ParkingHandler) CheckIn(c *gin.Context) {
 		return
 	}
 
+	if _, ok := authorizeBooking(c, h.fabricClient, req.BookingID); !ok {
+		return
+	}
+	user := currentCaller(c)
+
 	contract := h.fabricClient.GetParkingContract()
-	_, err := contract.SubmitTransaction("CheckInBooking", req.BookingID)
+	_, err := contract.SubmitTransaction("CheckInBooking", req.BookingID, user.UserID, user.Role)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ledgerError(c, err)
 		return
 	}
 
@@ -363,10 +480,15 @@ func (h *ParkingHandler) CheckOut(c *gin.Context) {
 		return
 	}
 
+	if _, ok := authorizeBooking(c, h.fabricClient, req.BookingID); !ok {
+		return
+	}
+	user := currentCaller(c)
+
 	contract := h.fabricClient.GetParkingContract()
-	result, err := contract.SubmitTransaction("CheckOutBooking", req.BookingID)
+	result, err := contract.SubmitTransaction("CheckOutBooking", req.BookingID, user.UserID, user.Role)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ledgerError(c, err)
 		return
 	}
 
@@ -384,119 +506,248 @@ func (h *ParkingHandler) ExtendBooking(c *gin.Context) {
 		return
 	}
 
-	// Process additional payment
-	userData, _ := c.Get("user")
-	var user struct {
-		UserID string `json:"userId"`
+	booking, ok := authorizeBooking(c, h.fabricClient, req.BookingID)
+	if !ok {
+		return
 	}
-	json.Unmarshal([]byte(userData.(string)), &user)
 
-	walletContract := h.fabricClient.GetWalletContract()
-	walletResult, err := walletContract.EvaluateTransaction("GetWalletByUserId", user.UserID)
+	// Process additional payment, from an organization's wallet if it paid the booking
+	user := currentCaller(c)
+
+	wallet, err := extensionPayer(h.fabricClient, user.UserID, booking.PaymentID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Wallet not found"})
 		return
 	}
 
-	var wallet struct {
-		WalletID string `json:"walletId"`
+	parkingContract := h.fabricClient.GetParkingContract()
+	quoteResult, err := parkingContract.EvaluateTransaction("QuoteExtension", req.BookingID, req.NewEndTime)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var price quote
+	json.Unmarshal(quoteResult, &price)
+
+	// Nothing to charge when the new end falls within time already paid for
+	if price.Amount == 0 {
+		_, err = parkingContract.SubmitTransaction("ExtendBooking", req.BookingID, req.NewEndTime, "0", user.UserID, user.Role)
+		if err != nil {
+			ledgerError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "Booking extended successfully",
+			"additionalCost": money.Format(0, wallet.Currency),
+			"currency":       wallet.Currency,
+		})
+		return
 	}
-	json.Unmarshal(walletResult, &wallet)
 
-	paymentId := "payment_" + uuid.New().String()
-	_, err = walletContract.SubmitTransaction(
-		"ProcessPayment",
-		paymentId,
-		wallet.WalletID,
-		fmt.Sprintf("%f", req.AdditionalCost),
-		"parking",
-		req.BookingID,
-		"Booking extension payment",
-	)
+	operatorId, err := h.spotOperator(booking.SpotID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment failed: " + err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	paymentId := idempotency.NewID(c, "payment_")
+	amount := money.Format(money.FromFloat(price.Amount, wallet.Currency), wallet.Currency)
+	sagaId := idempotency.NewID(c, "saga_")
+	_, err = h.sagas.Execute(context.WithoutCancel(c.Request.Context()), SagaExtendBooking, sagaId, map[string]string{
+		"userId":      user.UserID,
+		"walletId":    wallet.WalletID,
+		"spenderId":   wallet.SpenderID,
+		"locationId":  booking.SpotID,
+		"bookingId":   req.BookingID,
+		"newEndTime":  req.NewEndTime,
+		"price":       fmt.Sprintf("%f", price.Amount),
+		"amount":      amount,
+		"currency":    wallet.Currency,
+		"operatorId":  operatorId,
+		"paymentId":   paymentId,
+		"refundId":    idempotency.NewID(c, "refund_"),
+		"paymentType": "parking",
+		"referenceId": req.BookingID,
+		"description": "Booking extension payment",
+		"callerId":    user.UserID,
+		"callerRole":  user.Role,
+	})
+	if err != nil {
+		status := http.StatusBadRequest
+		if isForbidden(err) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error(), "sagaId": sagaId})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Booking extended successfully",
+		"additionalCost": amount,
+		"currency":       wallet.Currency,
+	})
+}
+
+// QuoteExtension returns the additional amount due to extend a booking
+func (h *ParkingHandler) QuoteExtension(c *gin.Context) {
+	bookingId := c.Param("id")
+	newEndTime := c.Query("newEndTime")
+	if newEndTime == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "newEndTime is required"})
 		return
 	}
 
 	contract := h.fabricClient.GetParkingContract()
-	_, err = contract.SubmitTransaction(
-		"ExtendBooking",
-		req.BookingID,
-		req.NewEndTime,
-		fmt.Sprintf("%f", req.AdditionalCost),
-	)
+	result, err := contract.EvaluateTransaction("QuoteExtension", bookingId, newEndTime)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Booking extended successfully"})
+	c.JSON(http.StatusOK, gin.H{"quote": json.RawMessage(result)})
 }
 
-// CancelBooking cancels a booking
+// CancelBooking cancels a booking and refunds what the refund policy grants.
+// The refund is retried in the background if the wallet channel is unavailable.
 func (h *ParkingHandler) CancelBooking(c *gin.Context) {
 	bookingId := c.Param("id")
 
-	contract := h.fabricClient.GetParkingContract()
-	_, err := contract.SubmitTransaction("CancelBooking", bookingId)
+	if _, ok := authorizeBooking(c, h.fabricClient, bookingId); !ok {
+		return
+	}
+	user := currentCaller(c)
+
+	sagaId := idempotency.NewID(c, "saga_")
+	cancelSaga, err := h.sagas.Execute(context.WithoutCancel(c.Request.Context()), SagaCancelBooking, sagaId, map[string]string{
+		"bookingId":  bookingId,
+		"callerId":   user.UserID,
+		"callerRole": user.Role,
+	})
+	if err != nil && (cancelSaga == nil || cancelSaga.Status != saga.StatusRunning) {
+		status := http.StatusBadRequest
+		if isForbidden(err) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error(), "sagaId": sagaId})
+		return
+	}
+
+	refundPercent, _ := strconv.Atoi(cancelSaga.Data["refundPercent"])
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":       "Booking cancelled, refund pending",
+			"refundPercent": refundPercent,
+			"sagaId":        sagaId,
+		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Booking cancelled successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Booking cancelled successfully",
+		"refundPercent": refundPercent,
+	})
 }
 
-// GetUserBookings returns all bookings for the current user
+// GetUserBookings returns a page of the bookings of the current user, optionally of
+// one spot and in some statuses
 func (h *ParkingHandler) GetUserBookings(c *gin.Context) {
-	userData, _ := c.Get("user")
-	var user struct {
-		UserID string `json:"userId"`
+	h.respondBookings(c, statusesQuery(c)...)
+}
+
+// GetActiveBookings returns a page of the active bookings of the current user
+func (h *ParkingHandler) GetActiveBookings(c *gin.Context) {
+	h.respondBookings(c, "confirmed", "active")
+}
+
+// GetBookingHistory returns a page of the booking history of the current user
+func (h *ParkingHandler) GetBookingHistory(c *gin.Context) {
+	h.respondBookings(c, "completed", "cancelled")
+}
+
+// respondBookings responds with a page of the current user's bookings in any of the
+// given statuses, or in any status if none are given
+func (h *ParkingHandler) respondBookings(c *gin.Context, statuses ...string) {
+	filter := BookingFilter{
+		UserID:   currentCaller(c).UserID,
+		SpotID:   c.Query("spotId"),
+		Statuses: statuses,
 	}
-	json.Unmarshal([]byte(userData.(string)), &user)
 
+	respondPage(c, h.fabricClient.GetParkingContract(), "SearchBookings", "bookings", filter)
+}
+
+// ==================== Pricing Endpoints ====================
+
+// GetPricingRules returns the pricing rules applied by the ledger
+func (h *ParkingHandler) GetPricingRules(c *gin.Context) {
 	contract := h.fabricClient.GetParkingContract()
-	result, err := contract.EvaluateTransaction("GetUserBookings", user.UserID)
+	result, err := contract.EvaluateTransaction("GetPricingRules")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"bookings": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"rules": json.RawMessage(result)})
 }
 
-// GetActiveBookings returns active bookings for the current user
-func (h *ParkingHandler) GetActiveBookings(c *gin.Context) {
-	userData, _ := c.Get("user")
-	var user struct {
-		UserID string `json:"userId"`
+// UpdatePricingRules replaces the pricing rules applied by the ledger
+func (h *ParkingHandler) UpdatePricingRules(c *gin.Context) {
+	var req PricingRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	json.Unmarshal([]byte(userData.(string)), &user)
 
 	contract := h.fabricClient.GetParkingContract()
-	result, err := contract.EvaluateTransaction("GetActiveBookings", user.UserID)
+	_, err := contract.SubmitTransaction(
+		"SetPricingRules",
+		fmt.Sprintf("%f", req.MinimumCharge),
+		strconv.Itoa(req.GranularityMinutes),
+		fmt.Sprintf("%f", req.DailyCap),
+	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"bookings": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"message": "Pricing rules updated successfully"})
 }
 
-// GetBookingHistory returns booking history for the current user
-func (h *ParkingHandler) GetBookingHistory(c *gin.Context) {
-	userData, _ := c.Get("user")
-	var user struct {
-		UserID string `json:"userId"`
+// Reindex writes the index entries of a page of spots and bookings created before the
+// parking indexes existed. Call again with the returned bookmark until it is empty.
+func (h *ParkingHandler) Reindex(c *gin.Context) {
+	var req ReindexRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		req = ReindexRequest{}
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = 100
 	}
-	json.Unmarshal([]byte(userData.(string)), &user)
 
 	contract := h.fabricClient.GetParkingContract()
-	result, err := contract.EvaluateTransaction("GetBookingHistory", user.UserID)
+	result, err := contract.SubmitTransaction("ReindexParking", strconv.Itoa(req.PageSize), req.Bookmark)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"bookings": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"reindex": json.RawMessage(result)})
+}
+
+// spotOperator returns the ID of the operator running a parking spot
+func (h *ParkingHandler) spotOperator(spotId string) (string, error) {
+	contract := h.fabricClient.GetParkingContract()
+	result, err := contract.EvaluateTransaction("GetParkingSpot", spotId)
+	if err != nil {
+		return "", err
+	}
+
+	var spot struct {
+		OperatorID string `json:"operatorId"`
+	}
+	if err := json.Unmarshal(result, &spot); err != nil {
+		return "", err
+	}
+	return spot.OperatorID, nil
 }
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// RefundPolicyHandler handles the cancellation refund policy of the parking or the charging chaincode
+type RefundPolicyHandler struct {
+	getContract func() *client.Contract
+}
+
+// NewRefundPolicyHandler creates a refund policy handler for the chaincode returned by getContract
+func NewRefundPolicyHandler(getContract func() *client.Contract) *RefundPolicyHandler {
+	return &RefundPolicyHandler{getContract: getContract}
+}
+
+// RefundTier refunds a percent of what was paid when cancelling at least MinNoticeMinutes before the start
+type RefundTier struct {
+	MinNoticeMinutes int `json:"minNoticeMinutes"`
+	Percent          int `json:"percent"`
+}
+
+// SetRefundPolicyRequest represents set refund policy request
+type SetRefundPolicyRequest struct {
+	Tiers          []RefundTier `json:"tiers"`
+	DefaultPercent *int         `json:"defaultPercent" binding:"required"`
+}
+
+// GetRefundPolicy returns the current refund policy
+func (h *RefundPolicyHandler) GetRefundPolicy(c *gin.Context) {
+	contract := h.getContract()
+	result, err := contract.EvaluateTransaction("GetRefundPolicy")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"refundPolicy": json.RawMessage(result)})
+}
+
+// SetRefundPolicy replaces the refund policy applied to later cancellations
+func (h *RefundPolicyHandler) SetRefundPolicy(c *gin.Context) {
+	var req SetRefundPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Tiers == nil {
+		req.Tiers = []RefundTier{}
+	}
+	tiersJSON, _ := json.Marshal(req.Tiers)
+
+	contract := h.getContract()
+	_, err := contract.SubmitTransaction("SetRefundPolicy", string(tiersJSON), strconv.Itoa(*req.DefaultPercent))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Refund policy updated successfully"})
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/saga"
+)
+
+// SagaHandler exposes the state of cross-channel sagas to administrators
+type SagaHandler struct {
+	coordinator *saga.Coordinator
+}
+
+// NewSagaHandler creates a new saga handler
+func NewSagaHandler(coordinator *saga.Coordinator) *SagaHandler {
+	return &SagaHandler{
+		coordinator: coordinator,
+	}
+}
+
+// ListSagas returns all sagas, optionally filtered by status
+func (h *SagaHandler) ListSagas(c *gin.Context) {
+	sagas, err := h.coordinator.List(saga.Status(c.Query("status")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sagas": sagas,
+		"total": len(sagas),
+	})
+}
+
+// GetSaga returns a saga by ID
+func (h *SagaHandler) GetSaga(c *gin.Context) {
+	s, err := h.coordinator.Get(c.Param("id"))
+	if err != nil {
+		if errors.Is(err, saga.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Saga not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"saga": s})
+}
+
+// RetrySaga retries an unfinished saga immediately
+func (h *SagaHandler) RetrySaga(c *gin.Context) {
+	s, err := h.coordinator.Retry(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, saga.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Saga not found"})
+		case errors.Is(err, saga.ErrInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "saga": s})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"saga": s})
+}
@@ -0,0 +1,499 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/money"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/saga"
+)
+
+// Saga types coordinating payments on the wallet channel with the parking and charging channels
+const (
+	SagaCreateBooking = "parking.create_booking"
+	SagaExtendBooking = "parking.extend_booking"
+	SagaStopCharging  = "charging.stop_session"
+	SagaCancelBooking = "parking.cancel_booking"
+	SagaCancelSession = "charging.cancel_session"
+)
+
+// RegisterSagas registers the cross-channel workflows with the saga coordinator.
+//
+// Every saga starts by charging the user's wallet and then applies the change on the
+// other channel. If that fails, the payment is refunded; the refund is retried by the
+// coordinator until it succeeds. Cancellations go the other way: once the booking or
+// session is cancelled, the refund its policy grants is retried until it is paid.
+// Steps check the ledger before acting so that they can safely be re-run after a
+// crash or a submit whose outcome is unknown. User actions are submitted with the caller
+// recorded when the saga started; compensations and refunds act with the system role.
+func RegisterSagas(coordinator *saga.Coordinator, fabricClient *fabric.Client) {
+	payment := walletPaymentStep(fabricClient)
+
+	coordinator.Register(saga.Definition{
+		Type: SagaCreateBooking,
+		Steps: []saga.Step{
+			payment,
+			{
+				Name:       "create_booking",
+				Action:     createBookingAction(fabricClient),
+				Compensate: cancelBookingCompensation(fabricClient),
+			},
+		},
+	})
+
+	coordinator.Register(saga.Definition{
+		Type: SagaExtendBooking,
+		Steps: []saga.Step{
+			payment,
+			{
+				Name:   "extend_booking",
+				Action: extendBookingAction(fabricClient),
+			},
+		},
+	})
+
+	coordinator.Register(saga.Definition{
+		Type: SagaStopCharging,
+		Steps: []saga.Step{
+			payment,
+			{
+				Name:   "stop_session",
+				Action: stopChargingAction(fabricClient),
+			},
+		},
+	})
+
+	coordinator.Register(saga.Definition{
+		Type: SagaCancelBooking,
+		Steps: []saga.Step{
+			{
+				Name:   "cancel_booking",
+				Action: cancelBookingAction(fabricClient),
+			},
+			cancellationRefundStep(fabricClient),
+		},
+	})
+
+	coordinator.Register(saga.Definition{
+		Type: SagaCancelSession,
+		Steps: []saga.Step{
+			{
+				Name:   "cancel_session",
+				Action: cancelSessionAction(fabricClient),
+			},
+			cancellationRefundStep(fabricClient),
+		},
+	})
+}
+
+// walletPaymentStep charges the wallet and refunds the payment on compensation.
+// Uses the saga data keys paymentId, walletId, amount, currency, operatorId,
+// paymentType, referenceId, description and refundId, and lineItems for an itemized
+// payment. amount is a decimal string in the wallet's currency. When spenderId is set,
+// the member pays from their organization's wallet within its limits for the spot or
+// station locationId.
+func walletPaymentStep(fabricClient *fabric.Client) saga.Step {
+	return saga.Step{
+		Name: "payment",
+		Action: func(ctx context.Context, s *saga.Saga) error {
+			contract := fabricClient.GetWalletContract()
+
+			// Already charged by a previous attempt
+			if _, err := contract.EvaluateTransaction("GetPayment", s.Data["paymentId"]); err == nil {
+				return nil
+			}
+
+			var err error
+			if s.Data["spenderId"] != "" {
+				lineItems := s.Data["lineItems"]
+				if lineItems == "" {
+					lineItems = "[]"
+				}
+				_, err = contract.SubmitTransaction(
+					"ProcessDelegatedPayment",
+					s.Data["paymentId"],
+					s.Data["walletId"],
+					s.Data["spenderId"],
+					s.Data["locationId"],
+					s.Data["amount"],
+					s.Data["currency"],
+					s.Data["operatorId"],
+					s.Data["paymentType"],
+					s.Data["referenceId"],
+					s.Data["description"],
+					lineItems,
+				)
+			} else if s.Data["lineItems"] != "" {
+				_, err = contract.SubmitTransaction(
+					"ProcessItemizedPayment",
+					s.Data["paymentId"],
+					s.Data["walletId"],
+					s.Data["currency"],
+					s.Data["operatorId"],
+					s.Data["paymentType"],
+					s.Data["referenceId"],
+					s.Data["description"],
+					s.Data["lineItems"],
+				)
+			} else {
+				_, err = contract.SubmitTransaction(
+					"ProcessPayment",
+					s.Data["paymentId"],
+					s.Data["walletId"],
+					s.Data["amount"],
+					s.Data["currency"],
+					s.Data["operatorId"],
+					s.Data["paymentType"],
+					s.Data["referenceId"],
+					s.Data["description"],
+				)
+			}
+			if err != nil {
+				return fmt.Errorf("payment failed: %w", err)
+			}
+			return nil
+		},
+		Compensate: func(ctx context.Context, s *saga.Saga) error {
+			contract := fabricClient.GetWalletContract()
+
+			// Already refunded by a previous attempt
+			if _, err := contract.EvaluateTransaction("GetPayment", s.Data["refundId"]); err == nil {
+				return nil
+			}
+
+			// Nothing to refund if the payment never reached the ledger
+			if _, err := contract.EvaluateTransaction("GetPayment", s.Data["paymentId"]); err != nil {
+				if isNotFound(err) {
+					return nil
+				}
+				return err
+			}
+
+			_, err := contract.SubmitTransaction("RefundPayment", s.Data["paymentId"], s.Data["amount"], s.Data["refundId"], s.ID, systemRole)
+			return err
+		},
+	}
+}
+
+func createBookingAction(fabricClient *fabric.Client) func(context.Context, *saga.Saga) error {
+	return func(ctx context.Context, s *saga.Saga) error {
+		contract := fabricClient.GetParkingContract()
+		_, err := contract.SubmitTransaction(
+			"CreateBooking",
+			s.Data["bookingId"],
+			s.Data["userId"],
+			s.Data["spotId"],
+			s.Data["startTime"],
+			s.Data["endTime"],
+			ledgerPrice(s),
+			s.Data["paymentId"],
+		)
+		if err != nil {
+			// The booking may have been committed even though the call failed
+			booking, getErr := getBooking(fabricClient, s.Data["bookingId"])
+			if getErr == nil && booking.PaymentID == s.Data["paymentId"] {
+				return nil
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+func cancelBookingCompensation(fabricClient *fabric.Client) func(context.Context, *saga.Saga) error {
+	return func(ctx context.Context, s *saga.Saga) error {
+		booking, err := getBooking(fabricClient, s.Data["bookingId"])
+		if err != nil {
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		if booking.Status == "cancelled" {
+			return nil
+		}
+
+		contract := fabricClient.GetParkingContract()
+		_, err = contract.SubmitTransaction("CancelBooking", s.Data["bookingId"], s.ID, systemRole)
+		return err
+	}
+}
+
+func extendBookingAction(fabricClient *fabric.Client) func(context.Context, *saga.Saga) error {
+	return func(ctx context.Context, s *saga.Saga) error {
+		// Sagas started before the caller was recorded were authorized by the handler
+		callerId, callerRole := s.Data["callerId"], s.Data["callerRole"]
+		if callerRole == "" {
+			callerId, callerRole = s.ID, systemRole
+		}
+
+		contract := fabricClient.GetParkingContract()
+		_, err := contract.SubmitTransaction(
+			"ExtendBooking",
+			s.Data["bookingId"],
+			s.Data["newEndTime"],
+			ledgerPrice(s),
+			callerId,
+			callerRole,
+		)
+		if err != nil {
+			// The extension may have been committed even though the call failed
+			booking, getErr := getBooking(fabricClient, s.Data["bookingId"])
+			if getErr == nil && booking.EndTime == s.Data["newEndTime"] {
+				return nil
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+func stopChargingAction(fabricClient *fabric.Client) func(context.Context, *saga.Saga) error {
+	return func(ctx context.Context, s *saga.Saga) error {
+		// Sagas started before the caller was recorded were authorized by the handler
+		callerId, callerRole := s.Data["callerId"], s.Data["callerRole"]
+		if callerRole == "" {
+			callerId, callerRole = s.ID, systemRole
+		}
+
+		contract := fabricClient.GetChargingContract()
+		result, err := contract.SubmitTransaction(
+			"StopChargingSession",
+			s.Data["sessionId"],
+			s.Data["totalEnergy"],
+			ledgerPrice(s),
+			s.Data["paymentId"],
+			s.Data["endTime"],
+			callerId,
+			callerRole,
+		)
+		if err != nil {
+			// The session may have been stopped even though the call failed
+			sessionResult, getErr := contract.EvaluateTransaction("GetChargingSession", s.Data["sessionId"])
+			if getErr != nil {
+				return err
+			}
+			var session struct {
+				Status    string `json:"status"`
+				PaymentID string `json:"paymentId"`
+			}
+			if json.Unmarshal(sessionResult, &session) != nil || session.Status != "completed" || session.PaymentID != s.Data["paymentId"] {
+				return err
+			}
+			result = sessionResult
+		}
+
+		// A rejected final meter reading leaves the session disputed; the payment is refunded
+		var session struct {
+			Status        string `json:"status"`
+			DisputeReason string `json:"disputeReason"`
+		}
+		json.Unmarshal(result, &session)
+		if session.Status == "disputed" {
+			return fmt.Errorf("session %s flagged for dispute: %s", s.Data["sessionId"], session.DisputeReason)
+		}
+
+		s.Data["session"] = string(result)
+		return nil
+	}
+}
+
+func cancelBookingAction(fabricClient *fabric.Client) func(context.Context, *saga.Saga) error {
+	return func(ctx context.Context, s *saga.Saga) error {
+		booking, err := getBooking(fabricClient, s.Data["bookingId"])
+		if err != nil {
+			return err
+		}
+
+		// Not cancelled yet by a previous attempt
+		if booking.Status != "cancelled" {
+			contract := fabricClient.GetParkingContract()
+			if _, err := contract.SubmitTransaction("CancelBooking", s.Data["bookingId"], s.Data["callerId"], s.Data["callerRole"]); err != nil {
+				booking, getErr := getBooking(fabricClient, s.Data["bookingId"])
+				if getErr != nil || booking.Status != "cancelled" {
+					return err
+				}
+			}
+			if booking, err = getBooking(fabricClient, s.Data["bookingId"]); err != nil {
+				return err
+			}
+		}
+
+		s.Data["referenceId"] = booking.BookingID
+		s.Data["paymentId"] = booking.PaymentID
+		s.Data["refundPercent"] = strconv.Itoa(booking.RefundPercent)
+		return nil
+	}
+}
+
+func cancelSessionAction(fabricClient *fabric.Client) func(context.Context, *saga.Saga) error {
+	return func(ctx context.Context, s *saga.Saga) error {
+		contract := fabricClient.GetChargingContract()
+		getSession := func() (*sessionRecord, error) {
+			result, err := contract.EvaluateTransaction("GetChargingSession", s.Data["sessionId"])
+			if err != nil {
+				return nil, err
+			}
+			var session sessionRecord
+			if err := json.Unmarshal(result, &session); err != nil {
+				return nil, err
+			}
+			return &session, nil
+		}
+
+		session, err := getSession()
+		if err != nil {
+			return err
+		}
+
+		// Not cancelled yet by a previous attempt
+		if session.Status != "cancelled" {
+			if _, err := contract.SubmitTransaction("CancelSession", s.Data["sessionId"], s.Data["callerId"], s.Data["callerRole"]); err != nil {
+				session, getErr := getSession()
+				if getErr != nil || session.Status != "cancelled" {
+					return err
+				}
+			}
+			if session, err = getSession(); err != nil {
+				return err
+			}
+		}
+
+		s.Data["referenceId"] = session.SessionID
+		s.Data["paymentId"] = session.PaymentID
+		s.Data["refundPercent"] = strconv.Itoa(session.RefundPercent)
+		return nil
+	}
+}
+
+// cancellationRefundStep refunds refundPercent of every payment made for the cancelled
+// booking or session. Payments made before the wallet indexed them by reference are
+// found through paymentId. The cancellation cannot be undone, so the step is retried
+// until the refunds are paid.
+func cancellationRefundStep(fabricClient *fabric.Client) saga.Step {
+	return saga.Step{
+		Name:      "refund",
+		Retriable: true,
+		Action: func(ctx context.Context, s *saga.Saga) error {
+			percent, _ := strconv.ParseInt(s.Data["refundPercent"], 10, 64)
+			if percent <= 0 {
+				return nil
+			}
+
+			contract := fabricClient.GetWalletContract()
+			result, err := contract.EvaluateTransaction("GetReferencePayments", s.Data["referenceId"])
+			if err != nil {
+				return err
+			}
+			var payments []paymentRecord
+			if err := json.Unmarshal(result, &payments); err != nil {
+				return err
+			}
+			if len(payments) == 0 && s.Data["paymentId"] != "" {
+				result, err := contract.EvaluateTransaction("GetPayment", s.Data["paymentId"])
+				if err != nil && !isNotFound(err) {
+					return err
+				}
+				if err == nil {
+					var payment paymentRecord
+					if err := json.Unmarshal(result, &payment); err != nil {
+						return err
+					}
+					payments = append(payments, payment)
+				}
+			}
+
+			for _, payment := range payments {
+				// The refund ID is derived from the saga so that a retry finds it
+				refundId := "refund_" + uuid.NewSHA1(uuid.NameSpaceOID, []byte(s.ID+"/"+payment.PaymentID)).String()
+				if _, err := contract.EvaluateTransaction("GetPayment", refundId); err == nil {
+					continue
+				}
+
+				refundMinor := (payment.AmountMinor*percent + 50) / 100
+				if refundable := payment.AmountMinor - payment.RefundedMinor; refundMinor > refundable {
+					refundMinor = refundable
+				}
+				if refundMinor <= 0 {
+					continue
+				}
+
+				_, err := contract.SubmitTransaction("RefundPayment", payment.PaymentID, money.Format(refundMinor, payment.Currency), refundId, s.ID, systemRole)
+				if err != nil {
+					return fmt.Errorf("refund of payment %s failed: %w", payment.PaymentID, err)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// bookingRecord holds the booking fields the sagas inspect
+type bookingRecord struct {
+	BookingID     string `json:"bookingId"`
+	UserID        string `json:"userId"`
+	SpotID        string `json:"spotId"`
+	EndTime       string `json:"endTime"`
+	Status        string `json:"status"`
+	PaymentID     string `json:"paymentId"`
+	RefundPercent int    `json:"refundPercent"`
+
+	raw json.RawMessage // the whole booking as read from the ledger
+}
+
+// sessionRecord holds the charging session fields the sagas inspect
+type sessionRecord struct {
+	SessionID     string `json:"sessionId"`
+	UserID        string `json:"userId"`
+	Status        string `json:"status"`
+	PaymentID     string `json:"paymentId"`
+	RefundPercent int    `json:"refundPercent"`
+
+	raw json.RawMessage // the whole session as read from the ledger
+}
+
+// paymentRecord holds the payment fields the refund step and authorization inspect
+type paymentRecord struct {
+	PaymentID     string `json:"paymentId"`
+	WalletID      string `json:"walletId"`
+	OperatorID    string `json:"operatorId"`
+	SpenderID     string `json:"spenderId"`
+	AmountMinor   int64  `json:"amountMinor"`
+	Currency      string `json:"currency"`
+	RefundedMinor int64  `json:"refundedMinor"`
+}
+
+func getBooking(fabricClient *fabric.Client, bookingId string) (*bookingRecord, error) {
+	contract := fabricClient.GetParkingContract()
+	result, err := contract.EvaluateTransaction("GetBooking", bookingId)
+	if err != nil {
+		return nil, err
+	}
+
+	var booking bookingRecord
+	if err := json.Unmarshal(result, &booking); err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
+
+// ledgerPrice returns the price quoted by the parking or charging chaincode, which
+// checks it again when booking or stopping. Sagas started before the wallet moved to
+// minor units only carry amount.
+func ledgerPrice(s *saga.Saga) string {
+	if price, ok := s.Data["price"]; ok {
+		return price
+	}
+	return s.Data["amount"]
+}
+
+// isNotFound reports whether a chaincode error says the requested record does not exist
+func isNotFound(err error) bool {
+	return strings.Contains(err.Error(), "does not exist") || strings.Contains(err.Error(), "not found")
+}
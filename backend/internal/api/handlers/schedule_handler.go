@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/idempotency"
+)
+
+// BlackoutHandler handles blackout endpoints of the parking or the charging chaincode
+type BlackoutHandler struct {
+	getContract func() *client.Contract
+}
+
+// NewBlackoutHandler creates a blackout handler for the chaincode returned by getContract
+func NewBlackoutHandler(getContract func() *client.Contract) *BlackoutHandler {
+	return &BlackoutHandler{getContract: getContract}
+}
+
+// OpeningHours represents the hours a facility, spot or station is open on some days
+type OpeningHours struct {
+	Days  []int  `json:"days"`  // 0 = Sunday ... 6 = Saturday, empty for every day
+	Open  string `json:"open"`  // HH:MM local time
+	Close string `json:"close"` // HH:MM local time, before open to close past midnight
+}
+
+// OpeningHoursRequest sets the opening hours of a spot or station; empty opening hours
+// fall back to the facility's for spots, and mean always open otherwise
+type OpeningHoursRequest struct {
+	UTCOffsetMinutes int            `json:"utcOffsetMinutes"`
+	OpeningHours     []OpeningHours `json:"openingHours"`
+}
+
+// CreateBlackoutRequest represents create blackout request
+type CreateBlackoutRequest struct {
+	ScopeType string `json:"scopeType" binding:"required"` // spot, facility or location; station or location
+	ScopeID   string `json:"scopeId" binding:"required"`
+	StartTime string `json:"startTime" binding:"required"`
+	EndTime   string `json:"endTime" binding:"required"`
+	Reason    string `json:"reason"`
+}
+
+// CreateBlackout closes the spots or stations of a scope for a time range
+func (h *BlackoutHandler) CreateBlackout(c *gin.Context) {
+	var req CreateBlackoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	blackoutId := idempotency.NewID(c, "blackout_")
+
+	contract := h.getContract()
+	_, err := contract.SubmitTransaction(
+		"CreateBlackout",
+		blackoutId,
+		req.ScopeType,
+		req.ScopeID,
+		req.StartTime,
+		req.EndTime,
+		req.Reason,
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Blackout created successfully",
+		"blackoutId": blackoutId,
+	})
+}
+
+// GetBlackouts returns the blackouts of a scope
+func (h *BlackoutHandler) GetBlackouts(c *gin.Context) {
+	scopeType := c.Query("scopeType")
+	scopeId := c.Query("scopeId")
+	if scopeType == "" || scopeId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scopeType and scopeId are required"})
+		return
+	}
+
+	contract := h.getContract()
+	result, err := contract.EvaluateTransaction("GetBlackoutsByScope", scopeType, scopeId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"blackouts": json.RawMessage(result)})
+}
+
+// DeleteBlackout lifts a blackout
+func (h *BlackoutHandler) DeleteBlackout(c *gin.Context) {
+	blackoutId := c.Param("id")
+
+	contract := h.getContract()
+	_, err := contract.SubmitTransaction("DeleteBlackout", blackoutId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Blackout deleted successfully"})
+}
+
+// openingHoursJSON encodes opening hours as the chaincode takes them, with empty lists
+// rather than null
+func openingHoursJSON(openingHours []OpeningHours) string {
+	if openingHours == nil {
+		openingHours = []OpeningHours{}
+	}
+	for i := range openingHours {
+		if openingHours[i].Days == nil {
+			openingHours[i].Days = []int{}
+		}
+	}
+
+	encoded, _ := json.Marshal(openingHours)
+	return string(encoded)
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/idempotency"
+)
+
+// TariffHandler handles tariff schedule endpoints of the parking or the charging chaincode
+type TariffHandler struct {
+	getContract func() *client.Contract
+}
+
+// NewTariffHandler creates a tariff handler for the chaincode returned by getContract
+func NewTariffHandler(getContract func() *client.Contract) *TariffHandler {
+	return &TariffHandler{getContract: getContract}
+}
+
+// TariffRule represents a time-of-day rate of a tariff
+type TariffRule struct {
+	Name      string  `json:"name"`
+	Days      []int   `json:"days"`      // 0 = Sunday ... 6 = Saturday, empty for every day
+	StartTime string  `json:"startTime"` // HH:MM local time
+	EndTime   string  `json:"endTime"`   // HH:MM local time
+	Rate      float64 `json:"rate"`
+}
+
+// SurgeTier represents an occupancy-based multiplier of a tariff
+type SurgeTier struct {
+	MinOccupancy float64 `json:"minOccupancy"` // percent
+	Multiplier   float64 `json:"multiplier"`
+}
+
+// CreateTariffRequest represents create tariff request
+type CreateTariffRequest struct {
+	Name             string       `json:"name" binding:"required"`
+	ScopeType        string       `json:"scopeType" binding:"required"`
+	ScopeID          string       `json:"scopeId" binding:"required"`
+	BaseRate         float64      `json:"baseRate"`
+	UTCOffsetMinutes int          `json:"utcOffsetMinutes"`
+	Rules            []TariffRule `json:"rules"`
+	SurgeTiers       []SurgeTier  `json:"surgeTiers"`
+	EffectiveFrom    string       `json:"effectiveFrom"` // defaults to now
+}
+
+// RetireTariffRequest represents retire tariff request
+type RetireTariffRequest struct {
+	EffectiveTo string `json:"effectiveTo"` // defaults to now
+}
+
+// CreateTariff creates a new tariff version
+func (h *TariffHandler) CreateTariff(c *gin.Context) {
+	var req CreateTariffRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Rules == nil {
+		req.Rules = []TariffRule{}
+	}
+	for i := range req.Rules {
+		if req.Rules[i].Days == nil {
+			req.Rules[i].Days = []int{}
+		}
+	}
+	if req.SurgeTiers == nil {
+		req.SurgeTiers = []SurgeTier{}
+	}
+	if req.EffectiveFrom == "" {
+		req.EffectiveFrom = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	rulesJSON, _ := json.Marshal(req.Rules)
+	surgeTiersJSON, _ := json.Marshal(req.SurgeTiers)
+
+	tariffId := idempotency.NewID(c, "tariff_")
+
+	contract := h.getContract()
+	_, err := contract.SubmitTransaction(
+		"CreateTariff",
+		tariffId,
+		req.Name,
+		req.ScopeType,
+		req.ScopeID,
+		fmt.Sprintf("%f", req.BaseRate),
+		strconv.Itoa(req.UTCOffsetMinutes),
+		string(rulesJSON),
+		string(surgeTiersJSON),
+		req.EffectiveFrom,
+	)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Tariff created successfully",
+		"tariffId": tariffId,
+	})
+}
+
+// GetTariff returns a tariff by ID
+func (h *TariffHandler) GetTariff(c *gin.Context) {
+	tariffId := c.Param("id")
+
+	contract := h.getContract()
+	result, err := contract.EvaluateTransaction("GetTariff", tariffId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tariff not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tariff": json.RawMessage(result)})
+}
+
+// GetTariffs returns the tariff versions attached to a scope
+func (h *TariffHandler) GetTariffs(c *gin.Context) {
+	scopeType := c.Query("scopeType")
+	scopeId := c.Query("scopeId")
+	if scopeType == "" || scopeId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scopeType and scopeId are required"})
+		return
+	}
+
+	contract := h.getContract()
+	result, err := contract.EvaluateTransaction("GetTariffsByScope", scopeType, scopeId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tariffs": json.RawMessage(result)})
+}
+
+// RetireTariff ends a tariff
+func (h *TariffHandler) RetireTariff(c *gin.Context) {
+	tariffId := c.Param("id")
+
+	var req RetireTariffRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.EffectiveTo == "" {
+		req.EffectiveTo = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	contract := h.getContract()
+	_, err := contract.SubmitTransaction("RetireTariff", tariffId, req.EffectiveTo)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tariff retired successfully"})
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/payments"
+)
+
+// topUpRecord holds the top-up fields the webhook checks
+type topUpRecord struct {
+	TopUpID     string `json:"topUpId"`
+	UserID      string `json:"userId"`
+	AmountMinor int64  `json:"amountMinor"`
+	Currency    string `json:"currency"`
+	Provider    string `json:"provider"`
+	ProviderRef string `json:"providerRef"`
+	Status      string `json:"status"`
+}
+
+// ==================== Top-up Endpoints ====================
+
+// GetTopUps returns the current user's top-ups
+func (h *WalletHandler) GetTopUps(c *gin.Context) {
+	userData, _ := c.Get("user")
+	var user struct {
+		UserID string `json:"userId"`
+	}
+	json.Unmarshal([]byte(userData.(string)), &user)
+
+	contract := h.fabricClient.GetWalletContract()
+	result, err := contract.EvaluateTransaction("GetUserTopUps", user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"topUps": decimalRecord(result)})
+}
+
+// GetTopUp returns one of the current user's top-ups, to poll for its confirmation
+func (h *WalletHandler) GetTopUp(c *gin.Context) {
+	userData, _ := c.Get("user")
+	var user struct {
+		UserID string `json:"userId"`
+	}
+	json.Unmarshal([]byte(userData.(string)), &user)
+
+	contract := h.fabricClient.GetWalletContract()
+	result, err := contract.EvaluateTransaction("GetTopUp", c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Top-up not found"})
+		return
+	}
+
+	var topUp topUpRecord
+	json.Unmarshal(result, &topUp)
+	if topUp.UserID != user.UserID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Top-up not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"topUp": decimalRecord(result)})
+}
+
+// PaymentWebhook receives payment outcomes from the payment provider. Confirmed
+// payments credit the wallet; failures and chargebacks are recorded on the top-up.
+// Any error status makes the provider deliver the event again later.
+func (h *WalletHandler) PaymentWebhook(c *gin.Context) {
+	if c.Param("provider") != h.provider.Name() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown payment provider"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	event, err := h.provider.ParseWebhook(c.Request.Header, body)
+	if err != nil {
+		if errors.Is(err, payments.ErrInvalidSignature) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if event.Type == "" {
+		c.JSON(http.StatusOK, gin.H{"received": true})
+		return
+	}
+
+	contract := h.fabricClient.GetWalletContract()
+	result, err := contract.EvaluateTransaction("GetTopUp", event.TopUpID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Top-up not found"})
+		return
+	}
+
+	var topUp topUpRecord
+	json.Unmarshal(result, &topUp)
+	if topUp.Provider != h.provider.Name() || topUp.ProviderRef != event.ProviderRef {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Event does not match the top-up's payment"})
+		return
+	}
+
+	// A failure reported after the payment was confirmed is stale
+	if event.Type == payments.EventFailed && topUp.Status != "pending" && topUp.Status != "failed" {
+		log.Printf("payments: ignoring failure event %s for %s top-up %s", event.ID, topUp.Status, topUp.TopUpID)
+		c.JSON(http.StatusOK, gin.H{"received": true})
+		return
+	}
+
+	switch event.Type {
+	case payments.EventSucceeded:
+		// Never credit a different amount than the user asked to top up
+		if event.AmountMinor != topUp.AmountMinor || event.Currency != topUp.Currency {
+			log.Printf("payments: event %s for top-up %s paid %d %s, expected %d %s",
+				event.ID, topUp.TopUpID, event.AmountMinor, event.Currency, topUp.AmountMinor, topUp.Currency)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Paid amount does not match the top-up"})
+			return
+		}
+		result, err = contract.SubmitTransaction("ConfirmTopUp", topUp.TopUpID)
+	case payments.EventFailed:
+		result, err = contract.SubmitTransaction("FailTopUp", topUp.TopUpID, event.Reason)
+	case payments.EventChargeback:
+		result, err = contract.SubmitTransaction("ChargebackTopUp", topUp.TopUpID, event.Reason)
+	}
+	if err != nil {
+		log.Printf("payments: failed to apply event %s to top-up %s: %v", event.ID, topUp.TopUpID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true, "topUp": decimalRecord(result)})
+}
@@ -6,18 +6,23 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/auth"
 	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
 )
 
 // UserHandler handles user management endpoints
 type UserHandler struct {
 	fabricClient *fabric.Client
+	tokens       *auth.TokenIssuer
+	revoked      *auth.RevocationList
 }
 
-// NewUserHandler creates a new user handler
-func NewUserHandler(fabricClient *fabric.Client) *UserHandler {
+// NewUserHandler creates a new user handler revoking the access tokens of deleted users
+func NewUserHandler(fabricClient *fabric.Client, tokens *auth.TokenIssuer, revoked *auth.RevocationList) *UserHandler {
 	return &UserHandler{
 		fabricClient: fabricClient,
+		tokens:       tokens,
+		revoked:      revoked,
 	}
 }
 
@@ -28,9 +33,19 @@ type UpdateUserRequest struct {
 	Phone     string `json:"phone"`
 }
 
-// GetUser returns a user by ID
+// AssignRoleRequest grants a role to a user
+type AssignRoleRequest struct {
+	Role   string `json:"role" binding:"required"`
+	Reason string `json:"reason" binding:"required"`
+}
+
+// GetUser returns a user by ID, to the user themselves or staff who can read users
 func (h *UserHandler) GetUser(c *gin.Context) {
 	userId := c.Param("id")
+	if !isSelfOr(c, userId, auth.PermReadUsers) {
+		forbidden(c, "user")
+		return
+	}
 
 	contract := h.fabricClient.GetUserContract()
 	result, err := contract.EvaluateTransaction("GetUser", userId)
@@ -42,9 +57,13 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"user": json.RawMessage(result)})
 }
 
-// UpdateUser updates a user
+// UpdateUser updates a user, by the user themselves or an admin
 func (h *UserHandler) UpdateUser(c *gin.Context) {
 	userId := c.Param("id")
+	if !isSelfOr(c, userId, auth.PermManageUsers) {
+		forbidden(c, "user")
+		return
+	}
 
 	var req UpdateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -73,10 +92,30 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	// Access tokens stay valid until they expire unless revoked
+	if err := h.revoked.RevokeUser(userId, h.tokens.TTL()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
 
-// ListAllUsers returns all users (admin only)
+// UnlockUser lifts the lockout of a user after repeated failed logins (support and admins)
+func (h *UserHandler) UnlockUser(c *gin.Context) {
+	userId := c.Param("id")
+
+	contract := h.fabricClient.GetUserContract()
+	_, err := contract.SubmitTransaction("UnlockUser", userId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
+
+// ListAllUsers returns all users (support and admins)
 func (h *UserHandler) ListAllUsers(c *gin.Context) {
 	contract := h.fabricClient.GetUserContract()
 	result, err := contract.EvaluateTransaction("ListAllUsers")
@@ -88,9 +127,14 @@ func (h *UserHandler) ListAllUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"users": json.RawMessage(result)})
 }
 
-// GetUserHistory returns the history of a user
+// GetUserHistory returns the history of a user, to the user themselves or staff who
+// can read users
 func (h *UserHandler) GetUserHistory(c *gin.Context) {
 	userId := c.Param("id")
+	if !isSelfOr(c, userId, auth.PermReadUsers) {
+		forbidden(c, "user")
+		return
+	}
 
 	contract := h.fabricClient.GetUserContract()
 	result, err := contract.EvaluateTransaction("GetUserHistory", userId)
@@ -101,3 +145,49 @@ func (h *UserHandler) GetUserHistory(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"history": json.RawMessage(result)})
 }
+
+// AssignRole grants a role to a user (admin only). The ledger checks that the caller is
+// an active admin changing someone else's role, and records who did it and why.
+func (h *UserHandler) AssignRole(c *gin.Context) {
+	userId := c.Param("id")
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	var req AssignRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !auth.IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	contract := h.fabricClient.GetUserContract()
+	result, err := contract.SubmitTransaction("AssignRole", userId, req.Role, claims.Subject, req.Reason)
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+
+	// Access tokens carry the role; the user refreshes or logs in again to get the new one
+	if err := h.revoked.RevokeUser(userId, h.tokens.TTL()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role assigned successfully", "assignment": json.RawMessage(result)})
+}
+
+// GetRoleHistory returns the role changes of a user, oldest first
+func (h *UserHandler) GetRoleHistory(c *gin.Context) {
+	userId := c.Param("id")
+
+	contract := h.fabricClient.GetUserContract()
+	result, err := contract.EvaluateTransaction("GetRoleHistory", userId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"history": json.RawMessage(result)})
+}
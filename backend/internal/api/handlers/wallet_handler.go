@@ -4,46 +4,68 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 
 	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/idempotency"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/money"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/payments"
 )
 
 // WalletHandler handles wallet and payment endpoints
 type WalletHandler struct {
 	fabricClient *fabric.Client
+	provider     payments.Provider
 }
 
-// NewWalletHandler creates a new wallet handler
-func NewWalletHandler(fabricClient *fabric.Client) *WalletHandler {
+// NewWalletHandler creates a new wallet handler collecting top-ups through provider
+func NewWalletHandler(fabricClient *fabric.Client, provider payments.Provider) *WalletHandler {
 	return &WalletHandler{
 		fabricClient: fabricClient,
+		provider:     provider,
 	}
 }
 
+// Amounts are decimal strings in the wallet's currency, such as "12.50"
+
 // CreateWalletRequest represents create wallet request
 type CreateWalletRequest struct {
-	InitialBalance float64 `json:"initialBalance"`
+	InitialBalance string `json:"initialBalance"`
+	Currency       string `json:"currency"` // ISO 4217 code, USD by default
 }
 
 // AddFundsRequest represents add funds request
 type AddFundsRequest struct {
-	Amount float64 `json:"amount" binding:"required,gt=0"`
+	Amount string `json:"amount" binding:"required"`
 }
 
 // ProcessPaymentRequest represents process payment request
 type ProcessPaymentRequest struct {
-	Amount      float64 `json:"amount" binding:"required,gt=0"`
-	Type        string  `json:"type" binding:"required"`
-	ReferenceID string  `json:"referenceId" binding:"required"`
-	Description string  `json:"description"`
+	Amount      string `json:"amount" binding:"required"`
+	Currency    string `json:"currency"` // must match the wallet's currency if set
+	Type        string `json:"type" binding:"required"`
+	ReferenceID string `json:"referenceId" binding:"required"`
+	Description string `json:"description"`
 }
 
 // RefundRequest represents refund request
 type RefundRequest struct {
-	Amount float64 `json:"amount" binding:"required,gt=0"`
+	Amount string `json:"amount" binding:"required"`
+}
+
+// MigrateAmountsRequest represents a request to migrate a page of wallet records
+type MigrateAmountsRequest struct {
+	PageSize int    `json:"pageSize"`
+	Bookmark string `json:"bookmark"`
+}
+
+// walletRecord holds the wallet fields the handlers need
+type walletRecord struct {
+	WalletID     string `json:"walletId"`
+	BalanceMinor int64  `json:"balanceMinor"`
+	Currency     string `json:"currency"`
 }
 
 // ==================== Wallet Endpoints ====================
@@ -52,7 +74,17 @@ type RefundRequest struct {
 func (h *WalletHandler) CreateWallet(c *gin.Context) {
 	var req CreateWalletRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		req.InitialBalance = 0
+		req = CreateWalletRequest{}
+	}
+	if req.InitialBalance == "" {
+		req.InitialBalance = "0"
+	}
+	if req.Currency == "" {
+		req.Currency = money.DefaultCurrency
+	}
+	if minor, err := money.Parse(req.InitialBalance, req.Currency); err != nil || minor < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalidAmount(req.InitialBalance, err)})
+		return
 	}
 
 	// Get user ID from context
@@ -62,14 +94,15 @@ func (h *WalletHandler) CreateWallet(c *gin.Context) {
 	}
 	json.Unmarshal([]byte(userData.(string)), &user)
 
-	walletId := "wallet_" + uuid.New().String()
+	walletId := idempotency.NewID(c, "wallet_")
 
 	contract := h.fabricClient.GetWalletContract()
 	_, err := contract.SubmitTransaction(
 		"CreateWallet",
 		walletId,
 		user.UserID,
-		fmt.Sprintf("%f", req.InitialBalance),
+		req.InitialBalance,
+		req.Currency,
 	)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -79,6 +112,7 @@ func (h *WalletHandler) CreateWallet(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{
 		"message":  "Wallet created successfully",
 		"walletId": walletId,
+		"currency": req.Currency,
 	})
 }
 
@@ -97,7 +131,7 @@ func (h *WalletHandler) GetWallet(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"wallet": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"wallet": decimalRecord(result)})
 }
 
 // GetBalance returns the current user's wallet balance
@@ -115,60 +149,91 @@ func (h *WalletHandler) GetBalance(c *gin.Context) {
 		return
 	}
 
-	var wallet struct {
-		WalletID string  `json:"walletId"`
-		Balance  float64 `json:"balance"`
-		Currency string  `json:"currency"`
-	}
+	var wallet walletRecord
 	json.Unmarshal(walletResult, &wallet)
 
 	c.JSON(http.StatusOK, gin.H{
-		"balance":  wallet.Balance,
+		"balance":  money.Format(wallet.BalanceMinor, wallet.Currency),
 		"currency": wallet.Currency,
 	})
 }
 
-// AddFunds adds funds to the current user's wallet
+// AddFunds starts a top-up of the current user's wallet. The wallet is credited once
+// the payment provider confirms the payment through its webhook.
 func (h *WalletHandler) AddFunds(c *gin.Context) {
+	h.startTopUp(c, currentCaller(c).UserID)
+}
+
+// AddOrganizationFunds starts a top-up of an organization's wallet (owners and managers)
+func (h *WalletHandler) AddOrganizationFunds(c *gin.Context) {
+	orgId := c.Param("id")
+	if _, ok := authorizeOrganization(c, h.fabricClient, orgId, true); !ok {
+		return
+	}
+	h.startTopUp(c, orgId)
+}
+
+// startTopUp creates a payment intent for a top-up of the wallet of ownerId, a user or
+// an organization, and records the pending top-up
+func (h *WalletHandler) startTopUp(c *gin.Context, ownerId string) {
 	var req AddFundsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	userData, _ := c.Get("user")
-	var user struct {
-		UserID string `json:"userId"`
-	}
-	json.Unmarshal([]byte(userData.(string)), &user)
-
+	user := currentCaller(c)
 	contract := h.fabricClient.GetWalletContract()
-	walletResult, err := contract.EvaluateTransaction("GetWalletByUserId", user.UserID)
+	walletResult, err := contract.EvaluateTransaction("GetWalletByUserId", ownerId)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Wallet not found"})
 		return
 	}
 
-	var wallet struct {
-		WalletID string `json:"walletId"`
-	}
+	var wallet walletRecord
 	json.Unmarshal(walletResult, &wallet)
 
-	transactionId := "topup_" + uuid.New().String()
-	_, err = contract.SubmitTransaction(
-		"AddFunds",
+	amountMinor, err := money.Parse(req.Amount, wallet.Currency)
+	if err != nil || amountMinor <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalidAmount(req.Amount, err)})
+		return
+	}
+
+	topUpId := idempotency.NewID(c, "topup_")
+	intent, err := h.provider.CreateIntent(c.Request.Context(), payments.IntentRequest{
+		TopUpID:     topUpId,
+		UserID:      user.UserID,
+		AmountMinor: amountMinor,
+		Currency:    wallet.Currency,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("failed to create payment: %v", err)})
+		return
+	}
+
+	result, err := contract.SubmitTransaction(
+		"CreateTopUp",
+		topUpId,
 		wallet.WalletID,
-		fmt.Sprintf("%f", req.Amount),
-		transactionId,
+		req.Amount,
+		h.provider.Name(),
+		intent.ProviderRef,
 	)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+		// A retry of a request whose top-up was already recorded
+		existing, getErr := contract.EvaluateTransaction("GetTopUp", topUpId)
+		if getErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		result = existing
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":       "Funds added successfully",
-		"transactionId": transactionId,
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":      "Top-up awaiting payment",
+		"topUp":        decimalRecord(result),
+		"clientSecret": intent.ClientSecret,
+		"redirectUrl":  intent.RedirectURL,
 	})
 }
 
@@ -187,7 +252,7 @@ func (h *WalletHandler) GetTransactions(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"transactions": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"transactions": decimalRecord(result)})
 }
 
 // GetTransaction returns a transaction by ID
@@ -201,7 +266,7 @@ func (h *WalletHandler) GetTransaction(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"transaction": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"transaction": decimalRecord(result)})
 }
 
 // GetTotalSpent returns total amount spent by the current user
@@ -213,13 +278,31 @@ func (h *WalletHandler) GetTotalSpent(c *gin.Context) {
 	json.Unmarshal([]byte(userData.(string)), &user)
 
 	contract := h.fabricClient.GetWalletContract()
+	walletResult, err := contract.EvaluateTransaction("GetWalletByUserId", user.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Wallet not found"})
+		return
+	}
+
+	var wallet walletRecord
+	json.Unmarshal(walletResult, &wallet)
+
 	result, err := contract.EvaluateTransaction("GetTotalSpent", user.UserID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"totalSpent": string(result)})
+	totalSpent, err := strconv.ParseInt(string(result), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"totalSpent": money.Format(totalSpent, wallet.Currency),
+		"currency":   wallet.Currency,
+	})
 }
 
 // ==================== Payment Endpoints ====================
@@ -245,17 +328,26 @@ func (h *WalletHandler) ProcessPayment(c *gin.Context) {
 		return
 	}
 
-	var wallet struct {
-		WalletID string `json:"walletId"`
-	}
+	var wallet walletRecord
 	json.Unmarshal(walletResult, &wallet)
 
-	paymentId := "payment_" + uuid.New().String()
+	if req.Currency != "" && req.Currency != wallet.Currency {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("payment currency %s does not match wallet currency %s", req.Currency, wallet.Currency)})
+		return
+	}
+	if minor, err := money.Parse(req.Amount, wallet.Currency); err != nil || minor <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalidAmount(req.Amount, err)})
+		return
+	}
+
+	paymentId := idempotency.NewID(c, "payment_")
 	result, err := contract.SubmitTransaction(
 		"ProcessPayment",
 		paymentId,
 		wallet.WalletID,
-		fmt.Sprintf("%f", req.Amount),
+		req.Amount,
+		wallet.Currency,
+		"", // no operator: direct payments are platform revenue
 		req.Type,
 		req.ReferenceID,
 		req.Description,
@@ -267,12 +359,13 @@ func (h *WalletHandler) ProcessPayment(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":   "Payment processed successfully",
-		"payment":   json.RawMessage(result),
+		"payment":   decimalRecord(result),
 		"paymentId": paymentId,
 	})
 }
 
-// RefundPayment refunds a payment
+// RefundPayment refunds a payment. Only the operator credited with the payment and
+// admins may refund it; users are refunded by cancelling their booking or session.
 func (h *WalletHandler) RefundPayment(c *gin.Context) {
 	paymentId := c.Param("id")
 
@@ -282,31 +375,49 @@ func (h *WalletHandler) RefundPayment(c *gin.Context) {
 		return
 	}
 
-	refundPaymentId := "refund_" + uuid.New().String()
+	payment, ok := authorizePayment(c, h.fabricClient, paymentId)
+	if !ok {
+		return
+	}
+	user := currentCaller(c)
+	if !user.canAccess("", payment.OperatorID) {
+		forbidden(c, "payment")
+		return
+	}
+
+	refundPaymentId := idempotency.NewID(c, "refund_")
 
+	// The ledger parses the amount in the currency of the payment
 	contract := h.fabricClient.GetWalletContract()
 	result, err := contract.SubmitTransaction(
 		"RefundPayment",
 		paymentId,
-		fmt.Sprintf("%f", req.Amount),
+		req.Amount,
 		refundPaymentId,
+		user.UserID,
+		user.Role,
 	)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ledgerError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":         "Payment refunded successfully",
-		"refund":          json.RawMessage(result),
+		"refund":          decimalRecord(result),
 		"refundPaymentId": refundPaymentId,
 	})
 }
 
-// GetPaymentReceipt returns a payment receipt
+// GetPaymentReceipt returns a payment receipt to the payer, the operator credited
+// with the payment or an admin
 func (h *WalletHandler) GetPaymentReceipt(c *gin.Context) {
 	paymentId := c.Param("id")
 
+	if _, ok := authorizePayment(c, h.fabricClient, paymentId); !ok {
+		return
+	}
+
 	contract := h.fabricClient.GetWalletContract()
 	result, err := contract.EvaluateTransaction("GetPaymentReceipt", paymentId)
 	if err != nil {
@@ -314,5 +425,58 @@ func (h *WalletHandler) GetPaymentReceipt(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"receipt": json.RawMessage(result)})
+	c.JSON(http.StatusOK, gin.H{"receipt": decimalRecord(result)})
+}
+
+// ==================== Admin Endpoints ====================
+
+// MigrateAmounts converts a page of wallet records holding floating point amounts to
+// minor units. Call again with the returned bookmark until it is empty.
+func (h *WalletHandler) MigrateAmounts(c *gin.Context) {
+	var req MigrateAmountsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		req = MigrateAmountsRequest{}
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = 100
+	}
+
+	contract := h.fabricClient.GetWalletContract()
+	result, err := contract.SubmitTransaction("MigrateAmounts", strconv.Itoa(req.PageSize), req.Bookmark)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"migration": json.RawMessage(result)})
+}
+
+// Reconcile checks every wallet and journal account balance against the double-entry
+// journal and reports the accounts that do not match
+func (h *WalletHandler) Reconcile(c *gin.Context) {
+	contract := h.fabricClient.GetWalletContract()
+	result, err := contract.EvaluateTransaction("Reconcile")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reconciliation": decimalRecord(result)})
+}
+
+// decimalRecord rewrites the minor unit amounts of a wallet ledger record as decimal strings
+func decimalRecord(result []byte) json.RawMessage {
+	formatted, err := money.FormatRecord(result)
+	if err != nil {
+		return json.RawMessage(result)
+	}
+	return json.RawMessage(formatted)
+}
+
+// invalidAmount describes an amount the API rejects
+func invalidAmount(amount string, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("invalid amount %q", amount)
 }
@@ -1,16 +1,18 @@
 package middleware
 
 import (
+	"encoding/json"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 
-	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/auth"
 )
 
-// AuthMiddleware validates session tokens from the blockchain
-func AuthMiddleware(fabricClient *fabric.Client) gin.HandlerFunc {
+// AuthMiddleware verifies signed access tokens locally, without querying the ledger,
+// and rejects revoked ones
+func AuthMiddleware(tokens *auth.TokenIssuer, revoked *auth.RevocationList) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get token from Authorization header
 		authHeader := c.GetHeader("Authorization")
@@ -30,48 +32,69 @@ func AuthMiddleware(fabricClient *fabric.Client) gin.HandlerFunc {
 
 		token := parts[1]
 
-		// Validate session on blockchain
-		contract := fabricClient.GetUserContract()
-		result, err := contract.EvaluateTransaction("ValidateSession", token)
+		claims, err := tokens.Verify(token)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			c.Abort()
+			return
+		}
+		if revoked.IsRevoked(claims) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
 			c.Abort()
 			return
 		}
 
-		// Parse user from result
-		// Store user info in context for handlers
-		c.Set("user", string(result))
+		// Store user info in context for handlers, in the shape of the ledger user
+		user, _ := json.Marshal(gin.H{
+			"userId":    claims.Subject,
+			"email":     claims.Email,
+			"firstName": claims.FirstName,
+			"lastName":  claims.LastName,
+			"role":      claims.Role,
+			"isActive":  true,
+		})
+		c.Set("user", string(user))
 		c.Set("token", token)
+		c.Set("claims", claims)
 
 		c.Next()
 	}
 }
 
-// AdminMiddleware checks if user has admin role
-func AdminMiddleware() gin.HandlerFunc {
+// RequirePermission checks that the user's role grants a permission. Staff roles must
+// also have MFA enabled before they can use it.
+func RequirePermission(permission auth.Permission) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get user from context (set by AuthMiddleware)
-		userData, exists := c.Get("user")
+		// Get claims from context (set by AuthMiddleware)
+		value, exists := c.Get("claims")
 		if !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
 			c.Abort()
 			return
 		}
+		claims := value.(*auth.Claims)
 
-		// Parse user data and check role
-		// In a real implementation, you would parse the JSON and check the role field
-		userStr := userData.(string)
-		if !strings.Contains(userStr, `"role":"admin"`) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		if !auth.HasPermission(claims.Role, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions", "permission": permission})
 			c.Abort()
 			return
 		}
+		if auth.RequiresMFA(claims.Role) && !claims.MFA {
+			staffMFARequired(c)
+			return
+		}
 
 		c.Next()
 	}
 }
 
+// staffMFARequired rejects a staff member who has not enabled MFA yet. They can still
+// log in and enroll at /auth/mfa, but not use their permissions until they do.
+func staffMFARequired(c *gin.Context) {
+	c.JSON(http.StatusForbidden, gin.H{"error": "Staff accounts must enable MFA", "mfaRequired": true})
+	c.Abort()
+}
+
 // CORSMiddleware adds CORS headers (fully permissive for educational purposes)
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
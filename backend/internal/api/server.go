@@ -1,13 +1,22 @@
 package api
 
 import (
+	"context"
+
 	"github.com/gin-gonic/gin"
 
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/auth"
 	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/config"
 	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
 	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/api/handlers"
 	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/api/middleware"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/idempotency"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/mail"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/ocpp"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/payments"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/saga"
 	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/security"
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/settlement"
 )
 
 // Server represents the API server
@@ -16,10 +25,18 @@ type Server struct {
 	config          *config.Config
 	fabricClient    *fabric.Client
 	securityMonitor *security.Monitor
+	sagas           *saga.Coordinator
+	settlements     *settlement.Scheduler
+	idempotency     *idempotency.Registry
+	payments        payments.Provider
+	tokens          *auth.TokenIssuer
+	revoked         *auth.RevocationList
+	mfaSecrets      *auth.SecretBox
+	mailer          mail.Mailer
 }
 
 // NewServer creates a new API server
-func NewServer(cfg *config.Config, fabricClient *fabric.Client) *Server {
+func NewServer(cfg *config.Config, fabricClient *fabric.Client, sagas *saga.Coordinator, idempotencyKeys *idempotency.Registry, paymentProvider payments.Provider, revoked *auth.RevocationList, mfaSecrets *auth.SecretBox, mailer mail.Mailer) *Server {
 	router := gin.Default()
 
 	// Initialize security monitor (store up to 10000 events)
@@ -36,8 +53,19 @@ func NewServer(cfg *config.Config, fabricClient *fabric.Client) *Server {
 		config:          cfg,
 		fabricClient:    fabricClient,
 		securityMonitor: securityMonitor,
+		sagas:           sagas,
+		settlements:     settlement.NewScheduler(fabricClient, cfg.SettlementInterval),
+		idempotency:     idempotencyKeys,
+		payments:        paymentProvider,
+		tokens:          auth.NewTokenIssuer(cfg.JWTSecret, cfg.AccessTokenTTL),
+		revoked:         revoked,
+		mfaSecrets:      mfaSecrets,
+		mailer:          mailer,
 	}
 
+	// Register cross-channel workflows before any request or recovery runs them
+	handlers.RegisterSagas(sagas, fabricClient)
+
 	server.setupRoutes()
 
 	return server
@@ -46,39 +74,92 @@ func NewServer(cfg *config.Config, fabricClient *fabric.Client) *Server {
 // setupRoutes sets up all API routes
 func (s *Server) setupRoutes() {
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(s.fabricClient)
-	userHandler := handlers.NewUserHandler(s.fabricClient)
-	parkingHandler := handlers.NewParkingHandler(s.fabricClient)
-	chargingHandler := handlers.NewChargingHandler(s.fabricClient)
-	walletHandler := handlers.NewWalletHandler(s.fabricClient)
+	authHandler := handlers.NewAuthHandler(s.fabricClient, s.tokens, s.revoked, s.mfaSecrets, s.mailer, handlers.AuthSettings{
+		RefreshTTL:      s.config.RefreshTokenTTL,
+		TokenKey:        s.config.SessionTokenKey,
+		MaxFailedLogins: s.config.MaxFailedLogins,
+		LockoutDuration: s.config.LockoutDuration,
+		AppURL:          s.config.AppURL,
+
+		BootstrapAdminEmail: s.config.BootstrapAdminEmail,
+	})
+	userHandler := handlers.NewUserHandler(s.fabricClient, s.tokens, s.revoked)
+	parkingHandler := handlers.NewParkingHandler(s.fabricClient, s.sagas)
+	facilityHandler := handlers.NewFacilityHandler(s.fabricClient)
+	chargingHandler := handlers.NewChargingHandler(s.fabricClient, s.sagas)
+	walletHandler := handlers.NewWalletHandler(s.fabricClient, s.payments)
+	organizationHandler := handlers.NewOrganizationHandler(s.fabricClient)
 	securityHandler := handlers.NewSecurityHandler(s.securityMonitor)
+	sagaHandler := handlers.NewSagaHandler(s.sagas)
+	operatorHandler := handlers.NewOperatorHandler(s.fabricClient, s.settlements)
+	parkingTariffHandler := handlers.NewTariffHandler(s.fabricClient.GetParkingContract)
+	chargingTariffHandler := handlers.NewTariffHandler(s.fabricClient.GetChargingContract)
+	parkingRefundPolicyHandler := handlers.NewRefundPolicyHandler(s.fabricClient.GetParkingContract)
+	chargingRefundPolicyHandler := handlers.NewRefundPolicyHandler(s.fabricClient.GetChargingContract)
+	parkingBlackoutHandler := handlers.NewBlackoutHandler(s.fabricClient.GetParkingContract)
+	chargingBlackoutHandler := handlers.NewBlackoutHandler(s.fabricClient.GetChargingContract)
+	authenticated := middleware.AuthMiddleware(s.tokens, s.revoked)
+	manageInfrastructure := middleware.RequirePermission(auth.PermManageInfrastructure)
+	idempotent := s.idempotency.Middleware()
+	centralSystem := ocpp.NewCentralSystem(handlers.NewOCPPHandler(s.fabricClient, s.sagas))
 
 	// Health check
 	s.router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok", "service": "cityflow-parking-api"})
 	})
 
+	// OCPP 1.6J central system (WebSocket, one connection per charge point)
+	s.router.GET("/ocpp/:chargePointId", func(c *gin.Context) {
+		centralSystem.ServeWS(c.Writer, c.Request, c.Param("chargePointId"))
+	})
+
 	// API v1 routes
 	v1 := s.router.Group("/api/v1")
 	{
 		// Authentication routes (public)
-		auth := v1.Group("/auth")
+		authRoutes := v1.Group("/auth")
 		{
-			auth.POST("/register", authHandler.Register)
-			auth.POST("/login", authHandler.Login)
-			auth.POST("/logout", middleware.AuthMiddleware(s.fabricClient), authHandler.Logout)
-			auth.GET("/me", middleware.AuthMiddleware(s.fabricClient), authHandler.GetCurrentUser)
+			authRoutes.POST("/register", idempotent, authHandler.Register)
+			authRoutes.POST("/login", authHandler.Login)
+			authRoutes.POST("/login/mfa", authHandler.LoginMFA)
+			authRoutes.POST("/refresh", authHandler.Refresh)
+			authRoutes.POST("/logout", authenticated, authHandler.Logout)
+			authRoutes.GET("/me", authenticated, authHandler.GetCurrentUser)
+
+			// Passwords and email verification
+			authRoutes.POST("/password/forgot", authHandler.ForgotPassword)
+			authRoutes.POST("/password/reset", authHandler.ResetPassword)
+			authRoutes.POST("/password/change", authenticated, authHandler.ChangePassword)
+			authRoutes.POST("/email/verify", authHandler.VerifyEmail)
+			authRoutes.POST("/email/resend", authenticated, authHandler.ResendVerification)
+
+			// Sessions of the current user, one per device
+			authRoutes.GET("/sessions", authenticated, authHandler.ListSessions)
+			authRoutes.DELETE("/sessions", authenticated, authHandler.RevokeOtherSessions)
+			authRoutes.DELETE("/sessions/:id", authenticated, authHandler.RevokeSession)
+			authRoutes.POST("/sessions/purge-legacy", authenticated, middleware.RequirePermission(auth.PermAdminister), authHandler.PurgeLegacySessions)
+
+			// TOTP second factor of the current user, mandatory for staff
+			authRoutes.GET("/mfa", authenticated, authHandler.GetMFAStatus)
+			authRoutes.POST("/mfa/enroll", authenticated, authHandler.EnrollMFA)
+			authRoutes.POST("/mfa/activate", authenticated, authHandler.ActivateMFA)
+			authRoutes.POST("/mfa/recovery-codes", authenticated, authHandler.RegenerateRecoveryCodes)
+			authRoutes.DELETE("/mfa", authenticated, authHandler.DisableMFA)
 		}
 
 		// User routes (protected)
 		users := v1.Group("/users")
-		users.Use(middleware.AuthMiddleware(s.fabricClient))
+		users.Use(authenticated)
+		users.Use(idempotent)
 		{
 			users.GET("/:id", userHandler.GetUser)
 			users.PUT("/:id", userHandler.UpdateUser)
-			users.DELETE("/:id", userHandler.DeleteUser)
-			users.GET("", middleware.AdminMiddleware(), userHandler.ListAllUsers)
+			users.DELETE("/:id", middleware.RequirePermission(auth.PermManageUsers), userHandler.DeleteUser)
+			users.POST("/:id/unlock", middleware.RequirePermission(auth.PermUnlockUsers), userHandler.UnlockUser)
+			users.GET("", middleware.RequirePermission(auth.PermReadUsers), userHandler.ListAllUsers)
 			users.GET("/:id/history", userHandler.GetUserHistory)
+			users.PUT("/:id/role", middleware.RequirePermission(auth.PermAssignRoles), userHandler.AssignRole)
+			users.GET("/:id/roles/history", middleware.RequirePermission(auth.PermReadUsers), userHandler.GetRoleHistory)
 		}
 
 		// Parking spot routes
@@ -87,17 +168,40 @@ func (s *Server) setupRoutes() {
 			// Public routes - specific routes BEFORE parameterized routes
 			parking.GET("/spots/search", parkingHandler.SearchSpots)
 			parking.GET("/spots/available", parkingHandler.GetAvailableSpots)
+			parking.GET("/spots/nearby", parkingHandler.GetNearbySpots)
 			parking.GET("/spots", parkingHandler.GetAllSpots)
 			parking.GET("/spots/:id", parkingHandler.GetSpot)
+			parking.GET("/spots/:id/availability", parkingHandler.GetSpotAvailability)
+			parking.GET("/spots/:id/quote", parkingHandler.QuoteBooking)
+			parking.GET("/facilities/occupancy", facilityHandler.GetFacilitiesOccupancy)
+			parking.GET("/facilities", facilityHandler.GetAllFacilities)
+			parking.GET("/facilities/:id", facilityHandler.GetFacility)
+			parking.GET("/facilities/:id/spots", facilityHandler.GetFacilitySpots)
+			parking.GET("/facilities/:id/occupancy", facilityHandler.GetFacilityOccupancy)
+			parking.GET("/tariffs", parkingTariffHandler.GetTariffs)
+			parking.GET("/tariffs/:id", parkingTariffHandler.GetTariff)
+			parking.GET("/refund-policy", parkingRefundPolicyHandler.GetRefundPolicy)
+			parking.GET("/blackouts", parkingBlackoutHandler.GetBlackouts)
 
 			// Protected routes
 			protected := parking.Group("")
-			protected.Use(middleware.AuthMiddleware(s.fabricClient))
+			protected.Use(authenticated)
+			protected.Use(idempotent)
 			{
-				// Admin only
-				protected.POST("/spots", middleware.AdminMiddleware(), parkingHandler.CreateSpot)
-				protected.PUT("/spots/:id", middleware.AdminMiddleware(), parkingHandler.UpdateSpot)
-				protected.DELETE("/spots/:id", middleware.AdminMiddleware(), parkingHandler.DeleteSpot)
+				// Infrastructure management
+				protected.POST("/spots", manageInfrastructure, parkingHandler.CreateSpot)
+				protected.PUT("/spots/:id", manageInfrastructure, parkingHandler.UpdateSpot)
+				protected.DELETE("/spots/:id", manageInfrastructure, parkingHandler.DeleteSpot)
+				protected.PUT("/spots/:id/facility", manageInfrastructure, facilityHandler.AssignSpot)
+				protected.PUT("/spots/:id/hours", manageInfrastructure, parkingHandler.SetSpotOpeningHours)
+				protected.POST("/blackouts", manageInfrastructure, parkingBlackoutHandler.CreateBlackout)
+				protected.DELETE("/blackouts/:id", manageInfrastructure, parkingBlackoutHandler.DeleteBlackout)
+				protected.POST("/facilities", manageInfrastructure, facilityHandler.CreateFacility)
+				protected.PUT("/facilities/:id", manageInfrastructure, facilityHandler.UpdateFacility)
+				protected.DELETE("/facilities/:id", manageInfrastructure, facilityHandler.DeleteFacility)
+				protected.POST("/tariffs", manageInfrastructure, parkingTariffHandler.CreateTariff)
+				protected.POST("/tariffs/:id/retire", manageInfrastructure, parkingTariffHandler.RetireTariff)
+				protected.PUT("/refund-policy", manageInfrastructure, parkingRefundPolicyHandler.SetRefundPolicy)
 
 				// Booking routes
 				protected.POST("/reserve", parkingHandler.CreateBooking)
@@ -111,6 +215,7 @@ func (s *Server) setupRoutes() {
 				protected.GET("/bookings/:id", parkingHandler.GetBooking)
 				protected.GET("/bookings/active", parkingHandler.GetActiveBookings)
 				protected.GET("/bookings/history", parkingHandler.GetBookingHistory)
+				protected.GET("/bookings/:id/quote", parkingHandler.QuoteExtension)
 			}
 		}
 
@@ -120,17 +225,36 @@ func (s *Server) setupRoutes() {
 			// Public routes - specific routes BEFORE parameterized routes
 			charging.GET("/stations/search", chargingHandler.SearchStations)
 			charging.GET("/stations/available", chargingHandler.GetAvailableStations)
+			charging.GET("/stations/nearby", chargingHandler.GetNearbyStations)
 			charging.GET("/stations", chargingHandler.GetAllStations)
 			charging.GET("/stations/:id", chargingHandler.GetStation)
+			charging.GET("/stations/:id/availability", chargingHandler.GetStationAvailability)
+			charging.GET("/tariffs", chargingTariffHandler.GetTariffs)
+			charging.GET("/tariffs/:id", chargingTariffHandler.GetTariff)
+			charging.GET("/refund-policy", chargingRefundPolicyHandler.GetRefundPolicy)
+			charging.GET("/blackouts", chargingBlackoutHandler.GetBlackouts)
 
 			// Protected routes
 			protected := charging.Group("")
-			protected.Use(middleware.AuthMiddleware(s.fabricClient))
+			protected.Use(authenticated)
+			protected.Use(idempotent)
 			{
-				// Admin only
-				protected.POST("/stations", middleware.AdminMiddleware(), chargingHandler.CreateStation)
-				protected.PUT("/stations/:id", middleware.AdminMiddleware(), chargingHandler.UpdateStation)
-				protected.DELETE("/stations/:id", middleware.AdminMiddleware(), chargingHandler.DeleteStation)
+				// Infrastructure management
+				protected.POST("/stations", manageInfrastructure, chargingHandler.CreateStation)
+				protected.PUT("/stations/:id", manageInfrastructure, chargingHandler.UpdateStation)
+				protected.DELETE("/stations/:id", manageInfrastructure, chargingHandler.DeleteStation)
+				protected.PUT("/stations/:id/fees", manageInfrastructure, chargingHandler.SetStationFees)
+				protected.PUT("/stations/:id/hours", manageInfrastructure, chargingHandler.SetStationOpeningHours)
+				protected.POST("/stations/:id/credential", manageInfrastructure, chargingHandler.ResetStationCredential)
+				protected.POST("/blackouts", manageInfrastructure, chargingBlackoutHandler.CreateBlackout)
+				protected.DELETE("/blackouts/:id", manageInfrastructure, chargingBlackoutHandler.DeleteBlackout)
+				protected.POST("/tariffs", manageInfrastructure, chargingTariffHandler.CreateTariff)
+				protected.POST("/tariffs/:id/retire", manageInfrastructure, chargingTariffHandler.RetireTariff)
+				protected.PUT("/refund-policy", manageInfrastructure, chargingRefundPolicyHandler.SetRefundPolicy)
+				protected.POST("/idtags/:idTag/block", manageInfrastructure, chargingHandler.BlockIdTag)
+
+				// OCPP identification
+				protected.POST("/idtags", chargingHandler.RegisterIdTag)
 
 				// Session routes
 				protected.POST("/start", chargingHandler.StartSession)
@@ -143,49 +267,117 @@ func (s *Server) setupRoutes() {
 				protected.GET("/sessions/:id", chargingHandler.GetSession)
 				protected.GET("/sessions/active", chargingHandler.GetActiveSessions)
 				protected.GET("/sessions/history", chargingHandler.GetSessionHistory)
+				protected.GET("/sessions/:id/quote", chargingHandler.QuoteSession)
+				protected.GET("/sessions/:id/readings", chargingHandler.GetSessionReadings)
 				protected.GET("/stats/energy", chargingHandler.GetEnergyStats)
 			}
 		}
 
 		// Wallet routes (all protected)
 		wallet := v1.Group("/wallet")
-		wallet.Use(middleware.AuthMiddleware(s.fabricClient))
+		wallet.Use(authenticated)
+		wallet.Use(idempotent)
 		{
 			wallet.POST("/create", walletHandler.CreateWallet)
 			wallet.GET("", walletHandler.GetWallet)
 			wallet.GET("/balance", walletHandler.GetBalance)
 			wallet.POST("/add-funds", walletHandler.AddFunds)
+			wallet.GET("/topups", walletHandler.GetTopUps)
+			wallet.GET("/topups/:id", walletHandler.GetTopUp)
 			wallet.GET("/transactions", walletHandler.GetTransactions)
 			wallet.GET("/transactions/:id", walletHandler.GetTransaction)
 			wallet.GET("/spending", walletHandler.GetTotalSpent)
 		}
 
+		// Organization routes (protected, members and managers checked per organization)
+		organizations := v1.Group("/organizations")
+		organizations.Use(authenticated)
+		organizations.Use(idempotent)
+		{
+			organizations.POST("", organizationHandler.CreateOrganization)
+			organizations.GET("", organizationHandler.GetOrganizations)
+			organizations.GET("/:id", organizationHandler.GetOrganization)
+			organizations.POST("/:id/wallet/add-funds", walletHandler.AddOrganizationFunds)
+			organizations.GET("/:id/members", organizationHandler.GetMembers)
+			organizations.POST("/:id/members", organizationHandler.AddMember)
+			organizations.GET("/:id/members/:userId", organizationHandler.GetMember)
+			organizations.PUT("/:id/members/:userId", organizationHandler.UpdateMember)
+			organizations.DELETE("/:id/members/:userId", organizationHandler.RemoveMember)
+			organizations.GET("/:id/statements/:month", organizationHandler.GetStatement)
+		}
+
 		// Payment routes (protected)
 		payment := v1.Group("/payment")
-		payment.Use(middleware.AuthMiddleware(s.fabricClient))
+		payment.Use(authenticated)
+		payment.Use(idempotent)
 		{
 			payment.POST("/process", walletHandler.ProcessPayment)
 			payment.POST("/refund/:id", walletHandler.RefundPayment)
 			payment.GET("/receipt/:id", walletHandler.GetPaymentReceipt)
 		}
 
-		// Security monitoring routes (admin only)
+		// Payment provider webhooks (public, verified by signature)
+		v1.POST("/payments/webhook/:provider", walletHandler.PaymentWebhook)
+
+		// Operator revenue routes (operators and admins)
+		operator := v1.Group("/operator")
+		operator.Use(authenticated)
+		operator.Use(middleware.RequirePermission(auth.PermViewRevenue))
+		{
+			operator.GET("/revenue", operatorHandler.GetRevenue)
+			operator.GET("/statements", operatorHandler.GetStatements)
+			operator.GET("/statements/:id", operatorHandler.GetStatement)
+		}
+
+		// Security monitoring routes (security auditors and admins)
 		securityRoutes := v1.Group("/security")
-		securityRoutes.Use(middleware.AuthMiddleware(s.fabricClient))
-		securityRoutes.Use(middleware.AdminMiddleware())
+		securityRoutes.Use(authenticated)
+		securityRoutes.Use(middleware.RequirePermission(auth.PermReadSecurity))
 		{
 			securityRoutes.GET("/dashboard", securityHandler.GetDashboard)
 			securityRoutes.GET("/events", securityHandler.GetEvents)
 			securityRoutes.GET("/events/range", securityHandler.GetEventsByTimeRange)
 			securityRoutes.GET("/alerts", securityHandler.GetAlerts)
-			securityRoutes.PUT("/alerts/:id/acknowledge", securityHandler.AcknowledgeAlert)
+			securityRoutes.PUT("/alerts/:id/acknowledge", middleware.RequirePermission(auth.PermManageSecurity), securityHandler.AcknowledgeAlert)
 			securityRoutes.GET("/stats", securityHandler.GetStats)
 			securityRoutes.GET("/health", securityHandler.GetSystemHealth)
 		}
+
+		// Administration routes (admin only)
+		admin := v1.Group("/admin")
+		admin.Use(authenticated)
+		admin.Use(middleware.RequirePermission(auth.PermAdminister))
+		admin.Use(idempotent)
+		{
+			admin.GET("/sagas", sagaHandler.ListSagas)
+			admin.GET("/sagas/:id", sagaHandler.GetSaga)
+			admin.POST("/sagas/:id/retry", sagaHandler.RetrySaga)
+			admin.GET("/pricing", parkingHandler.GetPricingRules)
+			admin.PUT("/pricing", parkingHandler.UpdatePricingRules)
+			admin.POST("/parking/reindex", parkingHandler.Reindex)
+			admin.POST("/charging/reindex", chargingHandler.Reindex)
+			admin.POST("/wallet/migrate", walletHandler.MigrateAmounts)
+			admin.GET("/wallet/reconcile", walletHandler.Reconcile)
+			admin.GET("/commission", operatorHandler.GetCommission)
+			admin.PUT("/commission", operatorHandler.SetCommission)
+			admin.POST("/settlements/run", operatorHandler.RunSettlement)
+		}
 	}
 }
 
 // Run starts the server
 func (s *Server) Run(addr string) error {
+	// Resume sagas interrupted by a previous shutdown and keep retrying compensations
+	go s.sagas.Run(context.Background())
+
+	// Pay operators their revenue at the end of every settlement period
+	go s.settlements.Run(context.Background())
+
+	// Forget stored Idempotency-Key responses once they expire
+	go s.idempotency.Run(context.Background())
+
+	// Forget revoked access tokens once they expire
+	go s.revoked.Run(context.Background())
+
 	return s.router.Run(addr)
 }
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// revocation covers the access tokens issued up to RevokedAt; it is dropped once
+// they have all expired
+type revocation struct {
+	RevokedAt time.Time `json:"revokedAt"`
+	Until     time.Time `json:"until"`
+}
+
+// RevocationList rejects access tokens before they expire: a single token after
+// logout, every token of a revoked session, or every token of a deactivated user.
+// It is persisted to a JSON file so revocations survive a restart.
+type RevocationList struct {
+	path    string
+	entries map[string]revocation
+	mu      sync.RWMutex
+}
+
+// NewRevocationList loads the revocation list persisted at path, creating it if needed
+func NewRevocationList(path string) (*RevocationList, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create revocation list directory: %w", err)
+	}
+
+	r := &RevocationList{path: path, entries: make(map[string]revocation)}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &r.entries); err != nil {
+			return nil, fmt.Errorf("corrupt revocation list %s: %w", path, err)
+		}
+	}
+	return r, nil
+}
+
+// RevokeToken rejects a single access token
+func (r *RevocationList) RevokeToken(claims *Claims) error {
+	return r.revoke("jti:"+claims.ID, claims.Expiry())
+}
+
+// RevokeSession rejects the access tokens issued so far for a session. ttl is the
+// access token lifetime, after which they have all expired.
+func (r *RevocationList) RevokeSession(sessionId string, ttl time.Duration) error {
+	return r.revoke("sid:"+sessionId, time.Now().Add(ttl))
+}
+
+// RevokeUser rejects the access tokens issued so far to a user
+func (r *RevocationList) RevokeUser(userId string, ttl time.Duration) error {
+	return r.revoke("sub:"+userId, time.Now().Add(ttl))
+}
+
+// IsRevoked reports whether an access token was revoked, directly or through its
+// session or user
+func (r *RevocationList) IsRevoked(claims *Claims) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range []string{"jti:" + claims.ID, "sid:" + claims.SessionID, "sub:" + claims.Subject} {
+		if entry, ok := r.entries[key]; ok && claims.IssuedAt <= entry.RevokedAt.Unix() {
+			return true
+		}
+	}
+	return false
+}
+
+// Run drops expired revocations every few minutes until ctx is cancelled
+func (r *RevocationList) Run(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.prune(); err != nil {
+				log.Printf("auth: failed to prune revocation list: %v", err)
+			}
+		}
+	}
+}
+
+func (r *RevocationList) revoke(key string, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[key] = revocation{RevokedAt: time.Now(), Until: until}
+	return r.save()
+}
+
+func (r *RevocationList) prune() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	pruned := false
+	for key, entry := range r.entries {
+		if now.After(entry.Until) {
+			delete(r.entries, key)
+			pruned = true
+		}
+	}
+	if !pruned {
+		return nil
+	}
+	return r.save()
+}
+
+// save writes the list atomically so a crash never leaves a truncated file
+func (r *RevocationList) save() error {
+	data, err := json.Marshal(r.entries)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), ".revoked-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), r.path)
+}
@@ -0,0 +1,89 @@
+package auth
+
+// Roles a user can hold, as stored by the user chaincode. New accounts are users;
+// admins grant the other roles.
+const (
+	RoleUser            = "user"
+	RoleOperator        = "operator"
+	RoleSupport         = "support"
+	RoleSecurityAuditor = "security-auditor"
+	RoleAdmin           = "admin"
+)
+
+// Permission is an action on the API that only some roles may take
+type Permission string
+
+// Permissions checked by the API's routes
+const (
+	// PermViewRevenue covers an operator's own revenue and settlement statements
+	PermViewRevenue Permission = "revenue:read"
+	// PermReadUsers covers reading other users' accounts
+	PermReadUsers Permission = "users:read"
+	// PermUnlockUsers covers lifting a lockout after failed logins
+	PermUnlockUsers Permission = "users:unlock"
+	// PermManageUsers covers editing and deactivating other users' accounts
+	PermManageUsers Permission = "users:manage"
+	// PermAssignRoles covers granting and revoking roles
+	PermAssignRoles Permission = "roles:assign"
+	// PermReadSecurity covers the security dashboard, events and alerts
+	PermReadSecurity Permission = "security:read"
+	// PermManageSecurity covers acknowledging security alerts
+	PermManageSecurity Permission = "security:manage"
+	// PermManageInfrastructure covers spots, stations, tariffs and refund policies
+	PermManageInfrastructure Permission = "infrastructure:manage"
+	// PermAdminister covers sagas, pricing, commission, settlements, wallet
+	// maintenance and session purges
+	PermAdminister Permission = "system:administer"
+)
+
+// rolePermissions grants each role its permissions; admins have them all
+var rolePermissions = map[string][]Permission{
+	RoleUser:     {},
+	RoleOperator: {PermViewRevenue},
+	RoleSupport:  {PermReadUsers, PermUnlockUsers},
+	RoleSecurityAuditor: {
+		PermReadSecurity,
+	},
+	RoleAdmin: {
+		PermViewRevenue,
+		PermReadUsers,
+		PermUnlockUsers,
+		PermManageUsers,
+		PermAssignRoles,
+		PermReadSecurity,
+		PermManageSecurity,
+		PermManageInfrastructure,
+		PermAdminister,
+	},
+}
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether a role grants a permission
+func HasPermission(role string, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions returns the permissions a role grants
+func Permissions(role string) []Permission {
+	return append([]Permission{}, rolePermissions[role]...)
+}
+
+// RequiresMFA reports whether a role is staff, whose accounts must have MFA enabled
+// before they can use their permissions
+func RequiresMFA(role string) bool {
+	switch role {
+	case RoleAdmin, RoleSupport, RoleSecurityAuditor:
+		return true
+	}
+	return false
+}
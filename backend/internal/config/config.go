@@ -1,9 +1,28 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"time"
 )
 
+// insecureWebhookSecret is the webhook secret earlier versions defaulted to, refused
+// because it is public
+const insecureWebhookSecret = "fake-webhook-secret-change-in-production"
+
+// insecureJWTSecret is the access token signing secret earlier versions defaulted to,
+// refused because it is public
+const insecureJWTSecret = "your-secret-key-change-in-production"
+
+// insecureSessionTokenKey is the token hashing key earlier versions defaulted to, refused
+// because it is public
+const insecureSessionTokenKey = "your-session-key-change-in-production"
+
+// insecureMFAEncryptionKey is the TOTP secret encryption key earlier versions defaulted
+// to, refused because it is public
+const insecureMFAEncryptionKey = "your-mfa-key-change-in-production"
+
 // Config holds application configuration
 type Config struct {
 	// Fabric connection settings
@@ -30,6 +49,49 @@ type Config struct {
 	// Server settings
 	ServerPort string
 	JWTSecret  string
+
+	// Lifetime of signed access tokens, and of the refresh tokens kept on the ledger
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Key of the hashes under which refresh, reset and verification tokens are stored on the ledger
+	SessionTokenKey string
+
+	// Key the TOTP secrets kept on the ledger are encrypted with
+	MFAEncryptionKey string
+
+	// File where revoked access tokens are persisted until they expire
+	RevocationListPath string
+
+	// Failed logins in a row that lock an account, and for how long
+	MaxFailedLogins int
+	LockoutDuration time.Duration
+
+	// Mailer sending password reset and verification links ("file" or "smtp"), the
+	// sender address, the directory of the file mailer and the server of the SMTP one
+	Mailer   string
+	MailFrom string
+	MailDir  string
+	SMTPAddr string
+
+	// Base URL of the frontend, for links in email
+	AppURL string
+
+	// Email of the account made the first admin once it is verified, while there is none
+	BootstrapAdminEmail string
+
+	// Directory where saga progress is persisted
+	SagaStoreDir string
+
+	// Directory where responses to requests with an Idempotency-Key are persisted
+	IdempotencyStoreDir string
+
+	// Payment provider collecting wallet top-ups, and the secret its webhooks are signed with
+	PaymentProvider      string
+	PaymentWebhookSecret string
+
+	// How often operator revenue is settled; settlement periods end on multiples of it
+	SettlementInterval time.Duration
 }
 
 // Load loads configuration from environment variables
@@ -62,8 +124,83 @@ func Load() (*Config, error) {
 
 		// Server settings
 		ServerPort: getEnv("PORT", "8080"),
-		JWTSecret:  getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
+		JWTSecret:  getEnv("JWT_SECRET", ""),
+
+		SessionTokenKey:    getEnv("SESSION_TOKEN_KEY", ""),
+		MFAEncryptionKey:   getEnv("MFA_ENCRYPTION_KEY", ""),
+		RevocationListPath: getEnv("REVOCATION_LIST_PATH", workDir+"/data/revoked-tokens.json"),
+
+		Mailer:   getEnv("MAILER", "file"),
+		MailFrom: getEnv("MAIL_FROM", "CityFlow <no-reply@cityflow.local>"),
+		MailDir:  getEnv("MAIL_DIR", workDir+"/data/mail"),
+		SMTPAddr: getEnv("SMTP_ADDR", "localhost:1025"),
+		AppURL:   getEnv("APP_URL", "http://localhost:5173"),
+
+		BootstrapAdminEmail: getEnv("BOOTSTRAP_ADMIN_EMAIL", ""),
+
+		SagaStoreDir:        getEnv("SAGA_STORE_DIR", workDir+"/data/sagas"),
+		IdempotencyStoreDir: getEnv("IDEMPOTENCY_STORE_DIR", workDir+"/data/idempotency"),
+
+		PaymentProvider:      getEnv("PAYMENT_PROVIDER", ""),
+		PaymentWebhookSecret: getEnv("PAYMENT_WEBHOOK_SECRET", ""),
+	}
+
+	// The fake provider credits wallets without charging anyone, so it must be chosen
+	// explicitly, and webhooks are only trusted with a secret of the deployment's own
+	if cfg.PaymentProvider == "" {
+		return nil, fmt.Errorf("PAYMENT_PROVIDER is required; set it to \"fake\" for local development")
+	}
+	if cfg.PaymentWebhookSecret == "" || cfg.PaymentWebhookSecret == insecureWebhookSecret {
+		return nil, fmt.Errorf("PAYMENT_WEBHOOK_SECRET must be set to a secret of your own")
+	}
+
+	// Access tokens are trusted on their signature alone, role and MFA claims included
+	if cfg.JWTSecret == "" || cfg.JWTSecret == insecureJWTSecret {
+		return nil, fmt.Errorf("JWT_SECRET must be set to a secret of your own")
+	}
+
+	// Refresh, reset and verification tokens are stored on the ledger hashed with this key;
+	// with a public one, their hashes can be matched by anyone reading the ledger
+	if cfg.SessionTokenKey == "" || cfg.SessionTokenKey == insecureSessionTokenKey {
+		return nil, fmt.Errorf("SESSION_TOKEN_KEY must be set to a secret of your own")
+	}
+
+	// With a public key, anyone reading the user channel can decrypt the TOTP secrets
+	if cfg.MFAEncryptionKey == "" || cfg.MFAEncryptionKey == insecureMFAEncryptionKey {
+		return nil, fmt.Errorf("MFA_ENCRYPTION_KEY must be set to a secret of your own")
+	}
+
+	settlementInterval, err := time.ParseDuration(getEnv("SETTLEMENT_INTERVAL", "24h"))
+	if err != nil || settlementInterval <= 0 {
+		return nil, fmt.Errorf("invalid SETTLEMENT_INTERVAL: %q", os.Getenv("SETTLEMENT_INTERVAL"))
+	}
+	cfg.SettlementInterval = settlementInterval
+
+	accessTokenTTL, err := time.ParseDuration(getEnv("ACCESS_TOKEN_TTL", "15m"))
+	if err != nil || accessTokenTTL <= 0 {
+		return nil, fmt.Errorf("invalid ACCESS_TOKEN_TTL: %q", os.Getenv("ACCESS_TOKEN_TTL"))
+	}
+	cfg.AccessTokenTTL = accessTokenTTL
+
+	// The ledger expires sessions in whole hours
+	refreshTokenTTL, err := time.ParseDuration(getEnv("REFRESH_TOKEN_TTL", "720h"))
+	if err != nil || refreshTokenTTL < time.Hour {
+		return nil, fmt.Errorf("invalid REFRESH_TOKEN_TTL: %q", os.Getenv("REFRESH_TOKEN_TTL"))
+	}
+	cfg.RefreshTokenTTL = refreshTokenTTL
+
+	maxFailedLogins, err := strconv.Atoi(getEnv("MAX_FAILED_LOGINS", "5"))
+	if err != nil || maxFailedLogins <= 0 {
+		return nil, fmt.Errorf("invalid MAX_FAILED_LOGINS: %q", os.Getenv("MAX_FAILED_LOGINS"))
+	}
+	cfg.MaxFailedLogins = maxFailedLogins
+
+	// The ledger locks accounts for whole minutes
+	lockoutDuration, err := time.ParseDuration(getEnv("LOCKOUT_DURATION", "15m"))
+	if err != nil || lockoutDuration < time.Minute {
+		return nil, fmt.Errorf("invalid LOCKOUT_DURATION: %q", os.Getenv("LOCKOUT_DURATION"))
 	}
+	cfg.LockoutDuration = lockoutDuration
 
 	// Set derived paths based on organization
 	// Using Admin user for backend API operations
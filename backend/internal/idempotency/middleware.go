@@ -0,0 +1,222 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Header is the request header carrying the client's idempotency key
+const Header = "Idempotency-Key"
+
+// ReplayedHeader is set on responses replayed from the store
+const ReplayedHeader = "Idempotent-Replayed"
+
+// maxKeyLength bounds the keys clients may send
+const maxKeyLength = 255
+
+// contextKey holds the hashed key of the current request in the gin context
+const contextKey = "idempotencyKey"
+
+// namespace derives ledger IDs from idempotency keys
+var namespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://cityflow.com/idempotency"))
+
+// Registry runs each keyed request once and replays its response to retries.
+// Responses are kept for the retention period.
+type Registry struct {
+	store     Store
+	retention time.Duration
+	inFlight  map[string]bool
+	mu        sync.Mutex
+}
+
+// NewRegistry creates a registry keeping responses in store for retention
+func NewRegistry(store Store, retention time.Duration) *Registry {
+	return &Registry{
+		store:     store,
+		retention: retention,
+		inFlight:  make(map[string]bool),
+	}
+}
+
+// Middleware honours the Idempotency-Key header of POST, PUT, PATCH and DELETE
+// requests. It must run after AuthMiddleware so that keys are scoped to the user.
+//
+// The first response for a key is stored unless it is a server error or a conflict,
+// which the client may retry. A retry with the same method, path and body gets the
+// stored response; reusing a key for a different request is rejected. Ledger IDs are
+// derived from the key (see NewID), so a retry that runs the handler again, because
+// the first attempt failed or the backend restarted before storing the response,
+// submits the same IDs and cannot charge or book twice.
+func (r *Registry) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+
+		clientKey := c.GetHeader(Header)
+		if clientKey == "" {
+			c.Next()
+			return
+		}
+		if len(clientKey) > maxKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := hash(currentUser(c), clientKey)
+		fingerprint := hash(c.Request.Method, c.Request.URL.Path, string(body))
+
+		if !r.acquire(key) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still in progress"})
+			c.Abort()
+			return
+		}
+		defer r.release(key)
+
+		record, err := r.store.Load(key)
+		if err == nil && time.Since(record.CreatedAt) < r.retention {
+			if record.Fingerprint != fingerprint {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+				c.Abort()
+				return
+			}
+			c.Header(ReplayedHeader, "true")
+			c.Data(record.StatusCode, record.ContentType, record.Body)
+			c.Abort()
+			return
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("idempotency: failed to load key: %v", err)
+		}
+
+		c.Set(contextKey, key)
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError || status == http.StatusConflict {
+			return
+		}
+		err = r.store.Save(&Record{
+			Key:         key,
+			Fingerprint: fingerprint,
+			StatusCode:  status,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+			CreatedAt:   time.Now(),
+		})
+		if err != nil {
+			log.Printf("idempotency: failed to store response: %v", err)
+		}
+	}
+}
+
+// Run deletes expired responses every hour until ctx is cancelled
+func (r *Registry) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.store.Prune(time.Now().Add(-r.retention)); err != nil {
+				log.Printf("idempotency: failed to prune responses: %v", err)
+			}
+		}
+	}
+}
+
+// NewID returns a new ledger ID such as "payment_<uuid>". In a request carrying an
+// Idempotency-Key the UUID is derived from the key and prefix, so every retry of the
+// request uses the same IDs. A handler must use distinct prefixes for the IDs it needs.
+func NewID(c *gin.Context, prefix string) string {
+	key := c.GetString(contextKey)
+	if key == "" {
+		return prefix + uuid.New().String()
+	}
+	return prefix + uuid.NewSHA1(namespace, []byte(key+"/"+prefix)).String()
+}
+
+func (r *Registry) acquire(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.inFlight[key] {
+		return false
+	}
+	r.inFlight[key] = true
+	return true
+}
+
+func (r *Registry) release(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.inFlight, key)
+}
+
+// currentUser returns the ID of the authenticated user, empty for public endpoints
+func currentUser(c *gin.Context) string {
+	userData, exists := c.Get("user")
+	if !exists {
+		return ""
+	}
+	var user struct {
+		UserID string `json:"userId"`
+	}
+	json.Unmarshal([]byte(userData.(string)), &user)
+	return user.UserID
+}
+
+// hash joins parts unambiguously and returns their SHA-256 in hex
+func hash(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the response body
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,130 @@
+// Package idempotency makes state-changing API requests safe to retry. A request sent
+// with an Idempotency-Key header runs once; repeating the key replays the stored
+// response instead of running the handler again.
+package idempotency
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when no response is stored for a key
+var ErrNotFound = errors.New("idempotency record not found")
+
+// Record is the stored response of the first request made with a key
+type Record struct {
+	Key         string    `json:"key"`         // hash of the caller and the Idempotency-Key
+	Fingerprint string    `json:"fingerprint"` // hash of the method, path and body
+	StatusCode  int       `json:"statusCode"`
+	ContentType string    `json:"contentType"`
+	Body        []byte    `json:"body"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Store persists idempotency records
+type Store interface {
+	Save(r *Record) error
+	Load(key string) (*Record, error)
+	Prune(before time.Time) error
+}
+
+// FileStore stores each record as a JSON file in a directory
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileStore creates a file-backed idempotency store rooted at dir
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create idempotency store directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Save writes a record atomically so a crash never leaves a truncated response
+func (fs *FileStore) Save(r *Record) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(fs.dir, ".key-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fs.path(r.Key))
+}
+
+// Load reads the record of a key
+func (fs *FileStore) Load(key string) (*Record, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data, err := os.ReadFile(fs.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var r Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("corrupt idempotency record %s: %w", key, err)
+	}
+	return &r, nil
+}
+
+// Prune deletes the records created before a time
+func (fs *FileStore) Prune(before time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Before(before) {
+			os.Remove(filepath.Join(fs.dir, entry.Name()))
+		}
+	}
+	return nil
+}
+
+func (fs *FileStore) path(key string) string {
+	return filepath.Join(fs.dir, key+".json")
+}
@@ -0,0 +1,35 @@
+// Package mail sends the API's outgoing email, such as password reset and email
+// verification links. Mailers are pluggable; the built-in ones deliver locally, to a
+// directory or to an SMTP sink such as MailHog, so no message leaves a development
+// machine.
+package mail
+
+import (
+	"context"
+	"fmt"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers outgoing email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the mailer configured by name: "file" writes messages to dir, "smtp"
+// relays them to the SMTP server at smtpAddr
+func New(name, from, dir, smtpAddr string) (Mailer, error) {
+	switch name {
+	case "file":
+		return NewFileMailer(dir, from)
+	case "smtp":
+		return NewSMTPMailer(smtpAddr, from), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", name)
+	}
+}
@@ -0,0 +1,156 @@
+// Package money converts between the integer minor units the wallet chaincode stores
+// and the decimal strings the API accepts and returns.
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of new wallets unless another one is requested
+const DefaultCurrency = "USD"
+
+// minorSuffix marks ledger fields holding minor units
+const minorSuffix = "Minor"
+
+// exponents lists the supported ISO 4217 currencies and their number of minor unit
+// digits. It must match the wallet chaincode.
+var exponents = map[string]int{
+	"AED": 2,
+	"AUD": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"INR": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"MAD": 2,
+	"SAR": 2,
+	"SEK": 2,
+	"USD": 2,
+}
+
+// Exponent returns the number of minor unit digits of an ISO 4217 currency
+func Exponent(currency string) (int, error) {
+	exponent, ok := exponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("unsupported currency %q", currency)
+	}
+	return exponent, nil
+}
+
+// Parse converts a decimal string such as "12.50" to minor units of currency.
+// Amounts with more decimals than the currency has are rejected rather than rounded.
+func Parse(amount, currency string) (int64, error) {
+	exponent, err := Exponent(currency)
+	if err != nil {
+		return 0, err
+	}
+
+	s := strings.TrimSpace(amount)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return 0, fmt.Errorf("invalid amount %q", amount)
+	}
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > exponent {
+		return 0, fmt.Errorf("amount %q has more than %d decimals for %s", amount, exponent, currency)
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	digits := whole + fraction
+	if digits == "" {
+		digits = "0"
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("invalid amount %q", amount)
+		}
+	}
+
+	minor, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %v", amount, err)
+	}
+	if negative {
+		minor = -minor
+	}
+	return minor, nil
+}
+
+// Format converts minor units of currency to a decimal string
+func Format(minor int64, currency string) string {
+	exponent, err := Exponent(currency)
+	if err != nil || exponent == 0 {
+		return strconv.FormatInt(minor, 10)
+	}
+
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	unit := int64(math.Pow10(exponent))
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/unit, exponent, minor%unit)
+}
+
+// FromFloat converts a computed price to minor units of currency, rounding half away
+// from zero. Prices are quoted by the parking and charging chaincodes as floats.
+func FromFloat(amount float64, currency string) int64 {
+	exponent, err := Exponent(currency)
+	if err != nil {
+		exponent = 2
+	}
+	return int64(math.Round(amount * math.Pow10(exponent)))
+}
+
+// FormatRecord rewrites the minor unit fields of a wallet ledger record, or a list
+// of records, as decimal strings: {"amountMinor": 1250, "currency": "EUR"} becomes
+// {"amount": "12.50", "currency": "EUR"}. Nested records without a currency, such as
+// line items, use the currency of the record that contains them.
+func FormatRecord(record []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(record))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(formatValue(value, DefaultCurrency))
+}
+
+func formatValue(value interface{}, currency string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if c, ok := v["currency"].(string); ok && c != "" {
+			currency = c
+		}
+		formatted := make(map[string]interface{}, len(v))
+		for key, field := range v {
+			if n, ok := field.(json.Number); ok && strings.HasSuffix(key, minorSuffix) {
+				if minor, err := n.Int64(); err == nil {
+					formatted[strings.TrimSuffix(key, minorSuffix)] = Format(minor, currency)
+					continue
+				}
+			}
+			formatted[key] = formatValue(field, currency)
+		}
+		return formatted
+	case []interface{}:
+		for i, item := range v {
+			v[i] = formatValue(item, currency)
+		}
+		return v
+	}
+	return value
+}
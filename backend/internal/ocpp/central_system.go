@@ -0,0 +1,257 @@
+package ocpp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// HeartbeatInterval is the heartbeat interval sent to charge points in seconds
+const HeartbeatInterval = 300
+
+// maxMessageSize bounds the size of a single frame read from a charge point
+const maxMessageSize = 64 * 1024
+
+// Handler applies the requests of charge points. chargePointId identifies the
+// charge point that sent the request.
+type Handler interface {
+	// AuthenticateChargePoint reports whether password is the credential of the charge point
+	AuthenticateChargePoint(ctx context.Context, chargePointId, password string) (bool, error)
+	BootNotification(ctx context.Context, chargePointId string, req *BootNotificationRequest) (*BootNotificationResponse, error)
+	StatusNotification(ctx context.Context, chargePointId string, req *StatusNotificationRequest) error
+	Authorize(ctx context.Context, chargePointId string, req *AuthorizeRequest) (*IdTagInfo, error)
+	StartTransaction(ctx context.Context, chargePointId string, req *StartTransactionRequest) (*StartTransactionResponse, error)
+	MeterValues(ctx context.Context, chargePointId string, req *MeterValuesRequest) error
+	StopTransaction(ctx context.Context, chargePointId string, req *StopTransactionRequest) (*StopTransactionResponse, error)
+}
+
+// CentralSystem accepts OCPP 1.6J connections from charge points
+type CentralSystem struct {
+	handler  Handler
+	upgrader websocket.Upgrader
+
+	mu          sync.Mutex
+	connections map[string]*websocket.Conn
+}
+
+// NewCentralSystem creates a central system dispatching requests to handler.
+// Charge points must authenticate with HTTP basic auth using their ID as username and
+// their own password (OCPP security profile 1); there is no shared or anonymous access.
+func NewCentralSystem(handler Handler) *CentralSystem {
+	return &CentralSystem{
+		handler: handler,
+		upgrader: websocket.Upgrader{
+			// Charge points send no Origin; requests from browsers must be same-origin
+			Subprotocols: []string{Subprotocol},
+		},
+		connections: make(map[string]*websocket.Conn),
+	}
+}
+
+// ServeWS upgrades the request to a WebSocket connection and serves the charge point
+// until it disconnects
+func (cs *CentralSystem) ServeWS(w http.ResponseWriter, r *http.Request, chargePointId string) {
+	if chargePointId == "" {
+		http.Error(w, "charge point ID is required", http.StatusBadRequest)
+		return
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok || username != chargePointId || password == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="ocpp"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	authenticated, err := cs.handler.AuthenticateChargePoint(r.Context(), chargePointId, password)
+	if err != nil {
+		log.Printf("OCPP authentication failed for %s: %v", chargePointId, err)
+		http.Error(w, "authentication unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if !authenticated {
+		w.Header().Set("WWW-Authenticate", `Basic realm="ocpp"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requestsSubprotocol(r) {
+		http.Error(w, "subprotocol "+Subprotocol+" is required", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := cs.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("OCPP upgrade failed for %s: %v", chargePointId, err)
+		return
+	}
+
+	cs.register(chargePointId, conn)
+	defer cs.unregister(chargePointId, conn)
+
+	log.Printf("OCPP charge point %s connected", chargePointId)
+	cs.serve(chargePointId, conn)
+	log.Printf("OCPP charge point %s disconnected", chargePointId)
+}
+
+// NewPassword returns a random charge point password. OCPP 1.6 limits the basic auth
+// password to 40 characters, so it is 20 random bytes in hex.
+func NewPassword() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate password: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// register tracks the connection of a charge point, closing an earlier one
+func (cs *CentralSystem) register(chargePointId string, conn *websocket.Conn) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if previous, ok := cs.connections[chargePointId]; ok {
+		previous.Close()
+	}
+	cs.connections[chargePointId] = conn
+}
+
+func (cs *CentralSystem) unregister(chargePointId string, conn *websocket.Conn) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.connections[chargePointId] == conn {
+		delete(cs.connections, chargePointId)
+	}
+	conn.Close()
+}
+
+// serve reads calls from a charge point and answers each before reading the next,
+// as OCPP allows only one outstanding call per direction
+func (cs *CentralSystem) serve(chargePointId string, conn *websocket.Conn) {
+	conn.SetReadLimit(maxMessageSize)
+
+	// A charge point that misses two heartbeats is considered gone
+	timeout := 2 * HeartbeatInterval * time.Second
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		if messageType != websocket.TextMessage {
+			continue
+		}
+
+		response := cs.dispatch(chargePointId, data)
+		if response == nil {
+			continue
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, response); err != nil {
+			return
+		}
+	}
+}
+
+// dispatch handles a single frame and returns the frame to answer with, if any
+func (cs *CentralSystem) dispatch(chargePointId string, data []byte) []byte {
+	call, err := ParseCall(data)
+	if err != nil {
+		// Frames without a unique ID, and results of calls we never made, cannot be answered
+		if call == nil {
+			log.Printf("OCPP %s sent an invalid frame: %v", chargePointId, err)
+			return nil
+		}
+		return callError(call.UniqueID, ErrorProtocolError, err.Error())
+	}
+
+	ctx := context.Background()
+
+	var result interface{}
+	switch call.Action {
+	case ActionBootNotification:
+		var req BootNotificationRequest
+		if err := json.Unmarshal(call.Payload, &req); err != nil {
+			return callError(call.UniqueID, ErrorFormationViolation, err.Error())
+		}
+		result, err = cs.handler.BootNotification(ctx, chargePointId, &req)
+	case ActionHeartbeat:
+		result = &HeartbeatResponse{CurrentTime: time.Now().UTC()}
+	case ActionStatusNotification:
+		var req StatusNotificationRequest
+		if err := json.Unmarshal(call.Payload, &req); err != nil {
+			return callError(call.UniqueID, ErrorFormationViolation, err.Error())
+		}
+		err = cs.handler.StatusNotification(ctx, chargePointId, &req)
+		result = &StatusNotificationResponse{}
+	case ActionAuthorize:
+		var req AuthorizeRequest
+		if err := json.Unmarshal(call.Payload, &req); err != nil {
+			return callError(call.UniqueID, ErrorFormationViolation, err.Error())
+		}
+		var info *IdTagInfo
+		info, err = cs.handler.Authorize(ctx, chargePointId, &req)
+		if info != nil {
+			result = &AuthorizeResponse{IdTagInfo: *info}
+		}
+	case ActionStartTransaction:
+		var req StartTransactionRequest
+		if err := json.Unmarshal(call.Payload, &req); err != nil {
+			return callError(call.UniqueID, ErrorFormationViolation, err.Error())
+		}
+		result, err = cs.handler.StartTransaction(ctx, chargePointId, &req)
+	case ActionMeterValues:
+		var req MeterValuesRequest
+		if err := json.Unmarshal(call.Payload, &req); err != nil {
+			return callError(call.UniqueID, ErrorFormationViolation, err.Error())
+		}
+		err = cs.handler.MeterValues(ctx, chargePointId, &req)
+		result = &MeterValuesResponse{}
+	case ActionStopTransaction:
+		var req StopTransactionRequest
+		if err := json.Unmarshal(call.Payload, &req); err != nil {
+			return callError(call.UniqueID, ErrorFormationViolation, err.Error())
+		}
+		result, err = cs.handler.StopTransaction(ctx, chargePointId, &req)
+	default:
+		return callError(call.UniqueID, ErrorNotImplemented, "action "+call.Action+" is not supported")
+	}
+
+	if err != nil {
+		log.Printf("OCPP %s %s failed: %v", chargePointId, call.Action, err)
+		return callError(call.UniqueID, ErrorInternalError, err.Error())
+	}
+
+	response, err := NewCallResult(call.UniqueID, result)
+	if err != nil {
+		return callError(call.UniqueID, ErrorInternalError, err.Error())
+	}
+	return response
+}
+
+// requestsSubprotocol reports whether the client offered the OCPP 1.6 subprotocol
+func requestsSubprotocol(r *http.Request) bool {
+	for _, protocol := range websocket.Subprotocols(r) {
+		if protocol == Subprotocol {
+			return true
+		}
+	}
+	return false
+}
+
+func callError(uniqueId, code, description string) []byte {
+	response, _ := NewCallError(uniqueId, code, description)
+	return response
+}
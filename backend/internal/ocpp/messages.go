@@ -0,0 +1,265 @@
+package ocpp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Subprotocol is the WebSocket subprotocol negotiated with OCPP 1.6J charge points
+const Subprotocol = "ocpp1.6"
+
+// Message type IDs of the OCPP-J RPC framework
+const (
+	MessageTypeCall       = 2
+	MessageTypeCallResult = 3
+	MessageTypeCallError  = 4
+)
+
+// Actions initiated by charge points
+const (
+	ActionBootNotification   = "BootNotification"
+	ActionHeartbeat          = "Heartbeat"
+	ActionStatusNotification = "StatusNotification"
+	ActionAuthorize          = "Authorize"
+	ActionStartTransaction   = "StartTransaction"
+	ActionMeterValues        = "MeterValues"
+	ActionStopTransaction    = "StopTransaction"
+)
+
+// CALLERROR codes
+const (
+	ErrorNotImplemented     = "NotImplemented"
+	ErrorFormationViolation = "FormationViolation"
+	ErrorProtocolError      = "ProtocolError"
+	ErrorInternalError      = "InternalError"
+)
+
+// Authorization statuses of an idTag
+const (
+	AuthorizationAccepted     = "Accepted"
+	AuthorizationBlocked      = "Blocked"
+	AuthorizationExpired      = "Expired"
+	AuthorizationInvalid      = "Invalid"
+	AuthorizationConcurrentTx = "ConcurrentTx"
+)
+
+// Call is a request sent by either side: [2, uniqueId, action, payload]
+type Call struct {
+	UniqueID string
+	Action   string
+	Payload  json.RawMessage
+}
+
+// ParseCall decodes an OCPP-J CALL frame
+func ParseCall(data []byte) (*Call, error) {
+	var frame []json.RawMessage
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return nil, fmt.Errorf("invalid frame: %v", err)
+	}
+	if len(frame) < 3 {
+		return nil, fmt.Errorf("frame too short")
+	}
+
+	var messageType int
+	if err := json.Unmarshal(frame[0], &messageType); err != nil {
+		return nil, fmt.Errorf("invalid message type: %v", err)
+	}
+
+	if messageType != MessageTypeCall {
+		return nil, fmt.Errorf("unexpected message type %d", messageType)
+	}
+
+	call := &Call{}
+	if err := json.Unmarshal(frame[1], &call.UniqueID); err != nil {
+		return nil, fmt.Errorf("invalid unique id: %v", err)
+	}
+	if len(frame) != 4 {
+		return call, fmt.Errorf("a call must have 4 elements")
+	}
+	if err := json.Unmarshal(frame[2], &call.Action); err != nil {
+		return call, fmt.Errorf("invalid action: %v", err)
+	}
+	call.Payload = frame[3]
+
+	return call, nil
+}
+
+// NewCall encodes a CALL frame
+func NewCall(uniqueId, action string, payload interface{}) ([]byte, error) {
+	return json.Marshal([]interface{}{MessageTypeCall, uniqueId, action, payload})
+}
+
+// NewCallResult encodes a CALLRESULT frame answering the call uniqueId
+func NewCallResult(uniqueId string, payload interface{}) ([]byte, error) {
+	return json.Marshal([]interface{}{MessageTypeCallResult, uniqueId, payload})
+}
+
+// NewCallError encodes a CALLERROR frame answering the call uniqueId
+func NewCallError(uniqueId, code, description string) ([]byte, error) {
+	return json.Marshal([]interface{}{MessageTypeCallError, uniqueId, code, description, struct{}{}})
+}
+
+// ParseResult decodes a CALLRESULT or CALLERROR frame into its unique ID and payload.
+// A CALLERROR is returned as an error.
+func ParseResult(data []byte) (string, json.RawMessage, error) {
+	var frame []json.RawMessage
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return "", nil, fmt.Errorf("invalid frame: %v", err)
+	}
+	if len(frame) < 3 {
+		return "", nil, fmt.Errorf("frame too short")
+	}
+
+	var messageType int
+	var uniqueId string
+	json.Unmarshal(frame[0], &messageType)
+	json.Unmarshal(frame[1], &uniqueId)
+
+	switch messageType {
+	case MessageTypeCallResult:
+		return uniqueId, frame[2], nil
+	case MessageTypeCallError:
+		var code, description string
+		json.Unmarshal(frame[2], &code)
+		if len(frame) > 3 {
+			json.Unmarshal(frame[3], &description)
+		}
+		return uniqueId, nil, fmt.Errorf("%s: %s", code, description)
+	default:
+		return uniqueId, nil, fmt.Errorf("unexpected message type %d", messageType)
+	}
+}
+
+// IdTagInfo describes the authorization status of an idTag
+type IdTagInfo struct {
+	Status     string     `json:"status"`
+	ExpiryDate *time.Time `json:"expiryDate,omitempty"`
+}
+
+// BootNotificationRequest is sent by a charge point when it starts up
+type BootNotificationRequest struct {
+	ChargePointVendor       string `json:"chargePointVendor"`
+	ChargePointModel        string `json:"chargePointModel"`
+	ChargePointSerialNumber string `json:"chargePointSerialNumber,omitempty"`
+	FirmwareVersion         string `json:"firmwareVersion,omitempty"`
+}
+
+// BootNotificationResponse tells a charge point whether it is registered
+type BootNotificationResponse struct {
+	Status      string    `json:"status"` // Accepted, Pending, Rejected
+	CurrentTime time.Time `json:"currentTime"`
+	Interval    int       `json:"interval"` // heartbeat interval in seconds
+}
+
+// HeartbeatResponse returns the central system time
+type HeartbeatResponse struct {
+	CurrentTime time.Time `json:"currentTime"`
+}
+
+// StatusNotificationRequest reports the status of a connector, or of the whole
+// charge point for connector 0
+type StatusNotificationRequest struct {
+	ConnectorID int        `json:"connectorId"`
+	ErrorCode   string     `json:"errorCode"`
+	Status      string     `json:"status"` // Available, Preparing, Charging, SuspendedEVSE, SuspendedEV, Finishing, Reserved, Unavailable, Faulted
+	Info        string     `json:"info,omitempty"`
+	Timestamp   *time.Time `json:"timestamp,omitempty"`
+}
+
+// StatusNotificationResponse is empty
+type StatusNotificationResponse struct{}
+
+// AuthorizeRequest asks whether an idTag may charge
+type AuthorizeRequest struct {
+	IdTag string `json:"idTag"`
+}
+
+// AuthorizeResponse returns the authorization status of an idTag
+type AuthorizeResponse struct {
+	IdTagInfo IdTagInfo `json:"idTagInfo"`
+}
+
+// StartTransactionRequest is sent when charging starts
+type StartTransactionRequest struct {
+	ConnectorID   int       `json:"connectorId"`
+	IdTag         string    `json:"idTag"`
+	MeterStart    int       `json:"meterStart"` // Wh
+	ReservationID *int      `json:"reservationId,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// StartTransactionResponse assigns the transaction ID
+type StartTransactionResponse struct {
+	TransactionID int       `json:"transactionId"`
+	IdTagInfo     IdTagInfo `json:"idTagInfo"`
+}
+
+// SampledValue is a single measurement of a meter value
+type SampledValue struct {
+	Value     string `json:"value"`
+	Context   string `json:"context,omitempty"`
+	Format    string `json:"format,omitempty"`
+	Measurand string `json:"measurand,omitempty"` // defaults to Energy.Active.Import.Register
+	Phase     string `json:"phase,omitempty"`
+	Location  string `json:"location,omitempty"`
+	Unit      string `json:"unit,omitempty"` // defaults to Wh
+}
+
+// MeterValue is a set of measurements taken at the same time
+type MeterValue struct {
+	Timestamp    time.Time      `json:"timestamp"`
+	SampledValue []SampledValue `json:"sampledValue"`
+}
+
+// MeasurandEnergyImport is the measurand of the energy register delivering to the vehicle
+const MeasurandEnergyImport = "Energy.Active.Import.Register"
+
+// EnergyWh returns the energy import register reading in Wh, if the meter value has one
+func (m MeterValue) EnergyWh() (float64, bool) {
+	for _, sample := range m.SampledValue {
+		if sample.Measurand != "" && sample.Measurand != MeasurandEnergyImport {
+			continue
+		}
+		// Phase readings are partial; only the total register counts
+		if sample.Phase != "" {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(sample.Value, 64)
+		if err != nil {
+			continue
+		}
+		if sample.Unit == "kWh" {
+			value *= 1000
+		}
+		return value, true
+	}
+	return 0, false
+}
+
+// MeterValuesRequest reports meter values of a connector
+type MeterValuesRequest struct {
+	ConnectorID   int          `json:"connectorId"`
+	TransactionID *int         `json:"transactionId,omitempty"`
+	MeterValue    []MeterValue `json:"meterValue"`
+}
+
+// MeterValuesResponse is empty
+type MeterValuesResponse struct{}
+
+// StopTransactionRequest is sent when charging stops
+type StopTransactionRequest struct {
+	IdTag           string       `json:"idTag,omitempty"`
+	MeterStop       int          `json:"meterStop"` // Wh
+	Timestamp       time.Time    `json:"timestamp"`
+	TransactionID   int          `json:"transactionId"`
+	Reason          string       `json:"reason,omitempty"`
+	TransactionData []MeterValue `json:"transactionData,omitempty"`
+}
+
+// StopTransactionResponse optionally returns the status of the idTag that stopped charging
+type StopTransactionResponse struct {
+	IdTagInfo *IdTagInfo `json:"idTagInfo,omitempty"`
+}
@@ -0,0 +1,130 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FakeSignatureHeader carries the signature of fake provider webhooks, in the form
+// "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">"
+const FakeSignatureHeader = "Fake-Signature"
+
+// signatureTolerance is how old a signed webhook may be, to limit replays
+const signatureTolerance = 5 * time.Minute
+
+// fakeNamespace derives intent IDs from top-up IDs
+var fakeNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://cityflow.com/payments/fake"))
+
+// FakeProvider is a local stand-in for a payment service. It never charges anyone:
+// intents stay open until a webhook signed with the shared secret reports their
+// outcome, e.g. one sent by the payment-sim command or a test.
+type FakeProvider struct {
+	secret []byte
+}
+
+// FakeEvent is the webhook payload of the fake provider
+type FakeEvent struct {
+	ID   string        `json:"id"`
+	Type string        `json:"type"` // payment.succeeded, payment.failed or payment.chargeback
+	Data FakeEventData `json:"data"`
+}
+
+// FakeEventData describes the payment a fake webhook is about
+type FakeEventData struct {
+	Intent      string `json:"intent"`
+	TopUpID     string `json:"topUpId"`
+	AmountMinor int64  `json:"amountMinor"`
+	Currency    string `json:"currency"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// NewFakeProvider creates a fake provider verifying webhooks with secret
+func NewFakeProvider(secret string) *FakeProvider {
+	return &FakeProvider{secret: []byte(secret)}
+}
+
+// Name returns "fake"
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+// CreateIntent returns an intent derived from the top-up ID
+func (p *FakeProvider) CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error) {
+	ref := "fake_pi_" + uuid.NewSHA1(fakeNamespace, []byte(req.TopUpID)).String()
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(ref))
+
+	return &Intent{
+		ProviderRef:  ref,
+		ClientSecret: ref + "_secret_" + hex.EncodeToString(mac.Sum(nil))[:24],
+	}, nil
+}
+
+// ParseWebhook verifies the Fake-Signature header and decodes the event
+func (p *FakeProvider) ParseWebhook(header http.Header, body []byte) (*Event, error) {
+	var timestamp, signature string
+	for _, part := range strings.Split(header.Get(FakeSignatureHeader), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signature == "" {
+		return nil, ErrInvalidSignature
+	}
+	age := time.Since(time.Unix(unix, 0))
+	if age > signatureTolerance || age < -signatureTolerance {
+		return nil, ErrInvalidSignature
+	}
+	expected := SignFake(string(p.secret), body, time.Unix(unix, 0))
+	if !hmac.Equal([]byte(expected), []byte(header.Get(FakeSignatureHeader))) {
+		return nil, ErrInvalidSignature
+	}
+
+	var payload FakeEvent
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid webhook payload: %v", err)
+	}
+
+	event := &Event{
+		ID:          payload.ID,
+		ProviderRef: payload.Data.Intent,
+		TopUpID:     payload.Data.TopUpID,
+		AmountMinor: payload.Data.AmountMinor,
+		Currency:    payload.Data.Currency,
+		Reason:      payload.Data.Reason,
+	}
+	switch payload.Type {
+	case "payment.succeeded":
+		event.Type = EventSucceeded
+	case "payment.failed":
+		event.Type = EventFailed
+	case "payment.chargeback":
+		event.Type = EventChargeback
+	}
+	return event, nil
+}
+
+// SignFake returns the Fake-Signature header of a webhook body sent at a time
+func SignFake(secret string, body []byte, at time.Time) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,72 @@
+// Package payments connects wallet top-ups to external payment providers. A top-up
+// starts as a payment intent the client completes with the provider; the provider
+// then reports the outcome through a signed webhook, and only a confirmed payment
+// credits the wallet.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Outcomes a provider reports for a top-up
+const (
+	EventSucceeded  = "succeeded"
+	EventFailed     = "failed"
+	EventChargeback = "chargeback"
+)
+
+// ErrInvalidSignature is returned for webhooks that are not signed by the provider
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// IntentRequest describes a top-up to collect
+type IntentRequest struct {
+	TopUpID     string // also the provider's idempotency key
+	UserID      string
+	AmountMinor int64
+	Currency    string
+}
+
+// Intent is a payment the client completes with the provider, either in the browser
+// with the client secret or on the provider's page at the redirect URL
+type Intent struct {
+	ProviderRef  string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// Event is a verified webhook notification about a top-up
+type Event struct {
+	ID          string
+	Type        string // EventSucceeded, EventFailed or EventChargeback; empty if not relevant
+	ProviderRef string
+	TopUpID     string
+	AmountMinor int64
+	Currency    string
+	Reason      string
+}
+
+// Provider collects top-up payments through an external payment service
+type Provider interface {
+	// Name identifies the provider in top-up records and webhook URLs
+	Name() string
+
+	// CreateIntent starts collecting a payment. Calling it again for the same top-up
+	// must return the same intent.
+	CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error)
+
+	// ParseWebhook verifies the signature of a webhook request and decodes its event
+	ParseWebhook(header http.Header, body []byte) (*Event, error)
+}
+
+// New returns the provider configured by name
+func New(name, webhookSecret string) (Provider, error) {
+	switch name {
+	case "fake":
+		return NewFakeProvider(webhookSecret), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", name)
+	}
+}
@@ -0,0 +1,371 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrInProgress is returned when a saga with the same ID is still being processed
+var ErrInProgress = errors.New("saga is still in progress")
+
+// Coordinator executes sagas step by step, persisting every transition so that
+// unfinished sagas can be resumed after a restart. Failed sagas are compensated
+// in reverse order, and compensations are retried with backoff until they succeed.
+type Coordinator struct {
+	store        Store
+	retention    time.Duration
+	definitions  map[string]*Definition
+	inFlight     map[string]bool
+	mu           sync.Mutex
+	pollInterval time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+}
+
+// NewCoordinator creates a new saga coordinator backed by store. Finished sagas are
+// kept for retention, during which executing one again returns its recorded outcome.
+func NewCoordinator(store Store, retention time.Duration) *Coordinator {
+	return &Coordinator{
+		store:        store,
+		retention:    retention,
+		definitions:  make(map[string]*Definition),
+		inFlight:     make(map[string]bool),
+		pollInterval: 5 * time.Second,
+		minBackoff:   2 * time.Second,
+		maxBackoff:   5 * time.Minute,
+	}
+}
+
+// Register adds a saga definition. It must be called before Execute or Run.
+func (c *Coordinator) Register(def Definition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := def
+	c.definitions[def.Type] = &d
+}
+
+// Execute starts a new saga and runs it until it completes or fails.
+// If a saga with the same ID already exists, its recorded outcome is returned
+// instead of running the steps again.
+func (c *Coordinator) Execute(ctx context.Context, sagaType, id string, data map[string]string) (*Saga, error) {
+	def, err := c.definition(sagaType)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.acquire(id) {
+		return nil, ErrInProgress
+	}
+	defer c.release(id)
+
+	existing, err := c.store.Load(id)
+	if err == nil {
+		return existing, outcome(existing)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	now := time.Now()
+	s := &Saga{
+		ID:        id,
+		Type:      sagaType,
+		Status:    StatusRunning,
+		Data:      data,
+		Steps:     make([]StepRecord, len(def.Steps)),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for i, step := range def.Steps {
+		s.Steps[i] = StepRecord{Name: step.Name, Status: StepPending, UpdatedAt: now}
+	}
+
+	if err := c.store.Save(s); err != nil {
+		return nil, fmt.Errorf("failed to persist saga: %w", err)
+	}
+
+	return s, c.run(ctx, def, s)
+}
+
+// Get returns a saga by ID
+func (c *Coordinator) Get(id string) (*Saga, error) {
+	return c.store.Load(id)
+}
+
+// List returns all sagas, optionally filtered by status
+func (c *Coordinator) List(status Status) ([]*Saga, error) {
+	sagas, err := c.store.List()
+	if err != nil {
+		return nil, err
+	}
+	if status == "" {
+		return sagas, nil
+	}
+
+	filtered := make([]*Saga, 0)
+	for _, s := range sagas {
+		if s.Status == status {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered, nil
+}
+
+// Retry processes an unfinished saga immediately instead of waiting for its next retry
+func (c *Coordinator) Retry(ctx context.Context, id string) (*Saga, error) {
+	if !c.acquire(id) {
+		return nil, ErrInProgress
+	}
+	defer c.release(id)
+
+	s, err := c.store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	if s.IsFinished() {
+		return s, nil
+	}
+
+	def, err := c.definition(s.Type)
+	if err != nil {
+		return s, err
+	}
+
+	return s, c.resume(ctx, def, s)
+}
+
+// Run resumes sagas interrupted by a restart, then retries pending compensations
+// and retriable steps, and deletes finished sagas every hour once their retention
+// has passed, until ctx is cancelled
+func (c *Coordinator) Run(ctx context.Context) {
+	c.Recover(ctx)
+	c.prune()
+
+	ticker := time.NewTicker(c.pollInterval)
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(time.Hour)
+	defer pruneTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.retryPending(ctx)
+		case <-pruneTicker.C:
+			c.prune()
+		}
+	}
+}
+
+// Recover resumes every unfinished saga found in the store. Sagas interrupted
+// mid-flight are rolled forward (steps are idempotent), compensating sagas
+// continue compensating.
+func (c *Coordinator) Recover(ctx context.Context) {
+	sagas, err := c.store.ListUnfinished()
+	if err != nil {
+		log.Printf("saga: failed to list sagas for recovery: %v", err)
+		return
+	}
+
+	for _, s := range sagas {
+		if s.IsFinished() {
+			continue
+		}
+		log.Printf("saga: recovering %s %s (status %s)", s.Type, s.ID, s.Status)
+		if _, err := c.Retry(ctx, s.ID); err != nil {
+			log.Printf("saga: recovery of %s failed: %v", s.ID, err)
+		}
+	}
+}
+
+// retryPending retries compensations and retriable steps whose backoff has elapsed
+func (c *Coordinator) retryPending(ctx context.Context) {
+	sagas, err := c.store.ListUnfinished()
+	if err != nil {
+		log.Printf("saga: failed to list sagas: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, s := range sagas {
+		if now.Before(s.NextRetryAt) {
+			continue
+		}
+		switch {
+		case s.Status == StatusCompensating:
+			if _, err := c.Retry(ctx, s.ID); err != nil && !errors.Is(err, ErrInProgress) {
+				log.Printf("saga: compensation of %s failed (attempt %d): %v", s.ID, s.CompensationAttempts, err)
+			}
+		case s.Status == StatusRunning && !s.NextRetryAt.IsZero():
+			if _, err := c.Retry(ctx, s.ID); err != nil && !errors.Is(err, ErrInProgress) {
+				log.Printf("saga: retry of %s failed: %v", s.ID, err)
+			}
+		}
+	}
+}
+
+// prune deletes the finished sagas whose retention has passed
+func (c *Coordinator) prune() {
+	if err := c.store.Prune(time.Now().Add(-c.retention)); err != nil {
+		log.Printf("saga: failed to prune finished sagas: %v", err)
+	}
+}
+
+// resume continues a saga from its recorded state
+func (c *Coordinator) resume(ctx context.Context, def *Definition, s *Saga) error {
+	if s.Status == StatusCompensating {
+		return c.compensate(ctx, def, s)
+	}
+	return c.run(ctx, def, s)
+}
+
+// run executes the remaining forward steps of a saga
+func (c *Coordinator) run(ctx context.Context, def *Definition, s *Saga) error {
+	for i, step := range def.Steps {
+		rec := &s.Steps[i]
+		if rec.Status == StepDone {
+			continue
+		}
+
+		rec.Status = StepRunning
+		rec.Attempts++
+		rec.UpdatedAt = time.Now()
+		err := c.save(s)
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = step.Action(ctx, s)
+		}
+		if err != nil && step.Retriable {
+			rec.Status = StepFailed
+			rec.Error = err.Error()
+			rec.UpdatedAt = time.Now()
+			s.Error = err.Error()
+			s.NextRetryAt = time.Now().Add(c.backoff(rec.Attempts))
+			c.save(s)
+			return err
+		}
+		if err != nil {
+			rec.Status = StepFailed
+			rec.Error = err.Error()
+			rec.UpdatedAt = time.Now()
+			s.Status = StatusCompensating
+			s.Error = err.Error()
+			c.save(s)
+
+			if compErr := c.compensate(ctx, def, s); compErr != nil {
+				log.Printf("saga: compensation of %s deferred: %v", s.ID, compErr)
+			}
+			return err
+		}
+
+		rec.Status = StepDone
+		rec.Error = ""
+		rec.UpdatedAt = time.Now()
+		c.save(s)
+	}
+
+	s.Status = StatusCompleted
+	s.Error = ""
+	s.NextRetryAt = time.Time{}
+	return c.save(s)
+}
+
+// compensate undoes the started steps of a saga in reverse order
+func (c *Coordinator) compensate(ctx context.Context, def *Definition, s *Saga) error {
+	for i := len(def.Steps) - 1; i >= 0; i-- {
+		rec := &s.Steps[i]
+		if rec.Status == StepPending || rec.Status == StepCompensated {
+			continue
+		}
+
+		step := def.Steps[i]
+		if step.Compensate != nil {
+			rec.Status = StepCompensating
+			if err := step.Compensate(ctx, s); err != nil {
+				rec.Error = err.Error()
+				rec.UpdatedAt = time.Now()
+				s.CompensationAttempts++
+				s.NextRetryAt = time.Now().Add(c.backoff(s.CompensationAttempts))
+				c.save(s)
+				return fmt.Errorf("%s compensation failed: %w", step.Name, err)
+			}
+		}
+
+		rec.Status = StepCompensated
+		rec.UpdatedAt = time.Now()
+		c.save(s)
+	}
+
+	s.Status = StatusCompensated
+	s.NextRetryAt = time.Time{}
+	return c.save(s)
+}
+
+func (c *Coordinator) save(s *Saga) error {
+	s.UpdatedAt = time.Now()
+	if err := c.store.Save(s); err != nil {
+		log.Printf("saga: failed to persist %s: %v", s.ID, err)
+		return fmt.Errorf("failed to persist saga: %w", err)
+	}
+	return nil
+}
+
+func (c *Coordinator) backoff(attempts int) time.Duration {
+	delay := c.minBackoff
+	for i := 1; i < attempts && delay < c.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > c.maxBackoff {
+		delay = c.maxBackoff
+	}
+	return delay
+}
+
+func (c *Coordinator) definition(sagaType string) (*Definition, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	def, ok := c.definitions[sagaType]
+	if !ok {
+		return nil, fmt.Errorf("unknown saga type %s", sagaType)
+	}
+	return def, nil
+}
+
+func (c *Coordinator) acquire(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.inFlight[id] {
+		return false
+	}
+	c.inFlight[id] = true
+	return true
+}
+
+func (c *Coordinator) release(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.inFlight, id)
+}
+
+// outcome converts the recorded state of an existing saga into the error Execute returns
+func outcome(s *Saga) error {
+	switch s.Status {
+	case StatusCompleted:
+		return nil
+	case StatusRunning:
+		return ErrInProgress
+	default:
+		return errors.New(s.Error)
+	}
+}
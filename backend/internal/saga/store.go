@@ -0,0 +1,233 @@
+package saga
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when a saga does not exist in the store
+var ErrNotFound = errors.New("saga not found")
+
+// Store persists saga records
+type Store interface {
+	Save(s *Saga) error
+	Load(id string) (*Saga, error)
+	List() ([]*Saga, error)
+	// ListUnfinished returns the sagas still running or compensating
+	ListUnfinished() ([]*Saga, error)
+	// Prune deletes the finished sagas last updated before a time
+	Prune(before time.Time) error
+}
+
+// finishedDir is the subdirectory finished sagas are moved to, so that polling for
+// unfinished ones does not read every saga ever run
+const finishedDir = "finished"
+
+// FileStore stores each saga as a JSON file in a directory, and finished sagas in its
+// finished subdirectory
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileStore creates a file-backed saga store rooted at dir. Finished sagas kept in
+// dir itself by earlier versions are moved to the finished subdirectory.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, finishedDir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create saga store directory: %w", err)
+	}
+	fs := &FileStore{dir: dir}
+
+	sagas, err := fs.readDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read saga store directory: %w", err)
+	}
+	for _, s := range sagas {
+		if !s.IsFinished() {
+			continue
+		}
+		if err := os.Rename(fs.path(s.ID), fs.finishedPath(s.ID)); err != nil {
+			return nil, fmt.Errorf("failed to move finished saga %s: %w", s.ID, err)
+		}
+	}
+
+	return fs, nil
+}
+
+// Save writes a saga atomically so a crash never leaves a truncated record
+func (fs *FileStore) Save(s *Saga) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir, path := fs.dir, fs.path(s.ID)
+	if s.IsFinished() {
+		dir, path = filepath.Join(fs.dir, finishedDir), fs.finishedPath(s.ID)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".saga-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// A finished saga never runs again, so it leaves the unfinished ones for good
+	if s.IsFinished() {
+		if err := os.Remove(fs.path(s.ID)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load reads a saga by ID
+func (fs *FileStore) Load(id string) (*Saga, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	s, err := fs.read(fs.path(id))
+	if errors.Is(err, ErrNotFound) {
+		return fs.read(fs.finishedPath(id))
+	}
+	return s, err
+}
+
+// List returns all sagas ordered by creation time
+func (fs *FileStore) List() ([]*Saga, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	sagas, err := fs.readDir(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+	finished, err := fs.readDir(filepath.Join(fs.dir, finishedDir))
+	if err != nil {
+		return nil, err
+	}
+	sagas = append(sagas, finished...)
+
+	sort.Slice(sagas, func(i, j int) bool {
+		return sagas[i].CreatedAt.Before(sagas[j].CreatedAt)
+	})
+
+	return sagas, nil
+}
+
+// ListUnfinished returns the sagas still running or compensating, ordered by creation time
+func (fs *FileStore) ListUnfinished() ([]*Saga, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	sagas, err := fs.readDir(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sagas, func(i, j int) bool {
+		return sagas[i].CreatedAt.Before(sagas[j].CreatedAt)
+	})
+
+	return sagas, nil
+}
+
+// Prune deletes the finished sagas last updated before a time
+func (fs *FileStore) Prune(before time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	dir := filepath.Join(fs.dir, finishedDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Before(before) {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return nil
+}
+
+// readDir reads the sagas stored in a directory, in no particular order
+func (fs *FileStore) readDir(dir string) ([]*Saga, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sagas := make([]*Saga, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		s, err := fs.read(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue // Skip unreadable records
+		}
+		sagas = append(sagas, s)
+	}
+
+	return sagas, nil
+}
+
+func (fs *FileStore) read(path string) (*Saga, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var s Saga
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (fs *FileStore) path(id string) string {
+	return filepath.Join(fs.dir, filepath.Base(id)+".json")
+}
+
+func (fs *FileStore) finishedPath(id string) string {
+	return filepath.Join(fs.dir, finishedDir, filepath.Base(id)+".json")
+}
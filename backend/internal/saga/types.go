@@ -0,0 +1,75 @@
+package saga
+
+import (
+	"context"
+	"time"
+)
+
+// Status represents the lifecycle state of a saga
+type Status string
+
+const (
+	StatusRunning      Status = "running"
+	StatusCompleted    Status = "completed"
+	StatusCompensating Status = "compensating"
+	StatusCompensated  Status = "compensated"
+)
+
+// StepStatus represents the state of a single saga step
+type StepStatus string
+
+const (
+	StepPending      StepStatus = "pending"
+	StepRunning      StepStatus = "running"
+	StepDone         StepStatus = "done"
+	StepFailed       StepStatus = "failed"
+	StepCompensated  StepStatus = "compensated"
+	StepCompensating StepStatus = "compensating"
+)
+
+// Saga is the durable record of a multi-step workflow spanning several channels
+type Saga struct {
+	ID                   string            `json:"id"`
+	Type                 string            `json:"type"`
+	Status               Status            `json:"status"`
+	Data                 map[string]string `json:"data"`
+	Steps                []StepRecord      `json:"steps"`
+	Error                string            `json:"error,omitempty"`
+	CompensationAttempts int               `json:"compensationAttempts"`
+	NextRetryAt          time.Time         `json:"nextRetryAt,omitempty"`
+	CreatedAt            time.Time         `json:"createdAt"`
+	UpdatedAt            time.Time         `json:"updatedAt"`
+}
+
+// StepRecord tracks the progress of one step of a saga
+type StepRecord struct {
+	Name      string     `json:"name"`
+	Status    StepStatus `json:"status"`
+	Attempts  int        `json:"attempts"`
+	Error     string     `json:"error,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// Step defines a forward action of a saga and how to undo it.
+// Actions and compensations must be idempotent: they may be re-run after a crash
+// or a timeout whose outcome is unknown.
+//
+// A failing Retriable step does not compensate the saga: it stays running and the
+// step is retried with backoff until it succeeds, for steps after the point of no return.
+type Step struct {
+	Name       string
+	Action     func(ctx context.Context, s *Saga) error
+	Compensate func(ctx context.Context, s *Saga) error
+	Retriable  bool
+}
+
+// Definition describes the ordered steps of a saga type
+type Definition struct {
+	Type  string
+	Steps []Step
+}
+
+// IsFinished reports whether the saga reached a terminal state
+func (s *Saga) IsFinished() bool {
+	return s.Status == StatusCompleted || s.Status == StatusCompensated
+}
@@ -0,0 +1,119 @@
+// Package settlement runs the periodic settlement of operator revenue on the wallet
+// channel. Each run closes the current period of every operator revenue account and
+// pays out what the operator earned, minus the platform commission and refunds.
+package settlement
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mouhsiiin/CityFlow-Parking/backend/internal/fabric"
+)
+
+// Scheduler settles every operator revenue account once per interval
+type Scheduler struct {
+	fabricClient *fabric.Client
+	interval     time.Duration
+}
+
+// Result summarizes a settlement run
+type Result struct {
+	PeriodEnd  string            `json:"periodEnd"`
+	Settled    []string          `json:"settled"`
+	Skipped    []string          `json:"skipped"`
+	Failed     []string          `json:"failed"`
+	Statements []json.RawMessage `json:"statements"`
+}
+
+// operatorAccount holds the account fields a settlement run needs
+type operatorAccount struct {
+	AccountID string `json:"accountId"`
+	OwnerID   string `json:"ownerId"`
+	Currency  string `json:"currency"`
+}
+
+// NewScheduler creates a scheduler settling once per interval
+func NewScheduler(fabricClient *fabric.Client, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		fabricClient: fabricClient,
+		interval:     interval,
+	}
+}
+
+// Run settles once per interval until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := s.RunOnce(ctx)
+			if err != nil {
+				log.Printf("settlement: run failed: %v", err)
+				continue
+			}
+			log.Printf("settlement: period ending %s settled for %d accounts, %d failed",
+				result.PeriodEnd, len(result.Settled), len(result.Failed))
+		}
+	}
+}
+
+// RunOnce settles every operator revenue account for the period ending at the last
+// interval boundary. Statement IDs are derived from the account and period, so a run
+// that is repeated, or overlaps with another backend instance, settles nothing twice.
+func (s *Scheduler) RunOnce(ctx context.Context) (*Result, error) {
+	periodEnd := time.Now().UTC().Truncate(s.interval)
+	result := &Result{
+		PeriodEnd:  periodEnd.Format(time.RFC3339),
+		Settled:    []string{},
+		Skipped:    []string{},
+		Failed:     []string{},
+		Statements: []json.RawMessage{},
+	}
+
+	contract := s.fabricClient.GetWalletContract()
+	accountsResult, err := contract.EvaluateTransaction("GetAccounts", "operator_revenue")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list operator accounts: %v", err)
+	}
+
+	var accounts []operatorAccount
+	if err := json.Unmarshal(accountsResult, &accounts); err != nil {
+		return nil, fmt.Errorf("failed to parse operator accounts: %v", err)
+	}
+
+	for _, account := range accounts {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		statementId := fmt.Sprintf("statement_%s_%s_%s", account.OwnerID, account.Currency, periodEnd.Format("20060102T150405Z"))
+		statement, err := contract.SubmitTransaction("SettleOperator", statementId, account.OwnerID, account.Currency, result.PeriodEnd)
+		if err != nil {
+			if alreadySettled(err) {
+				result.Skipped = append(result.Skipped, account.AccountID)
+				continue
+			}
+			log.Printf("settlement: failed to settle %s: %v", account.AccountID, err)
+			result.Failed = append(result.Failed, account.AccountID)
+			continue
+		}
+
+		result.Settled = append(result.Settled, account.AccountID)
+		result.Statements = append(result.Statements, json.RawMessage(statement))
+	}
+
+	return result, nil
+}
+
+// alreadySettled reports whether a settlement error says the period was closed before
+func alreadySettled(err error) bool {
+	return strings.Contains(err.Error(), "already exists") || strings.Contains(err.Error(), "already settled")
+}
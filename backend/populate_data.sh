@@ -1,14 +1,15 @@
 #!/bin/bash
 
 # Populate charging stations and parking spots (Morocco — focus: Tangier)
-# Requires: backend running, `jq` installed
+# Requires: backend started by start.sh and `jq` installed; see seed_admin.sh for how
+# the admin account below is set up
 
 set -euo pipefail
 
 API_BASE="http://localhost:8080/api/v1"
 API_BARE_BASE="http://localhost:8080"
-ADMIN_EMAIL="admin@admin.com"
-ADMIN_PASSWORD="admin123"
+
+source "$(dirname "${BASH_SOURCE[0]}")/seed_admin.sh"
 
 echo "Checking backend health..."
 if ! curl -s -f "${API_BARE_BASE%/}/health" > /dev/null; then
@@ -16,30 +17,11 @@ if ! curl -s -f "${API_BARE_BASE%/}/health" > /dev/null; then
   exit 1
 fi
 
-echo "Attempting to register admin (ignored if already exists)..."
-curl -s -X POST "${API_BASE}/auth/register" \
-  -H "Content-Type: application/json" \
-  -d "{
-    \"email\": \"${ADMIN_EMAIL}\",
-    \"password\": \"${ADMIN_PASSWORD}\",
-    \"firstName\": \"Admin\",
-    \"lastName\": \"User\",
-    \"phone\": \"+0000000000\",
-    \"role\": \"admin\"
-  }" > /dev/null || true
-
-echo "Logging in as admin..."
-LOGIN_RESPONSE=$(curl -s -X POST "${API_BASE}/auth/login" \
-  -H "Content-Type: application/json" \
-  -d "{\"email\": \"${ADMIN_EMAIL}\", \"password\": \"${ADMIN_PASSWORD}\"}")
-
-TOKEN=$(echo "$LOGIN_RESPONSE" | jq -r '.token // empty')
-
-if [ -z "$TOKEN" ]; then
-  echo "Failed to retrieve token. Response:"
-  echo "$LOGIN_RESPONSE"
+if ! admin_login; then
+  echo "Failed to log in as admin."
   exit 1
 fi
+TOKEN="$ADMIN_TOKEN"
 
 echo "Token retrieved."
 
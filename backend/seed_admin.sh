@@ -0,0 +1,120 @@
+#!/bin/bash
+
+# CityFlow - admin login for the seeding scripts
+# Sourced by populate_data.sh and generate_test_data.sh: admin_login sets ADMIN_TOKEN to
+# an access token of the bootstrap admin that admin routes accept.
+#
+# Admin routes require MFA, so the first run verifies the admin's email from the local
+# mail directory, which makes it the first admin, and enrolls it in MFA. The TOTP secret
+# is kept in data/seed-admin-totp-secret for later runs; codes come from cmd/totp.
+#
+# Requires: backend started by start.sh (BOOTSTRAP_ADMIN_EMAIL=admin@cityflow.com and the
+# file mailer), `jq`, and Go to run cmd/totp.
+
+SEED_ADMIN_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+
+ADMIN_EMAIL="${ADMIN_EMAIL:-admin@cityflow.com}"
+ADMIN_PASSWORD="${ADMIN_PASSWORD:-admin123}"
+ADMIN_TOTP_SECRET_FILE="${SEED_ADMIN_DIR}/data/seed-admin-totp-secret"
+SEED_MAIL_DIR="${MAIL_DIR:-${SEED_ADMIN_DIR}/data/mail}"
+
+totp_code() {
+  (cd "$SEED_ADMIN_DIR" && go run ./cmd/totp -secret "$1")
+}
+
+admin_post() {
+  local path="$1" token="$2" body="${3:-}"
+  if [ -n "$token" ]; then
+    curl -s -X POST "${API_BASE}${path}" -H "Content-Type: application/json" \
+      -H "Authorization: Bearer $token" ${body:+-d "$body"}
+  else
+    curl -s -X POST "${API_BASE}${path}" -H "Content-Type: application/json" \
+      ${body:+-d "$body"}
+  fi
+}
+
+# admin_password_login prints the response of a password login of the admin
+admin_password_login() {
+  admin_post /auth/login "" "{\"email\": \"${ADMIN_EMAIL}\", \"password\": \"${ADMIN_PASSWORD}\"}"
+}
+
+# verify_admin_email verifies the admin's email with the latest link mailed to it
+verify_admin_email() {
+  local mail token
+  mail=$(grep -l "^To: ${ADMIN_EMAIL}" "$SEED_MAIL_DIR"/*.eml 2>/dev/null | sort | tail -n 1 || true)
+  token=""
+  if [ -n "$mail" ]; then
+    token=$(grep -o 'verify-email?token=[0-9a-f]*' "$mail" | tail -n 1 | cut -d= -f2 || true)
+  fi
+  if [ -z "$token" ]; then
+    echo "No verification email for ${ADMIN_EMAIL} in ${SEED_MAIL_DIR}" >&2
+    return 1
+  fi
+  admin_post /auth/email/verify "" "{\"token\": \"${token}\"}" > /dev/null
+
+  # Becoming admin revokes the tokens issued up to this second, so log in after it
+  sleep 1
+}
+
+admin_login() {
+  echo "Registering admin ${ADMIN_EMAIL} (ignored if it already exists)..."
+  admin_post /auth/register "" "{
+    \"email\": \"${ADMIN_EMAIL}\",
+    \"password\": \"${ADMIN_PASSWORD}\",
+    \"firstName\": \"Admin\",
+    \"lastName\": \"User\",
+    \"phone\": \"+0000000000\"
+  }" > /dev/null || true
+
+  echo "Logging in as admin..."
+  local response
+  response=$(admin_password_login)
+
+  # Later runs: MFA is enabled, complete the login with a code
+  if [ "$(echo "$response" | jq -r '.mfaRequired // false')" = "true" ]; then
+    if [ ! -f "$ADMIN_TOTP_SECRET_FILE" ]; then
+      echo "MFA is enabled for ${ADMIN_EMAIL} but ${ADMIN_TOTP_SECRET_FILE} is missing" >&2
+      return 1
+    fi
+    local mfa_token
+    mfa_token=$(echo "$response" | jq -r '.mfaToken')
+    response=$(admin_post /auth/login/mfa "" \
+      "{\"mfaToken\": \"${mfa_token}\", \"code\": \"$(totp_code "$(cat "$ADMIN_TOTP_SECRET_FILE")")\"}")
+    ADMIN_TOKEN=$(echo "$response" | jq -r '.token // empty')
+    if [ -z "$ADMIN_TOKEN" ]; then
+      echo "MFA login failed: $response" >&2
+      return 1
+    fi
+    return 0
+  fi
+
+  # First run: the account becomes the first admin once its email is verified
+  if [ "$(echo "$response" | jq -r '.user.role // empty')" != "admin" ]; then
+    echo "Verifying admin email..."
+    verify_admin_email || return 1
+    response=$(admin_password_login)
+    if [ "$(echo "$response" | jq -r '.user.role // empty')" != "admin" ]; then
+      echo "${ADMIN_EMAIL} is not an admin; start the backend with BOOTSTRAP_ADMIN_EMAIL=${ADMIN_EMAIL} on a ledger without one" >&2
+      return 1
+    fi
+  fi
+
+  # Enroll in MFA, which admin routes require
+  echo "Enrolling admin in MFA..."
+  local token secret
+  token=$(echo "$response" | jq -r '.token // empty')
+  secret=$(admin_post /auth/mfa/enroll "$token" | jq -r '.secret // empty')
+  if [ -z "$secret" ]; then
+    echo "MFA enrollment failed" >&2
+    return 1
+  fi
+  mkdir -p "$(dirname "$ADMIN_TOTP_SECRET_FILE")"
+  (umask 077 && echo "$secret" > "$ADMIN_TOTP_SECRET_FILE")
+
+  response=$(admin_post /auth/mfa/activate "$token" "{\"code\": \"$(totp_code "$secret")\"}")
+  ADMIN_TOKEN=$(echo "$response" | jq -r '.token // empty')
+  if [ -z "$ADMIN_TOKEN" ]; then
+    echo "MFA activation failed: $response" >&2
+    return 1
+  fi
+}
@@ -162,6 +162,30 @@ pkill -f "cityflow-api" 2>/dev/null || true
 # Create logs directory
 mkdir -p logs
 
+# Export a secret unless it is set, generating it once into data/ so that it is kept
+# across restarts
+export_secret() {
+    local name=$1 file=data/$2
+    if [ -z "${!name}" ]; then
+        if [ ! -f "$file" ]; then
+            mkdir -p data
+            openssl rand -hex 32 > "$file"
+        fi
+        export "$name=$(cat "$file")"
+    fi
+}
+
+# This script runs a local network: use the fake payment provider unless one is set,
+# with a webhook secret kept across restarts for cmd/payment-sim
+export PAYMENT_PROVIDER=${PAYMENT_PROVIDER:-fake}
+export_secret PAYMENT_WEBHOOK_SECRET payment-webhook-secret
+export_secret JWT_SECRET jwt-secret
+export_secret SESSION_TOKEN_KEY session-token-key
+export_secret MFA_ENCRYPTION_KEY mfa-encryption-key
+
+# The account the seeding scripts (seed_admin.sh) set up as the first admin
+export BOOTSTRAP_ADMIN_EMAIL=${BOOTSTRAP_ADMIN_EMAIL:-admin@cityflow.com}
+
 # Start the API in the background
 nohup ./build/cityflow-api > logs/api.log 2>&1 &
 API_PID=$!
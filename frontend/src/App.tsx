@@ -6,6 +6,8 @@ import { AdminRoute } from './components/AdminRoute';
 import { Navbar } from './components';
 import { 
   Login, 
+  ResetPassword,
+  VerifyEmail,
   Dashboard, 
   Map, 
   Wallet, 
@@ -26,6 +28,8 @@ function App() {
           <Routes>
           {/* Public route */}
           <Route path="/login" element={<Login />} />
+          <Route path="/reset-password" element={<ResetPassword />} />
+          <Route path="/verify-email" element={<VerifyEmail />} />
 
           {/* Protected routes */}
           <Route
@@ -132,7 +136,7 @@ function App() {
           <Route
             path="/admin/security"
             element={
-              <AdminRoute>
+              <AdminRoute roles={['admin', 'security-auditor']}>
                 <Navbar />
                 <SecurityDashboard />
               </AdminRoute>
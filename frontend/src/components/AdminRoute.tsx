@@ -2,12 +2,14 @@ import React from 'react';
 import { Navigate } from 'react-router-dom';
 import { useAuth } from '../context/AuthContext';
 import { Loading } from './Loading';
+import type { UserRole } from '../types';
 
 interface AdminRouteProps {
   children: React.ReactNode;
+  roles?: UserRole[]; // roles allowed in, admins only by default
 }
 
-export const AdminRoute: React.FC<AdminRouteProps> = ({ children }) => {
+export const AdminRoute: React.FC<AdminRouteProps> = ({ children, roles = ['admin'] }) => {
   const { user, isAuthenticated, isLoading } = useAuth();
 
   if (isLoading) {
@@ -22,7 +24,7 @@ export const AdminRoute: React.FC<AdminRouteProps> = ({ children }) => {
     return <Navigate to="/login" replace />;
   }
 
-  if (user?.role !== 'admin') {
+  if (!user?.role || !roles.includes(user.role)) {
     return <Navigate to="/dashboard" replace />;
   }
 
@@ -38,6 +38,8 @@ export const Navbar: React.FC = () => {
   // Add admin links if user is admin
   if (user?.role === 'admin') {
     navItems.push({ path: '/admin', icon: Settings, label: 'Admin' });
+  }
+  if (user?.role === 'admin' || user?.role === 'security-auditor') {
     navItems.push({ path: '/admin/security', icon: Shield, label: 'Security' });
   }
 
@@ -9,9 +9,22 @@ export const API_ENDPOINTS = {
   
   // Auth (User Chaincode)
   LOGIN: '/api/v1/auth/login',
+  LOGIN_MFA: '/api/v1/auth/login/mfa',
   REGISTER: '/api/v1/auth/register',
   LOGOUT: '/api/v1/auth/logout',
+  REFRESH_TOKEN: '/api/v1/auth/refresh',
   CURRENT_USER: '/api/v1/auth/me',
+  FORGOT_PASSWORD: '/api/v1/auth/password/forgot',
+  RESET_PASSWORD: '/api/v1/auth/password/reset',
+  CHANGE_PASSWORD: '/api/v1/auth/password/change',
+  VERIFY_EMAIL: '/api/v1/auth/email/verify',
+  RESEND_VERIFICATION: '/api/v1/auth/email/resend',
+  SESSIONS: '/api/v1/auth/sessions',
+  SESSION_BY_ID: (id: string) => `/api/v1/auth/sessions/${id}`,
+  MFA: '/api/v1/auth/mfa',
+  MFA_ENROLL: '/api/v1/auth/mfa/enroll',
+  MFA_ACTIVATE: '/api/v1/auth/mfa/activate',
+  MFA_RECOVERY_CODES: '/api/v1/auth/mfa/recovery-codes',
   
   // Users (User Chaincode)
   USER_BY_ID: (id: string) => `/api/v1/users/${id}`,
@@ -19,6 +32,9 @@ export const API_ENDPOINTS = {
   DELETE_USER: (id: string) => `/api/v1/users/${id}`,
   LIST_ALL_USERS: '/api/v1/users',
   USER_HISTORY: (id: string) => `/api/v1/users/${id}/history`,
+  UNLOCK_USER: (id: string) => `/api/v1/users/${id}/unlock`,
+  USER_ROLE: (id: string) => `/api/v1/users/${id}/role`,
+  USER_ROLE_HISTORY: (id: string) => `/api/v1/users/${id}/roles/history`,
   
   // Parking Spots (Parking Chaincode)
   SPOTS: '/api/v1/parking/spots',
@@ -28,6 +44,19 @@ export const API_ENDPOINTS = {
   UPDATE_SPOT: (id: string) => `/api/v1/parking/spots/${id}`,
   DELETE_SPOT: (id: string) => `/api/v1/parking/spots/${id}`,
   SEARCH_SPOTS: '/api/v1/parking/spots/search',
+  NEARBY_SPOTS: '/api/v1/parking/spots/nearby',
+  SPOT_FACILITY: (id: string) => `/api/v1/parking/spots/${id}/facility`,
+  SPOT_HOURS: (id: string) => `/api/v1/parking/spots/${id}/hours`,
+  SPOT_AVAILABILITY: (id: string) => `/api/v1/parking/spots/${id}/availability`,
+  PARKING_BLACKOUTS: '/api/v1/parking/blackouts',
+  PARKING_BLACKOUT_BY_ID: (id: string) => `/api/v1/parking/blackouts/${id}`,
+
+  // Parking Facilities (Parking Chaincode)
+  FACILITIES: '/api/v1/parking/facilities',
+  FACILITY_BY_ID: (id: string) => `/api/v1/parking/facilities/${id}`,
+  FACILITY_SPOTS: (id: string) => `/api/v1/parking/facilities/${id}/spots`,
+  FACILITY_OCCUPANCY: (id: string) => `/api/v1/parking/facilities/${id}/occupancy`,
+  FACILITIES_OCCUPANCY: '/api/v1/parking/facilities/occupancy',
   
   // Parking Reservations (Parking Chaincode)
   CREATE_RESERVATION: '/api/v1/parking/reserve',
@@ -45,9 +74,14 @@ export const API_ENDPOINTS = {
   CHARGING_STATION_BY_ID: (id: string) => `/api/v1/charging/stations/${id}`,
   AVAILABLE_STATIONS: '/api/v1/charging/stations/available',
   SEARCH_STATIONS: '/api/v1/charging/stations/search',
+  NEARBY_STATIONS: '/api/v1/charging/stations/nearby',
   CREATE_STATION: '/api/v1/charging/stations',
   UPDATE_STATION: (id: string) => `/api/v1/charging/stations/${id}`,
   DELETE_STATION: (id: string) => `/api/v1/charging/stations/${id}`,
+  STATION_HOURS: (id: string) => `/api/v1/charging/stations/${id}/hours`,
+  STATION_AVAILABILITY: (id: string) => `/api/v1/charging/stations/${id}/availability`,
+  CHARGING_BLACKOUTS: '/api/v1/charging/blackouts',
+  CHARGING_BLACKOUT_BY_ID: (id: string) => `/api/v1/charging/blackouts/${id}`,
   
   // Charging Sessions (Charging Chaincode)
   START_CHARGING: '/api/v1/charging/start',
@@ -69,6 +103,14 @@ export const API_ENDPOINTS = {
   ADD_FUNDS: '/api/v1/wallet/add-funds',
   TOTAL_SPENDING: '/api/v1/wallet/spending',
   
+  // Organizations (User and Wallet Chaincode)
+  ORGANIZATIONS: '/api/v1/organizations',
+  ORGANIZATION_BY_ID: (id: string) => `/api/v1/organizations/${id}`,
+  ORGANIZATION_ADD_FUNDS: (id: string) => `/api/v1/organizations/${id}/wallet/add-funds`,
+  ORGANIZATION_MEMBERS: (id: string) => `/api/v1/organizations/${id}/members`,
+  ORGANIZATION_MEMBER: (id: string, userId: string) => `/api/v1/organizations/${id}/members/${userId}`,
+  ORGANIZATION_STATEMENT: (id: string, month: string) => `/api/v1/organizations/${id}/statements/${month}`,
+  
   // Payments (Wallet Chaincode)
   PROCESS_PAYMENT: '/api/v1/payment/process',
   REFUND_PAYMENT: (id: string) => `/api/v1/payment/refund/${id}`,
@@ -1,7 +1,7 @@
 import React, { useState } from 'react';
 import { useNavigate } from 'react-router-dom';
 import { useAuth } from '../context/AuthContext';
-import { authService } from '../services';
+import { authService, MFARequiredError } from '../services';
 import { Input, Button, Card } from '../components';
 import { LogIn, UserPlus } from 'lucide-react';
 
@@ -12,9 +12,11 @@ export const Login: React.FC = () => {
   const [firstName, setFirstName] = useState('');
   const [lastName, setLastName] = useState('');
   const [phone, setPhone] = useState('');
+  const [mfaToken, setMfaToken] = useState('');
+  const [mfaCode, setMfaCode] = useState('');
   const [error, setError] = useState('');
   const [isLoading, setIsLoading] = useState(false);
-  const { login } = useAuth();
+  const { login, loginMfa } = useAuth();
   const navigate = useNavigate();
 
   const handleLogin = async (e: React.FormEvent) => {
@@ -26,12 +28,31 @@ export const Login: React.FC = () => {
       await login(email, password);
       navigate('/dashboard');
     } catch (err: any) {
+      if (err instanceof MFARequiredError) {
+        setMfaToken(err.mfaToken);
+        return;
+      }
       setError(err.response?.data?.error || 'Login failed. Please check your credentials.');
     } finally {
       setIsLoading(false);
     }
   };
 
+  const handleMfa = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError('');
+    setIsLoading(true);
+
+    try {
+      await loginMfa(mfaToken, mfaCode);
+      navigate('/dashboard');
+    } catch (err: any) {
+      setError(err.response?.data?.error || 'Invalid code. Please try again.');
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
   const handleRegister = async (e: React.FormEvent) => {
     e.preventDefault();
     setError('');
@@ -63,7 +84,50 @@ export const Login: React.FC = () => {
           <p className="text-gray-600">Smart Parking & EV Charging</p>
         </div>
 
-        {!isRegistering ? (
+        {mfaToken ? (
+          <form onSubmit={handleMfa} className="space-y-4">
+            <Input
+              type="text"
+              label="Authentication code"
+              placeholder="6-digit code or recovery code"
+              value={mfaCode}
+              onChange={(e) => setMfaCode(e.target.value)}
+              autoComplete="one-time-code"
+              required
+            />
+
+            {error && (
+              <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded">
+                {error}
+              </div>
+            )}
+
+            <Button
+              type="submit"
+              variant="primary"
+              size="lg"
+              icon={LogIn}
+              isLoading={isLoading}
+              className="w-full"
+            >
+              Verify
+            </Button>
+
+            <div className="text-center mt-4">
+              <button
+                type="button"
+                onClick={() => {
+                  setMfaToken('');
+                  setMfaCode('');
+                  setError('');
+                }}
+                className="text-blue-600 hover:text-blue-800 text-sm font-medium"
+              >
+                Back to login
+              </button>
+            </div>
+          </form>
+        ) : !isRegistering ? (
           <form onSubmit={handleLogin} className="space-y-4">
             <Input
               type="email"
@@ -100,7 +164,7 @@ export const Login: React.FC = () => {
               Login
             </Button>
 
-            <div className="text-center mt-4">
+            <div className="text-center mt-4 space-y-2">
               <button
                 type="button"
                 onClick={() => {
@@ -111,6 +175,15 @@ export const Login: React.FC = () => {
               >
                 Don't have an account? Register here
               </button>
+              <div>
+                <button
+                  type="button"
+                  onClick={() => navigate('/reset-password')}
+                  className="text-gray-600 hover:text-gray-800 text-sm"
+                >
+                  Forgot your password?
+                </button>
+              </div>
             </div>
           </form>
         ) : (
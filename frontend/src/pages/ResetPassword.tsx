@@ -0,0 +1,149 @@
+import React, { useState } from 'react';
+import { useNavigate, useSearchParams } from 'react-router-dom';
+import { authService } from '../services';
+import { Input, Button, Card } from '../components';
+import { KeyRound, Mail } from 'lucide-react';
+
+// Requests a reset link, or sets a new password when opened from one (?token=...)
+export const ResetPassword: React.FC = () => {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get('token') || '';
+  const [email, setEmail] = useState('');
+  const [password, setPassword] = useState('');
+  const [confirmPassword, setConfirmPassword] = useState('');
+  const [error, setError] = useState('');
+  const [message, setMessage] = useState('');
+  const [isLoading, setIsLoading] = useState(false);
+  const navigate = useNavigate();
+
+  const handleRequest = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError('');
+    setIsLoading(true);
+
+    try {
+      await authService.forgotPassword(email);
+      setMessage('If this email is registered, a reset link is on its way.');
+    } catch (err: any) {
+      setError(err.response?.data?.error || 'Failed to send reset link. Please try again.');
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  const handleReset = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError('');
+    if (password !== confirmPassword) {
+      setError('Passwords do not match.');
+      return;
+    }
+    setIsLoading(true);
+
+    try {
+      await authService.resetPassword(token, password);
+      navigate('/login');
+    } catch (err: any) {
+      setError(err.response?.data?.error || 'Failed to reset password. Please try again.');
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  return (
+    <div className="min-h-screen bg-gradient-to-br from-blue-50 to-indigo-100 flex items-center justify-center p-4">
+      <Card className="w-full max-w-md">
+        <div className="text-center mb-8">
+          <h1 className="text-3xl font-bold text-gray-900 mb-2">Reset Password</h1>
+          <p className="text-gray-600">
+            {token ? 'Choose a new password' : "We'll email you a link to reset it"}
+          </p>
+        </div>
+
+        {token ? (
+          <form onSubmit={handleReset} className="space-y-4">
+            <Input
+              type="password"
+              label="New password"
+              placeholder="At least 6 characters"
+              value={password}
+              onChange={(e) => setPassword(e.target.value)}
+              required
+              minLength={6}
+            />
+
+            <Input
+              type="password"
+              label="Confirm new password"
+              placeholder="Repeat the new password"
+              value={confirmPassword}
+              onChange={(e) => setConfirmPassword(e.target.value)}
+              required
+              minLength={6}
+            />
+
+            {error && (
+              <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded">
+                {error}
+              </div>
+            )}
+
+            <Button
+              type="submit"
+              variant="primary"
+              size="lg"
+              icon={KeyRound}
+              isLoading={isLoading}
+              className="w-full"
+            >
+              Set Password
+            </Button>
+          </form>
+        ) : (
+          <form onSubmit={handleRequest} className="space-y-4">
+            <Input
+              type="email"
+              label="Email"
+              placeholder="Enter your email"
+              value={email}
+              onChange={(e) => setEmail(e.target.value)}
+              required
+            />
+
+            {error && (
+              <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded">
+                {error}
+              </div>
+            )}
+            {message && (
+              <div className="bg-green-50 border border-green-200 text-green-700 px-4 py-3 rounded">
+                {message}
+              </div>
+            )}
+
+            <Button
+              type="submit"
+              variant="primary"
+              size="lg"
+              icon={Mail}
+              isLoading={isLoading}
+              className="w-full"
+            >
+              Send Reset Link
+            </Button>
+          </form>
+        )}
+
+        <div className="text-center mt-4">
+          <button
+            type="button"
+            onClick={() => navigate('/login')}
+            className="text-blue-600 hover:text-blue-800 text-sm font-medium"
+          >
+            Back to login
+          </button>
+        </div>
+      </Card>
+    </div>
+  );
+};
@@ -21,7 +21,7 @@ const SecurityDashboard: React.FC = () => {
   const [eventFilter, setEventFilter] = useState({ type: '', severity: '' });
 
   useEffect(() => {
-    if (user?.role !== 'admin') {
+    if (user?.role !== 'admin' && user?.role !== 'security-auditor') {
       navigate('/dashboard');
       return;
     }
@@ -282,7 +282,7 @@ const SecurityDashboard: React.FC = () => {
                           {new Date(alert.timestamp).toLocaleString()}
                         </p>
                       </div>
-                      {!alert.acknowledged && (
+                      {!alert.acknowledged && user?.role === 'admin' && (
                         <Button
                           size="sm"
                           variant="secondary"
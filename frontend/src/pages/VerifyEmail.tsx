@@ -0,0 +1,55 @@
+import React, { useEffect, useState } from 'react';
+import { useNavigate, useSearchParams } from 'react-router-dom';
+import { authService } from '../services';
+import { Button, Card } from '../components';
+
+// Confirms the email address from the link mailed at registration (?token=...)
+export const VerifyEmail: React.FC = () => {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get('token') || '';
+  const [status, setStatus] = useState<'verifying' | 'verified' | 'failed'>('verifying');
+  const [error, setError] = useState('');
+  const navigate = useNavigate();
+
+  useEffect(() => {
+    if (!token) {
+      setStatus('failed');
+      setError('The verification link is incomplete.');
+      return;
+    }
+
+    authService
+      .verifyEmail(token)
+      .then(() => setStatus('verified'))
+      .catch((err: any) => {
+        setStatus('failed');
+        setError(err.response?.data?.error || 'Failed to verify email.');
+      });
+  }, [token]);
+
+  return (
+    <div className="min-h-screen bg-gradient-to-br from-blue-50 to-indigo-100 flex items-center justify-center p-4">
+      <Card className="w-full max-w-md">
+        <div className="text-center space-y-4">
+          <h1 className="text-3xl font-bold text-gray-900">Email Verification</h1>
+
+          {status === 'verifying' && <p className="text-gray-600">Verifying your email...</p>}
+          {status === 'verified' && (
+            <div className="bg-green-50 border border-green-200 text-green-700 px-4 py-3 rounded">
+              Your email is verified.
+            </div>
+          )}
+          {status === 'failed' && (
+            <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded">
+              {error}
+            </div>
+          )}
+
+          <Button variant="primary" className="w-full" onClick={() => navigate('/dashboard')}>
+            Continue
+          </Button>
+        </div>
+      </Card>
+    </div>
+  );
+};
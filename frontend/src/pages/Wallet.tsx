@@ -96,10 +96,14 @@ export const Wallet: React.FC = () => {
 
     try {
       setIsProcessing(true);
-      await apiWalletService.addFunds({ amount: amountNum });
+      const { redirectUrl } = await apiWalletService.addFunds({ amount: amountNum });
+      if (redirectUrl) {
+        window.location.href = redirectUrl;
+        return;
+      }
       notification.success(
-        'Deposit successful!',
-        `Added $${amountNum.toFixed(2)} to your wallet`
+        'Deposit started',
+        `$${amountNum.toFixed(2)} will be added to your wallet once the payment is confirmed`
       );
       setShowDepositModal(false);
       setAmount('');
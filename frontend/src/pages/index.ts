@@ -1,4 +1,6 @@
 export { Login } from './Login';
+export { ResetPassword } from './ResetPassword';
+export { VerifyEmail } from './VerifyEmail';
 export { Dashboard } from './Dashboard';
 export { Map } from './Map';
 export { Wallet } from './Wallet';
@@ -1,5 +1,5 @@
 import { apiClient } from './api';
-import type { ParkingSpot, ChargingStation } from '../types';
+import type { ParkingSpot, ChargingStation, PageParams } from '../types';
 
 export interface CreateParkingSpotRequest {
   spotNumber: string;
@@ -58,8 +58,8 @@ class AdminService {
     return apiClient.delete(`/api/v1/parking/spots/${spotId}`);
   }
 
-  async getAllParkingSpots(): Promise<{ spots: ParkingSpot[] }> {
-    return apiClient.get('/api/v1/parking/spots');
+  async getAllParkingSpots(params?: PageParams): Promise<{ spots: ParkingSpot[]; nextBookmark: string }> {
+    return apiClient.get('/api/v1/parking/spots', params);
   }
 
   async getParkingSpot(spotId: string): Promise<{ spot: ParkingSpot }> {
@@ -80,8 +80,8 @@ class AdminService {
     return apiClient.delete(`/api/v1/charging/stations/${stationId}`);
   }
 
-  async getAllChargingStations(): Promise<{ stations: ChargingStation[] }> {
-    return apiClient.get('/api/v1/charging/stations');
+  async getAllChargingStations(params?: PageParams): Promise<{ stations: ChargingStation[]; nextBookmark: string }> {
+    return apiClient.get('/api/v1/charging/stations', params);
   }
 
   async getChargingStation(stationId: string): Promise<{ station: ChargingStation }> {
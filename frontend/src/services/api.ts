@@ -1,8 +1,12 @@
-import axios, { type AxiosInstance, AxiosError } from 'axios';
-import { API_BASE_URL } from '../config/api';
+import axios, { type AxiosInstance, type AxiosRequestConfig, AxiosError } from 'axios';
+import { API_BASE_URL, API_ENDPOINTS } from '../config/api';
+
+const AUTH_TOKEN_KEY = 'authToken';
+const REFRESH_TOKEN_KEY = 'refreshToken';
 
 class ApiClient {
   private client: AxiosInstance;
+  private refreshing: Promise<string> | null = null;
 
   constructor() {
     this.client = axios.create({
@@ -15,7 +19,7 @@ class ApiClient {
     // Request interceptor to add auth token
     this.client.interceptors.request.use(
       (config) => {
-        const token = localStorage.getItem('authToken');
+        const token = localStorage.getItem(AUTH_TOKEN_KEY);
         if (token) {
           config.headers.Authorization = `Bearer ${token}`;
         }
@@ -24,13 +28,26 @@ class ApiClient {
       (error) => Promise.reject(error)
     );
 
-    // Response interceptor to handle errors
+    // Response interceptor to handle errors. An expired access token is refreshed once
+    // and the request retried; if that fails the user has to log in again.
     this.client.interceptors.response.use(
       (response) => response,
-      (error: AxiosError) => {
+      async (error: AxiosError) => {
+        const config = error.config as (AxiosRequestConfig & { _retried?: boolean }) | undefined;
+        if (error.response?.status === 401 && config && !config._retried && localStorage.getItem(REFRESH_TOKEN_KEY)) {
+          try {
+            // The request interceptor sends the new access token
+            await this.refreshAccessToken();
+            config._retried = true;
+            return this.client.request(config);
+          } catch {
+            // Fall through to the login redirect
+          }
+        }
         if (error.response?.status === 401) {
-          // Unauthorized - clear token and redirect to login
-          localStorage.removeItem('authToken');
+          // Unauthorized - clear tokens and redirect to login
+          localStorage.removeItem(AUTH_TOKEN_KEY);
+          localStorage.removeItem(REFRESH_TOKEN_KEY);
           window.location.href = '/login';
         }
         return Promise.reject(error);
@@ -38,24 +55,57 @@ class ApiClient {
     );
   }
 
+  // Exchanges the refresh token for a new access token. Concurrent 401s share one
+  // refresh, since the refresh token is rotated and can only be used once.
+  private refreshAccessToken(): Promise<string> {
+    if (!this.refreshing) {
+      this.refreshing = axios
+        .post<{ token: string; refreshToken: string }>(`${API_BASE_URL}${API_ENDPOINTS.REFRESH_TOKEN}`, {
+          refreshToken: localStorage.getItem(REFRESH_TOKEN_KEY),
+        })
+        .then((response) => {
+          localStorage.setItem(AUTH_TOKEN_KEY, response.data.token);
+          localStorage.setItem(REFRESH_TOKEN_KEY, response.data.refreshToken);
+          return response.data.token;
+        })
+        .finally(() => {
+          this.refreshing = null;
+        });
+    }
+    return this.refreshing;
+  }
+
   async get<T>(url: string, params?: any): Promise<T> {
     const response = await this.client.get<T>(url, { params });
     return response.data;
   }
 
   async post<T>(url: string, data?: any): Promise<T> {
-    const response = await this.client.post<T>(url, data);
-    return response.data;
+    return this.send<T>({ method: 'post', url, data });
   }
 
   async put<T>(url: string, data?: any): Promise<T> {
-    const response = await this.client.put<T>(url, data);
-    return response.data;
+    return this.send<T>({ method: 'put', url, data });
   }
 
   async delete<T>(url: string): Promise<T> {
-    const response = await this.client.delete<T>(url);
-    return response.data;
+    return this.send<T>({ method: 'delete', url });
+  }
+
+  // State-changing requests carry an Idempotency-Key, so a request whose response was
+  // lost can be retried without booking or charging twice
+  private async send<T>(config: AxiosRequestConfig, retries = 1): Promise<T> {
+    const headers = { ...config.headers, 'Idempotency-Key': crypto.randomUUID() };
+    for (let attempt = 0; ; attempt++) {
+      try {
+        const response = await this.client.request<T>({ ...config, headers });
+        return response.data;
+      } catch (error) {
+        if (attempt >= retries || !axios.isAxiosError(error) || error.response) {
+          throw error;
+        }
+      }
+    }
   }
 }
 
@@ -11,9 +11,36 @@ import type {
   Payment,
   LoginRequest,
   LoginResponse,
+  AuthSession,
+  MFAStatus,
+  MFAEnrollment,
+  UserRole,
+  RoleAssignment,
+  Organization,
+  OrgMember,
+  SpendingDelegation,
+  DelegatedStatement,
+  PageParams,
+  Page,
+  SearchSpotsParams,
+  SearchStationsParams,
+  BookingListParams,
+  SessionListParams,
+  NearbySpotsParams,
+  NearbyStationsParams,
+  NearbySpot,
+  NearbyStation,
+  Facility,
+  FacilityDetails,
+  FacilityOccupancy,
+  OpeningHoursSettings,
+  Blackout,
+  CreateBlackoutRequest,
+  TimeWindow,
 } from '../types';
 
 const AUTH_TOKEN_KEY = 'authToken';
+const REFRESH_TOKEN_KEY = 'refreshToken';
 
 // ==================== AUTH SERVICES ====================
 export const authService = {
@@ -31,6 +58,9 @@ export const authService = {
     const response = await apiClient.post<LoginResponse>(API_ENDPOINTS.LOGIN, credentials);
     // Persist token immediately so subsequent requests send Authorization header
     localStorage.setItem(AUTH_TOKEN_KEY, response.token);
+    if (response.refreshToken) {
+      localStorage.setItem(REFRESH_TOKEN_KEY, response.refreshToken);
+    }
     return response;
   },
 
@@ -38,8 +68,9 @@ export const authService = {
     try {
       await apiClient.post<void>(API_ENDPOINTS.LOGOUT);
     } finally {
-      // Always clear client token to avoid sending stale/invalid sessions
+      // Always clear client tokens to avoid sending stale/invalid sessions
       localStorage.removeItem(AUTH_TOKEN_KEY);
+      localStorage.removeItem(REFRESH_TOKEN_KEY);
     }
   },
 
@@ -51,6 +82,67 @@ export const authService = {
   getProfile: async (): Promise<User> => {
     return authService.getCurrentUser();
   },
+
+  getSessions: async (): Promise<AuthSession[]> => {
+    const response = await apiClient.get<{ sessions: AuthSession[] }>(API_ENDPOINTS.SESSIONS);
+    return response.sessions || [];
+  },
+
+  revokeSession: async (sessionId: string): Promise<void> => {
+    await apiClient.delete<void>(API_ENDPOINTS.SESSION_BY_ID(sessionId));
+  },
+
+  // Log out every other device, keeping this one signed in
+  revokeOtherSessions: async (): Promise<void> => {
+    await apiClient.delete<void>(API_ENDPOINTS.SESSIONS);
+  },
+
+  forgotPassword: async (email: string): Promise<void> => {
+    await apiClient.post<void>(API_ENDPOINTS.FORGOT_PASSWORD, { email });
+  },
+
+  resetPassword: async (token: string, newPassword: string): Promise<void> => {
+    await apiClient.post<void>(API_ENDPOINTS.RESET_PASSWORD, { token, newPassword });
+  },
+
+  changePassword: async (currentPassword: string, newPassword: string): Promise<void> => {
+    await apiClient.post<void>(API_ENDPOINTS.CHANGE_PASSWORD, { currentPassword, newPassword });
+  },
+
+  verifyEmail: async (token: string): Promise<void> => {
+    await apiClient.post<void>(API_ENDPOINTS.VERIFY_EMAIL, { token });
+  },
+
+  resendVerification: async (): Promise<void> => {
+    await apiClient.post<void>(API_ENDPOINTS.RESEND_VERIFICATION);
+  },
+
+  getMfaStatus: async (): Promise<MFAStatus> => {
+    return apiClient.get<MFAStatus>(API_ENDPOINTS.MFA);
+  },
+
+  enrollMfa: async (): Promise<MFAEnrollment> => {
+    return apiClient.post<MFAEnrollment>(API_ENDPOINTS.MFA_ENROLL);
+  },
+
+  // Returns the recovery codes, shown only once
+  activateMfa: async (code: string): Promise<string[]> => {
+    const response = await apiClient.post<{ recoveryCodes: string[]; token: string }>(
+      API_ENDPOINTS.MFA_ACTIVATE,
+      { code }
+    );
+    // The new access token is accepted by admin routes
+    localStorage.setItem(AUTH_TOKEN_KEY, response.token);
+    return response.recoveryCodes;
+  },
+
+  regenerateRecoveryCodes: async (code: string): Promise<string[]> => {
+    const response = await apiClient.post<{ recoveryCodes: string[] }>(
+      API_ENDPOINTS.MFA_RECOVERY_CODES,
+      { code }
+    );
+    return response.recoveryCodes;
+  },
 };
 
 // ==================== USER SERVICES ====================
@@ -74,6 +166,26 @@ export const userService = {
   getUserHistory: async (userId: string): Promise<any> => {
     return apiClient.get<any>(API_ENDPOINTS.USER_HISTORY(userId));
   },
+
+  unlockUser: async (userId: string): Promise<void> => {
+    await apiClient.post(API_ENDPOINTS.UNLOCK_USER(userId));
+  },
+
+  // Admin only; the user gets the new role when their access token is refreshed
+  assignRole: async (userId: string, role: UserRole, reason: string): Promise<RoleAssignment> => {
+    const response = await apiClient.put<{ assignment: RoleAssignment }>(
+      API_ENDPOINTS.USER_ROLE(userId),
+      { role, reason }
+    );
+    return response.assignment;
+  },
+
+  getRoleHistory: async (userId: string): Promise<RoleAssignment[]> => {
+    const response = await apiClient.get<{ history: RoleAssignment[] }>(
+      API_ENDPOINTS.USER_ROLE_HISTORY(userId)
+    );
+    return response.history;
+  },
 };
 
 // ==================== PARKING SPOT SERVICES ====================
@@ -86,13 +198,16 @@ export const parkingSpotService = {
     return apiClient.get<ParkingSpot>(API_ENDPOINTS.SPOT_BY_ID(spotId));
   },
 
-  searchSpots: async (params: {
-    latitude?: number;
-    longitude?: number;
-    radius?: number;
-    type?: string;
-  }): Promise<ParkingSpot[]> => {
-    return apiClient.get<ParkingSpot[]>(API_ENDPOINTS.SEARCH_SPOTS, params);
+  // Filters combine; pass nextBookmark back with the same filters for the next page
+  searchSpots: async (params: SearchSpotsParams): Promise<Page<ParkingSpot>> => {
+    const response = await apiClient.get<{ spots: ParkingSpot[]; nextBookmark: string }>(API_ENDPOINTS.SEARCH_SPOTS, params);
+    return { items: response.spots || [], nextBookmark: response.nextBookmark || '' };
+  },
+
+  // Nearest first, with distances in meters
+  getNearbySpots: async (params: NearbySpotsParams): Promise<NearbySpot[]> => {
+    const response = await apiClient.get<{ spots: NearbySpot[] }>(API_ENDPOINTS.NEARBY_SPOTS, params);
+    return response.spots || [];
   },
 
   getAvailableSpots: async (): Promise<ParkingSpot[]> => {
@@ -110,6 +225,78 @@ export const parkingSpotService = {
   deleteSpot: async (spotId: string): Promise<void> => {
     return apiClient.delete<void>(API_ENDPOINTS.DELETE_SPOT(spotId));
   },
+
+  // An empty facilityId takes the spot out of its facility
+  assignFacility: async (spotId: string, facilityId: string): Promise<void> => {
+    return apiClient.put<void>(API_ENDPOINTS.SPOT_FACILITY(spotId), { facilityId });
+  },
+
+  // Empty opening hours fall back to the facility's, or always open
+  setOpeningHours: async (spotId: string, data: OpeningHoursSettings): Promise<void> => {
+    return apiClient.put<void>(API_ENDPOINTS.SPOT_HOURS(spotId), data);
+  },
+
+  // Windows the spot is open, not blacked out and not reserved; defaults to the next 24 hours
+  getAvailability: async (spotId: string, params?: { from?: string; to?: string }): Promise<TimeWindow[]> => {
+    const response = await apiClient.get<{ availability: TimeWindow[] }>(API_ENDPOINTS.SPOT_AVAILABILITY(spotId), params);
+    return response.availability || [];
+  },
+
+  getBlackouts: async (scopeType: 'spot' | 'facility' | 'location', scopeId: string): Promise<Blackout[]> => {
+    const response = await apiClient.get<{ blackouts: Blackout[] }>(API_ENDPOINTS.PARKING_BLACKOUTS, { scopeType, scopeId });
+    return response.blackouts || [];
+  },
+
+  createBlackout: async (data: CreateBlackoutRequest): Promise<string> => {
+    const response = await apiClient.post<{ blackoutId: string }>(API_ENDPOINTS.PARKING_BLACKOUTS, data);
+    return response.blackoutId;
+  },
+
+  deleteBlackout: async (blackoutId: string): Promise<void> => {
+    return apiClient.delete<void>(API_ENDPOINTS.PARKING_BLACKOUT_BY_ID(blackoutId));
+  },
+};
+
+// ==================== PARKING FACILITY SERVICES ====================
+export const facilityService = {
+  getAllFacilities: async (): Promise<Facility[]> => {
+    const response = await apiClient.get<{ facilities: Facility[] }>(API_ENDPOINTS.FACILITIES);
+    return response.facilities || [];
+  },
+
+  getFacility: async (facilityId: string): Promise<Facility> => {
+    const response = await apiClient.get<{ facility: Facility }>(API_ENDPOINTS.FACILITY_BY_ID(facilityId));
+    return response.facility;
+  },
+
+  createFacility: async (data: FacilityDetails & { operatorId?: string }): Promise<string> => {
+    const response = await apiClient.post<{ facilityId: string }>(API_ENDPOINTS.FACILITIES, data);
+    return response.facilityId;
+  },
+
+  updateFacility: async (facilityId: string, data: FacilityDetails): Promise<void> => {
+    return apiClient.put<void>(API_ENDPOINTS.FACILITY_BY_ID(facilityId), data);
+  },
+
+  deleteFacility: async (facilityId: string): Promise<void> => {
+    return apiClient.delete<void>(API_ENDPOINTS.FACILITY_BY_ID(facilityId));
+  },
+
+  getFacilitySpots: async (facilityId: string, params?: PageParams & { status?: ParkingSpot['status'] }): Promise<Page<ParkingSpot>> => {
+    const response = await apiClient.get<{ spots: ParkingSpot[]; nextBookmark: string }>(API_ENDPOINTS.FACILITY_SPOTS(facilityId), params);
+    return { items: response.spots || [], nextBookmark: response.nextBookmark || '' };
+  },
+
+  getOccupancy: async (facilityId: string): Promise<FacilityOccupancy> => {
+    const response = await apiClient.get<{ occupancy: FacilityOccupancy }>(API_ENDPOINTS.FACILITY_OCCUPANCY(facilityId));
+    return response.occupancy;
+  },
+
+  // Occupancy of every active facility
+  getAllOccupancy: async (): Promise<FacilityOccupancy[]> => {
+    const response = await apiClient.get<{ facilities: FacilityOccupancy[] }>(API_ENDPOINTS.FACILITIES_OCCUPANCY);
+    return response.facilities || [];
+  },
 };
 
 // ==================== PARKING BOOKING SERVICES ====================
@@ -118,6 +305,7 @@ export const parkingBookingService = {
     spotId: string;
     startTime: string;
     endTime: string;
+    organizationId?: string; // pay from this organization's wallet
   }): Promise<Reservation> => {
     const response = await apiClient.post<{ booking?: any }>(API_ENDPOINTS.CREATE_RESERVATION, data);
     return parkingBookingService.mapBooking(response.booking || response);
@@ -145,10 +333,10 @@ export const parkingBookingService = {
     return apiClient.delete<void>(API_ENDPOINTS.CANCEL_BOOKING(bookingId));
   },
 
-  getUserBookings: async (): Promise<Reservation[]> => {
-    const response = await apiClient.get<{ bookings: any[] }>(API_ENDPOINTS.USER_BOOKINGS);
+  getUserBookings: async (params?: BookingListParams): Promise<Page<Reservation>> => {
+    const response = await apiClient.get<{ bookings: any[]; nextBookmark: string }>(API_ENDPOINTS.USER_BOOKINGS, params);
     const bookings = response.bookings || [];
-    return bookings.map(parkingBookingService.mapBooking);
+    return { items: bookings.map(parkingBookingService.mapBooking), nextBookmark: response.nextBookmark || '' };
   },
 
   getBooking: async (bookingId: string): Promise<Reservation> => {
@@ -156,16 +344,16 @@ export const parkingBookingService = {
     return parkingBookingService.mapBooking(response.booking || response);
   },
 
-  getActiveBookings: async (): Promise<Reservation[]> => {
-    const response = await apiClient.get<{ bookings: any[] }>(API_ENDPOINTS.ACTIVE_BOOKINGS);
+  getActiveBookings: async (params?: PageParams): Promise<Page<Reservation>> => {
+    const response = await apiClient.get<{ bookings: any[]; nextBookmark: string }>(API_ENDPOINTS.ACTIVE_BOOKINGS, params);
     const bookings = response.bookings || [];
-    return bookings.map(parkingBookingService.mapBooking);
+    return { items: bookings.map(parkingBookingService.mapBooking), nextBookmark: response.nextBookmark || '' };
   },
 
-  getBookingHistory: async (): Promise<Reservation[]> => {
-    const response = await apiClient.get<{ bookings: any[] }>(API_ENDPOINTS.BOOKING_HISTORY);
+  getBookingHistory: async (params?: PageParams): Promise<Page<Reservation>> => {
+    const response = await apiClient.get<{ bookings: any[]; nextBookmark: string }>(API_ENDPOINTS.BOOKING_HISTORY, params);
     const bookings = response.bookings || [];
-    return bookings.map(parkingBookingService.mapBooking);
+    return { items: bookings.map(parkingBookingService.mapBooking), nextBookmark: response.nextBookmark || '' };
   },
 
   // Helper to map blockchain booking data to frontend Reservation type
@@ -202,13 +390,16 @@ export const chargingStationService = {
     return apiClient.get<ChargingStation>(API_ENDPOINTS.CHARGING_STATION_BY_ID(stationId));
   },
 
-  searchStations: async (params: {
-    latitude?: number;
-    longitude?: number;
-    radius?: number;
-    connectorType?: string;
-  }): Promise<ChargingStation[]> => {
-    return apiClient.get<ChargingStation[]>(API_ENDPOINTS.SEARCH_STATIONS, params);
+  // Filters combine; pass nextBookmark back with the same filters for the next page
+  searchStations: async (params: SearchStationsParams): Promise<Page<ChargingStation>> => {
+    const response = await apiClient.get<{ stations: ChargingStation[]; nextBookmark: string }>(API_ENDPOINTS.SEARCH_STATIONS, params);
+    return { items: response.stations || [], nextBookmark: response.nextBookmark || '' };
+  },
+
+  // Nearest first, with distances in meters
+  getNearbyStations: async (params: NearbyStationsParams): Promise<NearbyStation[]> => {
+    const response = await apiClient.get<{ stations: NearbyStation[] }>(API_ENDPOINTS.NEARBY_STATIONS, params);
+    return response.stations || [];
   },
 
   getAvailableStations: async (): Promise<ChargingStation[]> => {
@@ -226,6 +417,31 @@ export const chargingStationService = {
   deleteStation: async (stationId: string): Promise<void> => {
     return apiClient.delete<void>(API_ENDPOINTS.DELETE_STATION(stationId));
   },
+
+  // Empty opening hours mean always open
+  setOpeningHours: async (stationId: string, data: OpeningHoursSettings): Promise<void> => {
+    return apiClient.put<void>(API_ENDPOINTS.STATION_HOURS(stationId), data);
+  },
+
+  // Windows the station is open and not blacked out; defaults to the next 24 hours
+  getAvailability: async (stationId: string, params?: { from?: string; to?: string }): Promise<TimeWindow[]> => {
+    const response = await apiClient.get<{ availability: TimeWindow[] }>(API_ENDPOINTS.STATION_AVAILABILITY(stationId), params);
+    return response.availability || [];
+  },
+
+  getBlackouts: async (scopeType: 'station' | 'location', scopeId: string): Promise<Blackout[]> => {
+    const response = await apiClient.get<{ blackouts: Blackout[] }>(API_ENDPOINTS.CHARGING_BLACKOUTS, { scopeType, scopeId });
+    return response.blackouts || [];
+  },
+
+  createBlackout: async (data: CreateBlackoutRequest): Promise<string> => {
+    const response = await apiClient.post<{ blackoutId: string }>(API_ENDPOINTS.CHARGING_BLACKOUTS, data);
+    return response.blackoutId;
+  },
+
+  deleteBlackout: async (blackoutId: string): Promise<void> => {
+    return apiClient.delete<void>(API_ENDPOINTS.CHARGING_BLACKOUT_BY_ID(blackoutId));
+  },
 };
 
 // ==================== CHARGING SESSION SERVICES ====================
@@ -246,7 +462,7 @@ export const chargingSessionService = {
     return chargingSessionService.mapSession(response.session || response);
   },
 
-  stopSession: async (data: { sessionId: string }): Promise<ChargingSession> => {
+  stopSession: async (data: { sessionId: string; organizationId?: string }): Promise<ChargingSession> => {
     const response = await apiClient.post<{ session?: any }>(API_ENDPOINTS.STOP_CHARGING, data);
     return chargingSessionService.mapSession(response.session || response);
   },
@@ -255,10 +471,10 @@ export const chargingSessionService = {
     return apiClient.delete<void>(API_ENDPOINTS.CANCEL_CHARGING(sessionId));
   },
 
-  getUserSessions: async (): Promise<ChargingSession[]> => {
-    const response = await apiClient.get<{ sessions: any[] }>(API_ENDPOINTS.USER_SESSIONS);
+  getUserSessions: async (params?: SessionListParams): Promise<Page<ChargingSession>> => {
+    const response = await apiClient.get<{ sessions: any[]; nextBookmark: string }>(API_ENDPOINTS.USER_SESSIONS, params);
     const sessions = response.sessions || [];
-    return sessions.map(chargingSessionService.mapSession);
+    return { items: sessions.map(chargingSessionService.mapSession), nextBookmark: response.nextBookmark || '' };
   },
 
   getSession: async (sessionId: string): Promise<ChargingSession> => {
@@ -266,16 +482,16 @@ export const chargingSessionService = {
     return chargingSessionService.mapSession(response.session || response);
   },
 
-  getActiveSessions: async (): Promise<ChargingSession[]> => {
-    const response = await apiClient.get<{ sessions: any[] }>(API_ENDPOINTS.ACTIVE_CHARGING_SESSIONS);
+  getActiveSessions: async (params?: PageParams): Promise<Page<ChargingSession>> => {
+    const response = await apiClient.get<{ sessions: any[]; nextBookmark: string }>(API_ENDPOINTS.ACTIVE_CHARGING_SESSIONS, params);
     const sessions = response.sessions || [];
-    return sessions.map(chargingSessionService.mapSession);
+    return { items: sessions.map(chargingSessionService.mapSession), nextBookmark: response.nextBookmark || '' };
   },
 
-  getSessionHistory: async (): Promise<ChargingSession[]> => {
-    const response = await apiClient.get<{ sessions: any[] }>(API_ENDPOINTS.CHARGING_HISTORY);
+  getSessionHistory: async (params?: PageParams): Promise<Page<ChargingSession>> => {
+    const response = await apiClient.get<{ sessions: any[]; nextBookmark: string }>(API_ENDPOINTS.CHARGING_HISTORY, params);
     const sessions = response.sessions || [];
-    return sessions.map(chargingSessionService.mapSession);
+    return { items: sessions.map(chargingSessionService.mapSession), nextBookmark: response.nextBookmark || '' };
   },
 
   getEnergyStats: async (): Promise<any> => {
@@ -305,6 +521,12 @@ export const chargingSessionService = {
 };
 
 // ==================== WALLET SERVICES ====================
+// The API exchanges wallet amounts as decimal strings, e.g. "12.50"
+const parseAmount = (amount: string | number | undefined): number | undefined =>
+  amount === undefined ? undefined : Number(amount);
+
+const formatAmount = (amount: number): string => amount.toFixed(2);
+
 export const walletService = {
   createWallet: async (): Promise<WalletInfo> => {
     await apiClient.post<{ walletId: string; message: string }>(API_ENDPOINTS.CREATE_WALLET);
@@ -321,7 +543,7 @@ export const walletService = {
       id: wallet.walletId,
       userId: wallet.userId,
       address: wallet.walletId, // Use walletId as address
-      balance: wallet.balance || 0,
+      balance: parseAmount(wallet.balance) || 0,
       currency: wallet.currency || 'USD',
       createdAt: wallet.createdAt,
       lastUpdated: wallet.lastUpdated,
@@ -329,11 +551,13 @@ export const walletService = {
   },
 
   getBalance: async (): Promise<{ balance: number }> => {
-    return apiClient.get<{ balance: number }>(API_ENDPOINTS.WALLET_BALANCE);
+    const response = await apiClient.get<{ balance: string }>(API_ENDPOINTS.WALLET_BALANCE);
+    return { balance: parseAmount(response.balance) || 0 };
   },
 
-  addFunds: async (data: { amount: number }): Promise<Transaction> => {
-    return apiClient.post<Transaction>(API_ENDPOINTS.ADD_FUNDS, data);
+  // Starts a top-up; the wallet is credited once the payment provider confirms it
+  addFunds: async (data: { amount: number }): Promise<{ topUp: any; clientSecret: string; redirectUrl?: string }> => {
+    return apiClient.post(API_ENDPOINTS.ADD_FUNDS, { amount: formatAmount(data.amount) });
   },
 
   getTransactions: async (): Promise<Transaction[]> => {
@@ -346,12 +570,12 @@ export const walletService = {
       userId: tx.userId,
       walletId: tx.walletId,
       type: tx.type,
-      amount: tx.amount,
+      amount: parseAmount(tx.amount) || 0,
       description: tx.description || '',
       timestamp: tx.timestamp,
       blockchainTxHash: tx.transactionId || tx.id, // Use transactionId as blockchain hash
-      balanceBefore: tx.balanceBefore,
-      balanceAfter: tx.balanceAfter,
+      balanceBefore: parseAmount(tx.balanceBefore),
+      balanceAfter: parseAmount(tx.balanceAfter),
       status: tx.status || 'confirmed',
       paymentId: tx.paymentId,
       reservationId: tx.reservationId || tx.bookingId,
@@ -370,12 +594,12 @@ export const walletService = {
       userId: tx.userId,
       walletId: tx.walletId,
       type: tx.type,
-      amount: tx.amount,
+      amount: parseAmount(tx.amount) || 0,
       description: tx.description || '',
       timestamp: tx.timestamp,
       blockchainTxHash: tx.transactionId || tx.id,
-      balanceBefore: tx.balanceBefore,
-      balanceAfter: tx.balanceAfter,
+      balanceBefore: parseAmount(tx.balanceBefore),
+      balanceAfter: parseAmount(tx.balanceAfter),
       status: tx.status || 'confirmed',
       paymentId: tx.paymentId,
       reservationId: tx.reservationId || tx.bookingId,
@@ -403,7 +627,10 @@ export const paymentService = {
     referenceId: string;
     description: string;
   }): Promise<Payment> => {
-    const response = await apiClient.post<{ payment?: any }>(API_ENDPOINTS.PROCESS_PAYMENT, data);
+    const response = await apiClient.post<{ payment?: any }>(API_ENDPOINTS.PROCESS_PAYMENT, {
+      ...data,
+      amount: formatAmount(data.amount),
+    });
     return paymentService.mapPayment(response.payment || response);
   },
 
@@ -422,7 +649,7 @@ export const paymentService = {
     id: payment.paymentId || payment.id,
     walletId: payment.walletId,
     userId: payment.userId,
-    amount: payment.amount,
+    amount: parseAmount(payment.amount) || 0,
     type: payment.type,
     referenceId: payment.referenceId,
     status: payment.status,
@@ -435,14 +662,77 @@ export const paymentService = {
   }),
 };
 
+// ==================== ORGANIZATION SERVICES ====================
+export const organizationService = {
+  createOrganization: async (data: { name: string; currency?: string }): Promise<{ organization: Organization; walletId: string }> => {
+    return apiClient.post(API_ENDPOINTS.ORGANIZATIONS, data);
+  },
+
+  getOrganizations: async (): Promise<Organization[]> => {
+    const response = await apiClient.get<{ organizations: Organization[] }>(API_ENDPOINTS.ORGANIZATIONS);
+    return response.organizations || [];
+  },
+
+  // The wallet is only returned to owners and managers
+  getOrganization: async (orgId: string): Promise<{ organization: Organization; role: OrgMember['role']; wallet?: any }> => {
+    return apiClient.get(API_ENDPOINTS.ORGANIZATION_BY_ID(orgId));
+  },
+
+  addFunds: async (orgId: string, data: { amount: number }): Promise<{ topUp: any; clientSecret: string; redirectUrl?: string }> => {
+    return apiClient.post(API_ENDPOINTS.ORGANIZATION_ADD_FUNDS(orgId), { amount: formatAmount(data.amount) });
+  },
+
+  getMembers: async (orgId: string): Promise<{ members: OrgMember[]; delegations: SpendingDelegation[] }> => {
+    return apiClient.get(API_ENDPOINTS.ORGANIZATION_MEMBERS(orgId));
+  },
+
+  getMember: async (orgId: string, userId: string): Promise<{ member: OrgMember; delegation?: SpendingDelegation; spentThisMonth?: string }> => {
+    return apiClient.get(API_ENDPOINTS.ORGANIZATION_MEMBER(orgId, userId));
+  },
+
+  addMember: async (orgId: string, data: {
+    email: string;
+    role: 'manager' | 'driver';
+    monthlyLimit: number;
+    allowedLocations?: string[];
+  }): Promise<{ member: OrgMember; delegation: SpendingDelegation }> => {
+    return apiClient.post(API_ENDPOINTS.ORGANIZATION_MEMBERS(orgId), {
+      ...data,
+      monthlyLimit: formatAmount(data.monthlyLimit),
+    });
+  },
+
+  updateMember: async (orgId: string, userId: string, data: {
+    role: 'manager' | 'driver';
+    monthlyLimit: number;
+    allowedLocations?: string[];
+  }): Promise<{ member: OrgMember; delegation: SpendingDelegation }> => {
+    return apiClient.put(API_ENDPOINTS.ORGANIZATION_MEMBER(orgId, userId), {
+      ...data,
+      monthlyLimit: formatAmount(data.monthlyLimit),
+    });
+  },
+
+  removeMember: async (orgId: string, userId: string): Promise<void> => {
+    return apiClient.delete<void>(API_ENDPOINTS.ORGANIZATION_MEMBER(orgId, userId));
+  },
+
+  getStatement: async (orgId: string, month: string): Promise<DelegatedStatement> => {
+    const response = await apiClient.get<{ statement: DelegatedStatement }>(API_ENDPOINTS.ORGANIZATION_STATEMENT(orgId, month));
+    return response.statement;
+  },
+};
+
 // ==================== EXPORT ALL ====================
 export default {
   auth: authService,
   user: userService,
   parkingSpot: parkingSpotService,
+  facility: facilityService,
   parkingBooking: parkingBookingService,
   chargingStation: chargingStationService,
   chargingSession: chargingSessionService,
   wallet: walletService,
   payment: paymentService,
+  organization: organizationService,
 };
@@ -21,6 +21,7 @@ import type {
   WalletInfo,
   Transaction,
   ApiResponse,
+  UserRole,
 } from '../types';
 import {
   mockUsers,
@@ -37,6 +38,28 @@ import {
 // Set to true to use mock data (for testing without backend)
 const USE_MOCK_DATA = false;
 const AUTH_TOKEN_KEY = 'authToken';
+const REFRESH_TOKEN_KEY = 'refreshToken';
+
+// Thrown by login when the account has MFA enabled; complete it with loginMfa
+export class MFARequiredError extends Error {
+  mfaToken: string;
+
+  constructor(mfaToken: string) {
+    super('MFA code required');
+    this.name = 'MFARequiredError';
+    this.mfaToken = mfaToken;
+  }
+}
+
+type BackendLoginResponse = {
+  token: string;
+  refreshToken?: string;
+  expiresIn?: number;
+  user: any;
+  message?: string;
+  mfaRequired?: boolean;
+  mfaToken?: string;
+};
 
 export const authService = {
   async login(credentials: LoginRequest): Promise<LoginResponse> {
@@ -48,11 +71,26 @@ export const authService = {
       }
       throw new Error('Invalid credentials');
     }
-    const response = await apiClient.post<{ token: string; user: any; message?: string }>(
+    const response = await apiClient.post<BackendLoginResponse>(
       API_ENDPOINTS.LOGIN,
       credentials
     );
-    
+    if (response.mfaRequired && response.mfaToken) {
+      throw new MFARequiredError(response.mfaToken);
+    }
+    return this.completeLogin(response);
+  },
+
+  // Second step of a login with MFA, with a TOTP or recovery code
+  async loginMfa(mfaToken: string, code: string): Promise<LoginResponse> {
+    const response = await apiClient.post<BackendLoginResponse>(
+      API_ENDPOINTS.LOGIN_MFA,
+      { mfaToken, code }
+    );
+    return this.completeLogin(response);
+  },
+
+  completeLogin(response: BackendLoginResponse): LoginResponse {
     // Transform backend response to match frontend User type
     const user: User = {
       id: response.user.userId || response.user.id,
@@ -62,8 +100,10 @@ export const authService = {
       phone: response.user.phone,
       walletAddress: response.user.walletAddress || '0x0000000000000000000000000000000000000000',
       balance: response.user.balance || 0,
-      role: response.user.role as 'user' | 'admin',
+      role: response.user.role as UserRole,
       isActive: response.user.isActive,
+      mfaEnabled: response.user.mfaEnabled,
+      emailVerified: response.user.emailVerified,
       createdAt: response.user.createdAt,
       updatedAt: response.user.updatedAt,
     };
@@ -72,8 +112,11 @@ export const authService = {
     if (response.token) {
       localStorage.setItem(AUTH_TOKEN_KEY, response.token);
     }
+    if (response.refreshToken) {
+      localStorage.setItem(REFRESH_TOKEN_KEY, response.refreshToken);
+    }
 
-    return { token: response.token, user };
+    return { token: response.token, refreshToken: response.refreshToken, expiresIn: response.expiresIn, user };
   },
 
   async register(data: { 
@@ -124,6 +167,7 @@ export const authService = {
       console.error('Logout API call failed:', error);
     }
     localStorage.removeItem(AUTH_TOKEN_KEY);
+    localStorage.removeItem(REFRESH_TOKEN_KEY);
   },
 
   async getProfile(): Promise<User> {
@@ -143,8 +187,10 @@ export const authService = {
       phone: userData.phone,
       walletAddress: userData.walletAddress || '0x0000000000000000000000000000000000000000',
       balance: userData.balance || 0,
-      role: userData.role as 'user' | 'admin',
+      role: userData.role as UserRole,
       isActive: userData.isActive,
+      mfaEnabled: userData.mfaEnabled,
+      emailVerified: userData.emailVerified,
       createdAt: userData.createdAt,
       updatedAt: userData.updatedAt,
     };
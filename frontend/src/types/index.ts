@@ -1,3 +1,6 @@
+// New accounts are users; admins grant the other roles
+export type UserRole = 'user' | 'operator' | 'support' | 'security-auditor' | 'admin';
+
 export interface User {
   id: string; // userId from blockchain
   email: string;
@@ -6,8 +9,10 @@ export interface User {
   phone?: string;
   walletAddress: string;
   balance: number;
-  role?: 'user' | 'admin';
+  role?: UserRole;
   isActive?: boolean;
+  mfaEnabled?: boolean;
+  emailVerified?: boolean;
   createdAt?: string;
   updatedAt?: string;
   // Blockchain metadata
@@ -30,6 +35,9 @@ export interface ParkingSpot {
   chargingPower?: number; // kW for EV charging
   hasEVCharging?: boolean;
   operatorId?: string;
+  facilityId?: string;
+  utcOffsetMinutes?: number;
+  openingHours?: OpeningHours[]; // the facility's, or always open, when empty
   createdAt?: string;
   updatedAt?: string;
   // Blockchain metadata
@@ -51,6 +59,8 @@ export interface Reservation {
   totalCost: number;
   qrCode?: string;
   paymentId?: string;
+  refundPercent?: number; // refunded share of the payments, set when cancelled
+  cancelledAt?: string;
   transactionId?: string;
   blockchainTxHash?: string; // Fabric transaction ID
   createdAt?: string;
@@ -94,6 +104,8 @@ export interface ChargingStation {
   status: 'available' | 'in-use' | 'maintenance' | 'out-of-service';
   features?: string[];
   operatorId?: string;
+  utcOffsetMinutes?: number;
+  openingHours?: OpeningHours[]; // always open when empty
   createdAt?: string;
   updatedAt?: string;
   // Blockchain metadata
@@ -114,6 +126,7 @@ export interface ChargingSession {
   totalCost: number;
   status: 'starting' | 'active' | 'completed' | 'cancelled';
   paymentId?: string;
+  refundPercent?: number; // refunded share of the payments, set when cancelled
   createdAt?: string;
   updatedAt?: string;
   // Blockchain metadata
@@ -143,7 +156,8 @@ export interface Payment {
   amount: number;
   type: 'parking' | 'charging' | 'refund';
   referenceId: string; // bookingId or sessionId
-  status: 'pending' | 'completed' | 'failed' | 'refunded';
+  refundedMinor?: number; // refunded so far, in minor units
+  status: 'pending' | 'completed' | 'failed' | 'partially_refunded' | 'refunded';
   description: string;
   createdAt: string;
   completedAt?: string;
@@ -158,6 +172,7 @@ export interface CreateReservationRequest {
   startTime: string;
   endTime: string;
   totalCost?: number;
+  organizationId?: string; // pay from this organization's wallet
 }
 
 export interface RegisterRequest {
@@ -174,10 +189,107 @@ export interface LoginRequest {
 }
 
 export interface LoginResponse {
-  token: string;
+  token: string; // short-lived access token
+  refreshToken?: string; // exchanged for a new access token at /auth/refresh
+  expiresIn?: number; // access token lifetime in seconds
   user: User;
 }
 
+// Returned by login instead of tokens when the account has MFA enabled
+export interface MFAChallengeResponse {
+  mfaRequired: true;
+  mfaToken: string; // exchanged with a code at /auth/login/mfa
+  message?: string;
+}
+
+export interface MFAStatus {
+  enabled: boolean;
+  required: boolean; // staff roles must enable MFA
+  recoveryCodesRemaining?: number;
+}
+
+export interface MFAEnrollment {
+  secret: string;
+  otpauthUrl: string;
+}
+
+// A login session, one per device
+export interface AuthSession {
+  sessionId: string;
+  createdAt: string;
+  lastUsedAt: string;
+  expiresAt: string;
+  ipAddress: string;
+  userAgent: string;
+  current: boolean; // the session of this browser
+}
+
+// A change of a user's role, recorded on the ledger
+export interface RoleAssignment {
+  assignmentId: string;
+  userId: string;
+  previousRole: UserRole | '';
+  role: UserRole;
+  assignedBy: string; // admin user ID, or "bootstrap"
+  reason: string;
+  assignedAt: string;
+}
+
+export type OrgRole = 'owner' | 'manager' | 'driver';
+
+// A fleet or company whose members share a wallet
+export interface Organization {
+  orgId: string;
+  name: string;
+  ownerId: string;
+  isActive: boolean;
+  createdAt: string;
+  updatedAt: string;
+}
+
+export interface OrgMember {
+  orgId: string;
+  userId: string;
+  role: OrgRole;
+  addedBy: string;
+  isActive: boolean;
+  createdAt: string;
+  updatedAt: string;
+}
+
+// What a member may spend from an organization's wallet
+export interface SpendingDelegation {
+  walletId: string;
+  userId: string;
+  currency: string;
+  monthlyLimit: string; // decimal, in the wallet's currency
+  allowedLocations: string[]; // spot or station IDs, empty for anywhere
+  isActive: boolean;
+  updatedAt: string;
+}
+
+export interface DelegateSpend {
+  userId: string;
+  payments: number;
+  spent: string;
+  refunded: string;
+  net: string;
+  monthlyLimit: string;
+}
+
+// What the members spent from an organization's wallet in a month (YYYY-MM, UTC)
+export interface DelegatedStatement {
+  walletId: string;
+  month: string;
+  currency: string;
+  spent: string;
+  refunded: string;
+  net: string;
+  users: DelegateSpend[];
+  paymentIds: string[];
+  refundIds: string[];
+}
+
 export interface ApiResponse<T> {
   success: boolean;
   data?: T;
@@ -185,18 +297,142 @@ export interface ApiResponse<T> {
   message?: string;
 }
 
-export interface SearchSpotsParams {
-  latitude?: number;
-  longitude?: number;
-  radius?: number;
-  type?: 'parking' | 'ev_charging';
+// Page parameters of list endpoints; pass back nextBookmark, with the same filters,
+// for the next page
+export interface PageParams {
+  pageSize?: number; // default 20, at most 100
+  bookmark?: string;
 }
 
-export interface SearchStationsParams {
-  latitude?: number;
-  longitude?: number;
-  radius?: number;
+export interface SearchSpotsParams extends PageParams {
+  location?: string;
+  facilityId?: string;
+  type?: string;
+  status?: ParkingSpot['status'];
+  minPrice?: number;
+  maxPrice?: number;
+  sortBy?: 'location' | 'price';
+}
+
+export interface SearchStationsParams extends PageParams {
+  location?: string;
   connectorType?: 'CCS' | 'CHAdeMO' | 'Type2' | 'Tesla';
+  status?: ChargingStation['status'];
+  minPower?: number; // kW
+  maxPower?: number; // kW
+  sortBy?: 'location' | 'power';
+}
+
+// A nearby search; without from and to only what is available now is returned
+export interface NearbyParams {
+  lat: number;
+  lng: number;
+  radius?: number; // meters, default 500, at most 10000
+  from?: string; // RFC3339
+  to?: string; // RFC3339
+  limit?: number; // default 20, at most 100
+}
+
+export interface NearbySpotsParams extends NearbyParams {
+  type?: string;
+  ev?: boolean; // only spots with EV charging
+}
+
+export interface NearbyStationsParams extends NearbyParams {
+  connectorType?: ChargingStation['connectorType'];
+  minPower?: number; // kW
+}
+
+// Spots and stations as returned by the ledger, with their distance from the search point
+export interface NearbySpot {
+  spot: any;
+  distance: number; // meters
+}
+
+export interface NearbyStation {
+  station: any;
+  distance: number; // meters
+}
+
+export interface GeoPoint {
+  latitude: number;
+  longitude: number;
+}
+
+export interface OpeningHours {
+  days?: number[]; // 0 = Sunday ... 6 = Saturday, every day when empty
+  open: string; // HH:MM local time
+  close: string; // HH:MM local time, before open to close past midnight
+}
+
+export interface OpeningHoursSettings {
+  utcOffsetMinutes: number; // local time of the opening hours
+  openingHours: OpeningHours[];
+}
+
+// Closes the spots or stations of a scope for a time range
+export interface Blackout {
+  blackoutId: string;
+  scopeType: 'spot' | 'facility' | 'location' | 'station';
+  scopeId: string;
+  startTime: string;
+  endTime: string;
+  reason: string;
+  createdAt: string;
+}
+
+export type CreateBlackoutRequest = Pick<Blackout, 'scopeType' | 'scopeId' | 'startTime' | 'endTime' | 'reason'>;
+
+// A half-open [startTime, endTime) range
+export interface TimeWindow {
+  startTime: string;
+  endTime: string;
+}
+
+// A garage, lot or street zone grouping parking spots
+export interface Facility {
+  facilityId: string;
+  name: string;
+  address: string;
+  polygon: GeoPoint[]; // boundary, empty if not mapped
+  utcOffsetMinutes: number;
+  openingHours: OpeningHours[]; // always open when empty
+  maxHeight: number; // meters, 0 for no limit
+  entryRules: string[];
+  operatorId: string;
+  isActive: boolean;
+  createdAt: string;
+  updatedAt: string;
+}
+
+export type FacilityDetails = Pick<Facility, 'name' | 'address' | 'polygon' | 'utcOffsetMinutes' | 'openingHours' | 'maxHeight' | 'entryRules'>;
+
+export interface FacilityOccupancy {
+  facilityId: string;
+  name: string;
+  total: number; // spots in service
+  available: number;
+  occupied: number; // checked in
+  reserved: number; // booked now, not checked in
+  maintenance: number;
+  occupancy: number; // percent of spots in service occupied or reserved
+  at: string;
+}
+
+export interface BookingListParams extends PageParams {
+  spotId?: string;
+  status?: string; // comma-separated
+}
+
+export interface SessionListParams extends PageParams {
+  stationId?: string;
+  status?: string; // comma-separated
+}
+
+// A page of a list endpoint; nextBookmark is empty on the last page
+export interface Page<T> {
+  items: T[];
+  nextBookmark: string;
 }
 
 export interface CreateBookingRequest {